@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// AdminUserIDs parses the comma-separated ADMIN_USER_IDS list of bot operators.
+func AdminUserIDs() []int64 {
+	var ids []int64
+	for _, field := range strings.Split(GetenvVar("ADMIN_USER_IDS", false), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func IsBotAdmin(userID int64) bool {
+	for _, id := range AdminUserIDs() {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Alert is an adulterant or contamination warning from one of the configured feeds.
+type Alert struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Summary   string    `json:"summary"`
+	Regions   []string  `json:"regions"`
+	Published time.Time `json:"published"`
+}
+
+// seenAlerts remembers pushed alerts ("<feed>|<id>") and when each feed was last polled ("<feed>").
+var seenAlerts *JSONStore[time.Time]
+
+const seenAlertRetention = 90 * 24 * time.Hour
+
+// AlertFeeds returns the feed URLs from ALERT_FEEDS (comma separated). Feeds may be RSS or a
+// JSON endpoint returning {"alerts": [...]}.
+func AlertFeeds() []string {
+	var feeds []string
+	for _, feed := range strings.Split(GetenvVar("ALERT_FEEDS", false), ",") {
+		if feed = strings.TrimSpace(feed); feed != "" {
+			feeds = append(feeds, feed)
+		}
+	}
+	return feeds
+}
+
+func alertPollInterval() time.Duration {
+	minutes, err := strconv.Atoi(GetenvVar("ALERT_POLL_MINUTES", false))
+	if err != nil || minutes < 1 {
+		minutes = 30
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+type rssFeed struct {
+	Items []struct {
+		GUID        string   `xml:"guid"`
+		Title       string   `xml:"title"`
+		Link        string   `xml:"link"`
+		Description string   `xml:"description"`
+		PubDate     string   `xml:"pubDate"`
+		Categories  []string `xml:"category"`
+	} `xml:"channel>item"`
+}
+
+var markupTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// FetchAlerts downloads and parses one feed.
+func FetchAlerts(feedURL string) ([]Alert, error) {
+	resp, err := HTTPClient(15 * time.Second).Get(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching alert feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("alert feed returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading alert feed: %w", err)
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		var payload struct {
+			Alerts []Alert `json:"alerts"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("error decoding alert feed: %w", err)
+		}
+		return payload.Alerts, nil
+	}
+
+	var feed rssFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("error decoding alert feed: %w", err)
+	}
+	alerts := make([]Alert, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		alert := Alert{
+			ID:      item.GUID,
+			Title:   strings.TrimSpace(item.Title),
+			URL:     strings.TrimSpace(item.Link),
+			Summary: strings.TrimSpace(html.UnescapeString(markupTagPattern.ReplaceAllString(item.Description, ""))),
+			Regions: item.Categories,
+		}
+		if alert.ID == "" {
+			alert.ID = alert.URL
+		}
+		if published, err := time.Parse(time.RFC1123Z, item.PubDate); err == nil {
+			alert.Published = published
+		} else if published, err := time.Parse(time.RFC1123, item.PubDate); err == nil {
+			alert.Published = published
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// AlertMatchesRegions reports whether an alert applies to a subscription. Subscriptions without
+// regions get every alert, and alerts without regions go to every subscriber.
+func AlertMatchesRegions(alert Alert, regions []string) bool {
+	if len(regions) == 0 || len(alert.Regions) == 0 {
+		return true
+	}
+	for _, want := range regions {
+		for _, region := range alert.Regions {
+			if strings.Contains(strings.ToLower(region), strings.ToLower(want)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func FormatAlert(alert Alert) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "⚠️ <b>%s</b>", html.EscapeString(alert.Title))
+	if len(alert.Regions) > 0 {
+		fmt.Fprintf(&b, "\n<i>%s</i>", html.EscapeString(strings.Join(alert.Regions, ", ")))
+	}
+	if alert.Summary != "" {
+		fmt.Fprintf(&b, "\n\n%s", html.EscapeString(Truncate(alert.Summary, 600)))
+	}
+	if alert.URL != "" {
+		fmt.Fprintf(&b, "\n\n<a href=\"%s\">Full alert</a>", html.EscapeString(alert.URL))
+	}
+	return b.String()
+}
+
+// PollAlertFeed fetches a feed and returns the alerts not seen before. The first poll of a feed
+// only records what is already there, so subscribers don't get its whole backlog.
+func PollAlertFeed(feedURL string) ([]Alert, error) {
+	alerts, err := FetchAlerts(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	_, polledBefore := seenAlerts.Get(feedURL)
+
+	var fresh []Alert
+	for _, alert := range alerts {
+		key := feedURL + "|" + alert.ID
+		if _, seen := seenAlerts.Get(key); seen || alert.ID == "" {
+			continue
+		}
+		if err := seenAlerts.Set(key, time.Now()); err != nil {
+			return nil, err
+		}
+		if polledBefore {
+			fresh = append(fresh, alert)
+		}
+	}
+	return fresh, seenAlerts.Set(feedURL, time.Now())
+}
+
+// PushAlert sends an alert to every chat subscribed to one of its regions. The subscribers are
+// collected first: sending may remediate a failure by updating the chat's settings, which can't
+// happen while they are being ranged over.
+func PushAlert(bot *tgbotapi.BotAPI, alert Alert) {
+	type subscriber struct {
+		chatID int64
+		pin    bool
+	}
+	var subscribers []subscriber
+	chatSettings.Range(func(key string, settings ChatSettings) bool {
+		if !settings.Alerts || settings.Inactive || !AlertMatchesRegions(alert, settings.AlertRegions) {
+			return true
+		}
+		if chatID, err := strconv.ParseInt(key, 10, 64); err == nil {
+			subscribers = append(subscribers, subscriber{chatID: chatID, pin: settings.PinAlerts})
+		}
+		return true
+	})
+
+	text := FormatAlert(alert)
+	delivered := 0
+	for _, sub := range subscribers {
+		msg := tgbotapi.NewMessage(sub.chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.DisableNotification = Delivery(MessageAlert, sub.chatID, time.Now()).Silent
+		sent, err := bot.Send(msg)
+		if err != nil {
+			log.Printf("Error pushing alert to chat %d: %v", sub.chatID, err)
+			NoteSendFailure(sub.chatID, err)
+			continue
+		}
+		delivered++
+		if sub.pin {
+			if err := PinAlert(bot, sub.chatID, sent.MessageID, alert.ID); err != nil {
+				log.Printf("Error pinning alert in chat %d: %v", sub.chatID, err)
+			}
+		}
+	}
+	EmitEvent(Event{Type: EventAlertSent, Data: map[string]interface{}{"id": alert.ID, "regions": alert.Regions, "chats": delivered}})
+}
+
+// ManualAlert builds an alert pushed by a bot admin from "<title>\n<summary>".
+func ManualAlert(text string, now time.Time) Alert {
+	title, summary, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return Alert{
+		ID:        "manual:" + strconv.FormatInt(now.Unix(), 10),
+		Title:     strings.TrimSpace(title),
+		Summary:   strings.TrimSpace(summary),
+		Published: now,
+	}
+}
+
+// ScheduleAlertFeeds polls ALERT_FEEDS every ALERT_POLL_MINUTES and pushes new alerts. A feed
+// that fails is retried once; alerts of the feeds that worked are already pushed by then.
+func ScheduleAlertFeeds(bot *tgbotapi.BotAPI) {
+	feeds := AlertFeeds()
+	if len(feeds) == 0 {
+		return
+	}
+
+	ScheduleJob(Job{
+		Name:       "alert_feeds",
+		Schedule:   Every(alertPollInterval()),
+		Jitter:     time.Minute,
+		Retries:    1,
+		RetryDelay: 5 * time.Minute,
+		RunAtStart: true,
+		Run: func(time.Time) error {
+			var errs []error
+			for _, feed := range feeds {
+				alerts, err := PollAlertFeed(feed)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("error polling alert feed %s: %w", feed, err))
+					continue
+				}
+				for _, alert := range alerts {
+					PushAlert(bot, alert)
+				}
+			}
+			pruneSeenAlerts()
+			return errors.Join(errs...)
+		},
+	})
+}
+
+func pruneSeenAlerts() {
+	cutoff := time.Now().Add(-seenAlertRetention)
+	var stale []string
+	seenAlerts.Range(func(key string, seen time.Time) bool {
+		if strings.Contains(key, "|") && seen.Before(cutoff) {
+			stale = append(stale, key)
+		}
+		return true
+	})
+	for _, key := range stale {
+		if err := seenAlerts.Delete(key); err != nil {
+			log.Printf("Error pruning seen alert: %v", err)
+		}
+	}
+}
+
+const alertsUsage = "Usage:\n/alerts on [region ...], e.g. /alerts on Germany Netherlands\n/alerts off\n" +
+	"/alerts pin on|off — pin alerts in this group\n/alerts push &lt;title&gt; — send an alert to every subscriber (bot admins)"
+
+// HandleAlertsCommand subscribes a chat to drug checking alerts, optionally for some regions only.
+func HandleAlertsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chat := update.Message.Chat
+	fields := strings.Fields(args)
+
+	if len(fields) == 0 {
+		settings := GetChatSettings(chat.ID)
+		status := "off"
+		if settings.Alerts {
+			status = "on, all regions"
+			if len(settings.AlertRegions) > 0 {
+				status = "on for " + html.EscapeString(strings.Join(settings.AlertRegions, ", "))
+			}
+		}
+		if settings.PinAlerts {
+			status += fmt.Sprintf(", pinned for %s (%d pinned now)", FormatDuration(alertPinDuration()), PinnedAlertCount(chat.ID))
+		}
+		return SendHTML(bot, chat.ID, fmt.Sprintf("Drug checking alerts are <b>%s</b>.\n%s", status, alertsUsage))
+	}
+	// Pushes are confirmed by a bot admin before they get here (see RequestAdminConfirmation)
+	if strings.ToLower(fields[0]) == "push" {
+		_, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+		alert := ManualAlert(text, time.Now())
+		if alert.Title == "" {
+			return SendHTML(bot, chat.ID, alertsUsage)
+		}
+		go PushAlert(bot, alert)
+		return SendHTML(bot, chat.ID, "Pushing the alert to every subscribed chat.")
+	}
+	if update.Message.From == nil || !IsChatAdmin(bot, chat, update.Message.From.ID) {
+		return SendHTML(bot, chat.ID, "Only group admins can change alert subscriptions.")
+	}
+
+	var reply string
+	var change func(settings *ChatSettings)
+	switch strings.ToLower(fields[0]) {
+	case "on":
+		regions := fields[1:]
+		change = func(settings *ChatSettings) {
+			settings.Alerts = true
+			settings.AlertRegions = regions
+		}
+		reply = "Subscribed to drug checking alerts."
+		if len(regions) > 0 {
+			reply = "Subscribed to drug checking alerts for " + html.EscapeString(strings.Join(regions, ", ")) + "."
+		}
+	case "off":
+		change = func(settings *ChatSettings) {
+			settings.Alerts = false
+			settings.AlertRegions = nil
+		}
+		reply = "Unsubscribed from drug checking alerts."
+	case "pin":
+		if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+			return SendHTML(bot, chat.ID, alertsUsage)
+		}
+		pin := fields[1] == "on"
+		if pin && (chat.IsPrivate() || !canPinMessages(bot, chat)) {
+			return SendHTML(bot, chat.ID, "I need to be a group admin allowed to pin messages for this.")
+		}
+		change = func(settings *ChatSettings) { settings.PinAlerts = pin }
+		reply = "Alerts won't be pinned."
+		if pin {
+			reply = fmt.Sprintf("Alerts will be pinned for %s.", FormatDuration(alertPinDuration()))
+		}
+	default:
+		return SendHTML(bot, chat.ID, alertsUsage)
+	}
+
+	if err := UpdateChatSettings(chat.ID, change); err != nil {
+		return err
+	}
+	return SendHTML(bot, chat.ID, reply)
+}
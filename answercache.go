@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// CachedAnswer is a backend answer to a standalone question, reused for the same question in
+// the same language while the semantic_cache flag is on.
+type CachedAnswer struct {
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	Language  string    `json:"language"`
+	CreatedAt time.Time `json:"created_at"`
+	// Version counts how often the answer was refreshed since it was first cached
+	Version int `json:"version"`
+}
+
+var answerCache *JSONStore[CachedAnswer]
+
+// AnswerCacheEnabled reports whether answers are served from and saved to the cache.
+func AnswerCacheEnabled(chatID, userID int64) bool {
+	return answerCache != nil && FeatureEnabled(FlagSemanticCache, chatID, userID)
+}
+
+// AnswerCacheKey identifies a question regardless of case, spacing and trailing punctuation.
+// Standard style keys leave the style out, as they did before there were styles.
+func AnswerCacheKey(question, language, style string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(question)), " ")
+	normalized = strings.TrimRight(normalized, "?!. ")
+	if style != "" && style != StyleStandard {
+		language += "\x00" + style
+	}
+	sum := sha256.Sum256([]byte(language + "\x00" + normalized))
+	return hex.EncodeToString(sum[:16])
+}
+
+// answerStaleAfter is the age from which cached answers are shown with a refresh button, from
+// ANSWER_CACHE_STALE_DAYS (default 30).
+func answerStaleAfter() time.Duration {
+	days, err := strconv.Atoi(GetenvVar("ANSWER_CACHE_STALE_DAYS", false))
+	if err != nil || days < 1 {
+		days = 30
+	}
+	return time.Duration(days) * oneDay
+}
+
+// CacheAnswer saves a fresh answer, keeping the version count of the answer it replaces.
+func CacheAnswer(key, question, answer, language string) error {
+	return answerCache.Update(key, func(cached CachedAnswer) CachedAnswer {
+		version := 1
+		if cached.Answer != "" {
+			version = cached.Version + 1
+		}
+		return CachedAnswer{Question: question, Answer: answer, Language: language, CreatedAt: time.Now(), Version: version}
+	})
+}
+
+// StaleAnswerNote tells the reader how old a cached answer is once it is stale.
+func StaleAnswerNote(cached CachedAnswer, now time.Time) string {
+	age := now.Sub(cached.CreatedAt)
+	if age < answerStaleAfter() {
+		return ""
+	}
+	return fmt.Sprintf("\n\n<i>🕰 This answer was written %s ago (%s). Tap Refresh for an up-to-date one.</i>",
+		formatDays(age), cached.CreatedAt.UTC().Format("2006-01-02"))
+}
+
+// RefreshKeyboard is the answer keyboard with a "Refresh" button for stale cached answers
+// ("rf:<message id>").
+func RefreshKeyboard(messageID int) tgbotapi.InlineKeyboardMarkup {
+	markup := AnswerKeyboard(messageID)
+	markup.InlineKeyboard = append(markup.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔄 Refresh", "rf:"+strconv.Itoa(messageID))))
+	return markup
+}
+
+// HandleRefreshCallback answers a stale cached question again, updates the cache and notes
+// what changed.
+func HandleRefreshCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	messageID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	chatID := query.Message.Chat.ID
+	entry, ok := feedback.Get(FeedbackKey(chatID, messageID))
+	if !ok {
+		return AnswerCallback(bot, query, "This answer is too old to refresh. Ask again instead.")
+	}
+	if entry.UserID != 0 && entry.UserID != query.From.ID {
+		return AnswerCallback(bot, query, "Only the person who asked can refresh this answer.")
+	}
+	if OverQuota(query.From.ID) {
+		return AnswerCallback(bot, query, QuotaMessage(query.From.ID))
+	}
+	if err := AnswerCallback(bot, query, "Refreshing…"); err != nil {
+		return err
+	}
+
+	run := func() {
+		if err := refreshAnswer(bot, chatID, messageID, entry); err != nil {
+			log.Printf("Error refreshing answer: %v", err)
+		}
+	}
+	if askPool == nil {
+		run()
+		return nil
+	}
+	askPool.Submit(&AskJob{Run: run})
+	return nil
+}
+
+func refreshAnswer(bot *tgbotapi.BotAPI, chatID int64, messageID int, entry FeedbackEntry) error {
+	language := ReplyLanguage(chatID, entry.UserID)
+	style := AnswerStyle(chatID, entry.UserID)
+	request := PromptRequest{
+		Question:     entry.Question,
+		Temperature:  0.25,
+		SystemPrompt: SystemPrompt() + LanguageInstruction(language),
+	}
+	ApplyStyle(&request, style)
+	started := time.Now()
+	response, err := Ask(request)
+	AuditExchange(chatID, entry.UserID, "refresh", request, response, err, started)
+	if err != nil {
+		return err
+	}
+	rawAnswer := response.Text()
+	if !response.Refused() {
+		if err := CacheAnswer(AnswerCacheKey(entry.Question, language, style), entry.Question, rawAnswer, language); err != nil {
+			log.Printf("Error updating cached answer: %v", err)
+		}
+	}
+	if err := RecordAnswer(chatID, messageID, entry.UserID, entry.Question, rawAnswer); err != nil {
+		log.Printf("Error recording refreshed answer: %v", err)
+	}
+
+	added, removed := DiffKeyPoints(entry.Answer, rawAnswer)
+	answer := AnswerHTML(rawAnswer) + FormatChangeNote(added, removed)
+	keyboard := AnswerKeyboard(messageID)
+	mode := GetChatSettings(chatID).LinkPreview
+	return EditMessageHTML(bot, chatID, messageID, answer, LinkPreviewFor(mode, answer), &keyboard)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version the requests are written for.
+const anthropicVersion = "2023-06-01"
+
+// anthropicProvider talks to the Anthropic Messages API directly. It has no moderation or
+// embedding endpoints.
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+// newAnthropicProvider reads ANTHROPIC_API_KEY, ANTHROPIC_MODEL and ANTHROPIC_BASE_URL.
+func newAnthropicProvider() anthropicProvider {
+	p := anthropicProvider{
+		baseURL: strings.TrimRight(GetenvVar("ANTHROPIC_BASE_URL", false), "/"),
+		apiKey:  GetenvVar("ANTHROPIC_API_KEY", false),
+		model:   GetenvVar("ANTHROPIC_MODEL", false),
+	}
+	if p.baseURL == "" {
+		p.baseURL = "https://api.anthropic.com/v1"
+	}
+	return p
+}
+
+func (p anthropicProvider) Name() string { return ProviderAnthropic }
+
+func (p anthropicProvider) headers() map[string]string {
+	return map[string]string{"x-api-key": p.apiKey, "anthropic-version": anthropicVersion}
+}
+
+type anthropicRequest struct {
+	Model       string           `json:"model"`
+	System      string           `json:"system,omitempty"`
+	Messages    []HistoryMessage `json:"messages"`
+	Temperature float64          `json:"temperature"`
+	MaxTokens   int              `json:"max_tokens"`
+	Stream      bool             `json:"stream,omitempty"`
+}
+
+// alternatingMessages merges consecutive messages of the same role, since the conversation has
+// to alternate, and opens it with a user message when the history starts with an answer.
+func alternatingMessages(messages []HistoryMessage) []HistoryMessage {
+	var merged []HistoryMessage
+	for _, message := range messages {
+		if len(merged) == 0 && message.Role != "user" {
+			merged = append(merged, HistoryMessage{Role: "user", Content: "(Continuing our conversation.)"})
+		}
+		if last := len(merged) - 1; last >= 0 && merged[last].Role == message.Role {
+			merged[last].Content += "\n\n" + message.Content
+			continue
+		}
+		merged = append(merged, message)
+	}
+	return merged
+}
+
+func (p anthropicProvider) request(request PromptRequest, stream bool) anthropicRequest {
+	tokens := request.Tokens
+	if tokens <= 0 {
+		tokens = 1000
+	}
+	return anthropicRequest{
+		Model:       p.model,
+		System:      request.SystemPrompt,
+		Messages:    alternatingMessages(chatMessages(request)),
+		Temperature: request.Temperature,
+		MaxTokens:   tokens,
+		Stream:      stream,
+	}
+}
+
+func (p anthropicProvider) Ask(ctx context.Context, request PromptRequest) (*PromptResponse, error) {
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := postProviderJSON(ctx, p.baseURL+"/messages", p.headers(), p.request(request, false), &result); err != nil {
+		return nil, err
+	}
+	var answer strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			answer.WriteString(block.Text)
+		}
+	}
+	response := &PromptResponse{Assistant: answer.String()}
+	if err := response.Validate(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (p anthropicProvider) Stream(ctx context.Context, request PromptRequest, onDelta func(answer string), onStatus func(status string)) (*PromptResponse, error) {
+	return streamProvider(ctx, p.baseURL+"/messages", p.headers(), p.request(request, true), onDelta,
+		func(data string) (string, bool, error) {
+			var event struct {
+				Type  string `json:"type"`
+				Delta struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"delta"`
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				return "", false, &SchemaError{Reason: "stream event: " + err.Error()}
+			}
+			switch event.Type {
+			case "content_block_delta":
+				if event.Delta.Type == "text_delta" {
+					return event.Delta.Text, false, nil
+				}
+			case "message_stop":
+				return "", true, nil
+			case "error":
+				return "", false, &BackendError{Status: 200, Message: event.Error.Message}
+			}
+			return "", false, nil
+		})
+}
+
+func (p anthropicProvider) Moderate(ctx context.Context, text string) (*Moderation, error) {
+	return nil, ErrProviderUnsupported
+}
+
+func (p anthropicProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	return nil, ErrProviderUnsupported
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ArchivedSession points to a conversation session moved to cold storage.
+type ArchivedSession struct {
+	Name       string    `json:"name"`
+	Location   string    `json:"location"`
+	Turns      int       `json:"turns"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// archivedSessions indexes the archived sessions of each user by ChatKey.
+var archivedSessions *JSONStore[[]ArchivedSession]
+
+// ArchiveDestination is where idle sessions are archived: a local directory or
+// "s3://bucket/prefix". Archival is disabled when CONVERSATION_ARCHIVE is empty.
+func ArchiveDestination() string {
+	return GetenvVar("CONVERSATION_ARCHIVE", false)
+}
+
+// archiveAfter is how long a session is idle before it is archived, from
+// CONVERSATION_ARCHIVE_DAYS (default 14).
+func archiveAfter() time.Duration {
+	return retentionDays("CONVERSATION_ARCHIVE_DAYS", 14)
+}
+
+func archiveName(userKey, session string, at time.Time) string {
+	return "conversations/" + userKey + "/" + strconv.FormatInt(at.Unix(), 10) + "-" + url.PathEscape(session) + ".json.gz"
+}
+
+func writeColdObject(destination, name string, data []byte) (string, error) {
+	if strings.HasPrefix(destination, "s3://") {
+		bucket, prefix := parseS3Location(destination)
+		key := strings.TrimPrefix(strings.TrimSuffix(prefix, "/")+"/"+name, "/")
+		if err := S3ClientFromEnv().PutObject(bucket, key, data); err != nil {
+			return "", err
+		}
+		return "s3://" + bucket + "/" + key, nil
+	}
+	path := filepath.Join(destination, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("error creating archive dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return "", fmt.Errorf("error writing archive: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("error writing archive: %w", err)
+	}
+	return path, nil
+}
+
+func readColdObject(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "s3://") {
+		bucket, key := parseS3Location(location)
+		return S3ClientFromEnv().GetObject(bucket, key)
+	}
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	return data, nil
+}
+
+func deleteColdObject(location string) error {
+	if strings.HasPrefix(location, "s3://") {
+		bucket, key := parseS3Location(location)
+		return S3ClientFromEnv().DeleteObject(bucket, key)
+	}
+	if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting archive: %w", err)
+	}
+	return nil
+}
+
+func compressSession(session Session) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(session); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressSession(data []byte) (Session, error) {
+	var session Session
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return session, fmt.Errorf("error reading archive: %w", err)
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(io.LimitReader(reader, maxResponseBytes)).Decode(&session); err != nil {
+		return session, fmt.Errorf("error decoding archived session: %w", err)
+	}
+	return session, nil
+}
+
+// ArchiveIdleSessions moves sessions untouched for archiveAfter to ArchiveDestination, keeping
+// only an index entry in the hot store. It returns how many sessions it archived.
+func ArchiveIdleSessions(now time.Time) (int, error) {
+	destination := ArchiveDestination()
+	if destination == "" || conversations == nil {
+		return 0, nil
+	}
+	cutoff := now.Add(-archiveAfter())
+	idle := map[string]map[string]Session{}
+	conversations.Range(func(key string, user UserSessions) bool {
+		for name, session := range user.Sessions {
+			if session.UpdatedAt.Before(cutoff) {
+				if idle[key] == nil {
+					idle[key] = map[string]Session{}
+				}
+				idle[key][name] = session
+			}
+		}
+		return true
+	})
+
+	count := 0
+	var errs []error
+	for key, sessions := range idle {
+		var archived []ArchivedSession
+		for name, session := range sessions {
+			data, err := compressSession(session)
+			if err == nil {
+				var location string
+				location, err = writeColdObject(destination, archiveName(key, name, now), data)
+				if err == nil {
+					archived = append(archived, ArchivedSession{Name: name, Location: location, Turns: len(session.Turns), UpdatedAt: session.UpdatedAt, ArchivedAt: now})
+					continue
+				}
+			}
+			errs = append(errs, fmt.Errorf("error archiving a session of %s: %w", key, err))
+		}
+		if len(archived) == 0 {
+			continue
+		}
+		// Only drop sessions nobody wrote to while they were being archived
+		var kept, stale []ArchivedSession
+		err := conversations.Update(key, func(user UserSessions) UserSessions {
+			user = user.cloned()
+			kept, stale = nil, nil
+			for _, entry := range archived {
+				if session, ok := user.Sessions[entry.Name]; ok && session.UpdatedAt.Equal(entry.UpdatedAt) {
+					delete(user.Sessions, entry.Name)
+					kept = append(kept, entry)
+				} else {
+					stale = append(stale, entry)
+				}
+			}
+			if _, ok := user.Sessions[user.Active]; !ok {
+				user.Active = ""
+			}
+			return user
+		})
+		if err == nil {
+			err = archivedSessions.Update(key, func(entries []ArchivedSession) []ArchivedSession {
+				return append(append([]ArchivedSession{}, entries...), kept...)
+			})
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		count += len(kept)
+		for _, entry := range stale {
+			if err := deleteColdObject(entry.Location); err != nil {
+				log.Printf("Error deleting the archive of a session in use: %v", err)
+			}
+		}
+	}
+	return count, errors.Join(errs...)
+}
+
+// PruneArchives deletes archived sessions last updated before cutoff, so archival doesn't
+// extend the conversation retention period.
+func PruneArchives(cutoff time.Time) (int, error) {
+	if archivedSessions == nil {
+		return 0, nil
+	}
+	var keys []string
+	archivedSessions.Range(func(key string, entries []ArchivedSession) bool {
+		for _, entry := range entries {
+			if entry.UpdatedAt.Before(cutoff) {
+				keys = append(keys, key)
+				break
+			}
+		}
+		return true
+	})
+	count := 0
+	for _, key := range keys {
+		n, err := deleteArchives(key, func(entry ArchivedSession) bool { return entry.UpdatedAt.Before(cutoff) })
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// DeleteArchivedSessions deletes all of a user's archived sessions, with the conversation memory.
+func DeleteArchivedSessions(userID int64) error {
+	if archivedSessions == nil {
+		return nil
+	}
+	_, err := deleteArchives(ChatKey(userID), func(ArchivedSession) bool { return true })
+	return err
+}
+
+// deleteArchives deletes the archived sessions of a user that match, keeping the index entries
+// of those whose archive couldn't be deleted so a later run retries.
+func deleteArchives(key string, match func(ArchivedSession) bool) (int, error) {
+	entries, _ := archivedSessions.Get(key)
+	var kept []ArchivedSession
+	var firstErr error
+	for _, entry := range entries {
+		if !match(entry) {
+			kept = append(kept, entry)
+			continue
+		}
+		if err := deleteColdObject(entry.Location); err != nil {
+			kept = append(kept, entry)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	deleted := len(entries) - len(kept)
+	var err error
+	if len(kept) == 0 {
+		err = archivedSessions.Delete(key)
+	} else {
+		err = archivedSessions.Set(key, kept)
+	}
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, firstErr
+}
+
+// RestoreArchivedSession brings an archived session back into the user's conversations, under
+// "<name>-restored" if the user has started a session of the same name since. An empty name
+// restores every archived session of the user. It returns the names restored as.
+func RestoreArchivedSession(userID int64, name string) ([]string, error) {
+	key := ChatKey(userID)
+	entries, _ := archivedSessions.Get(key)
+	var restored []string
+	for _, entry := range entries {
+		if name != "" && !strings.EqualFold(entry.Name, name) {
+			continue
+		}
+		data, err := readColdObject(entry.Location)
+		if err != nil {
+			return restored, err
+		}
+		session, err := decompressSession(data)
+		if err != nil {
+			return restored, err
+		}
+		var restoredAs string
+		err = conversations.Update(key, func(user UserSessions) UserSessions {
+			user = user.cloned()
+			if len(user.Sessions) >= MaxSessionsPerUser {
+				return user
+			}
+			restoredAs = entry.Name
+			if _, exists := user.Sessions[restoredAs]; exists {
+				restoredAs = string([]rune(entry.Name)[:min(len([]rune(entry.Name)), 23)]) + "-restored"
+			}
+			// Restored sessions count as fresh, or the next run would archive them again
+			session.UpdatedAt = time.Now()
+			user.Sessions[restoredAs] = session
+			return user
+		})
+		if err != nil {
+			return restored, err
+		}
+		if restoredAs == "" {
+			return restored, fmt.Errorf("the user already has %d sessions", MaxSessionsPerUser)
+		}
+		restored = append(restored, restoredAs)
+		location := entry.Location
+		if _, err := deleteArchives(key, func(e ArchivedSession) bool { return e.Location == location }); err != nil {
+			log.Printf("Error deleting restored archive %s: %v", location, err)
+		}
+	}
+	if len(restored) == 0 {
+		return nil, fmt.Errorf("no archived session matches")
+	}
+	return restored, nil
+}
+
+// ScheduleArchival archives idle sessions every night when CONVERSATION_ARCHIVE is set.
+func ScheduleArchival() {
+	if ArchiveDestination() == "" {
+		return
+	}
+	ScheduleJob(Job{
+		Name:       "archive",
+		Schedule:   DailyAt(3),
+		Jitter:     10 * time.Minute,
+		Retries:    2,
+		RetryDelay: 15 * time.Minute,
+		Run: func(time.Time) error {
+			count, err := ArchiveIdleSessions(time.Now())
+			if count > 0 {
+				log.Printf("Archived %d idle conversation sessions", count)
+			}
+			if err != nil {
+				return ReportError(fmt.Errorf("error archiving conversations: %w", err), ErrorContext{Command: "archive"})
+			}
+			return nil
+		},
+	})
+}
+
+const archiveUsage = "Usage:\n/archive — archival status\n/archive list &lt;user id&gt;\n/archive restore &lt;user id&gt; [session]\n/archive run"
+
+// FormatArchiveStatus summarizes the hot and archived conversation sessions.
+func FormatArchiveStatus() string {
+	destination := ArchiveDestination()
+	if destination == "" {
+		return "Conversation archival is off. Set CONVERSATION_ARCHIVE to a directory or s3://bucket/prefix to turn it on."
+	}
+	hot, users, archived := 0, 0, 0
+	conversations.Range(func(_ string, user UserSessions) bool {
+		hot += len(user.Sessions)
+		return true
+	})
+	archivedSessions.Range(func(_ string, entries []ArchivedSession) bool {
+		users++
+		archived += len(entries)
+		return true
+	})
+	return fmt.Sprintf("🗄 <b>Conversation archive</b>\nDestination: <code>%s</code>\nArchived after %s idle\n\nActive sessions: %d\nArchived: %s of %s\n\n%s",
+		html.EscapeString(destination), formatDays(archiveAfter()), hot, countOf(archived, "session"), countOf(users, "user"), archiveUsage)
+}
+
+// FormatArchivedSessions lists a user's archived sessions, oldest first.
+func FormatArchivedSessions(userID int64) string {
+	entries, _ := archivedSessions.Get(ChatKey(userID))
+	if len(entries) == 0 {
+		return "No archived sessions for this user."
+	}
+	entries = append([]ArchivedSession{}, entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UpdatedAt.Before(entries[j].UpdatedAt) })
+	lines := []string{fmt.Sprintf("🗄 <b>Archived sessions of %d</b>", userID)}
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("• <b>%s</b>: %s, last used %s, archived %s", html.EscapeString(entry.Name),
+			countOf(entry.Turns, "turn"), entry.UpdatedAt.UTC().Format("2006-01-02"), entry.ArchivedAt.UTC().Format("2006-01-02")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HandleArchiveCommand lets bot admins inspect conversation archival and restore archived sessions.
+func HandleArchiveCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return SendHTML(bot, chatID, FormatArchiveStatus())
+	}
+	if fields[0] == "run" {
+		if err := RunJobNow("archive"); err != nil {
+			return SendHTML(bot, chatID, html.EscapeString(err.Error()))
+		}
+		return SendHTML(bot, chatID, "Archival will run within a few seconds.")
+	}
+	if len(fields) < 2 {
+		return SendHTML(bot, chatID, archiveUsage)
+	}
+	userID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return SendHTML(bot, chatID, archiveUsage)
+	}
+	switch fields[0] {
+	case "list":
+		return SendHTML(bot, chatID, FormatArchivedSessions(userID))
+	case "restore":
+		restored, err := RestoreArchivedSession(userID, strings.Join(fields[2:], " "))
+		reply := ""
+		if len(restored) > 0 {
+			reply = "Restored: " + html.EscapeString(strings.Join(restored, ", ")) + "\n"
+		}
+		if err != nil {
+			reply += html.EscapeString(err.Error())
+		}
+		return SendHTML(bot, chatID, strings.TrimSpace(reply))
+	}
+	return SendHTML(bot, chatID, archiveUsage)
+}
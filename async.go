@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Reasons an answer is predicted to be slow.
+const (
+	SlowLongText  = "long"
+	SlowBatch     = "batch"
+	SlowEnsemble  = "ensemble"
+	SlowQueue     = "queue"
+	SlowLabResult = "lab_result"
+)
+
+const (
+	// asyncBatchQuestions is how many questions in one message make it slow.
+	asyncBatchQuestions = 3
+	// asyncQueueWait is the expected queue wait that makes an answer slow.
+	asyncQueueWait = time.Minute
+)
+
+// asyncAnswers are the acknowledgements of answers delivered as a new reply, by thinkingKey, with
+// the reason they were predicted slow.
+var asyncAnswers = NewBoundedMap[string, string]("async_answers", 10000, time.Hour)
+
+// asyncAnswersEnabled is false with ASYNC_ANSWERS=off, which edits every answer into its
+// thinking message.
+func asyncAnswersEnabled() bool {
+	return !strings.EqualFold(GetenvVar("ASYNC_ANSWERS", false), "off")
+}
+
+// asyncAnswerChars is ASYNC_ANSWER_CHARS (default 1500): questions or forwarded texts at least this
+// long are answered asynchronously.
+func asyncAnswerChars() int {
+	chars, err := strconv.Atoi(GetenvVar("ASYNC_ANSWER_CHARS", false))
+	if err != nil || chars <= 0 {
+		return 1500
+	}
+	return chars
+}
+
+// SlowAnswerReason predicts whether answering a question will take long enough that the asker
+// shouldn't have to watch the thinking message, and why. It returns "" for ordinary questions.
+func SlowAnswerReason(message *tgbotapi.Message, question string) string {
+	if !asyncAnswersEnabled() {
+		return ""
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	forwarded, hasForward := forwardedContext(message)
+	switch {
+	case utf8.RuneCountInString(question) >= asyncAnswerChars(),
+		hasForward && utf8.RuneCountInString(forwarded.Content) >= asyncAnswerChars():
+		return SlowLongText
+	case len(SplitQuestions(question)) >= asyncBatchQuestions:
+		return SlowBatch
+	case EnsembleEnabled(message.Chat.ID, userID):
+		return SlowEnsemble
+	case askPool != nil && askPool.EstimatedWait(askPool.QueueDepth()+1) >= asyncQueueWait:
+		return SlowQueue
+	}
+	return ""
+}
+
+// AsyncAckText acknowledges a slow request right away.
+func AsyncAckText(reason string) string {
+	switch reason {
+	case SlowLongText:
+		return "📄 That's a lot to read. I'm working on it and will reply with the answer when it's ready, no need to wait here."
+	case SlowBatch:
+		return "📝 Several questions at once take a little longer. I'll reply with the answers when they're ready."
+	case SlowQueue:
+		return "⏳ I'm busy right now. Your question is in the queue and I'll reply with the answer when it's ready."
+	case SlowLabResult:
+		return "🔬 Reading your report, this can take a minute. I'll reply with what I find."
+	default:
+		return "⏳ This one takes a bit longer. I'll reply with the answer when it's ready."
+	}
+}
+
+// asyncDoneText replaces the acknowledgement once the answer was sent.
+const asyncDoneText = "✅ Answered in the reply below."
+
+// asyncFailedText tells the asker a background answer failed, as a reply so they notice.
+const asyncFailedText = "Sorry, I couldn't finish that answer. Please try asking again in a few minutes."
+
+// asyncFailedAckText replaces the acknowledgement of a failed answer, pointing to the reply.
+const asyncFailedAckText = "❌ Failed, see below."
+
+// MarkAsyncAnswer records that the answer acknowledged by messageID is delivered as a new reply.
+func MarkAsyncAnswer(chatID int64, messageID int, reason string) {
+	asyncAnswers.Set(thinkingKey(chatID, messageID), reason)
+}
+
+// IsAsyncAnswer reports whether the answer acknowledged by messageID is delivered as a new reply.
+func IsAsyncAnswer(chatID int64, messageID int) bool {
+	_, ok := asyncAnswers.Get(thinkingKey(chatID, messageID))
+	return ok
+}
+
+// StartAsyncReply sends the message a background answer is edited into, as a reply to the
+// question so the asker gets a notification, and marks the acknowledgement as done. The answer
+// is then edited in right away, long before an edit could time out.
+func StartAsyncReply(bot *tgbotapi.BotAPI, message *tgbotapi.Message, ackID int) (int, error) {
+	reply := tgbotapi.NewMessage(message.Chat.ID, "✅ Your answer is ready")
+	reply.ReplyToMessageID = message.MessageID
+	reply.AllowSendingWithoutReply = true
+	sent, err := bot.Send(reply)
+	if err != nil {
+		return 0, err
+	}
+	if err := EditMessageHTML(bot, message.Chat.ID, ackID, asyncDoneText, nil, nil); err != nil {
+		log.Printf("Error updating async acknowledgement: %v", err)
+	}
+	return sent.MessageID, nil
+}
+
+// FinishAsyncAnswer forgets an async answer, and tells the asker when it failed.
+func FinishAsyncAnswer(bot *tgbotapi.BotAPI, message *tgbotapi.Message, ackID int, err error) {
+	key := thinkingKey(message.Chat.ID, ackID)
+	if _, ok := asyncAnswers.LoadAndDelete(key); !ok || err == nil {
+		return
+	}
+	reply := tgbotapi.NewMessage(message.Chat.ID, asyncFailedText)
+	reply.ReplyToMessageID = message.MessageID
+	reply.AllowSendingWithoutReply = true
+	if _, sendErr := bot.Send(reply); sendErr != nil {
+		log.Printf("Error reporting failed async answer in chat %d: %v", message.Chat.ID, sendErr)
+	}
+	if editErr := EditMessageHTML(bot, message.Chat.ID, ackID, asyncFailedAckText, nil, nil); editErr != nil {
+		log.Printf("Error updating async acknowledgement: %v", editErr)
+	}
+}
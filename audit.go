@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one sampled backend exchange, kept for offline evaluation of answer quality.
+type AuditRecord struct {
+	At time.Time `json:"at"`
+	// User and Chat are pseudonymized with HashUserID
+	User      string          `json:"user"`
+	Chat      string          `json:"chat"`
+	Mode      string          `json:"mode"`
+	Request   PromptRequest   `json:"request"`
+	Response  *PromptResponse `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	LatencyMs int64           `json:"latency_ms"`
+}
+
+// auditMu serializes appends so concurrent answers don't interleave lines.
+var auditMu sync.Mutex
+
+// AuditSampleRate is the fraction of backend exchanges written to the audit log, read from
+// AUDIT_SAMPLE_RATE. It defaults to 0, which turns the audit log off.
+func AuditSampleRate() float64 {
+	rate, err := strconv.ParseFloat(GetenvVar("AUDIT_SAMPLE_RATE", false), 64)
+	if err != nil || rate <= 0 {
+		return 0
+	}
+	return min(rate, 1)
+}
+
+// SampleAudit decides whether the current exchange goes to the audit log.
+func SampleAudit() bool {
+	rate := AuditSampleRate()
+	return rate > 0 && rand.Float64() < rate
+}
+
+func auditDir() string {
+	return filepath.Join(DataDir(), "audit")
+}
+
+// WriteAudit appends a record to <DATA_DIR>/audit/audit-<date>.jsonl.
+func WriteAudit(record AuditRecord) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if err := os.MkdirAll(auditDir(), 0o755); err != nil {
+		return fmt.Errorf("error creating audit dir: %w", err)
+	}
+	path := filepath.Join(auditDir(), "audit-"+record.At.UTC().Format("2006-01-02")+".jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening audit file: %w", err)
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(record); err != nil {
+		return fmt.Errorf("error writing audit record: %w", err)
+	}
+	return nil
+}
+
+// AuditExchange samples a finished backend call into the audit log. Chats in privacy mode are
+// never audited.
+func AuditExchange(chatID, userID int64, mode string, request PromptRequest, response *PromptResponse, callErr error, started time.Time) {
+	if PrivacyEnabled(chatID) || !SampleAudit() {
+		return
+	}
+	record := AuditRecord{
+		At:        started,
+		User:      HashUserID(userID),
+		Chat:      HashUserID(chatID),
+		Mode:      mode,
+		Request:   request,
+		Response:  response,
+		LatencyMs: time.Since(started).Milliseconds(),
+	}
+	if callErr != nil {
+		record.Error = callErr.Error()
+	}
+	if err := WriteAudit(record); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// pruneAuditFiles deletes audit files from before cutoff and returns how many there were.
+func pruneAuditFiles(cutoff time.Time) (int, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	entries, err := os.ReadDir(auditDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		date, ok := strings.CutPrefix(strings.TrimSuffix(entry.Name(), ".jsonl"), "audit-")
+		day, err := time.Parse("2006-01-02", date)
+		// A file holds a whole day, so it goes once the day has fully passed the cutoff
+		if !ok || err != nil || !day.Add(24*time.Hour).Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(auditDir(), entry.Name())); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Backend response schema versions this bot understands. Version 0 is the original
+// unversioned response that only carries "assistant".
+var supportedSchemaVersions = map[int]bool{0: true, 1: true}
+
+// HistoryMessage is one prior message of the conversation sent as context.
+type HistoryMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// PromptRequest is the body of a /prompt call.
+type PromptRequest struct {
+	Question     string           `json:"question"`
+	Temperature  float64          `json:"temperature"`
+	Tokens       int              `json:"tokens"`
+	SystemPrompt string           `json:"system_prompt,omitempty"`
+	History      []HistoryMessage `json:"history,omitempty"`
+}
+
+// Moderation is the backend's verdict on the question.
+type Moderation struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// PromptResponse is the body returned by /prompt.
+type PromptResponse struct {
+	SchemaVersion int         `json:"schema_version,omitempty"`
+	Assistant     string      `json:"assistant"`
+	Error         string      `json:"error,omitempty"`
+	Refusal       string      `json:"refusal,omitempty"`
+	Moderation    *Moderation `json:"moderation,omitempty"`
+	// Stopped marks a streamed answer cut short by the asker; Assistant holds what arrived
+	Stopped bool `json:"-"`
+}
+
+// BackendError is an error the backend reported in its response.
+type BackendError struct {
+	Status  int
+	Message string
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("backend error (status %d): %s", e.Status, e.Message)
+}
+
+// SchemaError means the backend response didn't match the expected schema.
+type SchemaError struct {
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return "unexpected API response format: " + e.Reason
+}
+
+// Validate checks that the response is something the bot can act on.
+func (r *PromptResponse) Validate() error {
+	if !supportedSchemaVersions[r.SchemaVersion] {
+		return &SchemaError{Reason: fmt.Sprintf("unsupported schema_version %d", r.SchemaVersion)}
+	}
+	if r.Error != "" || r.Refusal != "" || (r.Moderation != nil && r.Moderation.Flagged) {
+		return nil
+	}
+	if r.Assistant == "" {
+		return &SchemaError{Reason: "missing assistant answer"}
+	}
+	return nil
+}
+
+// Refused reports whether the backend declined to answer, either explicitly or through moderation.
+func (r *PromptResponse) Refused() bool {
+	return r.Refusal != "" || (r.Moderation != nil && r.Moderation.Flagged && r.Assistant == "")
+}
+
+// Text returns what should be shown to the user.
+func (r *PromptResponse) Text() string {
+	switch {
+	case r.Refusal != "":
+		return r.Refusal
+	case r.Moderation != nil && r.Moderation.Flagged && r.Assistant == "":
+		return ModerationMessage
+	default:
+		return r.Assistant
+	}
+}
+
+// logSchemaMismatch records a response the bot couldn't use, with its raw payload.
+func logSchemaMismatch(err error, raw []byte) {
+	log.Printf("Backend schema mismatch: %v; raw payload: %s", err, Truncate(string(raw), 2000))
+}
+
+// Prompt sends a typed request to a PsyAI API endpoint and returns its validated response.
+// Everything but the ensemble, which picks models by endpoint, goes through Ask instead.
+func Prompt(apiURL string, request PromptRequest) (*PromptResponse, error) {
+	return promptContext(context.Background(), apiURL, request)
+}
+
+func promptContext(ctx context.Context, apiURL string, request PromptRequest) (*PromptResponse, error) {
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := backendClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading API response: %w", err)
+	}
+	if len(raw) > maxResponseBytes {
+		return nil, fmt.Errorf("API response exceeds %d bytes", maxResponseBytes)
+	}
+
+	var response PromptResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, &BackendError{Status: resp.StatusCode, Message: Truncate(string(raw), 200)}
+		}
+		schemaErr := &SchemaError{Reason: err.Error()}
+		logSchemaMismatch(schemaErr, raw)
+		return nil, schemaErr
+	}
+	if response.Error != "" || resp.StatusCode >= 400 {
+		message := response.Error
+		if message == "" {
+			message = resp.Status
+		}
+		return nil, &BackendError{Status: resp.StatusCode, Message: message}
+	}
+	if err := response.Validate(); err != nil {
+		logSchemaMismatch(err, raw)
+		return nil, err
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backupManifestName = "manifest.json"
+
+// BackupManifest lists every store file in a snapshot with its checksum.
+type BackupManifest struct {
+	CreatedAt time.Time    `json:"created_at"`
+	Files     []BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupDestination is where snapshots are written: a local directory or "s3://bucket/prefix".
+// Nightly backups are disabled when it is empty.
+func BackupDestination() string {
+	return GetenvVar("BACKUP_DESTINATION", false)
+}
+
+// storeFiles returns the store files currently in DataDir.
+func storeFiles() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(DataDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// CreateSnapshot archives every store in DataDir into a gzipped tarball with a manifest.
+// Stores are saved with an atomic rename, so each file is read in a consistent state.
+func CreateSnapshot() ([]byte, error) {
+	paths, err := storeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("error listing stores: %w", err)
+	}
+
+	manifest := BackupManifest{CreatedAt: time.Now().UTC()}
+	contents := map[string][]byte{}
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		name := filepath.Base(path)
+		contents[name] = raw
+		manifest.Files = append(manifest.Files, BackupFile{Name: name, Size: int64(len(raw)), SHA256: sha256Hex(raw)})
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(backupManifestName, manifestJSON); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+	for _, file := range manifest.Files {
+		if err := write(file.Name, contents[file.Name]); err != nil {
+			return nil, fmt.Errorf("error writing snapshot: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifySnapshot checks a snapshot against its manifest and returns the store files it contains.
+func VerifySnapshot(snapshot []byte) (*BackupManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(snapshot))
+	if err != nil {
+		return nil, nil, fmt.Errorf("snapshot is not gzipped: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *BackupManifest
+	files := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading snapshot: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s from snapshot: %w", header.Name, err)
+		}
+		if header.Name == backupManifestName {
+			manifest = &BackupManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("error decoding manifest: %w", err)
+			}
+			continue
+		}
+		files[header.Name] = data
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("snapshot has no %s", backupManifestName)
+	}
+
+	for _, file := range manifest.Files {
+		// Only plain file names are restored; anything else could escape DataDir
+		if file.Name != filepath.Base(file.Name) || !strings.HasSuffix(file.Name, ".json") {
+			return nil, nil, fmt.Errorf("invalid file name %q in manifest", file.Name)
+		}
+		data, ok := files[file.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is listed in the manifest but missing", file.Name)
+		}
+		if int64(len(data)) != file.Size || sha256Hex(data) != file.SHA256 {
+			return nil, nil, fmt.Errorf("%s does not match its checksum", file.Name)
+		}
+		if !json.Valid(data) {
+			return nil, nil, fmt.Errorf("%s is not valid JSON", file.Name)
+		}
+	}
+	if len(files) != len(manifest.Files) {
+		return nil, nil, fmt.Errorf("snapshot contains files not listed in the manifest")
+	}
+	return manifest, files, nil
+}
+
+func snapshotName(at time.Time) string {
+	return "psyai-backup-" + at.UTC().Format("20060102T150405Z") + ".tar.gz"
+}
+
+func parseS3Location(location string) (bucket, key string) {
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	return bucket, key
+}
+
+// Backup writes a new snapshot to BackupDestination and returns its location.
+func Backup() (string, error) {
+	destination := BackupDestination()
+	if destination == "" {
+		return "", fmt.Errorf("BACKUP_DESTINATION is not configured")
+	}
+	snapshot, err := CreateSnapshot()
+	if err != nil {
+		return "", err
+	}
+	name := snapshotName(time.Now())
+
+	if strings.HasPrefix(destination, "s3://") {
+		bucket, prefix := parseS3Location(destination)
+		key := strings.TrimSuffix(prefix, "/") + "/" + name
+		key = strings.TrimPrefix(key, "/")
+		if err := S3ClientFromEnv().PutObject(bucket, key, snapshot); err != nil {
+			return "", err
+		}
+		return "s3://" + bucket + "/" + key, nil
+	}
+
+	if err := os.MkdirAll(destination, 0o700); err != nil {
+		return "", fmt.Errorf("error creating backup dir: %w", err)
+	}
+	path := filepath.Join(destination, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, snapshot, 0o600); err != nil {
+		return "", fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("error writing snapshot: %w", err)
+	}
+	pruneLocalBackups(destination)
+	return path, nil
+}
+
+// pruneLocalBackups keeps the newest BACKUP_KEEP snapshots (default 14) in a local destination.
+// S3 destinations are expected to use a bucket lifecycle rule instead.
+func pruneLocalBackups(dir string) {
+	keep, err := strconv.Atoi(GetenvVar("BACKUP_KEEP", false))
+	if err != nil || keep <= 0 {
+		keep = 14
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "psyai-backup-*.tar.gz"))
+	if err != nil || len(paths) <= keep {
+		return
+	}
+	// Names embed the UTC timestamp, so lexical order is chronological
+	sort.Strings(paths)
+	for _, path := range paths[:len(paths)-keep] {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error pruning backup %s: %v", path, err)
+		}
+	}
+}
+
+// ReadSnapshot loads a snapshot from a local path or "s3://bucket/key".
+func ReadSnapshot(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "s3://") {
+		bucket, key := parseS3Location(location)
+		return S3ClientFromEnv().GetObject(bucket, key)
+	}
+	snapshot, err := os.ReadFile(location)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot verifies a snapshot and replaces the stores in DataDir with it. The current
+// files are moved to <DATA_DIR>/pre-restore-<time>/ first. The bot must not be running, since
+// it keeps stores in memory and would overwrite the restored files.
+func RestoreSnapshot(location string) (*BackupManifest, error) {
+	snapshot, err := ReadSnapshot(location)
+	if err != nil {
+		return nil, err
+	}
+	manifest, files, err := VerifySnapshot(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot failed verification: %w", err)
+	}
+
+	current, err := storeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("error listing stores: %w", err)
+	}
+	if len(current) > 0 {
+		asideDir := filepath.Join(DataDir(), "pre-restore-"+time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.MkdirAll(asideDir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creating %s: %w", asideDir, err)
+		}
+		for _, path := range current {
+			if err := os.Rename(path, filepath.Join(asideDir, filepath.Base(path))); err != nil {
+				return nil, fmt.Errorf("error moving %s aside: %w", path, err)
+			}
+		}
+		log.Printf("Moved %d existing stores to %s", len(current), asideDir)
+	}
+
+	if err := os.MkdirAll(DataDir(), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating data dir: %w", err)
+	}
+	for _, file := range manifest.Files {
+		path := filepath.Join(DataDir(), file.Name)
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, files[file.Name], 0o600); err != nil {
+			return nil, fmt.Errorf("error writing %s: %w", file.Name, err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return nil, fmt.Errorf("error writing %s: %w", file.Name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// ScheduleBackups snapshots the stores every night at BACKUP_HOUR UTC (default 4).
+func ScheduleBackups() {
+	if BackupDestination() == "" {
+		return
+	}
+	hour, err := strconv.Atoi(GetenvVar("BACKUP_HOUR", false))
+	if err != nil || hour < 0 || hour > 23 {
+		hour = 4
+	}
+
+	ScheduleJob(Job{
+		Name:     "backup",
+		Schedule: DailyAt(hour),
+		// Several instances backing up to the same bucket shouldn't all upload at once
+		Jitter:     10 * time.Minute,
+		Retries:    2,
+		RetryDelay: 15 * time.Minute,
+		Run: func(time.Time) error {
+			location, err := Backup()
+			if err != nil {
+				return ReportError(fmt.Errorf("error backing up stores: %w", err), ErrorContext{Command: "backup"})
+			}
+			log.Printf("Backed up stores to %s", location)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MaxBatchQuestions caps how many questions from one message are answered separately.
+const MaxBatchQuestions = 4
+
+var listItemPattern = regexp.MustCompile(`^\s*(\d{1,2}[.)]|[-•*])\s+(.+)$`)
+
+// SplitQuestions splits a message into separate questions when it is a numbered or bulleted
+// list, or when every line is a question of its own. Anything else is one question, so a
+// question with a few lines of context isn't torn apart.
+func SplitQuestions(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 2 {
+		return []string{text}
+	}
+
+	var items []string
+	var intro string
+	for _, line := range lines {
+		if match := listItemPattern.FindStringSubmatch(line); match != nil {
+			items = append(items, match[2])
+		} else if len(items) > 0 {
+			items[len(items)-1] += " " + line
+		} else {
+			intro += line + " "
+		}
+	}
+	if len(items) >= 2 {
+		// A lead-in like "I'm taking sertraline." applies to every item
+		for i := range items {
+			items[i] = strings.TrimSpace(intro + items[i])
+		}
+		return capBatch(items)
+	}
+
+	for _, line := range lines {
+		if !strings.HasSuffix(line, "?") {
+			return []string{text}
+		}
+	}
+	return capBatch(lines)
+}
+
+func capBatch(questions []string) []string {
+	if len(questions) > MaxBatchQuestions {
+		return questions[:MaxBatchQuestions]
+	}
+	return questions
+}
+
+// PromptBatch answers the questions concurrently and combines the answers into one response
+// with a heading per question. It fails only if every question failed.
+func PromptBatch(request PromptRequest, questions []string) (*PromptResponse, error) {
+	// Keep the combined answer within one Telegram message
+	tokens := request.Tokens / len(questions)
+	if tokens < 250 {
+		tokens = 250
+	}
+
+	answers := make([]string, len(questions))
+	errs := make([]error, len(questions))
+	var wg sync.WaitGroup
+	for i, question := range questions {
+		wg.Add(1)
+		go func(i int, question string) {
+			defer wg.Done()
+			single := request
+			single.Question = question
+			single.Tokens = tokens
+			response, err := Ask(single)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			answers[i] = response.Text()
+		}(i, question)
+	}
+	wg.Wait()
+
+	var sections []string
+	failed := 0
+	for i, question := range questions {
+		answer := answers[i]
+		if errs[i] != nil {
+			failed++
+			log.Printf("Error answering batch question %d: %v", i+1, errs[i])
+			answer = "_I couldn't answer this one, please ask it again on its own._"
+		}
+		sections = append(sections, fmt.Sprintf("## %d. %s\n%s", i+1, question, strings.TrimSpace(answer)))
+	}
+	if failed == len(questions) {
+		return nil, errs[0]
+	}
+	return &PromptResponse{Assistant: strings.Join(sections, "\n\n")}, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const MaxBookmarksPerUser = 100
+
+// Bookmark is an answer a user saved with 🔖 or /save.
+type Bookmark struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	SavedAt   time.Time `json:"saved_at"`
+}
+
+var bookmarks *JSONStore[[]Bookmark]
+
+// Bookmarks returns a user's saved answers, newest last.
+func Bookmarks(userID int64) []Bookmark {
+	if bookmarks == nil {
+		return nil
+	}
+	saved, _ := bookmarks.Get(ChatKey(userID))
+	return saved
+}
+
+// SaveBookmark stores an answer for the user. It reports false if it was already saved.
+func SaveBookmark(userID int64, bookmark Bookmark) (bool, error) {
+	added := true
+	err := bookmarks.Update(ChatKey(userID), func(saved []Bookmark) []Bookmark {
+		for _, existing := range saved {
+			if existing.ChatID == bookmark.ChatID && existing.MessageID == bookmark.MessageID {
+				added = false
+				return saved
+			}
+		}
+		saved = append(append([]Bookmark{}, saved...), bookmark)
+		if len(saved) > MaxBookmarksPerUser {
+			saved = saved[len(saved)-MaxBookmarksPerUser:]
+		}
+		return saved
+	})
+	return added, err
+}
+
+// RemoveBookmark deletes the bookmark at index (0 is the newest).
+func RemoveBookmark(userID int64, index int) error {
+	return bookmarks.Update(ChatKey(userID), func(saved []Bookmark) []Bookmark {
+		i := len(saved) - 1 - index
+		if i < 0 || i >= len(saved) {
+			return saved
+		}
+		return append(append([]Bookmark{}, saved[:i]...), saved[i+1:]...)
+	})
+}
+
+// bookmarkFromAnswer builds a bookmark from the recorded exchange behind an answer message.
+func bookmarkFromAnswer(chatID int64, messageID int) (Bookmark, bool) {
+	entry, ok := feedback.Get(FeedbackKey(chatID, messageID))
+	if !ok {
+		return Bookmark{}, false
+	}
+	return Bookmark{ChatID: chatID, MessageID: messageID, Question: entry.Question, Answer: entry.Answer, SavedAt: time.Now()}, true
+}
+
+// HandleBookmarkCallback saves an answer from its 🔖 button ("bm:<message id>").
+func HandleBookmarkCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	messageID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	bookmark, ok := bookmarkFromAnswer(query.Message.Chat.ID, messageID)
+	if !ok {
+		return AnswerCallback(bot, query, "This answer is too old to save.")
+	}
+	added, err := SaveBookmark(query.From.ID, bookmark)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return AnswerCallback(bot, query, "Already in your saved answers.")
+	}
+	if query.Message.Chat.IsPrivate() {
+		return AnswerCallback(bot, query, "🔖 Saved. See /saved.")
+	}
+	return AnswerCallback(bot, query, "🔖 Saved. Send me /saved in a private chat to see it.")
+}
+
+// HandleSaveCommand saves the answer /save replies to.
+func HandleSaveCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsReplyToBot(bot, update.Message) {
+		return SendHTML(bot, chatID, "Reply to one of my answers with /save to bookmark it.")
+	}
+	if PrivacyEnabled(chatID) {
+		return SendHTML(bot, chatID, "🔒 Privacy mode is on in this chat, so answers from it can't be saved.")
+	}
+	reply := update.Message.ReplyToMessage
+	bookmark, ok := bookmarkFromAnswer(chatID, reply.MessageID)
+	if !ok {
+		turn := RepliedTurn(reply)
+		bookmark = Bookmark{ChatID: chatID, MessageID: reply.MessageID, Question: turn.Question, Answer: turn.Answer, SavedAt: time.Now()}
+	}
+	added, err := SaveBookmark(update.Message.From.ID, bookmark)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return SendHTML(bot, chatID, "That answer is already in your saved answers.")
+	}
+	return SendHTML(bot, chatID, "🔖 Saved. Use /saved in a private chat with me to see your saved answers.")
+}
+
+func HandleSavedCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	text, markup := BookmarkPage(update.Message.From.ID, 0)
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleBookmarkPageCallback pages through saved answers ("bms:<page>") and removes them ("bmd:<page>").
+func HandleBookmarkPageCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, remove bool, args []string) error {
+	if query.Message == nil || len(args) != 1 {
+		return AnswerCallback(bot, query, "")
+	}
+	page, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	toast := ""
+	if remove {
+		if err := RemoveBookmark(query.From.ID, page); err != nil {
+			return err
+		}
+		toast = "Removed."
+	}
+
+	text, markup := BookmarkPage(query.From.ID, page)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = markup
+	if _, err := bot.Send(edit); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, toast)
+}
+
+// BookmarkPage renders one saved answer, newest first, with navigation buttons.
+func BookmarkPage(userID int64, page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	saved := Bookmarks(userID)
+	if len(saved) == 0 {
+		return "No saved answers yet. Tap 🔖 under an answer to save it.", nil
+	}
+	if page < 0 {
+		page = 0
+	}
+	if page >= len(saved) {
+		page = len(saved) - 1
+	}
+	bookmark := saved[len(saved)-1-page]
+
+	text := fmt.Sprintf("<b>Saved answer %d/%d</b> · %s\n\n", page+1, len(saved), bookmark.SavedAt.In(UserLocation(userID)).Format("2006-01-02"))
+	if bookmark.Question != "" {
+		text += fmt.Sprintf("<b>%s</b>\n\n", html.EscapeString(Truncate(bookmark.Question, 300)))
+	}
+	text += ConvertToTelegramHTML(html.EscapeString(Truncate(bookmark.Answer, 3000)))
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀ Newer", "bms:"+strconv.Itoa(page-1)))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData("🗑 Remove", "bmd:"+strconv.Itoa(page)))
+	if page < len(saved)-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Older ▶", "bms:"+strconv.Itoa(page+1)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return text, &markup
+}
@@ -1,22 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"html"
 	"log"
-	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"time"
+	"unicode/utf16"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/joho/godotenv"
 )
 
+// GetenvVar returns a configuration value, from the environment, a <key>_FILE secret or the
+// secret manager (see lookupEnv), optionally base64-decoded.
 func GetenvVar(key string, isEnvVarBase64 bool) string {
-	value := os.Getenv(key)
+	value := lookupEnv(key)
 	if !isEnvVarBase64 {
 		return value
 	}
@@ -27,15 +28,36 @@ func GetenvVar(key string, isEnvVarBase64 bool) string {
 	return string(decodedValue)
 }
 
-func DeleteMention(text string, entities []tgbotapi.MessageEntity) string {
+// DeleteMention removes mentions of the bot and bot commands from text. Entity offsets and
+// lengths count UTF-16 code units, so the text is cut as UTF-16 rather than by bytes.
+func DeleteMention(text string, entities []tgbotapi.MessageEntity, botUsername string) string {
+	units := utf16.Encode([]rune(text))
+	remove := make([]bool, len(units))
 	for _, entity := range entities {
-		if entity.Type == "mention" {
-			return text[:entity.Offset] + text[entity.Offset+entity.Length:]
+		start, end := entity.Offset, entity.Offset+entity.Length
+		if start < 0 || end > len(units) || start >= end {
+			continue
+		}
+		ownMention := entity.Type == "mention" && strings.EqualFold(string(utf16.Decode(units[start:end])), "@"+botUsername)
+		if !ownMention && entity.Type != "bot_command" {
+			continue
+		}
+		for i := start; i < end; i++ {
+			remove[i] = true
+		}
+	}
+
+	kept := make([]uint16, 0, len(units))
+	for i, unit := range units {
+		if !remove[i] {
+			kept = append(kept, unit)
 		}
 	}
-	return text
+	return strings.TrimSpace(string(utf16.Decode(kept)))
 }
 
+// ConvertToTelegramHTML converts the Markdown of an answer to Telegram HTML. Answers can also hold
+// raw HTML from the backend, so the result is sanitized.
 func ConvertToTelegramHTML(text string) string {
 	replacements := map[string]string{
 		`## (.*)`:                            "<b>$1</b>",
@@ -64,148 +86,731 @@ func ConvertToTelegramHTML(text string) string {
 		}
 	}
 
-	return text
+	return SanitizeTelegramHTML(text)
 }
 
-func Api(apiURL string, params map[string]interface{}) (map[string]interface{}, error) {
-	jsonBody, err := json.Marshal(params)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling request body: %w", err)
+func HandleInfoCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, drugName string) error {
+	chatID := update.Message.Chat.ID
+	if strings.TrimSpace(drugName) == "" {
+		return SendHTML(bot, chatID, "Usage: /info &lt;substance&gt;, e.g. /info mdma")
 	}
 
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+	matches := ResolveSubstance(drugName)
+	if len(matches) > 0 && matches[0].Confidence >= ConfidentMatch {
+		var userID int64
+		if update.Message.From != nil {
+			userID = update.Message.From.ID
+		}
+		return SendHTML(bot, chatID, FormatSubstanceCard(matches[0].Key, ChatLocale(chatID, userID)))
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making API request: %w", err)
+	if len(matches) == 0 {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't have a factsheet for <b>%s</b> yet.", html.EscapeString(drugName)))
 	}
-	defer resp.Body.Close()
 
-	var apiResponse map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&apiResponse)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding API response: %w", err)
+	// Low confidence: let the user pick
+	var row []tgbotapi.InlineKeyboardButton
+	for i, match := range matches {
+		if i == 3 {
+			break
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(substances[match.Key].Name, "info:"+match.Key))
 	}
-
-	return apiResponse, nil
-}
-
-func HandleStartCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
-	START_TEXT := GetenvVar("START_TEXT", true)
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, START_TEXT)
-	msg.ParseMode = tgbotapi.ModeMarkdown
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Did you mean one of these instead of <b>%s</b>?", html.EscapeString(drugName)))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
 	_, err := bot.Send(msg)
 	return err
 }
 
-func HandleInfoCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, drugName string) error {
-	info_text := drugName
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, info_text)
-	msg.ParseMode = tgbotapi.ModeHTML
-	_, err := bot.Send(msg)
-	return err
+// HandleInfoCallback shows the factsheet picked from the "did you mean" buttons ("info:<key>").
+func HandleInfoCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	if _, ok := substances[args[0]]; !ok {
+		return AnswerCallback(bot, query, "Unknown substance.")
+	}
+
+	quickPrompts.Delete(query.From.ID)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, FormatSubstanceCard(args[0], ChatLocale(query.Message.Chat.ID, query.From.ID)))
+	edit.ParseMode = tgbotapi.ModeHTML
+	if _, err := bot.Send(edit); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
 }
 
 func HandleAskCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, question string) error {
+	// Nothing to ask (stickers, media without a caption, ...)
+	if strings.TrimSpace(question) == "" {
+		return nil
+	}
+
+	// A forward on its own waits for the question about it
+	if update.Message.Chat.IsPrivate() && IsUserForward(update.Message) {
+		if held, err := HoldForward(bot, update.Message); held {
+			return err
+		}
+	}
+
 	// Group context and direct mention
 	if update.Message.Chat.IsGroup() || update.Message.Chat.IsSuperGroup() {
 		botUsername := bot.Self.UserName
 		botMention := "@" + strings.ToLower(botUsername) // Make it lowercase for comparison
 
-		// Check if the message text contains the bot's mention
-		mentioned := strings.Contains(strings.ToLower(update.Message.Text), botMention)
+		// Check if the message text contains the bot's mention or the chat's trigger
+		mentioned := strings.Contains(strings.ToLower(question), botMention) || HasTrigger(update.Message.Chat.ID, question)
+
+		// A direct reply to one of the bot's answers is a follow-up, no mention needed, and so is
+		// any reply in a thread the bot follows
+		mentioned = mentioned || IsReplyToBot(bot, update.Message)
+		if _, following := FollowedThreadOf(update.Message); following {
+			mentioned = true
+		}
 
 		if !mentioned {
-			// Exit if the message is in a group and does not contain the bot's mention
-			return nil
+			// Not addressed to the bot: at most offer factsheets for the substances it names
+			return PromptSubstanceInfo(bot, update.Message, question)
+		}
+	}
+	return askQuestion(bot, update, question)
+}
+
+// HandleExplicitAskCommand answers "/ask <question>", which is addressed to the bot even in groups
+// without a mention.
+func HandleExplicitAskCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	if strings.TrimSpace(args) == "" {
+		return SendHTML(bot, update.Message.Chat.ID, "Usage: /ask &lt;question&gt;")
+	}
+	// The full text keeps the entity offsets valid, answerQuestion strips the command itself
+	question, _ := MessageText(update.Message)
+	return askQuestion(bot, update, question)
+}
+
+func askQuestion(bot *tgbotapi.BotAPI, update tgbotapi.Update, question string) error {
+	var userID int64
+	if update.Message.From != nil {
+		userID = update.Message.From.ID
+	}
+	if reply := QuestionTooLong(question); reply != "" {
+		return SendHTML(bot, update.Message.Chat.ID, html.EscapeString(reply))
+	}
+	if OverQuota(userID) {
+		return SendHTML(bot, update.Message.Chat.ID, QuotaMessage(userID))
+	}
+
+	// Double taps and client retries point to the earlier answer instead of generating another
+	questionKey, recent := ClaimQuestion(update.Message.Chat.ID, userID, question, update.Message.MessageID)
+	if recent != nil {
+		return PointToEarlierAnswer(bot, update, recent)
+	}
+	// Implausible doses are questioned right away, the answer may take a while
+	if err := SendUnitWarnings(bot, update.Message, question); err != nil {
+		log.Printf("Error sending unit warning: %v", err)
+	}
+
+	// Vague dosage questions get a clarifying question first, unless earlier messages give the context
+	if !IsReplyToBot(bot, update.Message) && !hasConversationContext(update.Message) && !hasFollowedContext(update.Message) &&
+		!hasForwardedContext(update.Message) {
+		if missing := ClassifyAmbiguity(question, nil); missing != "" {
+			return AskClarification(bot, update, questionKey, question, missing)
+		}
+	}
+
+	// In groups, members asking what another member just asked get a pointer to that answer.
+	// Questions building on a conversation aren't shared, their answers depend on it
+	chat := update.Message.Chat
+	if (chat.IsGroup() || chat.IsSuperGroup()) && !IsReplyToBot(bot, update.Message) && !hasFollowedContext(update.Message) &&
+		!hasForwardedContext(update.Message) {
+		_, entities := MessageText(update.Message)
+		if earlier := ClaimGroupQuestion(questionKey, chat.ID, userID, DeleteMention(question, entities, bot.Self.UserName)); earlier != nil {
+			ForgetQuestion(questionKey)
+			return PointToGroupAnswer(bot, update, earlier)
 		}
 	}
 
 	// Typing indicator
 	bot.Send(tgbotapi.NewChatAction(update.Message.Chat.ID, tgbotapi.ChatTyping))
 
-	// Send "Thinking..." message
+	// Send "Thinking..." message. Slow requests get an acknowledgement instead and their answer
+	// comes as a new reply
+	slow := SlowAnswerReason(update.Message, question)
 	thinkingMsg := tgbotapi.NewMessage(update.Message.Chat.ID, ThinkingMessage)
+	if slow != "" {
+		thinkingMsg.Text = AsyncAckText(slow)
+	}
 	thinkingMsg.ReplyToMessageID = update.Message.MessageID // Reply to the original message
+	start := func(thinkingMsgSent tgbotapi.Message, err error) error {
+		if err != nil {
+			FinishQuestion(questionKey, err)
+			return err
+		}
+		if slow != "" {
+			MarkAsyncAnswer(update.Message.Chat.ID, thinkingMsgSent.MessageID, slow)
+		}
+		SetQuestionAnswer(questionKey, thinkingMsgSent.MessageID)
+		return StartAnswer(bot, update, questionKey, thinkingMsgSent.MessageID, question)
+	}
 	thinkingMsgSent, err := bot.Send(thinkingMsg)
-	if err != nil {
-		return err
+	if rateLimitWait(err) > 0 {
+		// Wait out the rate limit off the dispatcher, which keeps handling other chats meanwhile
+		go func() {
+			if err := start(SendWaitingOutRateLimits(bot, thinkingMsg)); err != nil {
+				log.Printf("Error answering rate limited question in chat %d: %v", update.Message.Chat.ID, err)
+			}
+		}()
+		return nil
 	}
+	return start(thinkingMsgSent, err)
+}
 
-	apiURL := GetenvVar("BASE_URL_BETA", false) + ApiPromptEndpoint
-	question = DeleteMention(question, update.Message.Entities)
-	requestBody := map[string]interface{}{
-		"question":    question,
-		"temperature": 0.25,
-		"tokens":      1000,
-	}
+// hasFollowedContext reports whether the message replies into a followed thread with earlier turns.
+func hasFollowedContext(message *tgbotapi.Message) bool {
+	thread, ok := FollowedThreadOf(message)
+	return ok && len(thread.Turns) > 0
+}
 
-	apiResponse, err := Api(apiURL, requestBody)
-	if err != nil {
+// hasConversationContext reports whether the asker's conversation memory already holds earlier turns.
+func hasConversationContext(message *tgbotapi.Message) bool {
+	return Allowed(message, CapConversationMemory) && len(ActiveSession(message.From.ID).Turns) > 0
+}
+
+// StartAnswer answers a claimed question into the thinking message, through the ask queue when
+// there is one.
+func StartAnswer(bot *tgbotapi.BotAPI, update tgbotapi.Update, questionKey string, thinkingMsgID int, question string) error {
+	var userID int64
+	if update.Message.From != nil {
+		userID = update.Message.From.ID
+	}
+	release := TrackThinking(update.Message.Chat.ID, thinkingMsgID, userID)
+	if askPool == nil {
+		defer release()
+		err := AnswerQuestion(bot, update, thinkingMsgID, question)
+		FinishQuestion(questionKey, err)
 		return err
 	}
 
-	answer, ok := apiResponse["assistant"].(string)
-	answer = ConvertToTelegramHTML(answer)
-	if !ok {
-		return fmt.Errorf("unexpected API response format")
+	notice := &QueueNotice{bot: bot, chatID: update.Message.Chat.ID, messageID: thinkingMsgID}
+	async := IsAsyncAnswer(update.Message.Chat.ID, thinkingMsgID)
+	job := &AskJob{
+		Run: func() {
+			defer release()
+			notice.Start()
+			err := AnswerQuestion(bot, update, thinkingMsgID, question)
+			FinishQuestion(questionKey, err)
+			if err != nil {
+				log.Printf("Error answering question: %v", err)
+			}
+		},
+		OnPosition: notice.Show,
+	}
+	// The acknowledgement of an async answer already says it will take a while
+	if async {
+		job.OnPosition = nil
+	}
+	if position := askPool.Submit(job); position > 0 && !async {
+		notice.Show(position, askPool.EstimatedWait(position))
 	}
+	return nil
+}
+
+// AnswerQuestion queries the backend and replaces the thinking message with the answer.
+func AnswerQuestion(bot *tgbotapi.BotAPI, update tgbotapi.Update, thinkingMsgID int, question string) error {
+	start := time.Now()
+	cached, err := answerQuestion(bot, update, thinkingMsgID, question)
+	latency := time.Since(start)
+	FinishAsyncAnswer(bot, update.Message, thinkingMsgID, err)
 
-	answerMsg := tgbotapi.NewEditMessageText(update.Message.Chat.ID, thinkingMsgSent.MessageID, answer)
-	answerMsg.ParseMode = tgbotapi.ModeHTML
-	_, err = bot.Send(answerMsg)
+	AddBreadcrumb("backend", "answered question", map[string]interface{}{"latency_ms": latency.Milliseconds(), "failed": err != nil})
+	RecordHandlerRun(bot, "ask", latency, err, fmt.Sprintf("%s chat %d", update.Message.Chat.Type, update.Message.Chat.ID))
+	err = ReportError(err, ErrorContext{Command: "ask", ChatType: update.Message.Chat.Type, BackendLatency: latency})
+
+	event := AskEvent{Latency: latency, Failed: err != nil, Cached: cached, Substances: DetectSubstances(question)}
+	if update.Message.From != nil {
+		event.UserID = update.Message.From.ID
+	}
+	if statsErr := RecordAskEvent(event); statsErr != nil {
+		log.Printf("Error recording stats: %v", statsErr)
+	}
+	EmitEvent(UserEvent(EventQuestionAnswered, event.UserID, update.Message.Chat.ID, update.Message.Chat.Type, map[string]interface{}{
+		"latency_ms": latency.Milliseconds(),
+		"failed":     event.Failed,
+		"cached":     cached,
+		"substances": event.Substances,
+	}))
 	return err
 }
 
-func main() {
-	err := godotenv.Load()
+// answerQuestion reports whether the answer came from the answer cache.
+func answerQuestion(bot *tgbotapi.BotAPI, update tgbotapi.Update, thinkingMsgID int, question string) (bool, error) {
+	_, entities := MessageText(update.Message)
+	question = DeleteMention(question, entities, bot.Self.UserName)
+	question = StripTrigger(update.Message.Chat.ID, question)
+	question, previewMode := ExtractPreviewTag(question)
+	if previewMode == "" {
+		previewMode = GetChatSettings(update.Message.Chat.ID).LinkPreview
+	}
+
+	var userID int64
+	if update.Message.From != nil {
+		userID = update.Message.From.ID
+	}
+	pipeline := NewPipeline(bot, update.Message.Chat.ID)
+	defer pipeline.Finish()
+	// Synthesis, sourcing and trafficking requests never reach the backend
+	moderated := pipeline.Start(StageModeration)
+	category, source, rule := CheckContentGate(question)
+	if category == "" {
+		moderated("allow", nil)
+	} else {
+		moderated("block:"+category+" ("+source+")", nil)
+		log.Printf("Content gate blocked a %s question in chat %d (%s)", category, update.Message.Chat.ID, source)
+		safety := SafetyEvent{Origin: SafetyOriginGate, Category: category, Rule: source + ":" + rule, ChatType: update.Message.Chat.Type}
+		if err := RecordSafetyEvent(userID, safety); err != nil {
+			log.Printf("Error recording safety event: %v", err)
+		}
+		event := GateEvent{ChatID: update.Message.Chat.ID, UserID: userID, Question: question, Category: category, Source: source, At: time.Now()}
+		if PrivacyEnabled(update.Message.Chat.ID) {
+			event.Question = ""
+		}
+		if err := RecordGateEvent(event); err != nil {
+			log.Printf("Error recording gate event: %v", err)
+		}
+		if !update.Message.Chat.IsPrivate() && update.Message.From != nil {
+			action := ModerationAction{ChatID: update.Message.Chat.ID, ChatTitle: update.Message.Chat.Title, Kind: ModerationRefusal, UserID: userID,
+				User: spamSender(update.Message.From), Rule: category, Text: question}
+			if err := RecordModeration(action); err != nil {
+				log.Printf("Error recording refusal: %v", err)
+			}
+		}
+		return false, EditMessageHTML(bot, update.Message.Chat.ID, thinkingMsgID, html.EscapeString(GatedRefusalMessage), &LinkPreviewOptions{IsDisabled: true}, nil)
+	}
+	language := ReplyLanguage(update.Message.Chat.ID, userID)
+	style := AnswerStyle(update.Message.Chat.ID, userID)
+	request := PromptRequest{
+		Question:     question,
+		Temperature:  0.25,
+		SystemPrompt: SystemPrompt() + LanguageInstruction(language),
+	}
+	ApplyStyle(&request, style)
+
+	private := Allowed(update.Message, CapConversationMemory)
+	followUp, isFollowUp := FollowUpTurn(update.Message)
+	if private {
+		session := ActiveSession(update.Message.From.ID)
+		if len(session.Turns) > 0 || session.Summary != "" {
+			request.History = SessionHistory(session)
+		}
+		// A follow-up to an older answer brings that exchange back
+		if isFollowUp && (len(session.Turns) == 0 || session.Turns[len(session.Turns)-1].Answer != followUp.Answer) {
+			request.History = append(request.History, HistoryMessages([]Turn{followUp})...)
+		}
+	} else if isFollowUp {
+		request.History = HistoryMessages([]Turn{followUp})
+	} else if thread, ok := FollowedThreadOf(update.Message); ok && len(thread.Turns) > 0 {
+		request.History = HistoryMessages(thread.Turns)
+	} else if IsReplyToBot(bot, update.Message) {
+		request.History = HistoryMessages([]Turn{RepliedTurn(update.Message.ReplyToMessage)})
+	}
+	// A forwarded message the question is about comes last, right before the question
+	if forwarded, ok := TakeForwardedContext(update.Message); ok {
+		request.History = append(request.History, forwarded)
+	}
+	if pipeline.Enabled(StageRetrieval) {
+		retrieved := pipeline.Start(StageRetrieval)
+		facts, keys := RetrieveReferenceData(question)
+		request.SystemPrompt += facts
+		if len(keys) == 0 {
+			retrieved("none", nil)
+		} else {
+			retrieved(strings.Join(keys, ","), nil)
+		}
+	}
+
+	// Only standalone questions are cached, answers that build on a conversation are not
+	var cacheKey string
+	var cached *CachedAnswer
+	if request.History == nil && AnswerCacheEnabled(update.Message.Chat.ID, userID) && len(SplitQuestions(question)) == 1 {
+		cacheKey = AnswerCacheKey(question, language, style)
+		if hit, ok := answerCache.Get(cacheKey); ok {
+			cached = &hit
+		}
+	}
+
+	// An async answer keeps its acknowledgement until the answer is sent as a new reply
+	async := IsAsyncAnswer(update.Message.Chat.ID, thinkingMsgID)
+	progress := StartProgress(bot, update.Message.Chat.ID, thinkingMsgID, question)
+	defer progress.Stop()
+	if async {
+		progress.Stop()
+	}
+
+	var response *PromptResponse
+	var err error
+	var coalescer *EditCoalescer
+	var ensemble *EnsembleRecord
+	started := time.Now()
+	mode := "prompt"
+	answered := pipeline.Start(StageAnswer)
+	if cached != nil {
+		mode = "cache"
+		response = &PromptResponse{Assistant: cached.Answer}
+	} else if questions := SplitQuestions(question); len(questions) > 1 {
+		// Several questions in one message are answered separately, so they aren't streamed
+		mode = "batch"
+		response, err = PromptBatch(request, questions)
+	} else if EnsembleEnabled(update.Message.Chat.ID, userID) {
+		mode = "ensemble"
+		var record EnsembleRecord
+		response, record, err = PromptEnsemble(GetenvVar("BASE_URL_BETA", false)+ApiPromptEndpoint, request)
+		ensemble = &record
+	} else if StreamingEnabled(update.Message.Chat.ID, userID) && !async {
+		mode = "stream"
+		stop := StopKeyboard(thinkingMsgID)
+		coalescer = NewEditCoalescer(bot, update.Message.Chat.ID, thinkingMsgID, &stop)
+		ctx, done := StartStoppableStream(update.Message.Chat.ID, thinkingMsgID, userID)
+		defer done()
+		response, err = CurrentProvider().Stream(ctx, request, func(answer string) {
+			progress.Stop()
+			coalescer.Update(answer)
+		}, progress.Stage)
+	} else {
+		response, err = Ask(request)
+	}
+	progress.Stop()
+	answered(mode, err)
+	if cached == nil {
+		AuditExchange(update.Message.Chat.ID, userID, mode, request, response, err, started)
+	}
+	if err != nil {
+		return false, err
+	}
+	if response.Refused() {
+		log.Printf("Backend refused question in chat %d", update.Message.Chat.ID)
+		if err := RecordSafetyEvent(userID, BackendRefusalEvent(response, update.Message.Chat.Type)); err != nil {
+			log.Printf("Error recording safety event: %v", err)
+		}
+	}
+	rawAnswer := response.Text()
+	if response.Stopped && strings.TrimSpace(rawAnswer) == "" {
+		return false, EditMessageHTML(bot, update.Message.Chat.ID, thinkingMsgID, "<i>⏹ Stopped before the answer started.</i>", &LinkPreviewOptions{IsDisabled: true}, nil)
+	}
+	// A flagged answer is still sent, with a note, and never cached
+	var recheckNote string
+	if pipeline.Enabled(StageRecheck) && cached == nil && !response.Refused() && !response.Stopped {
+		rechecked := pipeline.Start(StageRecheck)
+		reason, flagged, err := RecheckAnswer(question, rawAnswer)
+		switch {
+		case err != nil:
+			rechecked("", err)
+		case flagged:
+			rechecked("unsafe", nil)
+			recheckNote = RecheckNote(reason)
+			if err := RecordSafetyEvent(userID, SafetyEvent{Origin: SafetyOriginRecheck, Category: "unsafe", Rule: "guard", ChatType: update.Message.Chat.Type}); err != nil {
+				log.Printf("Error recording safety event: %v", err)
+			}
+		default:
+			rechecked("safe", nil)
+		}
+	}
+	// Privacy mode chats get the note rather than having their answer stored for review
+	var doseNote string
+	if mode := DoseCheckMode(); mode != DoseCheckOff && !response.Refused() {
+		if divergences := CrossCheckDoses(question, rawAnswer); len(divergences) > 0 {
+			if mode == DoseCheckBlock && !PrivacyEnabled(update.Message.Chat.ID) {
+				RecordDoseCheck(userID, update.Message.Chat.Type, mode, divergences)
+				if err := HoldAnswer(update.Message.Chat.ID, thinkingMsgID, userID, question, rawAnswer, divergences); err != nil {
+					log.Printf("Error holding answer for review: %v", err)
+				}
+				return false, EditMessageHTML(bot, update.Message.Chat.ID, thinkingMsgID, html.EscapeString(HeldAnswerMessage), &LinkPreviewOptions{IsDisabled: true}, nil)
+			}
+			RecordDoseCheck(userID, update.Message.Chat.Type, DoseCheckNote, divergences)
+			doseNote = DoseCorrectionNote(divergences)
+		}
+	}
+	answerMsgID := thinkingMsgID
+	if async {
+		if answerMsgID, err = StartAsyncReply(bot, update.Message, thinkingMsgID); err != nil {
+			return false, err
+		}
+	}
+	// Long answers continue in replies to the first message, which keeps the buttons; the notes
+	// go at the very end
+	parts, truncated := SplitAnswer(rawAnswer)
+	answer := AnswerPartHTML(parts[0])
+	var continued []string
+	for _, part := range parts[1:] {
+		continued = append(continued, ContinuedPrefix+AnswerPartHTML(part))
+	}
+	notes := doseNote + recheckNote
+	if chat := update.Message.Chat; (chat.IsGroup() || chat.IsSuperGroup()) && recheckNote == "" && !response.Refused() {
+		SetGroupAnswerExcerpt(chat.ID, thinkingMsgID, rawAnswer)
+	}
+	if truncated {
+		notes = TruncatedNote + notes
+	}
+	if response.Stopped {
+		notes += StoppedNote
+	}
+	if Allowed(update.Message, CapNotes) {
+		notes += NotesReminder(userID, DetectSubstances(question), UserLocation(userID))
+	}
+	var staleNote string
+	if cached != nil {
+		staleNote = StaleAnswerNote(*cached, time.Now())
+		notes += staleNote
+	} else if cacheKey != "" && recheckNote == "" && !response.Refused() && !response.Stopped && !PrivacyEnabled(update.Message.Chat.ID) {
+		if err := CacheAnswer(cacheKey, question, rawAnswer, language); err != nil {
+			log.Printf("Error caching answer: %v", err)
+		}
+	}
+
+	if private {
+		if err := RecordTurn(update.Message.From.ID, question, rawAnswer); err != nil {
+			log.Printf("Error recording conversation turn: %v", err)
+		}
+		go CompactSession(update.Message.From.ID)
+	} else {
+		RecordFollowedTurn(update.Message, answerMsgID, question, rawAnswer)
+	}
+
+	// The answer buttons act on the recorded exchange, so privacy mode drops both
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	if PrivacyEnabled(update.Message.Chat.ID) {
+		notes += PrivacyNotice(ReplyLanguage(update.Message.Chat.ID, userID))
+	} else {
+		if err := RecordAnswer(update.Message.Chat.ID, answerMsgID, userID, question, rawAnswer); err != nil {
+			log.Printf("Error recording answer for feedback: %v", err)
+		}
+		if ensemble != nil {
+			if err := RecordEnsemble(update.Message.Chat.ID, answerMsgID, *ensemble); err != nil {
+				log.Printf("Error recording ensemble answers: %v", err)
+			}
+		}
+		markup := AnswerKeyboard(answerMsgID)
+		if staleNote != "" {
+			markup = RefreshKeyboard(answerMsgID)
+		}
+		keyboard = &markup
+	}
+
+	if len(continued) == 0 {
+		answer += notes
+	} else {
+		continued[len(continued)-1] += notes
+	}
+
+	preview := LinkPreviewFor(previewMode, answer)
+	err = DeliverAnswer(bot, update.Message.Chat.ID, answerMsgID, answer, func() error {
+		if coalescer != nil {
+			return coalescer.Flush(answer, preview, keyboard)
+		}
+		return EditMessageHTML(bot, update.Message.Chat.ID, answerMsgID, answer, preview, keyboard)
+	})
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return false, err
+	}
+	if err := SendContinuations(bot, update.Message.Chat.ID, answerMsgID, continued); err != nil {
+		return false, err
+	}
 
+	// Tables and formulas render poorly as text, so they follow as images
+	if err := SendAnswerImages(bot, update.Message.Chat.ID, answerMsgID, rawAnswer); err != nil {
+		log.Printf("Error sending answer images: %v", err)
 	}
+	return cached != nil, nil
+}
 
+func main() {
+	if err := NewRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// NewBotFromEnv authorizes the bot with TELETOKEN.
+func NewBotFromEnv() (*tgbotapi.BotAPI, error) {
 	// Constants
 	TELETOKEN := GetenvVar("TELETOKEN", false)
 
-	bot, err := tgbotapi.NewBotAPI(TELETOKEN)
+	bot, err := tgbotapi.NewBotAPIWithClient(TELETOKEN, tgbotapi.APIEndpoint, TelegramClient())
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-
-	bot.Debug = true
 	log.Printf("Authorized on account %s", bot.Self.UserName)
+	return bot, nil
+}
+
+// RunBot starts the bot and handles updates until the process exits. mode is "polling" or "webhook".
+// Users are only served once the startup self-test passes, unless skipSelfTest is set.
+func RunBot(mode string, skipSelfTest bool) error {
+	if mode != "polling" && mode != "webhook" {
+		return fmt.Errorf("unknown update mode %q, expected polling or webhook", mode)
+	}
+	if err := OpenStores(); err != nil {
+		return err
+	}
 
-	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 60
+	InitErrorTracking()
+	defer FlushErrorTracking()
 
-	updates := bot.GetUpdatesChan(updateConfig)
+	bot, err := NewBotFromEnv()
+	if err != nil {
+		return err
+	}
+	if skipSelfTest {
+		log.Print("Skipping the startup self-test")
+	} else if err := SelfTest(bot, mode); err != nil {
+		return err
+	}
+	// Debug logs every request's parameters, message texts included, so it is opt-in
+	bot.Debug = GetenvVar("TELEGRAM_DEBUG", false) == "true"
+	SweepThinkingMessages(bot)
+
+	askPool = NewWorkerPool(WorkerCountFromEnv())
+	go RegisterBotCommands(bot)
+	ScheduleEviction()
+	StartEventBus()
+	ScheduleRetention()
+	ScheduleFeedbackExport()
+	ScheduleBackups()
+	ScheduleArchival()
+	ScheduleAlertFeeds(bot)
+	ScheduleAlertPinExpiry(bot)
+	ScheduleSpotlights(bot)
+	ScheduleCheckIns(bot)
+	ScheduleWeeklySummaries(bot)
+	ScheduleModerationDigests(bot)
+	StartScheduler()
+
+	if addr := GetenvVar("INTERNAL_HTTP_ADDR", false); addr != "" {
+		StartInternalServer(addr, NewInternalMux())
+	}
+	if addr := GetenvVar("WEBAPP_ADDR", false); addr != "" {
+		StartWebAppServer(addr, bot)
+	} else if mode != "webhook" && WebAppURL() != "" {
+		log.Print("WEBAPP_URL is set but not served: set WEBAPP_ADDR or run in webhook mode")
+	}
+
+	var updates <-chan tgbotapi.Update
+	switch mode {
+	case "webhook":
+		addr := GetenvVar("WEBHOOK_ADDR", false)
+		if addr == "" {
+			addr = ":8443"
+		}
+		if updates, err = WebhookUpdates(bot, GetenvVar("WEBHOOK_URL", false), addr); err != nil {
+			return err
+		}
+	default:
+		// getUpdates is refused while a webhook is registered
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			return fmt.Errorf("error removing webhook: %w", err)
+		}
+		updateConfig := tgbotapi.NewUpdate(0)
+		updateConfig.Timeout = 60
+		updates = PollUpdates(bot, updateConfig)
+	}
 
 	for update := range updates {
-		if update.Message == nil {
-			continue
+		HandleUpdate(bot, update)
+	}
+	return nil
+}
+
+// HandleUpdate routes a single update, reporting errors and recovering from panics.
+func HandleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	context := ErrorContext{}
+	if chat := update.FromChat(); chat != nil {
+		context.ChatType = chat.Type
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			ReportPanic(r, context)
+		}
+	}()
+
+	if update.CallbackQuery != nil {
+		context.Command = "callback"
+		start := time.Now()
+		err := HandleCallbackQuery(bot, update)
+		prefix, _, _ := strings.Cut(update.CallbackQuery.Data, ":")
+		RecordHandlerRun(bot, "callback:"+prefix, time.Since(start), err, update.CallbackQuery.Data)
+		if err != nil {
+			log.Printf("Error handling callback '%s': %v", update.CallbackQuery.Data, err)
+			ReportError(err, context)
+		}
+		return
+	}
+
+	if update.PreCheckoutQuery != nil {
+		context.Command = "pre_checkout"
+		if err := HandlePreCheckoutQuery(bot, update.PreCheckoutQuery); err != nil {
+			log.Printf("Error answering pre-checkout query: %v", err)
+			ReportError(err, context)
 		}
+		return
+	}
 
-		var err error
+	if update.MyChatMember != nil {
+		context.Command = "my_chat_member"
+		if err := HandleMyChatMember(bot, update.MyChatMember); err != nil {
+			log.Printf("Error handling membership change in chat %d: %v", update.MyChatMember.Chat.ID, err)
+			ReportError(err, context)
+		}
+		return
+	}
 
-		switch update.Message.Command() {
-		case "start":
-			err = HandleStartCommand(bot, update)
-		case "info":
-			drugName := update.Message.CommandArguments()
-			log.Print(drugName)
-			err = HandleInfoCommand(bot, update, drugName)
-		default:
-			question := update.Message.Text
-			err = HandleAskCommand(bot, update, question)
+	if update.Message == nil {
+		return
+	}
+
+	if HandleChatMigration(update.Message) {
+		return
+	}
+	NormalizeCommandPrefix(update.Message)
+
+	if handled, err := HandleFeedbackComment(bot, update); handled {
+		if err != nil {
+			log.Printf("Error saving feedback comment: %v", err)
+			ReportError(err, context)
 		}
+		return
+	}
 
+	if handled, err := HandleLogFormReply(bot, update); handled {
 		if err != nil {
-			log.Printf("Error handling command '%s': %v", update.Message.Command(), err)
+			log.Printf("Error searching substances for the log form: %v", err)
+			ReportError(err, context)
 		}
+		return
+	}
+
+	if handled, err := HandleQuickAction(bot, update); handled {
+		if err != nil {
+			log.Printf("Error handling quick action: %v", err)
+			ReportError(err, context)
+		}
+		return
+	}
+
+	if CheckSpam(bot, update) {
+		return
+	}
+	BufferGroupMessage(update)
+	if ForOtherBot(bot, update.Message) {
+		return
+	}
+
+	context.Command = update.Message.Command()
+	start := time.Now()
+	err := Dispatch(bot, update)
+	name := "message"
+	if context.Command != "" {
+		name = "/" + ResolveCommand(update.Message.Chat.ID, context.Command)
+	}
+	RecordHandlerRun(bot, name, time.Since(start), err, fmt.Sprintf("%s chat %d", update.Message.Chat.Type, update.Message.Chat.ID))
+	if err != nil {
+		log.Printf("Error handling command '%s': %v", update.Message.Command(), err)
+		ReportError(err, context)
 	}
 }
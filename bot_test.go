@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDeleteMention(t *testing.T) {
+	mention := func(offset, length int) tgbotapi.MessageEntity {
+		return tgbotapi.MessageEntity{Type: "mention", Offset: offset, Length: length}
+	}
+	command := func(offset, length int) tgbotapi.MessageEntity {
+		return tgbotapi.MessageEntity{Type: "bot_command", Offset: offset, Length: length}
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		want     string
+	}{
+		{"plain", "@psyai_bot how long does LSD last?", []tgbotapi.MessageEntity{mention(0, 10)}, "how long does LSD last?"},
+		{"case insensitive", "@PsyAI_Bot hi", []tgbotapi.MessageEntity{mention(0, 10)}, "hi"},
+		// 🍄 is a surrogate pair, two UTF-16 code units
+		{"emoji before mention", "🍄 @psyai_bot dosage?", []tgbotapi.MessageEntity{mention(3, 10)}, "🍄  dosage?"},
+		{"emoji after mention", "@psyai_bot 🍄🍄 dosage?", []tgbotapi.MessageEntity{mention(0, 10)}, "🍄🍄 dosage?"},
+		{"flag and skin tone", "🇳🇱👍🏽 @psyai_bot is it legal?", []tgbotapi.MessageEntity{mention(9, 10)}, "🇳🇱👍🏽  is it legal?"},
+		{"bot command", "/ask what is set and setting?", []tgbotapi.MessageEntity{command(0, 4)}, "what is set and setting?"},
+		{"bot command with username", "/ask@psyai_bot 💊 mdma", []tgbotapi.MessageEntity{command(0, 14)}, "💊 mdma"},
+		{"other mention kept", "@psyai_bot ask @someone_else 🙂", []tgbotapi.MessageEntity{mention(0, 10), mention(15, 13)}, "ask @someone_else 🙂"},
+		{"only other mention", "@someone_else what about 2C-B?", []tgbotapi.MessageEntity{mention(0, 13)}, "@someone_else what about 2C-B?"},
+		{
+			"multiple entities",
+			"😵‍💫 /ask @psyai_bot ketamine and @friend 🍺",
+			[]tgbotapi.MessageEntity{command(6, 4), mention(11, 10), mention(35, 7), {Type: "bold", Offset: 22, Length: 8}},
+			"😵‍💫   ketamine and @friend 🍺",
+		},
+		{"mention at end", "is 5-MeO-DMT safe? 🐸 @psyai_bot", []tgbotapi.MessageEntity{mention(22, 10)}, "is 5-MeO-DMT safe? 🐸"},
+		{"only mention", "@psyai_bot", []tgbotapi.MessageEntity{mention(0, 10)}, ""},
+		{"out of range entity ignored", "🍄 hi", []tgbotapi.MessageEntity{mention(2, 20)}, "🍄 hi"},
+		{"no entities", "👋 hello", nil, "👋 hello"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DeleteMention(test.text, test.entities, "psyai_bot"); got != test.want {
+				t.Errorf("DeleteMention(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
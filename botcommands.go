@@ -0,0 +1,239 @@
+package main
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// commandDescriptions translate the command menu for users whose Telegram app is set to one of
+// the onboarding languages. Admin commands are only listed in English.
+var commandDescriptions = map[string]map[string]string{
+	"de": {
+		"start":        "Einführung in PsyAI",
+		"ask":          "Eine Frage zu Safer Use stellen",
+		"info":         "Informationen zu einer Substanz",
+		"effects":      "Wirkungen einer Substanz nach Kategorie",
+		"interactions": "Kombination von zwei oder mehr Substanzen prüfen",
+		"menu":         "Schnellaktionen ein- oder ausblenden",
+		"checklist":    "Sicherheits-Checkliste vor dem Konsum",
+		"define":       "Einen Safer-Use-Begriff erklären",
+		"roa":          "Konsumformen einer Substanz",
+		"log":          "Eine Dosis eintragen",
+		"history":      "Deine eingetragenen Dosen",
+		"note":         "Private Notizen zu Substanzen",
+		"style":        "Kurze, normale oder ausführliche Antworten",
+		"weekly":       "Wochenübersicht deiner Dosen",
+		"tolerance":    "Toleranz nach einer Pause abschätzen",
+		"follow":       "Antworten in diesem Thread ohne Erwähnung beantworten",
+		"unfollow":     "Dem Thread nicht mehr folgen",
+		"tldr":         "Die letzte Gruppendiskussion zusammenfassen",
+		"timezone":     "Deine Zeitzone festlegen",
+		"save":         "Eine Antwort speichern (darauf antworten)",
+		"saved":        "Deine gespeicherten Antworten",
+		"premium":      "Unterstützer werden",
+		"donate":       "PsyAI unterstützen",
+		"speak":        "Eine Antwort vorlesen (darauf antworten)",
+		"session":      "Gesprächssitzungen verwalten",
+		"alerts":       "Drug-Checking-Warnungen für diesen Chat",
+		"spam":         "Betrugs- und Dealernachrichten in dieser Gruppe löschen",
+		"checkin":      "Anonyme Check-in-Umfragen für Eventnächte planen",
+		"privacy":      "Nichts aus diesem Chat speichern",
+		"consent":      "Zustimmung zur Datenspeicherung prüfen oder widerrufen",
+		"settings":     "Chat-Einstellungen",
+		"status":       "Ist der Bot gerade langsam?",
+	},
+	"es": {
+		"start":        "Introducción a PsyAI",
+		"ask":          "Hacer una pregunta de reducción de riesgos",
+		"info":         "Información sobre una sustancia",
+		"effects":      "Efectos de una sustancia por categoría",
+		"interactions": "Comprobar una combinación de dos o más sustancias",
+		"menu":         "Mostrar u ocultar las acciones rápidas",
+		"checklist":    "Lista de seguridad antes de consumir",
+		"define":       "Explicar un término de reducción de riesgos",
+		"roa":          "Vías de administración de una sustancia",
+		"log":          "Registrar una dosis",
+		"history":      "Tus dosis registradas",
+		"note":         "Notas privadas sobre sustancias",
+		"style":        "Respuestas breves, normales o detalladas",
+		"weekly":       "Resumen semanal de tus dosis",
+		"tolerance":    "Estimar la tolerancia tras un descanso",
+		"follow":       "Responder en este hilo sin mención",
+		"unfollow":     "Dejar de seguir el hilo",
+		"tldr":         "Resumir la conversación reciente del grupo",
+		"timezone":     "Configurar tu zona horaria",
+		"save":         "Guardar una respuesta (respóndele)",
+		"saved":        "Tus respuestas guardadas",
+		"premium":      "Hazte colaborador",
+		"donate":       "Apoya a PsyAI",
+		"speak":        "Leer una respuesta en voz alta (respóndele)",
+		"session":      "Gestionar sesiones de conversación",
+		"alerts":       "Alertas de análisis de sustancias para este chat",
+		"spam":         "Borrar mensajes de estafas y vendedores en este grupo",
+		"checkin":      "Programar encuestas anónimas de apoyo para noches de evento",
+		"privacy":      "No guardar nada de este chat",
+		"consent":      "Revisar o retirar tu consentimiento para guardar tus datos",
+		"settings":     "Ajustes del chat",
+		"status":       "¿Va lento el bot ahora mismo?",
+	},
+	"fr": {
+		"start":        "Présentation de PsyAI",
+		"ask":          "Poser une question de réduction des risques",
+		"info":         "Informations sur une substance",
+		"effects":      "Effets d'une substance par catégorie",
+		"interactions": "Vérifier un mélange de deux substances ou plus",
+		"menu":         "Afficher ou masquer les actions rapides",
+		"checklist":    "Liste de sécurité avant de consommer",
+		"define":       "Expliquer un terme de réduction des risques",
+		"roa":          "Voies d'administration d'une substance",
+		"log":          "Noter une dose",
+		"history":      "Vos doses notées",
+		"note":         "Notes privées sur les substances",
+		"style":        "Réponses courtes, normales ou détaillées",
+		"weekly":       "Résumé hebdomadaire de tes doses",
+		"tolerance":    "Estimer la tolérance après une pause",
+		"follow":       "Répondre dans ce fil sans mention",
+		"unfollow":     "Ne plus suivre le fil",
+		"tldr":         "Résumer la discussion récente du groupe",
+		"timezone":     "Définir votre fuseau horaire",
+		"save":         "Enregistrer une réponse (répondez-y)",
+		"saved":        "Vos réponses enregistrées",
+		"premium":      "Devenir soutien",
+		"donate":       "Soutenir PsyAI",
+		"speak":        "Lire une réponse à voix haute (répondez-y)",
+		"session":      "Gérer les sessions de conversation",
+		"alerts":       "Alertes d'analyse de produits pour ce chat",
+		"spam":         "Supprimer les arnaques et annonces de vendeurs dans ce groupe",
+		"checkin":      "Programmer des sondages anonymes de soutien pour les soirées",
+		"privacy":      "Ne rien enregistrer de ce chat",
+		"consent":      "Consulter ou retirer ton consentement au stockage de tes données",
+		"settings":     "Paramètres du chat",
+		"status":       "Le bot est-il lent en ce moment ?",
+	},
+	"pt": {
+		"start":        "Introdução ao PsyAI",
+		"ask":          "Fazer uma pergunta de redução de riscos",
+		"info":         "Informação sobre uma substância",
+		"effects":      "Efeitos de uma substância por categoria",
+		"interactions": "Verificar uma combinação de duas ou mais substâncias",
+		"menu":         "Mostrar ou ocultar as ações rápidas",
+		"checklist":    "Checklist de segurança antes do uso",
+		"define":       "Explicar um termo de redução de riscos",
+		"roa":          "Vias de administração de uma substância",
+		"log":          "Registar uma dose",
+		"history":      "As tuas doses registadas",
+		"note":         "Notas privadas sobre substâncias",
+		"style":        "Respostas curtas, normais ou detalhadas",
+		"weekly":       "Resumo semanal das tuas doses",
+		"tolerance":    "Estimar a tolerância após uma pausa",
+		"follow":       "Responder neste tópico sem menção",
+		"unfollow":     "Deixar de seguir o tópico",
+		"tldr":         "Resumir a conversa recente do grupo",
+		"timezone":     "Definir o teu fuso horário",
+		"save":         "Guardar uma resposta (responde-lhe)",
+		"saved":        "As tuas respostas guardadas",
+		"premium":      "Torna-te apoiante",
+		"donate":       "Apoiar o PsyAI",
+		"speak":        "Ler uma resposta em voz alta (responde-lhe)",
+		"session":      "Gerir sessões de conversa",
+		"alerts":       "Alertas de testagem de substâncias para este chat",
+		"spam":         "Apagar mensagens de burla e de vendedores neste grupo",
+		"checkin":      "Agendar enquetes anônimas de apoio para noites de evento",
+		"privacy":      "Não guardar nada deste chat",
+		"consent":      "Rever ou retirar o consentimento para guardar os teus dados",
+		"settings":     "Definições do chat",
+		"status":       "O bot está lento agora?",
+	},
+	"ru": {
+		"start":        "Знакомство с PsyAI",
+		"ask":          "Задать вопрос о снижении вреда",
+		"info":         "Информация о веществе",
+		"effects":      "Эффекты вещества по категориям",
+		"interactions": "Проверить сочетание двух и более веществ",
+		"menu":         "Показать или скрыть быстрые действия",
+		"checklist":    "Чек-лист безопасности перед употреблением",
+		"define":       "Объяснить термин снижения вреда",
+		"roa":          "Способы употребления вещества",
+		"log":          "Записать дозу",
+		"history":      "Ваши записанные дозы",
+		"note":         "Личные заметки о веществах",
+		"style":        "Краткие, обычные или подробные ответы",
+		"weekly":       "Еженедельная сводка доз",
+		"tolerance":    "Оценить толерантность после перерыва",
+		"follow":       "Отвечать в этой ветке без упоминания",
+		"unfollow":     "Перестать следить за веткой",
+		"tldr":         "Кратко пересказать недавнее обсуждение в группе",
+		"timezone":     "Указать часовой пояс",
+		"save":         "Сохранить ответ (ответьте на него)",
+		"saved":        "Ваши сохранённые ответы",
+		"premium":      "Стать спонсором",
+		"donate":       "Поддержать PsyAI",
+		"speak":        "Прочитать ответ вслух (ответьте на него)",
+		"session":      "Управление сессиями разговора",
+		"alerts":       "Предупреждения drug checking для этого чата",
+		"spam":         "Удалять мошеннические сообщения и рекламу продавцов в группе",
+		"checkin":      "Анонимные опросы «как вы?» на вечера мероприятий",
+		"privacy":      "Ничего не сохранять из этого чата",
+		"consent":      "Проверить или отозвать согласие на хранение данных",
+		"settings":     "Настройки чата",
+		"status":       "Бот сейчас работает медленно?",
+	},
+}
+
+type commandScope struct {
+	Scope    tgbotapi.BotCommandScope
+	ChatType string
+	Admin    bool
+}
+
+// CommandMenu lists the commands available in a chat type, in registration order, with
+// descriptions in the language ("" for English). Admin commands are included when admin is set.
+func CommandMenu(chatType, language string, admin bool) []tgbotapi.BotCommand {
+	var menu []tgbotapi.BotCommand
+	for _, name := range commandOrder {
+		command := commands[name]
+		if command.AdminOnly && !admin {
+			continue
+		}
+		if command.Requires != "" && !chatPolicies[chatType][command.Requires] {
+			continue
+		}
+		description := command.Description
+		if translated, ok := commandDescriptions[language][name]; ok {
+			description = translated
+		}
+		menu = append(menu, tgbotapi.BotCommand{Command: name, Description: description})
+	}
+	return menu
+}
+
+// RegisterBotCommands publishes the command registry as Telegram's command menu: one list for
+// private chats and one for groups, each translated per language, and the admin commands in the
+// private chats of the bot admins.
+func RegisterBotCommands(bot *tgbotapi.BotAPI) {
+	languages := []string{""}
+	for _, language := range onboardingLanguages {
+		if _, ok := commandDescriptions[language.Code]; ok {
+			languages = append(languages, language.Code)
+		}
+	}
+
+	scopes := []commandScope{
+		{tgbotapi.NewBotCommandScopeAllPrivateChats(), "private", false},
+		{tgbotapi.NewBotCommandScopeAllGroupChats(), "group", false},
+	}
+	for _, id := range AdminUserIDs() {
+		scopes = append(scopes, commandScope{tgbotapi.NewBotCommandScopeChat(id), "private", true})
+	}
+
+	for _, scope := range scopes {
+		// A chat scope outranks the language-specific private scope, so admins need every language too
+		for _, language := range languages {
+			config := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(scope.Scope, language, CommandMenu(scope.ChatType, language, scope.Admin)...)
+			if _, err := bot.Request(config); err != nil {
+				log.Printf("Error registering %s commands (%s, language %q): %v", scope.ChatType, scope.Scope.Type, language, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BoundedMap is a concurrency-safe map for in-memory state that must not grow with uptime. Entries
+// expire after ttl, and once max entries are held the oldest tenth is dropped to make room.
+type BoundedMap[K comparable, V any] struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	entries map[K]boundedEntry[V]
+}
+
+type boundedEntry[V any] struct {
+	value V
+	added time.Time
+}
+
+// NewBoundedMap creates a map and registers it for periodic eviction and the memory metrics.
+func NewBoundedMap[K comparable, V any](name string, max int, ttl time.Duration) *BoundedMap[K, V] {
+	m := &BoundedMap[K, V]{max: max, ttl: ttl, entries: map[K]boundedEntry[V]{}}
+	RegisterMemoryGauge(name, m.Len)
+	RegisterEvictor(name, m.Evict)
+	return m
+}
+
+func (m *BoundedMap[K, V]) expired(entry boundedEntry[V], now time.Time) bool {
+	return m.ttl > 0 && now.Sub(entry.added) > m.ttl
+}
+
+func (m *BoundedMap[K, V]) Get(key K) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok || m.expired(entry, time.Now()) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (m *BoundedMap[K, V]) Set(key K, value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; !ok && m.max > 0 && len(m.entries) >= m.max {
+		m.evictLocked(time.Now())
+		if len(m.entries) >= m.max {
+			m.dropOldestLocked(max(m.max/10, 1))
+		}
+	}
+	m.entries[key] = boundedEntry[V]{value: value, added: time.Now()}
+}
+
+func (m *BoundedMap[K, V]) Delete(key K) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// LoadAndDelete removes an entry and returns it if it hadn't expired.
+func (m *BoundedMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	delete(m.entries, key)
+	if !ok || m.expired(entry, time.Now()) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (m *BoundedMap[K, V]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Evict drops expired entries and returns how many there were.
+func (m *BoundedMap[K, V]) Evict(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.evictLocked(now)
+}
+
+func (m *BoundedMap[K, V]) evictLocked(now time.Time) int {
+	evicted := 0
+	for key, entry := range m.entries {
+		if m.expired(entry, now) {
+			delete(m.entries, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (m *BoundedMap[K, V]) dropOldestLocked(count int) {
+	type aged struct {
+		key   K
+		added time.Time
+	}
+	all := make([]aged, 0, len(m.entries))
+	for key, entry := range m.entries {
+		all = append(all, aged{key, entry.added})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].added.Before(all[j].added) })
+	for _, entry := range all[:min(count, len(all))] {
+		delete(m.entries, entry.key)
+	}
+}
+
+func init() {
+	RegisterMemoryGauge("message_buffers", messageBufferCount)
+	RegisterEvictor("message_buffers", evictMessageBuffers)
+	RegisterMemoryGauge("stream_edit_slots", chatEditSlotCount)
+}
+
+var (
+	memoryMu     sync.Mutex
+	memoryGauges = map[string]func() int{}
+	evictors     = map[string]func(now time.Time) int{}
+)
+
+// RegisterMemoryGauge adds an in-memory structure to the sizes reported by MemorySizes.
+func RegisterMemoryGauge(name string, size func() int) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	memoryGauges[name] = size
+}
+
+// RegisterEvictor adds a function the eviction loop calls every minute. It returns how many
+// entries it dropped.
+func RegisterEvictor(name string, evict func(now time.Time) int) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	evictors[name] = evict
+}
+
+// MemorySizes returns the entry count of every registered in-memory structure and persistent store.
+func MemorySizes() map[string]int {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	sizes := make(map[string]int, len(memoryGauges))
+	for name, size := range memoryGauges {
+		sizes[name] = size()
+	}
+	for name, store := range persistentStores() {
+		sizes["store:"+name] = store.Len()
+	}
+	return sizes
+}
+
+// retentionDays reads a retention period in days from the environment.
+func retentionDays(name string, fallback int) time.Duration {
+	days, err := strconv.Atoi(GetenvVar(name, false))
+	if err != nil || days <= 0 {
+		days = fallback
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PruneStores deletes persisted records past their retention: conversation sessions untouched
+// for CONVERSATION_RETENTION_DAYS (default 90) and their archives, feedback older than FEEDBACK_RETENTION_DAYS
+// (default 180), cached answers older than ANSWER_CACHE_RETENTION_DAYS (default 180) and gate,
+// dead letter, ensemble, held answer and audit logs older than LOG_RETENTION_DAYS (default 90).
+// Moderation actions, which can hold deleted messages, go after MODERATION_RETENTION_DAYS (default 7).
+func PruneStores(now time.Time) {
+	report := func(name string, count int, err error) {
+		if err != nil {
+			log.Printf("Error pruning %s: %v", name, err)
+		} else if count > 0 {
+			log.Printf("Pruned %d old %s records", count, name)
+		}
+	}
+
+	if conversations != nil {
+		cutoff := now.Add(-retentionDays("CONVERSATION_RETENTION_DAYS", 90))
+		count, err := conversations.Compact(func(user UserSessions) (UserSessions, bool) {
+			user = user.cloned()
+			for name, session := range user.Sessions {
+				if session.UpdatedAt.Before(cutoff) {
+					delete(user.Sessions, name)
+				}
+			}
+			if _, ok := user.Sessions[user.Active]; !ok {
+				user.Active = ""
+			}
+			return user, len(user.Sessions) > 0
+		})
+		report("conversation", count, err)
+		count, err = PruneArchives(cutoff)
+		report("archived conversation", count, err)
+	}
+	if feedback != nil {
+		cutoff := now.Add(-retentionDays("FEEDBACK_RETENTION_DAYS", 180))
+		count, err := feedback.DeleteWhere(func(_ string, entry FeedbackEntry) bool { return entry.AnsweredAt.Before(cutoff) })
+		report("feedback", count, err)
+	}
+	if answerCache != nil {
+		cutoff := now.Add(-retentionDays("ANSWER_CACHE_RETENTION_DAYS", 180))
+		count, err := answerCache.DeleteWhere(func(_ string, cached CachedAnswer) bool { return cached.CreatedAt.Before(cutoff) })
+		report("cached answer", count, err)
+	}
+
+	cutoff := now.Add(-retentionDays("LOG_RETENTION_DAYS", 90))
+	if gateLog != nil {
+		count, err := gateLog.DeleteWhere(func(_ string, event GateEvent) bool { return event.At.Before(cutoff) })
+		report("gate log", count, err)
+	}
+	if deadLetters != nil {
+		count, err := deadLetters.DeleteWhere(func(_ string, letter DeadLetter) bool { return letter.At.Before(cutoff) })
+		report("dead letter", count, err)
+	}
+	if ensembleLog != nil {
+		count, err := ensembleLog.DeleteWhere(func(_ string, record EnsembleRecord) bool { return record.At.Before(cutoff) })
+		report("ensemble", count, err)
+	}
+	if heldAnswers != nil {
+		count, err := heldAnswers.DeleteWhere(func(_ string, held HeldAnswer) bool { return held.At.Before(cutoff) })
+		report("held answer", count, err)
+	}
+	if moderationLog != nil {
+		cutoff := now.Add(-retentionDays("MODERATION_RETENTION_DAYS", 7))
+		count, err := moderationLog.DeleteWhere(func(_ string, action ModerationAction) bool { return action.At.Before(cutoff) })
+		report("moderation", count, err)
+	}
+	count, err := pruneAuditFiles(cutoff)
+	report("audit file", count, err)
+}
+
+// ScheduleRetention runs PruneStores every hour.
+func ScheduleRetention() {
+	ScheduleJob(Job{
+		Name:       "retention",
+		Schedule:   Every(time.Hour),
+		RunAtStart: true,
+		Run: func(time.Time) error {
+			PruneStores(time.Now())
+			return nil
+		},
+	})
+}
+
+// ScheduleEviction runs the registered evictors, which drop expired in-memory entries such as
+// cached backend results, every minute.
+func ScheduleEviction() {
+	ScheduleJob(Job{
+		Name:     "eviction",
+		Schedule: Every(time.Minute),
+		Run: func(time.Time) error {
+			now := time.Now()
+			memoryMu.Lock()
+			current := make(map[string]func(time.Time) int, len(evictors))
+			for name, evict := range evictors {
+				current[name] = evict
+			}
+			memoryMu.Unlock()
+			for name, evict := range current {
+				if count := evict(now); count > 0 {
+					log.Printf("Evicted %d expired entries from %s", count, name)
+				}
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// HandleCallbackQuery routes inline button taps by the prefix of their callback data.
+func HandleCallbackQuery(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
+	query := update.CallbackQuery
+	parts := strings.Split(query.Data, ":")
+
+	switch parts[0] {
+	case "fb":
+		return HandleFeedbackCallback(bot, query, parts[1:])
+	case "fbr":
+		return HandleFeedbackReviewCallback(bot, query, parts[1:])
+	case "mp":
+		return HandleMentionPromptCallback(bot, query, parts[1:])
+	case "info":
+		return HandleInfoCallback(bot, query, parts[1:])
+	case "eff":
+		return HandleEffectsCallback(bot, query, parts[1:])
+	case "st":
+		return HandleStopCallback(bot, query, parts[1:])
+	case "rf":
+		return HandleRefreshCallback(bot, query, parts[1:])
+	case "rg":
+		return HandleRegenerateCallback(bot, query, parts[1:])
+	case "fu":
+		return HandleFollowUpCallback(bot, query, parts[1:])
+	case "cl":
+		return HandleClarifyCallback(bot, query, parts[1:])
+	case "ck":
+		return HandleChecklistCallback(bot, query, parts[1:])
+	case "roa":
+		return HandleRoaCallback(bot, query, parts[1:])
+	case "lf":
+		return HandleLogFormCallback(bot, query, parts[1:])
+	case "us":
+		return HandleUnitSanityCallback(bot, query, parts[1:])
+	case "tts":
+		return HandleSpeakCallback(bot, query, parts[1:])
+	case "adm":
+		return HandleAdminConfirmCallback(bot, query, parts[1:])
+	case "ob":
+		return HandleOnboardingCallback(bot, query, parts[1:])
+	case "cs":
+		return HandleConsentCallback(bot, query, parts[1:])
+	case "bm":
+		return HandleBookmarkCallback(bot, query, parts[1:])
+	case "bms":
+		return HandleBookmarkPageCallback(bot, query, false, parts[1:])
+	case "bmd":
+		return HandleBookmarkPageCallback(bot, query, true, parts[1:])
+	case "md":
+		return HandleModerationCallback(bot, query, parts[1:])
+	default:
+		return AnswerCallback(bot, query, "")
+	}
+}
+
+// AnswerCallback acknowledges a button tap, optionally showing a toast.
+func AnswerCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, text string) error {
+	_, err := bot.Request(tgbotapi.NewCallback(query.ID, text))
+	return err
+}
+
+// AnswerKeyboard returns the buttons attached under every answer.
+func AnswerKeyboard(messageID int) tgbotapi.InlineKeyboardMarkup {
+	row := FeedbackButtons(messageID)
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔖", "bm:"+strconv.Itoa(messageID)))
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔄", "rg:"+strconv.Itoa(messageID)))
+	row = append(row, FollowUpButton(messageID))
+	if TTSURL() != "" {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔊", "tts:"+strconv.Itoa(messageID)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// present reports whether a chat member status means the bot is in the chat.
+func present(status string) bool {
+	switch status {
+	case "creator", "administrator", "member", "restricted":
+		return true
+	default:
+		return false
+	}
+}
+
+// HandleMyChatMember reacts to the bot being added to or removed from a chat, or a user
+// blocking or unblocking it. Groups that add the bot are registered and introduced to it;
+// chats that remove or block it are marked inactive until it is back.
+func HandleMyChatMember(bot *tgbotapi.BotAPI, change *tgbotapi.ChatMemberUpdated) error {
+	chat := change.Chat
+	was, is := present(change.OldChatMember.Status), present(change.NewChatMember.Status)
+	if was == is {
+		return nil
+	}
+
+	if !is {
+		log.Printf("Removed from chat %d (%s)", chat.ID, change.NewChatMember.Status)
+		return MarkChatInactive(chat.ID, time.Unix(int64(change.Date), 0))
+	}
+
+	log.Printf("Added to chat %d (%s)", chat.ID, chat.Type)
+	err := UpdateChatSettings(chat.ID, func(settings *ChatSettings) {
+		settings.Inactive = false
+		settings.InactiveSince = time.Time{}
+		if !chat.IsPrivate() {
+			settings.AddedAt = time.Unix(int64(change.Date), 0)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if chat.IsGroup() || chat.IsSuperGroup() {
+		return SendGroupIntro(bot, chat.ID)
+	}
+	return nil
+}
+
+// MarkChatInactive stops pushes to a chat that removed or blocked the bot. Its settings are
+// kept so they apply again when the bot is added back.
+func MarkChatInactive(chatID int64, since time.Time) error {
+	return UpdateChatSettings(chatID, func(settings *ChatSettings) {
+		settings.Inactive = true
+		settings.InactiveSince = since
+	})
+}
+
+// ChatActive reports whether pushes may go to a chat.
+func ChatActive(chatID int64) bool {
+	return !GetChatSettings(chatID).Inactive
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// HandleChatMigration moves a group's state to its new ID when Telegram upgrades it to a
+// supergroup. Both service messages arrive, one in each chat, so whichever comes first does the
+// move and the other finds nothing left to do. It reports whether the message was a migration.
+func HandleChatMigration(message *tgbotapi.Message) bool {
+	var from, to int64
+	switch {
+	case message.MigrateToChatID != 0:
+		from, to = message.Chat.ID, message.MigrateToChatID
+	case message.MigrateFromChatID != 0:
+		from, to = message.MigrateFromChatID, message.Chat.ID
+	default:
+		return false
+	}
+	if err := MigrateChat(from, to); err != nil {
+		log.Printf("Error migrating chat %d to %d: %v", from, to, err)
+	}
+	return true
+}
+
+// MigrateChat remaps the settings, alert subscription, feature flag overrides, check-ins, pinned
+// alerts, moderation log and buffered messages of chat from to chat to. State already stored for
+// to is kept.
+func MigrateChat(from, to int64) error {
+	moved := false
+	if chatSettings != nil {
+		if settings, ok := chatSettings.Get(ChatKey(from)); ok {
+			if _, exists := chatSettings.Get(ChatKey(to)); !exists {
+				if err := chatSettings.Set(ChatKey(to), settings); err != nil {
+					return err
+				}
+			}
+			if err := chatSettings.Delete(ChatKey(from)); err != nil {
+				return err
+			}
+			moved = true
+		}
+	}
+
+	if flagOverrides != nil {
+		updated := map[string]FeatureFlag{}
+		flagOverrides.Range(func(name string, flag FeatureFlag) bool {
+			enabled, ok := flag.Chats[ChatKey(from)]
+			if !ok {
+				return true
+			}
+			chats := make(map[string]bool, len(flag.Chats))
+			for chat, value := range flag.Chats {
+				chats[chat] = value
+			}
+			delete(chats, ChatKey(from))
+			if _, exists := chats[ChatKey(to)]; !exists {
+				chats[ChatKey(to)] = enabled
+			}
+			flag.Chats = chats
+			updated[name] = flag
+			return true
+		})
+		for name, flag := range updated {
+			if err := flagOverrides.Set(name, flag); err != nil {
+				return err
+			}
+			moved = true
+		}
+	}
+
+	if checkIns != nil {
+		var pending []CheckIn
+		checkIns.Range(func(_ string, checkIn CheckIn) bool {
+			if checkIn.ChatID == from {
+				pending = append(pending, checkIn)
+			}
+			return true
+		})
+		for _, checkIn := range pending {
+			if err := checkIns.Delete(checkInKey(from, checkIn.At)); err != nil {
+				return err
+			}
+			checkIn.ChatID = to
+			if err := checkIns.Set(checkInKey(to, checkIn.At), checkIn); err != nil {
+				return err
+			}
+			moved = true
+		}
+	}
+
+	if pinnedAlerts != nil {
+		var pinned []PinnedAlert
+		pinnedAlerts.Range(func(_ string, alert PinnedAlert) bool {
+			if alert.ChatID == from {
+				pinned = append(pinned, alert)
+			}
+			return true
+		})
+		for _, alert := range pinned {
+			if err := pinnedAlerts.Delete(pinnedAlertKey(from, alert.MessageID)); err != nil {
+				return err
+			}
+			alert.ChatID = to
+			if err := pinnedAlerts.Set(pinnedAlertKey(to, alert.MessageID), alert); err != nil {
+				return err
+			}
+			moved = true
+		}
+	}
+
+	if moderationLog != nil {
+		var actions []ModerationAction
+		moderationLog.Range(func(_ string, action ModerationAction) bool {
+			if action.ChatID == from {
+				actions = append(actions, action)
+			}
+			return true
+		})
+		for _, action := range actions {
+			if err := moderationLog.Delete(moderationKey(from, action.At)); err != nil {
+				return err
+			}
+			action.ChatID = to
+			if err := moderationLog.Set(moderationKey(to, action.At), action); err != nil {
+				return err
+			}
+			moved = true
+		}
+	}
+
+	messageBuffersMu.Lock()
+	if buffer, ok := messageBuffers[from]; ok {
+		merged := append(append([]BufferedMessage{}, buffer...), messageBuffers[to]...)
+		messageBuffers[to] = merged[max(len(merged)-messageBufferSize, 0):]
+		delete(messageBuffers, from)
+	}
+	messageBuffersMu.Unlock()
+
+	if moved {
+		log.Printf("Migrated chat %d to supergroup %d", from, to)
+	}
+	return nil
+}
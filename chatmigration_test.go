@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateChatMovesEveryChatKeyedStore(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	if err := OpenStores(); err != nil {
+		t.Fatal(err)
+	}
+	const from, to = int64(-4001), int64(-1004001)
+	at := time.Now().Truncate(time.Second)
+
+	if err := UpdateChatSettings(from, func(settings *ChatSettings) { settings.Alerts = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := flagOverrides.Set("migration_test", FeatureFlag{Chats: map[string]bool{ChatKey(from): true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkIns.Set(checkInKey(from, at), CheckIn{ChatID: from, Question: "How is everyone?", At: at}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinnedAlerts.Set(pinnedAlertKey(from, 7), PinnedAlert{ChatID: from, MessageID: 7, Expires: at.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := moderationLog.Set(moderationKey(from, at), ModerationAction{ChatID: from, Kind: ModerationSpam, At: at}); err != nil {
+		t.Fatal(err)
+	}
+	messageBuffersMu.Lock()
+	messageBuffers[from] = []BufferedMessage{{MessageID: 1}}
+	messageBuffersMu.Unlock()
+
+	// Each check counts what a store holds for a chat, under keys and fields that must agree
+	stores := map[string]func(chatID int64) int{
+		"chat settings": func(chatID int64) int {
+			if _, ok := chatSettings.Get(ChatKey(chatID)); ok {
+				return 1
+			}
+			return 0
+		},
+		"flag overrides": func(chatID int64) int {
+			flag, _ := flagOverrides.Get("migration_test")
+			if _, ok := flag.Chats[ChatKey(chatID)]; ok {
+				return 1
+			}
+			return 0
+		},
+		"check-ins": func(chatID int64) int {
+			if checkIn, ok := checkIns.Get(checkInKey(chatID, at)); ok && checkIn.ChatID == chatID {
+				return 1
+			}
+			return 0
+		},
+		"pinned alerts": func(chatID int64) int {
+			if pinned, ok := pinnedAlerts.Get(pinnedAlertKey(chatID, 7)); ok && pinned.ChatID == chatID {
+				return PinnedAlertCount(chatID)
+			}
+			return 0
+		},
+		"moderation log": func(chatID int64) int {
+			if action, ok := moderationLog.Get(moderationKey(chatID, at)); ok && action.ChatID == chatID {
+				return len(ModerationSince(at)[chatID])
+			}
+			return 0
+		},
+		"message buffers": func(chatID int64) int {
+			messageBuffersMu.Lock()
+			defer messageBuffersMu.Unlock()
+			return len(messageBuffers[chatID])
+		},
+	}
+
+	if err := MigrateChat(from, to); err != nil {
+		t.Fatal(err)
+	}
+	for name, count := range stores {
+		if n := count(from); n != 0 {
+			t.Errorf("%s: %d entries left for the old chat", name, n)
+		}
+		if n := count(to); n != 1 {
+			t.Errorf("%s: %d entries for the new chat, want 1", name, n)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultCheckInQuestion = "Anyone need a check-in tonight?"
+	maxCheckInsPerChat     = 20
+	// checkInLateness is how late a check-in may still be posted, e.g. after downtime
+	checkInLateness = 2 * time.Hour
+)
+
+// checkInOptions are the answers of every check-in poll. The order matters to
+// FormatCheckInResults, which calls out the second and third.
+var checkInOptions = []string{
+	"👍 I'm doing fine",
+	"💬 I could use a check-in",
+	"🆘 I need help now",
+	"👀 Just looking out for others",
+}
+
+// CheckIn is an anonymous check-in poll scheduled by a group admin. Once posted it stays open
+// until Closes, then the results go privately to the group's admins.
+type CheckIn struct {
+	ChatID    int64     `json:"chat_id"`
+	ChatTitle string    `json:"chat_title,omitempty"`
+	Question  string    `json:"question"`
+	At        time.Time `json:"at"`
+	CreatedBy int64     `json:"created_by"`
+	MessageID int       `json:"message_id,omitempty"`
+	Closes    time.Time `json:"closes,omitempty"`
+}
+
+// checkIns holds the scheduled and open check-ins by "<chat>:<unix time>".
+var checkIns *JSONStore[CheckIn]
+
+func checkInKey(chatID int64, at time.Time) string {
+	return fmt.Sprintf("%d:%d", chatID, at.Unix())
+}
+
+// checkInOpenFor is how long polls stay open, from CHECKIN_POLL_HOURS (default 3).
+func checkInOpenFor() time.Duration {
+	hours, err := strconv.Atoi(GetenvVar("CHECKIN_POLL_HOURS", false))
+	if err != nil || hours < 1 {
+		hours = 3
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// ChatCheckIns returns the check-ins of a chat that haven't been posted yet, soonest first.
+func ChatCheckIns(chatID int64) []CheckIn {
+	var scheduled []CheckIn
+	checkIns.Range(func(_ string, checkIn CheckIn) bool {
+		if checkIn.ChatID == chatID && checkIn.MessageID == 0 {
+			scheduled = append(scheduled, checkIn)
+		}
+		return true
+	})
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].At.Before(scheduled[j].At) })
+	return scheduled
+}
+
+// parseCheckInTime reads "[today|tomorrow|YYYY-MM-DD] HH:MM" in location, returning the time and
+// how many fields it used. A bare time is its next occurrence.
+func parseCheckInTime(fields []string, now time.Time, location *time.Location) (time.Time, int, bool) {
+	if len(fields) == 0 {
+		return time.Time{}, 0, false
+	}
+	now = now.In(location)
+	day, used := now, 0
+	switch fields[0] {
+	case "today":
+		used = 1
+	case "tomorrow":
+		day, used = now.AddDate(0, 0, 1), 1
+	default:
+		if date, err := time.ParseInLocation("2006-01-02", fields[0], location); err == nil {
+			day, used = date, 1
+		}
+	}
+	if len(fields) <= used {
+		return time.Time{}, 0, false
+	}
+	clock, err := time.Parse("15:04", fields[used])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	at := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, location)
+	if used == 0 && !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, used + 1, true
+}
+
+const checkInUsage = "Usage:\n/checkin &lt;time&gt; [question] — schedule an anonymous check-in poll, e.g. /checkin 2026-07-18 23:30 or /checkin tomorrow 22:00\n" +
+	"/checkin now [question]\n/checkin — list the scheduled check-ins\n/checkin cancel &lt;number&gt;\n\n" +
+	"Times are in your time zone (/timezone). Results are sent privately to the group's admins."
+
+// HandleCheckInCommand lets group admins schedule anonymous "anyone need a check-in?" polls for
+// event nights, list them and cancel them.
+func HandleCheckInCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chat := update.Message.Chat
+	if update.Message.From == nil || !IsChatAdmin(bot, chat, update.Message.From.ID) {
+		return SendHTML(bot, chat.ID, "Only group admins can schedule check-ins.")
+	}
+	userID := update.Message.From.ID
+	location := UserLocation(userID)
+	scheduled := ChatCheckIns(chat.ID)
+
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		return SendHTML(bot, chat.ID, FormatCheckIns(scheduled, location, UserLocale(userID)))
+	case fields[0] == "cancel" && len(fields) == 2:
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n > len(scheduled) {
+			return SendHTML(bot, chat.ID, "There's no scheduled check-in with that number, see /checkin.")
+		}
+		if err := checkIns.Delete(checkInKey(chat.ID, scheduled[n-1].At)); err != nil {
+			return err
+		}
+		return SendHTML(bot, chat.ID, "🗑 Check-in cancelled.")
+	}
+
+	now := time.Now()
+	at, used := now, 1
+	if fields[0] != "now" {
+		var ok bool
+		if at, used, ok = parseCheckInTime(fields, now, location); !ok || !at.After(now) {
+			return SendHTML(bot, chat.ID, checkInUsage)
+		}
+	}
+	if len(scheduled) >= maxCheckInsPerChat {
+		return SendHTML(bot, chat.ID, fmt.Sprintf("This group has %d check-ins scheduled, the most I keep. Cancel some with /checkin cancel &lt;number&gt;.", len(scheduled)))
+	}
+	question := strings.Join(fields[used:], " ")
+	if question == "" {
+		question = defaultCheckInQuestion
+	}
+	checkIn := CheckIn{ChatID: chat.ID, ChatTitle: chat.Title, Question: Truncate(question, 300), At: at.Truncate(time.Minute), CreatedBy: userID}
+	if err := checkIns.Set(checkInKey(chat.ID, checkIn.At), checkIn); err != nil {
+		return err
+	}
+	if fields[0] == "now" {
+		return PostCheckIn(bot, checkIn)
+	}
+	return SendHTML(bot, chat.ID, fmt.Sprintf("🗓 Check-in scheduled for <b>%s</b> (%s). I'll send the results to the group's admins privately after %s.",
+		checkInTime(checkIn.At.In(location), UserLocale(userID)), html.EscapeString(location.String()), countOf(int(checkInOpenFor().Hours()), "hour")))
+}
+
+// checkInTime writes when a check-in is posted, like "Sat Mar 8, 23:00".
+func checkInTime(at time.Time, locale Locale) string {
+	return at.Format("Mon Jan 2, ") + locale.Clock(at)
+}
+
+// FormatCheckIns lists the scheduled check-ins numbered for /checkin cancel, in the time zone
+// and locale of the admin asking.
+func FormatCheckIns(scheduled []CheckIn, location *time.Location, locale Locale) string {
+	if len(scheduled) == 0 {
+		return "No check-ins scheduled.\n\n" + checkInUsage
+	}
+	lines := []string{"🗓 <b>Scheduled check-ins</b>"}
+	for i, checkIn := range scheduled {
+		lines = append(lines, fmt.Sprintf("%d. %s: %s", i+1, checkInTime(checkIn.At.In(location), locale), html.EscapeString(checkIn.Question)))
+	}
+	return strings.Join(lines, "\n") + fmt.Sprintf("\n\n<i>Times in %s.</i>", html.EscapeString(location.String()))
+}
+
+// PostCheckIn sends the anonymous poll of a check-in and keeps it open until it is closed by
+// RunCheckIns.
+func PostCheckIn(bot *tgbotapi.BotAPI, checkIn CheckIn) error {
+	poll := tgbotapi.NewPoll(checkIn.ChatID, checkIn.Question, checkInOptions...)
+	poll.IsAnonymous = true
+	poll.DisableNotification = Delivery(MessageCheckIn, checkIn.ChatID, time.Now()).Silent
+	sent, err := bot.Send(poll)
+	if err != nil {
+		if deleteErr := checkIns.Delete(checkInKey(checkIn.ChatID, checkIn.At)); deleteErr != nil {
+			log.Printf("Error dropping check-in: %v", deleteErr)
+		}
+		return fmt.Errorf("error posting check-in poll: %w", err)
+	}
+	checkIn.MessageID = sent.MessageID
+	checkIn.Closes = time.Now().Add(checkInOpenFor())
+	return checkIns.Set(checkInKey(checkIn.ChatID, checkIn.At), checkIn)
+}
+
+// CloseCheckIn stops a check-in poll and sends its results to the group's admins.
+func CloseCheckIn(bot *tgbotapi.BotAPI, checkIn CheckIn) error {
+	if err := checkIns.Delete(checkInKey(checkIn.ChatID, checkIn.At)); err != nil {
+		return err
+	}
+	poll, err := bot.StopPoll(tgbotapi.NewStopPoll(checkIn.ChatID, checkIn.MessageID))
+	if err != nil {
+		return fmt.Errorf("error closing check-in poll: %w", err)
+	}
+	chat := &tgbotapi.Chat{ID: checkIn.ChatID, Title: checkIn.ChatTitle}
+	NotifyChatAdmins(bot, chat, FormatCheckInResults(checkIn, poll))
+	return nil
+}
+
+// FormatCheckInResults summarizes a closed poll for the group's admins. Votes are anonymous, so
+// only the counts are known.
+func FormatCheckInResults(checkIn CheckIn, poll tgbotapi.Poll) string {
+	title := checkIn.ChatTitle
+	if title == "" {
+		title = "your group"
+	}
+	lines := []string{fmt.Sprintf("🫂 <b>Check-in results for %s</b>\n<i>%s</i>", html.EscapeString(title), html.EscapeString(checkIn.Question))}
+	counts := make([]int, len(checkInOptions))
+	for i, option := range poll.Options {
+		if i < len(counts) {
+			counts[i] = option.VoterCount
+		}
+		lines = append(lines, fmt.Sprintf("%s: <b>%d</b>", html.EscapeString(option.Text), option.VoterCount))
+	}
+	lines = append(lines, fmt.Sprintf("%s in total.", countOf(poll.TotalVoterCount, "vote")))
+	switch {
+	case counts[2] > 0:
+		lines = append(lines, "\n⚠️ Someone said they need help now. Consider posting how to reach the group's care team or local emergency services, and that anyone can message an admin privately.")
+	case counts[1] > 0:
+		lines = append(lines, "\nSomeone would like a check-in. A short message in the group inviting people to reach out privately can help.")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RunCheckIns posts the check-ins that are due and closes the polls whose time is up. Check-ins
+// missed by more than checkInLateness, or in chats that removed the bot, are dropped.
+func RunCheckIns(bot *tgbotapi.BotAPI, now time.Time) {
+	var due, closing []CheckIn
+	checkIns.Range(func(_ string, checkIn CheckIn) bool {
+		switch {
+		case checkIn.MessageID == 0 && !checkIn.At.After(now):
+			due = append(due, checkIn)
+		case checkIn.MessageID != 0 && !checkIn.Closes.After(now):
+			closing = append(closing, checkIn)
+		}
+		return true
+	})
+
+	for _, checkIn := range due {
+		if now.Sub(checkIn.At) > checkInLateness || !ChatActive(checkIn.ChatID) {
+			if err := checkIns.Delete(checkInKey(checkIn.ChatID, checkIn.At)); err != nil {
+				log.Printf("Error dropping check-in: %v", err)
+			}
+			continue
+		}
+		if err := PostCheckIn(bot, checkIn); err != nil {
+			log.Printf("Error posting check-in in chat %d: %v", checkIn.ChatID, err)
+		}
+	}
+	for _, checkIn := range closing {
+		if err := CloseCheckIn(bot, checkIn); err != nil {
+			log.Printf("Error closing check-in in chat %d: %v", checkIn.ChatID, err)
+		}
+	}
+}
+
+// ScheduleCheckIns checks for due and finished check-ins every minute.
+func ScheduleCheckIns(bot *tgbotapi.BotAPI) {
+	ScheduleJob(Job{
+		Name:       "checkins",
+		Schedule:   Every(time.Minute),
+		RunAtStart: true,
+		Run: func(time.Time) error {
+			RunCheckIns(bot, time.Now())
+			return nil
+		},
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// checklistSession is how long a checklist keeps its ticks, about one night out.
+const checklistSession = 12 * time.Hour
+
+// ChecklistItem is one thing to have sorted before using. Label fits a button, Detail is the
+// advice shown in the message.
+type ChecklistItem struct {
+	ID     string
+	Label  string
+	Detail string
+}
+
+// baseChecklist applies to every substance, in order.
+var baseChecklist = []ChecklistItem{
+	{"test", "Tested it", "Tested with a reagent kit or a drug checking service, so you know what it is."},
+	{"scale", "Weighed the dose", "Weighed the dose with a milligram scale rather than eyeballing it."},
+	{"sitter", "Someone knows", "A sober friend or sitter knows what you're taking and is around."},
+	{"water", "Water at hand", "Water within reach: sip regularly."},
+	{"spacing", "Enough spacing", "Enough time since the last dose for tolerance to reset."},
+	{"combos", "Checked combos", "Checked combinations with anything else you're taking today, see /interactions."},
+	{"emergency", "Know the emergency number", "You know how to reach emergency services where you are."},
+}
+
+// substanceChecklists add items per substance, and replace base items with the same ID.
+var substanceChecklists = map[string][]ChecklistItem{
+	"mdma": {
+		{"water", "Water, not too much", "About 500ml of water an hour when dancing, less when resting: overdrinking is dangerous too."},
+		{"cool", "Plan breaks", "Plan breaks somewhere cool to avoid overheating."},
+	},
+	"lsd": {
+		{"setting", "Set and setting", "A comfortable place, a good state of mind and a free day after."},
+		{"meds", "No lithium or tramadol", "Not taking lithium or tramadol, which can cause seizures with psychedelics."},
+	},
+	"psilocybin": {
+		{"setting", "Set and setting", "A comfortable place, a good state of mind and a free day after."},
+		{"meds", "No lithium or tramadol", "Not taking lithium or tramadol, which can cause seizures with psychedelics."},
+	},
+	"2c-b": {
+		{"setting", "Set and setting", "A comfortable place, a good state of mind and a free day after."},
+	},
+	"ketamine": {
+		{"seated", "Somewhere to sit", "Somewhere safe to sit or lie down, away from water and traffic."},
+		{"depressants", "No alcohol or GHB", "No alcohol, GHB or benzos tonight: together they can stop your breathing."},
+	},
+	"ghb": {
+		{"timer", "Timer set", "A timer for at least 2 hours between doses, and the dose measured with a syringe."},
+		{"depressants", "No alcohol", "No alcohol or other depressants tonight: the combination causes most GHB deaths."},
+	},
+	"cocaine": {
+		{"kit", "Own straw", "Your own straw or note, not shared, to avoid hepatitis C."},
+	},
+	"opioids": {
+		{"naloxone", "Naloxone nearby", "Naloxone nearby and someone who knows how to use it."},
+		{"alone", "Not alone", "Not using alone: if you must, a never-use-alone line can check on you."},
+	},
+	"alcohol": {
+		{"food", "Eaten", "Eaten a meal beforehand."},
+		{"ride", "Ride home", "A way home that doesn't involve driving."},
+	},
+}
+
+// checklistSkips are base items that don't apply to a substance.
+var checklistSkips = map[string]map[string]bool{
+	"alcohol":  {"test": true, "scale": true, "spacing": true},
+	"cannabis": {"scale": true},
+	"nitrous":  {"test": true, "scale": true},
+}
+
+// Checklist returns the items for a substance: the base items, replaced or skipped per substance,
+// then its own.
+func Checklist(key string) []ChecklistItem {
+	extras := map[string]ChecklistItem{}
+	for _, item := range substanceChecklists[key] {
+		extras[item.ID] = item
+	}
+	var items []ChecklistItem
+	for _, item := range baseChecklist {
+		if checklistSkips[key][item.ID] {
+			continue
+		}
+		if replacement, ok := extras[item.ID]; ok {
+			item = replacement
+			delete(extras, item.ID)
+		}
+		items = append(items, item)
+	}
+	for _, item := range substanceChecklists[key] {
+		if _, ok := extras[item.ID]; ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checklistTicks are the ticked items of each user's checklist by "<user>:<substance>", kept for
+// a session.
+var checklistTicks = NewBoundedMap[string, map[string]bool]("checklists", 10000, checklistSession)
+
+func checklistKey(userID int64, key string) string {
+	return fmt.Sprintf("%d:%s", userID, key)
+}
+
+// spacingDetail adds the tolerance reset time to the spacing item, and in private chats when the
+// user last logged the substance.
+func spacingDetail(key string, userID int64, private bool) string {
+	profile, ok := toleranceProfiles[key]
+	if !ok {
+		return ""
+	}
+	detail := fmt.Sprintf(" For %s that's about %s.", substances[key].Name, formatDays(profile.FullReset))
+	if private {
+		if at, ok := lastUse(DoseHistory(userID), key); ok {
+			since := time.Since(at)
+			detail += fmt.Sprintf(" You last logged it %s ago.", formatDays(since))
+			if since < profile.HalfReset {
+				detail += " ⚠️"
+			}
+		}
+	}
+	return detail
+}
+
+// FormatChecklist renders a checklist with a checkbox button per item. Buttons carry
+// "ck:<user>:<substance>:<item>", "ck:<user>:<substance>:reset" starts over.
+func FormatChecklist(key string, userID int64, private bool, ticked map[string]bool) (string, tgbotapi.InlineKeyboardMarkup) {
+	items := Checklist(key)
+	var b strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	done := 0
+	for _, item := range items {
+		box := "⬜"
+		if ticked[item.ID] {
+			box = "✅"
+			done++
+		}
+		detail := item.Detail
+		if item.ID == "spacing" {
+			detail += spacingDetail(key, userID, private)
+		}
+		fmt.Fprintf(&b, "%s %s\n", box, html.EscapeString(detail))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(box+" "+item.Label,
+			fmt.Sprintf("ck:%d:%s:%s", userID, key, item.ID))))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("↺ Start over", fmt.Sprintf("ck:%d:%s:reset", userID, key))))
+
+	header := fmt.Sprintf("📝 <b>%s checklist</b> · %d/%d done\n\n", html.EscapeString(substances[key].Name), done, len(items))
+	footer := "\n<i>Tap an item once it's sorted. Start low and go slow.</i>"
+	if done == len(items) {
+		footer = "\n🎉 <b>All set.</b> Start low, go slow, and look after each other."
+	}
+	return header + b.String() + footer, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// HandleChecklistCommand sends the pre-use checklist of a substance.
+func HandleChecklistCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(args)
+	if name == "" || update.Message.From == nil {
+		return SendHTML(bot, chatID, "Usage: /checklist &lt;substance&gt;, e.g. /checklist mdma")
+	}
+	matches := ResolveSubstance(name)
+	if len(matches) == 0 || matches[0].Confidence < ConfidentMatch {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't know the substance <b>%s</b>.", html.EscapeString(name)))
+	}
+	key, userID := matches[0].Key, update.Message.From.ID
+	ticked, _ := checklistTicks.Get(checklistKey(userID, key))
+
+	text, markup := FormatChecklist(key, userID, update.Message.Chat.IsPrivate(), ticked)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = markup
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleChecklistCallback ticks or unticks an item ("ck:<user>:<substance>:<item|reset>"). Only
+// the user the checklist belongs to can tick it.
+func HandleChecklistCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 3 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	if userID != query.From.ID {
+		return AnswerCallback(bot, query, "This is someone else's checklist, start your own with /checklist.")
+	}
+	key, item := args[1], args[2]
+	if _, ok := substances[key]; !ok {
+		return AnswerCallback(bot, query, "Unknown substance.")
+	}
+
+	ticked, _ := checklistTicks.Get(checklistKey(userID, key))
+	updated := map[string]bool{}
+	if item != "reset" {
+		for id, on := range ticked {
+			updated[id] = on
+		}
+		updated[item] = !updated[item]
+	}
+	checklistTicks.Set(checklistKey(userID, key), updated)
+
+	text, markup := FormatChecklist(key, userID, query.Message.Chat.IsPrivate(), updated)
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, nil, &markup); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// What an ambiguous dosage question is missing.
+const (
+	ClarifySubstance = "substance"
+	ClarifyUnit      = "unit"
+	ClarifyRoute     = "route"
+)
+
+var (
+	dosageQuestionPattern = regexp.MustCompile(`(?i)\b(how much|how many|dose|doses|dosage|dosing|redose|too much|a lot|enough|strong dose)\b`)
+	// questionAmountPattern finds numbers and the word right after them, which should be a unit
+	questionAmountPattern = regexp.MustCompile(`(?i)\b(\d+(?:[.,]\d+)?)\s*([\p{L}µ%]*)`)
+
+	amountUnits = map[string]bool{
+		"mg": true, "µg": true, "ug": true, "mcg": true, "g": true, "gr": true, "gram": true, "grams": true,
+		"ml": true, "cl": true, "l": true, "%": true, "tab": true, "tabs": true, "pill": true, "pills": true,
+		"drink": true, "drinks": true, "beer": true, "beers": true, "shot": true, "shots": true,
+		"hit": true, "hits": true, "line": true, "lines": true, "bump": true, "bumps": true, "puff": true, "puffs": true,
+		"blotter": true, "blotters": true, "cap": true, "caps": true, "x": true, "mgs": true,
+	}
+	// Numbers followed by these aren't amounts
+	nonAmountWords = map[string]bool{
+		"h": true, "hr": true, "hrs": true, "hour": true, "hours": true, "min": true, "mins": true, "minute": true,
+		"minutes": true, "day": true, "days": true, "week": true, "weeks": true, "month": true, "months": true,
+		"year": true, "years": true, "yo": true, "kg": true, "kgs": true, "lb": true, "lbs": true, "kilos": true,
+		"am": true, "pm": true, "times": true, "th": true, "st": true, "nd": true, "rd": true, "people": true,
+	}
+	// routeWords are how people mention a route in passing, besides the route names themselves
+	routeWords = []string{"snort", "sniff", "nose", "smok", "vape", "vapor", "eat", "edible", "swallow", "drink",
+		"oral", "mouth", "inject", "shoot", "plug", "rectal", "boof", "sublingual", "tongue", "intramuscular",
+		"insufflat", "bomb", "parachut"}
+
+	// routeSensitive are substances commonly taken several ways at very different doses. Others
+	// are answered for their usual route.
+	routeSensitive = map[string]bool{"cannabis": true, "cocaine": true, "ketamine": true, "amphetamine": true, "opioids": true}
+
+	// clarifications are the questions waiting for a clarifying tap, by the clarifying message
+	clarifications = NewBoundedMap[string, *clarification]("clarifications", 10000, 15*time.Minute)
+)
+
+// clarification is a question held back until the asker fills in what was missing.
+type clarification struct {
+	Update      tgbotapi.Update
+	Question    string
+	QuestionKey string
+	Missing     string
+	Asked       map[string]bool
+}
+
+func clarificationKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// ClassifyAmbiguity returns what a dosage question is missing to be answered precisely: the
+// substance, the unit of an amount or, for route sensitive substances, the route. It returns
+// "" for questions that aren't about dosage or are specific enough. skip lists what was already
+// asked.
+func ClassifyAmbiguity(question string, skip map[string]bool) string {
+	lower := strings.ToLower(question)
+	if !dosageQuestionPattern.MatchString(lower) || len(SplitQuestions(question)) > 1 {
+		return ""
+	}
+	found := DetectSubstances(lower)
+	if len(found) == 0 {
+		if skip[ClarifySubstance] {
+			return ""
+		}
+		return ClarifySubstance
+	}
+	if !skip[ClarifyUnit] && hasBareAmount(lower) {
+		return ClarifyUnit
+	}
+	if !skip[ClarifyRoute] && len(found) == 1 && routeSensitive[found[0]] && !mentionsRoute(lower, found[0]) {
+		return ClarifyRoute
+	}
+	return ""
+}
+
+// hasBareAmount reports whether text has a number without a unit, as in "is 150 of mdma a lot".
+func hasBareAmount(text string) bool {
+	// Substance names like "2c-b" contain numbers that aren't amounts
+	text = substanceWordPattern.ReplaceAllStringFunc(text, func(word string) string {
+		if _, ok := substances[word]; ok {
+			return ""
+		}
+		if _, ok := substanceAliases[word]; ok {
+			return ""
+		}
+		return word
+	})
+	for _, match := range questionAmountPattern.FindAllStringSubmatch(text, -1) {
+		word := match[2]
+		if amountUnits[word] || nonAmountWords[word] {
+			continue
+		}
+		// Spelled out units
+		if strings.HasPrefix(word, "mg") || strings.HasPrefix(word, "mcg") || strings.HasPrefix(word, "microgram") ||
+			strings.HasPrefix(word, "milligram") || strings.HasPrefix(word, "gram") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func mentionsRoute(text, key string) bool {
+	for _, route := range substanceRoutes[key] {
+		if strings.Contains(text, strings.ToLower(route.Name)) {
+			return true
+		}
+	}
+	for _, word := range substanceWordPattern.FindAllString(text, -1) {
+		if word == "iv" || word == "im" {
+			return true
+		}
+		for _, route := range routeWords {
+			if strings.HasPrefix(word, route) {
+				return true
+			}
+		}
+	}
+	return strings.Contains(text, "under the tongue") || strings.Contains(text, "lemon tek")
+}
+
+// prompt returns the clarifying question and its quick replies.
+func (c *clarification) prompt() (string, tgbotapi.InlineKeyboardMarkup) {
+	var text string
+	var row []tgbotapi.InlineKeyboardButton
+	var rows [][]tgbotapi.InlineKeyboardButton
+	addRow := func() {
+		if len(row) > 0 {
+			rows, row = append(rows, row), nil
+		}
+	}
+
+	switch c.Missing {
+	case ClarifySubstance:
+		text = "Which substance is this about? Doses differ a lot between substances."
+		for _, key := range logFormSubstances {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(substances[key].Name, "cl:s:"+key))
+			if len(row) == 4 {
+				addRow()
+			}
+		}
+	case ClarifyUnit:
+		text = "Which unit is that amount in?"
+		for _, unit := range logFormUnits {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(unit, "cl:u:"+unit))
+		}
+	case ClarifyRoute:
+		key := DetectSubstances(strings.ToLower(c.Question))[0]
+		text = fmt.Sprintf("How are you taking %s? The dose depends on the route.", html.EscapeString(substances[key].Name))
+		for i, route := range substanceRoutes[key] {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(route.Name, "cl:r:"+strconv.Itoa(i)))
+		}
+	}
+	addRow()
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Just answer", "cl:go")))
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// AskClarification holds a vague dosage question back and asks for what's missing instead.
+func AskClarification(bot *tgbotapi.BotAPI, update tgbotapi.Update, questionKey, question, missing string) error {
+	c := &clarification{Update: update, Question: question, QuestionKey: questionKey, Missing: missing, Asked: map[string]bool{missing: true}}
+	text, markup := c.prompt()
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = update.Message.MessageID
+	msg.ReplyMarkup = markup
+	sent, err := bot.Send(msg)
+	if err != nil {
+		FinishQuestion(questionKey, err)
+		return err
+	}
+	SetQuestionAnswer(questionKey, sent.MessageID)
+	clarifications.Set(clarificationKey(sent.Chat.ID, sent.MessageID), c)
+	return nil
+}
+
+// HandleClarifyCallback fills in a held-back question ("cl:s:<substance>", "cl:u:<unit>",
+// "cl:r:<route index>") or answers it as asked ("cl:go"). Once nothing is missing the
+// clarifying message becomes the thinking message of the answer.
+func HandleClarifyCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) == 0 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	key := clarificationKey(query.Message.Chat.ID, query.Message.MessageID)
+	c, ok := clarifications.Get(key)
+	if !ok {
+		return AnswerCallback(bot, query, "This question has expired, please ask again.")
+	}
+	if from := c.Update.Message.From; from != nil && from.ID != query.From.ID {
+		return AnswerCallback(bot, query, "Only the person who asked can answer this.")
+	}
+
+	if args[0] != "go" {
+		if len(args) != 2 {
+			return AnswerCallback(bot, query, "")
+		}
+		detail, ok := c.detail(args[0], args[1])
+		if !ok {
+			return AnswerCallback(bot, query, "")
+		}
+		c.Question += " (" + detail + ")"
+		if c.Missing = ClassifyAmbiguity(c.Question, c.Asked); c.Missing != "" {
+			c.Asked[c.Missing] = true
+			clarifications.Set(key, c)
+			text, markup := c.prompt()
+			if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, nil, &markup); err != nil {
+				return err
+			}
+			return AnswerCallback(bot, query, "")
+		}
+	}
+
+	clarifications.Delete(key)
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, ThinkingMessage, nil, nil); err != nil {
+		return err
+	}
+	if err := AnswerCallback(bot, query, ""); err != nil {
+		return err
+	}
+	return StartAnswer(bot, c.Update, c.QuestionKey, query.Message.MessageID, c.Question)
+}
+
+// detail is the text a quick reply adds to the question.
+func (c *clarification) detail(kind, value string) (string, bool) {
+	switch kind {
+	case "s":
+		substance, ok := substances[value]
+		return "about " + substance.Name, ok
+	case "u":
+		for _, unit := range logFormUnits {
+			if unit == value {
+				return "the amount is in " + unit, true
+			}
+		}
+	case "r":
+		found := DetectSubstances(strings.ToLower(c.Question))
+		index, err := strconv.Atoi(value)
+		if len(found) == 0 || err != nil || index < 0 || index >= len(substanceRoutes[found[0]]) {
+			return "", false
+		}
+		return "route: " + strings.ToLower(substanceRoutes[found[0]][index].Name), true
+	}
+	return "", false
+}
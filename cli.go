@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+)
+
+// NewRootCommand builds the CLI. Running it without a subcommand runs the bot, as before.
+func NewRootCommand() *cobra.Command {
+	var envFile string
+	var skipSelfTest bool
+	root := &cobra.Command{
+		Use:          "psyai-tg-bot",
+		Short:        "PsyAI Telegram bot",
+		SilenceUsage: true,
+		// Every subcommand shares the same configuration loading
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Without --env a missing .env is fine: the configuration can come from the
+			// environment and *_FILE secrets alone, as in containers
+			if err := godotenv.Load(envFile); err != nil && (cmd.Flags().Changed("env") || !os.IsNotExist(err)) {
+				return fmt.Errorf("error loading %s file: %w", envFile, err)
+			}
+			return LoadSecrets()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunBot(defaultUpdateMode(), skipSelfTest)
+		},
+	}
+	root.PersistentFlags().StringVar(&envFile, "env", ".env", "environment file to load")
+	root.Flags().BoolVar(&skipSelfTest, "skip-selftest", false, "serve users even if the startup self-test fails")
+
+	root.AddCommand(newRunCommand(), newMigrateCommand(), newSendCommand(), newSpotlightCommand(), newExportCommand())
+	root.AddCommand(newBackupCommand(), newVerifyCommand(), newRestoreCommand(), newLoadTestCommand())
+	return root
+}
+
+// defaultUpdateMode is UPDATE_MODE, or "polling".
+func defaultUpdateMode() string {
+	if mode := GetenvVar("UPDATE_MODE", false); mode != "" {
+		return mode
+	}
+	return "polling"
+}
+
+func newRunCommand() *cobra.Command {
+	var mode string
+	var skipSelfTest bool
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the bot (long polling or webhook)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mode == "" {
+				mode = defaultUpdateMode()
+			}
+			return RunBot(mode, skipSelfTest)
+		},
+	}
+	cmd.Flags().StringVar(&mode, "mode", "", "polling or webhook (default $UPDATE_MODE or polling)")
+	cmd.Flags().BoolVar(&skipSelfTest, "skip-selftest", false, "serve users even if the startup self-test fails")
+	return cmd
+}
+
+func newMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the stores in DATA_DIR to the current schema (stop the bot first)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := OpenStores(); err != nil {
+				return err
+			}
+			applied, err := Migrate()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Applied %d migrations, schema version is %d\n", applied, SchemaVersion())
+			return nil
+		},
+	}
+}
+
+func newSendCommand() *cobra.Command {
+	var chatID int64
+	var asHTML bool
+	cmd := &cobra.Command{
+		Use:   "send --chat <id> <text>",
+		Short: "Send a one-off message as the bot",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			bot, err := NewBotFromEnv()
+			if err != nil {
+				return err
+			}
+			msg := tgbotapi.NewMessage(chatID, strings.Join(args, " "))
+			if asHTML {
+				msg.ParseMode = tgbotapi.ModeHTML
+			}
+			sent, err := bot.Send(msg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Sent message %d to chat %d\n", sent.MessageID, chatID)
+			return nil
+		},
+	}
+	cmd.Flags().Int64Var(&chatID, "chat", 0, "chat ID to send to")
+	cmd.Flags().BoolVar(&asHTML, "html", false, "parse the text as HTML")
+	cmd.MarkFlagRequired("chat")
+	return cmd
+}
+
+func newSpotlightCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "spotlight",
+		Short: "Post a substance spotlight to SPOTLIGHT_CHANNEL now",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			channel := GetenvVar("SPOTLIGHT_CHANNEL", false)
+			if channel == "" {
+				return fmt.Errorf("SPOTLIGHT_CHANNEL is not set")
+			}
+			if err := OpenStores(); err != nil {
+				return err
+			}
+			bot, err := NewBotFromEnv()
+			if err != nil {
+				return err
+			}
+			key, err := PostSpotlight(bot, channel)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Posted spotlight for %s to %s\n", key, channel)
+			return nil
+		},
+	}
+}
+
+func newExportCommand() *cobra.Command {
+	var store, out string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Dump stores as JSON lines of {store, key, value}",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var w io.Writer = os.Stdout
+			if out != "" {
+				file, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+			return ExportStores(w, store)
+		},
+	}
+	cmd.Flags().StringVar(&store, "store", "", "only export this store, e.g. feedback")
+	cmd.Flags().StringVarP(&out, "out", "o", "", "write to a file instead of stdout")
+	return cmd
+}
+
+// ExportStores writes every entry of the store files in DataDir (or only the named one) as JSON lines.
+func ExportStores(w io.Writer, only string) error {
+	paths, err := storeFiles()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if only != "" && name != only {
+			continue
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return fmt.Errorf("error decoding store %s: %w", name, err)
+		}
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			line := struct {
+				Store string          `json:"store"`
+				Key   string          `json:"key"`
+				Value json.RawMessage `json:"value"`
+			}{name, key, entries[key]}
+			if err := encoder.Encode(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func newBackupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "backup",
+		Short: "Write a snapshot to BACKUP_DESTINATION",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			location, err := Backup()
+			if err != nil {
+				return err
+			}
+			fmt.Println("Backed up stores to", location)
+			return nil
+		},
+	}
+}
+
+func newVerifyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify <snapshot>",
+		Short: "Check a snapshot's integrity (local path or s3://bucket/key)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshot, err := ReadSnapshot(args[0])
+			if err != nil {
+				return err
+			}
+			manifest, _, err := VerifySnapshot(snapshot)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("OK: %d stores, created %s\n", len(manifest.Files), manifest.CreatedAt.Format("2006-01-02 15:04:05 UTC"))
+			return nil
+		},
+	}
+}
+
+func newRestoreCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore <snapshot>",
+		Short: "Replace the stores with a snapshot (stop the bot first)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := RestoreSnapshot(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Restored %d stores from %s (created %s)\n", len(manifest.Files), args[0], manifest.CreatedAt.Format("2006-01-02 15:04:05 UTC"))
+			return nil
+		},
+	}
+}
+
+func newLoadTestCommand() *cobra.Command {
+	options := LoadTestOptions{}
+	cmd := &cobra.Command{
+		Use:   "loadtest",
+		Short: "Measure throughput and latency with synthetic traffic against stubbed Telegram and backend APIs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := RunLoadTest(options)
+			if err != nil {
+				return err
+			}
+			fmt.Print(FormatLoadTestReport(report))
+			return nil
+		},
+	}
+	cmd.Flags().Float64Var(&options.Rate, "rate", 5, "questions per second")
+	cmd.Flags().DurationVar(&options.Duration, "duration", 30*time.Second, "how long to inject questions")
+	cmd.Flags().IntVar(&options.Users, "users", 50, "synthetic users the questions are spread over")
+	cmd.Flags().DurationVar(&options.BackendLatency, "backend-latency", 2*time.Second, "average backend answer time")
+	cmd.Flags().DurationVar(&options.TelegramLatency, "telegram-latency", 50*time.Millisecond, "Telegram API call time")
+	cmd.Flags().Float64Var(&options.RateLimitShare, "rate-limited", 0, "share of Telegram calls answered with 429")
+	cmd.Flags().BoolVar(&options.Streaming, "stream", false, "stream answers")
+	cmd.Flags().DurationVar(&options.Drain, "drain", time.Minute, "how long to wait for outstanding answers")
+	return cmd
+}
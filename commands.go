@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// CommandHandler handles a slash command. args is the text after the command.
+type CommandHandler func(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error
+
+// Command is an entry in the command registry.
+type Command struct {
+	Name        string
+	Description string
+	Handler     CommandHandler
+	// Requires is the chat policy capability the command needs, if any.
+	Requires Capability
+	// Confirm, when set, reports whether the arguments make a sensitive change that a bot admin
+	// has to confirm (see RequestAdminConfirmation).
+	Confirm func(args string) bool
+	// AdminOnly commands are for bot admins and only listed in their command menus.
+	AdminOnly bool
+}
+
+// commands is filled in init to avoid an initialization cycle with handlers that consult it.
+var commands map[string]Command
+
+// commandOrder is the registration order, which is the order of the Telegram command menu.
+var commandOrder []string
+
+func init() {
+	register := func(command Command) {
+		commands[command.Name] = command
+		commandOrder = append(commandOrder, command.Name)
+	}
+	commands = map[string]Command{}
+
+	register(Command{Name: "start", Description: "Introduction to PsyAI", Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+		return HandleStartCommand(bot, update, args)
+	}})
+	register(Command{Name: "ask", Description: "Ask a harm reduction question", Handler: HandleExplicitAskCommand, Requires: CapAsk})
+	register(Command{Name: "info", Description: "Substance information", Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+		log.Print(args)
+		return HandleInfoCommand(bot, update, args)
+	}})
+	register(Command{Name: "effects", Description: "Effects of a substance by category", Handler: HandleEffectsCommand})
+	register(Command{Name: "interactions", Description: "Check a combination of two or more substances", Handler: HandleInteractionsCommand})
+	register(Command{Name: "menu", Description: "Show or hide the quick actions keyboard", Handler: HandleMenuCommand})
+	register(Command{Name: "checklist", Description: "Pre-use safety checklist for a substance", Handler: HandleChecklistCommand})
+	register(Command{Name: "define", Description: "Explain a harm reduction term", Handler: HandleDefineCommand})
+	register(Command{Name: "roa", Description: "Routes of administration of a substance", Handler: HandleRoaCommand})
+	register(Command{Name: "log", Description: "Log a dose", Handler: HandleLogCommand, Requires: CapDoseLog})
+	register(Command{Name: "history", Description: "Show your logged doses", Handler: HandleHistoryCommand, Requires: CapDoseLog})
+	register(Command{Name: "note", Description: "Private notes on substances", Handler: HandleNoteCommand, Requires: CapNotes})
+	register(Command{Name: "weekly", Description: "Weekly summary of your logged doses", Handler: HandleWeeklyCommand, Requires: CapDoseLog})
+	register(Command{Name: "tolerance", Description: "Estimate tolerance after a break", Handler: HandleToleranceCommand})
+	register(Command{Name: "follow", Description: "Answer replies in this thread without a mention", Handler: HandleFollowCommand, Requires: CapAsk})
+	register(Command{Name: "unfollow", Description: "Stop following the thread", Handler: HandleUnfollowCommand})
+	register(Command{Name: "tldr", Description: "Summarize the recent group discussion", Handler: HandleTldrCommand, Requires: CapDigest})
+	register(Command{Name: "timezone", Description: "Set your time zone", Handler: HandleTimezoneCommand})
+	register(Command{Name: "save", Description: "Bookmark an answer (reply to it)", Handler: HandleSaveCommand})
+	register(Command{Name: "saved", Description: "Your saved answers", Handler: HandleSavedCommand, Requires: CapBookmarks})
+	register(Command{Name: "premium", Description: "Become a supporter", Handler: HandlePremiumCommand})
+	register(Command{Name: "donate", Description: "Support PsyAI", Handler: HandlePremiumCommand})
+	register(Command{Name: "speak", Description: "Read an answer out loud (reply to it)", Handler: HandleSpeakCommand})
+	register(Command{Name: "session", Description: "Manage conversation sessions", Handler: HandleSessionCommand, Requires: CapSessions})
+	register(Command{Name: "alerts", Description: "Drug checking alerts for this chat", Handler: HandleAlertsCommand, Confirm: changesSubcommands("push")})
+	register(Command{Name: "spam", Description: "Delete scam and vendor messages in this group", Handler: HandleSpamCommand, Requires: CapModeration})
+	register(Command{Name: "checkin", Description: "Schedule anonymous check-in polls for event nights", Handler: HandleCheckInCommand, Requires: CapCheckIns})
+	register(Command{Name: "privacy", Description: "Stop storing anything from this chat", Handler: HandlePrivacyCommand})
+	register(Command{Name: "consent", Description: "Review or withdraw your consent to storing your data", Handler: HandleConsentCommand})
+	register(Command{Name: "settings", Description: "Chat settings", Handler: HandleSettingsCommand})
+	register(Command{Name: "style", Description: "Short, standard or detailed answers", Handler: HandleStyleCommand})
+	register(Command{Name: "status", Description: "Is the bot slow right now?", Handler: HandleStatusCommand})
+	register(Command{Name: "feedback", Description: "Review answer feedback (admins)", Handler: HandleFeedbackCommand, AdminOnly: true})
+	register(Command{Name: "stats", Description: "Usage statistics (admins)", Handler: HandleStatsCommand, AdminOnly: true})
+	register(Command{Name: "flags", Description: "Feature flags (admins)", Handler: HandleFlagsCommand, Confirm: changesSubcommands("set", "chat", "reset"), AdminOnly: true})
+	register(Command{Name: "gatelog", Description: "Review blocked questions (admins)", Handler: HandleGateLogCommand, AdminOnly: true})
+	register(Command{Name: "held", Description: "Review answers held by the dosage check (admins)", Handler: HandleHeldCommand, Confirm: changesSubcommands("release", "discard"), AdminOnly: true})
+	register(Command{Name: "archive", Description: "Archived conversations (admins)", Handler: HandleArchiveCommand, Confirm: changesSubcommands("restore", "run"), AdminOnly: true})
+	register(Command{Name: "jobs", Description: "Scheduled jobs (admins)", Handler: HandleJobsCommand, Confirm: changesSubcommands("cancel", "resume", "run"), AdminOnly: true})
+	register(Command{Name: "deadletters", Description: "Inspect undelivered answers (admins)", Handler: HandleDeadLettersCommand, Confirm: changesSubcommands("retry", "clear"), AdminOnly: true})
+	register(Command{Name: "persona", Description: "Configure the bot persona (admins)", Handler: HandlePersonaCommand, Confirm: changesSubcommands("set", "reset"), AdminOnly: true})
+}
+
+// DefaultCommandAliases are translated command names available in every chat.
+var DefaultCommandAliases = map[string]string{
+	"ajustes":       "settings",
+	"einstellungen": "settings",
+	"historial":     "history",
+	"verlauf":       "history",
+	"registro":      "log",
+	"protokoll":     "log",
+	"sesion":        "session",
+	"sitzung":       "session",
+}
+
+func FindCommand(name string) (Command, bool) {
+	command, ok := commands[name]
+	return command, ok
+}
+
+// ResolveCommand maps a chat alias or default translation to the registered command name.
+func ResolveCommand(chatID int64, name string) string {
+	if _, ok := commands[name]; ok || name == "" {
+		return name
+	}
+	if target, ok := GetChatSettings(chatID).Aliases[name]; ok {
+		return target
+	}
+	if target, ok := DefaultCommandAliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// Dispatch routes a message to its command handler, or to the ask pipeline for anything else.
+func Dispatch(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
+	if update.Message.SuccessfulPayment != nil {
+		return HandleSuccessfulPayment(bot, update)
+	}
+	name := ResolveCommand(update.Message.Chat.ID, update.Message.Command())
+	AddBreadcrumb("command", name, map[string]interface{}{"chat_type": update.Message.Chat.Type})
+
+	// Settings stay reachable everywhere so admins can lift a topic restriction
+	if name != "settings" && !InAllowedTopic(update) {
+		return nil
+	}
+	if command, ok := FindCommand(name); ok {
+		if !Allowed(update.Message, command.Requires) {
+			return SendHTML(bot, update.Message.Chat.ID, PolicyDeniedMessage(update.Message.Chat, command.Requires))
+		}
+		args := update.Message.CommandArguments()
+		if command.Confirm != nil && command.Confirm(args) {
+			return RequestAdminConfirmation(bot, update, command, args)
+		}
+		return command.Handler(bot, update, args)
+	}
+	if update.Message.Location != nil && Allowed(update.Message, CapLocation) {
+		return HandleSharedLocation(bot, update)
+	}
+	if IsLabResultUpload(update.Message) && Allowed(update.Message, CapLabResults) && LabResultsEnabled(update.Message.Chat.ID, update.Message.From.ID) {
+		return HandleLabResultUpload(bot, update)
+	}
+	if !Allowed(update.Message, CapAsk) {
+		return nil
+	}
+	question, _ := MessageText(update.Message)
+	return HandleAskCommand(bot, update, question)
+}
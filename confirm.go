@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ConfirmationTimeout is how long an admin has to tap Confirm.
+const ConfirmationTimeout = 2 * time.Minute
+
+// pendingConfirmation is a sensitive admin command waiting for its Confirm tap.
+type pendingConfirmation struct {
+	AdminID int64
+	ChatID  int64
+	Update  tgbotapi.Update
+	Command Command
+	Args    string
+	Expires time.Time
+}
+
+var (
+	confirmationsMu sync.Mutex
+	confirmations   = map[string]pendingConfirmation{}
+	// lastTOTPStep stops a code from being used twice
+	lastTOTPStep uint64
+)
+
+// TOTPSecret returns ADMIN_TOTP_SECRET, the base32 secret shared with the admins' authenticator
+// apps. When it is set, sensitive commands need a current code as their last argument.
+func TOTPSecret() string {
+	return strings.ToUpper(strings.ReplaceAll(GetenvVar("ADMIN_TOTP_SECRET", false), " ", ""))
+}
+
+// totpCode computes the RFC 6238 code (SHA-1, 6 digits) for a 30 second time step.
+func totpCode(secret []byte, step uint64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// VerifyTOTP checks a code against the current time step and its neighbours, allowing for
+// clock drift, and rejects codes that were already used.
+func VerifyTOTP(secret, code string, now time.Time) bool {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(code) != 6 {
+		return false
+	}
+	current := uint64(now.Unix() / 30)
+
+	confirmationsMu.Lock()
+	defer confirmationsMu.Unlock()
+	for _, step := range []uint64{current - 1, current, current + 1} {
+		if step > lastTOTPStep && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			lastTOTPStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// RequestAdminConfirmation holds back a sensitive command until the admin who sent it taps
+// Confirm. The sender must be a configured bot admin and, with ADMIN_TOTP_SECRET set, end the
+// command with a valid code.
+func RequestAdminConfirmation(bot *tgbotapi.BotAPI, update tgbotapi.Update, command Command, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+	if secret := TOTPSecret(); secret != "" {
+		fields := strings.Fields(args)
+		if len(fields) == 0 || !VerifyTOTP(secret, fields[len(fields)-1], time.Now()) {
+			return SendHTML(bot, chatID, fmt.Sprintf("Add a current authenticator code to confirm, e.g. /%s %s 123456",
+				command.Name, html.EscapeString(args)))
+		}
+		args = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(args), fields[len(fields)-1]))
+	}
+
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	key := hex.EncodeToString(token)
+
+	confirmationsMu.Lock()
+	now := time.Now()
+	for k, pending := range confirmations {
+		if now.After(pending.Expires) {
+			delete(confirmations, k)
+		}
+	}
+	confirmations[key] = pendingConfirmation{
+		AdminID: update.Message.From.ID,
+		ChatID:  chatID,
+		Update:  update,
+		Command: command,
+		Args:    args,
+		Expires: now.Add(ConfirmationTimeout),
+	}
+	confirmationsMu.Unlock()
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Run <code>/%s %s</code>?", command.Name, html.EscapeString(args)))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Confirm", "adm:"+key+":yes"),
+		tgbotapi.NewInlineKeyboardButtonData("✖ Cancel", "adm:"+key+":no"),
+	))
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleAdminConfirmCallback runs or cancels a held command ("adm:<token>:yes|no"). Only the
+// admin who sent the command, in the same chat, can confirm it.
+func HandleAdminConfirmCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 2 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+
+	confirmationsMu.Lock()
+	pending, ok := confirmations[args[0]]
+	valid := ok && pending.AdminID == query.From.ID && pending.ChatID == query.Message.Chat.ID && IsBotAdmin(query.From.ID)
+	if valid {
+		delete(confirmations, args[0])
+	}
+	confirmationsMu.Unlock()
+
+	if !ok || time.Now().After(pending.Expires) {
+		return AnswerCallback(bot, query, "This confirmation expired. Send the command again.")
+	}
+	if !valid {
+		return AnswerCallback(bot, query, "Only the admin who sent the command can confirm it.")
+	}
+
+	status := "✖ Cancelled"
+	if args[1] == "yes" {
+		status = "✅ Confirmed"
+	}
+	text := fmt.Sprintf("%s <code>/%s %s</code>", status, pending.Command.Name, html.EscapeString(pending.Args))
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, nil, nil); err != nil {
+		return err
+	}
+	if err := AnswerCallback(bot, query, ""); err != nil {
+		return err
+	}
+	if args[1] != "yes" {
+		return nil
+	}
+	return pending.Command.Handler(bot, pending.Update, pending.Args)
+}
+
+// changesSubcommands returns a Confirm function for commands whose listed subcommands change state.
+func changesSubcommands(subcommands ...string) func(args string) bool {
+	return func(args string) bool {
+		fields := strings.Fields(strings.ToLower(args))
+		if len(fields) == 0 {
+			return false
+		}
+		for _, subcommand := range subcommands {
+			if fields[0] == subcommand {
+				return true
+			}
+		}
+		return false
+	}
+}
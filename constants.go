@@ -1,8 +1,34 @@
 package main // Or whatever your package name is
 
+import "time"
+
 const (
 	BotUsername       = "doseslog_bot"
 	ThinkingMessage   = "PsyAI is thinking..."
 	ApiPromptEndpoint = "/prompt?model=openai"
+	ApiStreamEndpoint = "/prompt/stream?model=openai"
+	StreamCursor      = " ▍"
+	QueuedMessage     = "⏳ PsyAI is busy right now. You're #%d in line (about %s)..."
+
+	ModerationMessage        = "I can't help with that request. If you or someone near you is in danger, please contact local emergency services."
+	FeedbackCommentPrompt    = "Sorry about that. What was wrong with this answer? Reply to this message to tell us (optional)."
+	DuplicateInFlightMessage = "☝️ I'm already working on this question, the answer will appear above."
+	DuplicateAnsweredMessage = "☝️ I answered this just above."
+
+	GroupDuplicateInFlightMessage = "☝️ Someone just asked the same question, I'm answering it above."
+	GroupDuplicateAnsweredMessage = "☝️ Someone asked this a moment ago."
+	GroupDuplicateFollowUpHint    = "If your situation is different, reply to the answer with the details."
+	GatedRefusalMessage           = "I can't help with making, buying or selling drugs.\n\n" +
+		"If you're going to use anyway, I can help you do it more safely: ask me about dosing, interactions, " +
+		"drug checking or what to do if something goes wrong."
+	OnboardingDisclaimer = "PsyAI gives harm reduction information, not medical advice, and it can be wrong. " +
+		"Don't rely on it in an emergency: if someone is unresponsive, overheating or having a seizure, call emergency services. " +
+		"Drug checking is the only way to know what's in your substance."
 	// ...other constants
+
+	QueueNoticeInterval = 3 * time.Second
+	StreamEditInterval  = 1500 * time.Millisecond
+
+	DuplicateQuestionWindow = time.Minute
+	GroupDuplicateWindow    = 10 * time.Minute
 )
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Dose check modes, from DOSE_CHECK.
+const (
+	DoseCheckOff   = "off"
+	DoseCheckNote  = "note"
+	DoseCheckBlock = "block"
+)
+
+// HeldAnswerMessage replaces an answer held for review by DOSE_CHECK=block.
+const HeldAnswerMessage = "⚠️ This answer mentions a dose I couldn't verify against my dosage data, so it is held for review. " +
+	"Please check a trusted factsheet, and start low."
+
+// HeldAnswer is an answer whose dose numbers diverged from the factsheet, kept for admins to
+// release or discard.
+type HeldAnswer struct {
+	ChatID      int64            `json:"chat_id"`
+	MessageID   int              `json:"message_id"`
+	UserID      int64            `json:"user_id"`
+	Question    string           `json:"question"`
+	Answer      string           `json:"answer"`
+	Divergences []DoseDivergence `json:"divergences"`
+	At          time.Time        `json:"at"`
+}
+
+// DoseDivergence is a dose in an answer above the factsheet's range for the substance.
+type DoseDivergence struct {
+	Substance string `json:"substance"`
+	Claim     string `json:"claim"`
+	Route     string `json:"route"`
+	// Range is the factsheet's dosage for the route, e.g. "Common 75-140mg, Heavy 180mg+"
+	Range  string `json:"range"`
+	Source string `json:"source"`
+}
+
+var (
+	heldAnswers *JSONStore[HeldAnswer]
+	// factsheetDoses caches the dosage data answers are checked against
+	factsheetDoses = NewBoundedMap[string, factsheetEntry]("factsheet_doses", 200, time.Hour)
+
+	// answerDosePattern finds doses and dose ranges with a mass unit, e.g. "120mg" or "75-150 µg"
+	answerDosePattern = regexp.MustCompile(`(?i)(` + amountNumber + `)(?:\s*(?:-|–|to)\s*(` + amountNumber + `))?\s*(mg|µg|ug|mcg|g|grams?)\b`)
+	// factsheetAmountPattern reads factsheet amounts like "75-150µg", "10mg" or "200µg+"
+	factsheetAmountPattern = regexp.MustCompile(`(?i)^\s*(` + amountNumber + `)(?:\s*-\s*(` + amountNumber + `))?\s*(mg|µg|ug|mcg|g)\s*(\+?)\s*$`)
+	thousandsPattern       = regexp.MustCompile(`^` + thousandsAmount + `$`)
+)
+
+// thousandsAmount is a number with commas grouping thousands, like "1,500" or "2,000.5".
+const thousandsAmount = `\d{1,3}(?:,\d{3})+(?:\.\d+)?`
+
+// amountNumber matches an amount: thousands grouped with commas, or a decimal point, or a
+// decimal comma followed by one or two digits ("0,5"). A comma followed by three digits is always
+// read as grouping.
+const amountNumber = `(?:` + thousandsAmount + `|\d+(?:\.\d+|,\d{1,2})?)`
+
+type factsheetEntry struct {
+	doses  []DoseLevel
+	source string
+}
+
+// DoseCheckMode reads DOSE_CHECK: "note" (the default) appends a correction to answers whose
+// doses exceed the factsheet, "block" holds them for admin review and "off" disables the check.
+func DoseCheckMode() string {
+	switch mode := strings.ToLower(GetenvVar("DOSE_CHECK", false)); mode {
+	case DoseCheckOff, DoseCheckBlock:
+		return mode
+	default:
+		return DoseCheckNote
+	}
+}
+
+// doseCheckTolerance is how far above the factsheet's highest dose a claim may go before it is
+// flagged, from DOSE_CHECK_TOLERANCE in percent (default 50).
+func doseCheckTolerance() float64 {
+	percent, err := strconv.Atoi(GetenvVar("DOSE_CHECK_TOLERANCE", false))
+	if err != nil || percent < 0 {
+		percent = 50
+	}
+	return float64(percent) / 100
+}
+
+// milligrams converts an amount in a mass unit to mg.
+func milligrams(amount float64, unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "µg", "ug", "mcg":
+		return amount / 1000, true
+	case "mg":
+		return amount, true
+	case "g", "gram", "grams":
+		return amount * 1000, true
+	default:
+		return 0, false
+	}
+}
+
+// parseNumber reads an amount matched by amountNumber.
+func parseNumber(s string) (float64, error) {
+	if thousandsPattern.MatchString(s) {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+}
+
+// factsheetCeiling returns the highest dose in mg a route's levels name: the upper end of a
+// range, or the start of an open-ended "heavy" amount.
+func factsheetCeiling(levels []DoseLevel) (float64, bool) {
+	ceiling, found := 0.0, false
+	for _, level := range levels {
+		match := factsheetAmountPattern.FindStringSubmatch(level.Amount)
+		if match == nil {
+			continue
+		}
+		upper := match[1]
+		if match[2] != "" {
+			upper = match[2]
+		}
+		amount, err := parseNumber(upper)
+		if err != nil {
+			continue
+		}
+		if mg, ok := milligrams(amount, match[3]); ok && mg > ceiling {
+			ceiling, found = mg, true
+		}
+	}
+	return ceiling, found
+}
+
+func cachedFactsheetDoses(key string) ([]DoseLevel, string, bool) {
+	if entry, ok := factsheetDoses.Get(key); ok {
+		return entry.doses, entry.source, len(entry.doses) > 0
+	}
+	doses, source, err := GetSubstanceDoses(key)
+	if err != nil {
+		doses = nil
+	}
+	factsheetDoses.Set(key, factsheetEntry{doses: doses, source: source})
+	return doses, source, len(doses) > 0
+}
+
+// checkedSubstance picks the substance an answer's doses are about: the only one the answer
+// names, or else the only one the question names. Answers about several substances aren't
+// checked, as their numbers can't be attributed reliably.
+func checkedSubstance(question, answer string) (string, bool) {
+	if found := DetectSubstances(answer); len(found) == 1 {
+		return found[0], true
+	} else if len(found) > 1 {
+		return "", false
+	}
+	if found := DetectSubstances(question); len(found) == 1 {
+		return found[0], true
+	}
+	return "", false
+}
+
+// CrossCheckDoses returns the doses in an answer that go beyond the factsheet's range for the
+// substance by more than the tolerance. The route the answer names is checked when the
+// factsheet has it, the most permissive route otherwise.
+func CrossCheckDoses(question, answer string) []DoseDivergence {
+	claims := answerDosePattern.FindAllStringSubmatch(answer, -1)
+	if len(claims) == 0 {
+		return nil
+	}
+	key, ok := checkedSubstance(question, answer)
+	if !ok {
+		return nil
+	}
+	doses, source, ok := cachedFactsheetDoses(key)
+	if !ok {
+		return nil
+	}
+
+	byRoute := map[string][]DoseLevel{}
+	var routes []string
+	for _, dose := range doses {
+		if _, seen := byRoute[dose.Route]; !seen {
+			routes = append(routes, dose.Route)
+		}
+		byRoute[dose.Route] = append(byRoute[dose.Route], dose)
+	}
+	route, ceiling := "", 0.0
+	lower := strings.ToLower(answer)
+	for _, name := range routes {
+		limit, ok := factsheetCeiling(byRoute[name])
+		if !ok {
+			continue
+		}
+		if strings.Contains(lower, strings.ToLower(name)) {
+			route, ceiling = name, limit
+			break
+		}
+		if limit > ceiling {
+			route, ceiling = name, limit
+		}
+	}
+	if route == "" {
+		return nil
+	}
+
+	limit := ceiling * (1 + doseCheckTolerance())
+	var divergences []DoseDivergence
+	seen := map[string]bool{}
+	for _, claim := range claims {
+		upper := claim[1]
+		if claim[2] != "" {
+			upper = claim[2]
+		}
+		amount, err := parseNumber(upper)
+		if err != nil {
+			continue
+		}
+		mg, ok := milligrams(amount, claim[3])
+		text := strings.TrimSpace(claim[0])
+		if !ok || mg <= limit || seen[text] {
+			continue
+		}
+		seen[text] = true
+		divergences = append(divergences, DoseDivergence{
+			Substance: key,
+			Claim:     text,
+			Route:     route,
+			Range:     formatRouteRange(byRoute[route]),
+			Source:    source,
+		})
+	}
+	return divergences
+}
+
+func formatRouteRange(levels []DoseLevel) string {
+	parts := make([]string, 0, len(levels))
+	for _, level := range levels {
+		if level.Level == "Common" || level.Level == "Strong" || level.Level == "Heavy" {
+			parts = append(parts, level.Level+" "+level.Amount)
+		}
+	}
+	if len(parts) == 0 {
+		for _, level := range levels {
+			parts = append(parts, level.Level+" "+level.Amount)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DoseCorrectionNote is appended to an answer whose doses diverge from the factsheet.
+func DoseCorrectionNote(divergences []DoseDivergence) string {
+	if len(divergences) == 0 {
+		return ""
+	}
+	first := divergences[0]
+	claims := make([]string, len(divergences))
+	for i, divergence := range divergences {
+		claims[i] = "<b>" + html.EscapeString(divergence.Claim) + "</b>"
+	}
+	verb := "is"
+	if len(claims) > 1 {
+		verb = "are"
+	}
+	return fmt.Sprintf("\n\n⚠️ <b>Dosage check</b>: %s %s above the %s range in my dosage data (%s: %s). "+
+		"Go by the factsheet and start low.\n<i>Source: %s</i>",
+		strings.Join(claims, ", "), verb, html.EscapeString(substances[first.Substance].Name),
+		html.EscapeString(first.Route), html.EscapeString(first.Range), html.EscapeString(first.Source))
+}
+
+func heldAnswerKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// HoldAnswer stores an answer for review instead of delivering it.
+func HoldAnswer(chatID int64, messageID int, userID int64, question, answer string, divergences []DoseDivergence) error {
+	if heldAnswers == nil {
+		return nil
+	}
+	return heldAnswers.Set(heldAnswerKey(chatID, messageID), HeldAnswer{
+		ChatID:      chatID,
+		MessageID:   messageID,
+		UserID:      userID,
+		Question:    Truncate(question, 500),
+		Answer:      answer,
+		Divergences: divergences,
+		At:          time.Now(),
+	})
+}
+
+// RecordDoseCheck counts a flagged answer in the safety stats, by substance.
+func RecordDoseCheck(userID int64, chatType, mode string, divergences []DoseDivergence) {
+	log.Printf("Dose check (%s): %d claims above the %s factsheet", mode, len(divergences), divergences[0].Substance)
+	event := SafetyEvent{Origin: SafetyOriginDoseCheck, Category: mode, Rule: divergences[0].Substance, ChatType: chatType}
+	if err := RecordSafetyEvent(userID, event); err != nil {
+		log.Printf("Error recording safety event: %v", err)
+	}
+}
+
+const heldUsage = "Usage:\n/held — list answers held by the dosage check\n/held release &lt;id&gt; — deliver with a correction note\n/held discard &lt;id&gt;"
+
+// HandleHeldCommand lets bot admins review answers held by DOSE_CHECK=block.
+func HandleHeldCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		return SendHTML(bot, chatID, formatHeldAnswers())
+	case (fields[0] == "release" || fields[0] == "discard") && len(fields) == 2:
+		held, ok := heldAnswers.Get(fields[1])
+		if !ok {
+			return SendHTML(bot, chatID, "No held answer with that id.")
+		}
+		if fields[0] == "release" {
+			text := AnswerHTML(held.Answer) + DoseCorrectionNote(held.Divergences)
+			err := EditMessageHTML(bot, held.ChatID, held.MessageID, text, &LinkPreviewOptions{IsDisabled: true}, nil)
+			if err != nil {
+				NoteSendFailure(held.ChatID, err)
+				return SendHTML(bot, chatID, "Release failed: "+html.EscapeString(err.Error()))
+			}
+		}
+		if err := heldAnswers.Delete(fields[1]); err != nil {
+			return err
+		}
+		if fields[0] == "release" {
+			return SendHTML(bot, chatID, "Released.")
+		}
+		return SendHTML(bot, chatID, "Discarded.")
+	default:
+		return SendHTML(bot, chatID, heldUsage)
+	}
+}
+
+func formatHeldAnswers() string {
+	type entry struct {
+		key  string
+		held HeldAnswer
+	}
+	var entries []entry
+	heldAnswers.Range(func(key string, held HeldAnswer) bool {
+		entries = append(entries, entry{key, held})
+		return true
+	})
+	if len(entries) == 0 {
+		return "No answers are held for review."
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].held.At.After(entries[j].held.At)
+	})
+	total := len(entries)
+	if len(entries) > 10 {
+		entries = entries[:10]
+	}
+
+	lines := []string{fmt.Sprintf("<b>Held answers</b> (%d)", total)}
+	for _, e := range entries {
+		claims := make([]string, len(e.held.Divergences))
+		for i, divergence := range e.held.Divergences {
+			claims[i] = divergence.Claim
+		}
+		var factsheet string
+		if len(e.held.Divergences) > 0 {
+			factsheet = e.held.Divergences[0].Route + ": " + e.held.Divergences[0].Range
+		}
+		lines = append(lines, fmt.Sprintf("<code>%s</code> · %s\nQ: <i>%s</i>\nClaims %s, factsheet %s\n%s",
+			e.key, e.held.At.UTC().Format("2006-01-02 15:04"), html.EscapeString(Truncate(e.held.Question, 150)),
+			html.EscapeString(strings.Join(claims, ", ")), html.EscapeString(factsheet),
+			html.EscapeString(Truncate(e.held.Answer, 200))))
+	}
+	lines = append(lines, heldUsage)
+	return strings.Join(lines, "\n\n")
+}
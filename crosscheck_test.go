@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"120", 120},
+		{"0.5", 0.5},
+		{"0,5", 0.5},
+		{"1,25", 1.25},
+		{"1,000", 1000},
+		{"1,500", 1500},
+		{"12,000,000", 12000000},
+		{"2,000.5", 2000.5},
+	}
+	for _, test := range tests {
+		if got, err := parseNumber(test.text); err != nil || got != test.want {
+			t.Errorf("parseNumber(%q) = %v, %v, want %v", test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestCrossCheckDosesReadsThousands(t *testing.T) {
+	factsheetDoses.Set("mdma", factsheetEntry{doses: []DoseLevel{
+		{Route: "oral", Level: "Common", Amount: "75-140mg"},
+		{Route: "oral", Level: "Strong", Amount: "140-180mg"},
+	}, source: "test"})
+	t.Setenv("DOSE_CHECK_TOLERANCE", "50")
+
+	tests := []struct {
+		answer  string
+		flagged string
+	}{
+		{"A common oral dose of MDMA is 75-140mg.", ""},
+		{"A common oral dose of MDMA is 75-140 mg, up to 180mg.", ""},
+		{"Some people take 1,000mg of MDMA.", "1,000mg"},
+		{"Take 1,500-2,000 mg of MDMA orally.", "1,500-2,000 mg"},
+		{"MDMA at 1,200.5mg is dangerous.", "1,200.5mg"},
+		{"Redosing with 0,5 g of MDMA is far too much.", "0,5 g"},
+		{"Half of 0,1g MDMA is 50mg.", ""},
+	}
+	for _, test := range tests {
+		divergences := CrossCheckDoses("", test.answer)
+		flagged := ""
+		if len(divergences) > 0 {
+			flagged = divergences[0].Claim
+		}
+		if flagged != test.flagged || len(divergences) > 1 {
+			t.Errorf("CrossCheckDoses(%q) flagged %q (%d claims), want %q", test.answer, flagged, len(divergences), test.flagged)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DeadLetter is an answer that could not be delivered, kept for admins to inspect or retry.
+type DeadLetter struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	Text      string    `json:"text"`
+	Reason    string    `json:"reason"`
+	Code      int       `json:"code"`
+	Attempts  int       `json:"attempts"`
+	At        time.Time `json:"at"`
+}
+
+var deadLetters *JSONStore[DeadLetter]
+
+const maxDeliveryAttempts = 3
+
+// telegramError unwraps the Bot API error behind err, if any.
+func telegramError(err error) (*tgbotapi.Error, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsBlockedError reports whether Telegram refused a send because the chat is gone for good:
+// the user blocked the bot, deleted their account, or the bot was removed from the group.
+func IsBlockedError(err error) bool {
+	return ClassifyTelegramError(err) == TelegramBlocked
+}
+
+// isPermanentSendError reports whether retrying a send can't help. Rate limits and network
+// errors are transient; other Bot API errors mean the request itself is refused.
+func isPermanentSendError(err error) bool {
+	apiErr, ok := telegramError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.Code != 429 && apiErr.Code < 500
+}
+
+// rateLimitWait is how long Telegram asked to wait before retrying a rate limited call, 0 for
+// other errors.
+func rateLimitWait(err error) time.Duration {
+	if apiErr, ok := telegramError(err); ok && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+// SendWaitingOutRateLimits sends a message, waiting out Telegram's rate limits up to
+// maxDeliveryAttempts times.
+func SendWaitingOutRateLimits(bot *tgbotapi.BotAPI, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	sent, err := bot.Send(c)
+	for attempts := 1; attempts < maxDeliveryAttempts && rateLimitWait(err) > 0; attempts++ {
+		time.Sleep(rateLimitWait(err))
+		sent, err = bot.Send(c)
+	}
+	return sent, err
+}
+
+// DeliverAnswer edits the thinking message into the answer, retrying rate limits and falling
+// back to a new message when the thinking message was deleted. An answer that still can't be
+// delivered is recorded as a dead letter.
+func DeliverAnswer(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string, send func() error) error {
+	var err error
+	attempts := 0
+	for attempts < maxDeliveryAttempts {
+		attempts++
+		if err = send(); err == nil {
+			return nil
+		}
+		apiErr, ok := telegramError(err)
+		if ok && apiErr.Code == 400 && strings.Contains(strings.ToLower(apiErr.Message), "message to edit not found") {
+			send = func() error { return SendHTML(bot, chatID, text) }
+			continue
+		}
+		if wait := rateLimitWait(err); wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+		if isPermanentSendError(err) {
+			break
+		}
+		time.Sleep(time.Duration(attempts) * time.Second)
+	}
+
+	if recordErr := RecordDeadLetter(chatID, messageID, text, err, attempts); recordErr != nil {
+		log.Printf("Error recording dead letter: %v", recordErr)
+	}
+	NoteSendFailure(chatID, err)
+	return err
+}
+
+func deadLetterKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// RecordDeadLetter stores an undeliverable answer with the reason Telegram gave.
+func RecordDeadLetter(chatID int64, messageID int, text string, cause error, attempts int) error {
+	if deadLetters == nil {
+		return nil
+	}
+	letter := DeadLetter{ChatID: chatID, MessageID: messageID, Text: text, Reason: cause.Error(), Attempts: attempts, At: time.Now()}
+	if PrivacyEnabled(chatID) {
+		letter.Text = ""
+	}
+	if apiErr, ok := telegramError(cause); ok {
+		letter.Code = apiErr.Code
+	}
+	return deadLetters.Set(deadLetterKey(chatID, messageID), letter)
+}
+
+// NoteSendFailure marks a chat that blocked the bot or no longer exists as inactive, so scheduled
+// pushes stop trying it. Its settings, memory, dose log and bookmarks are kept in case the user
+// comes back. The Telegram transport notices these failures as well; marking twice is harmless.
+func NoteSendFailure(chatID int64, err error) {
+	if !IsBlockedError(err) || !ChatActive(chatID) {
+		return
+	}
+	if err := MarkChatInactive(chatID, time.Now()); err != nil {
+		log.Printf("Error deactivating chat %d: %v", chatID, err)
+		return
+	}
+	log.Printf("Deactivated chat %d: %v", chatID, err)
+}
+
+const deadLettersUsage = "Usage:\n/deadletters — list undelivered answers\n/deadletters retry &lt;id&gt;\n/deadletters clear"
+
+// HandleDeadLettersCommand lets bot admins inspect and retry undelivered answers.
+func HandleDeadLettersCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		return SendHTML(bot, chatID, formatDeadLetters())
+	case fields[0] == "retry" && len(fields) == 2:
+		letter, ok := deadLetters.Get(fields[1])
+		if !ok {
+			return SendHTML(bot, chatID, "No dead letter with that id.")
+		}
+		if letter.Text == "" {
+			return SendHTML(bot, chatID, "That answer was in a privacy mode chat, so its text wasn't kept.")
+		}
+		if err := SendHTML(bot, letter.ChatID, letter.Text); err != nil {
+			letter.Attempts++
+			letter.Reason = err.Error()
+			letter.At = time.Now()
+			if setErr := deadLetters.Set(fields[1], letter); setErr != nil {
+				return setErr
+			}
+			NoteSendFailure(letter.ChatID, err)
+			return SendHTML(bot, chatID, "Retry failed: "+html.EscapeString(err.Error()))
+		}
+		if err := deadLetters.Delete(fields[1]); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "Delivered.")
+	case fields[0] == "clear" && len(fields) == 1:
+		var keys []string
+		deadLetters.Range(func(key string, _ DeadLetter) bool {
+			keys = append(keys, key)
+			return true
+		})
+		for _, key := range keys {
+			if err := deadLetters.Delete(key); err != nil {
+				return err
+			}
+		}
+		return SendHTML(bot, chatID, "Cleared "+strconv.Itoa(len(keys))+" dead letters.")
+	default:
+		return SendHTML(bot, chatID, deadLettersUsage)
+	}
+}
+
+func formatDeadLetters() string {
+	type entry struct {
+		key    string
+		letter DeadLetter
+	}
+	var entries []entry
+	deadLetters.Range(func(key string, letter DeadLetter) bool {
+		entries = append(entries, entry{key, letter})
+		return true
+	})
+	if len(entries) == 0 {
+		return "No undelivered answers."
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].letter.At.After(entries[j].letter.At)
+	})
+	total := len(entries)
+	if len(entries) > 10 {
+		entries = entries[:10]
+	}
+
+	lines := []string{fmt.Sprintf("<b>Undelivered answers</b> (%d)", total)}
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("<code>%s</code> · %s · %d attempts\n%s\n<i>%s</i>",
+			e.key, e.letter.At.UTC().Format("2006-01-02 15:04"), e.letter.Attempts,
+			html.EscapeString(e.letter.Reason), html.EscapeString(Truncate(html.UnescapeString(markupTagPattern.ReplaceAllString(e.letter.Text, "")), 150))))
+	}
+	lines = append(lines, deadLettersUsage)
+	return strings.Join(lines, "\n\n")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// recentQuestion is a question a user asked within the last DuplicateQuestionWindow, or in a
+// group within the last GroupDuplicateWindow.
+type recentQuestion struct {
+	at                time.Time
+	questionMessageID int
+	answerMessageID   int
+	answered          bool
+	failed            bool
+	// askerID, words, fingerprint and excerpt are only set for questions shared in a group
+	askerID     int64
+	words       map[string]bool
+	fingerprint string
+	excerpt     string
+}
+
+var (
+	// recentQuestionsMu makes claiming a question atomic; the entries themselves expire on their own
+	recentQuestionsMu sync.Mutex
+	// recentQuestions live as long as the group window, so answers slower than
+	// DuplicateQuestionWindow still reach the group's record; ClaimQuestion checks the user's
+	// shorter window itself
+	recentQuestions = NewBoundedMap[string, *recentQuestion]("recent_questions", 10000, max(DuplicateQuestionWindow, GroupDuplicateWindow))
+)
+
+func questionKey(chatID, userID int64, question string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(question)), " ")
+	return fmt.Sprintf("%d:%d:%s", chatID, userID, normalized)
+}
+
+// ClaimQuestion registers a question about to be answered. If the same user asked the same
+// question in the chat within DuplicateQuestionWindow, it returns the earlier ask instead.
+func ClaimQuestion(chatID, userID int64, question string, messageID int) (string, *recentQuestion) {
+	recentQuestionsMu.Lock()
+	defer recentQuestionsMu.Unlock()
+
+	now := time.Now()
+	key := questionKey(chatID, userID, question)
+	if recent, ok := recentQuestions.Get(key); ok && now.Sub(recent.at) <= DuplicateQuestionWindow {
+		copied := *recent
+		return key, &copied
+	}
+	recentQuestions.Set(key, &recentQuestion{at: now, questionMessageID: messageID})
+	return key, nil
+}
+
+// SetQuestionAnswer records the message the answer to a claimed question is written into.
+func SetQuestionAnswer(key string, answerMessageID int) {
+	recentQuestionsMu.Lock()
+	defer recentQuestionsMu.Unlock()
+	if recent, ok := recentQuestions.Get(key); ok {
+		recent.answerMessageID = answerMessageID
+	}
+}
+
+// FinishQuestion marks a claimed question as answered, or forgets it if answering failed so a retry goes through.
+func FinishQuestion(key string, err error) {
+	recentQuestionsMu.Lock()
+	defer recentQuestionsMu.Unlock()
+	recent, ok := recentQuestions.Get(key)
+	if err != nil {
+		recentQuestions.Delete(key)
+		if ok {
+			recent.failed = true
+		}
+		return
+	}
+	if ok {
+		recent.answered = true
+	}
+}
+
+// ForgetQuestion drops a claimed question that won't be answered, so asking it again goes through.
+func ForgetQuestion(key string) {
+	recentQuestionsMu.Lock()
+	defer recentQuestionsMu.Unlock()
+	recentQuestions.Delete(key)
+}
+
+// PointToEarlierAnswer replies to a duplicate question with a pointer to the earlier answer.
+func PointToEarlierAnswer(bot *tgbotapi.BotAPI, update tgbotapi.Update, recent *recentQuestion) error {
+	text := DuplicateInFlightMessage
+	replyTo := recent.questionMessageID
+	if recent.answerMessageID != 0 {
+		replyTo = recent.answerMessageID
+	}
+	if recent.answered {
+		text = DuplicateAnsweredMessage
+	}
+
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTo
+	msg.AllowSendingWithoutReply = true
+	_, err := bot.Send(msg)
+	return err
+}
+
+// groupQuestions are the recent questions of each group, oldest first, so that several members
+// asking the same thing get one answer.
+var groupQuestions = NewBoundedMap[int64, []*recentQuestion]("group_questions", 10000, GroupDuplicateWindow)
+
+// questionFingerprint is what two questions must share exactly to count as the same: the
+// substances and the numbers in them. "100mg of mdma" and "300mg of mdma" are different questions
+// however many words they share.
+func questionFingerprint(question string) string {
+	substances := DetectSubstances(question)
+	sort.Strings(substances)
+	var numbers []string
+	for _, field := range strings.FieldsFunc(question, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' }) {
+		if number, err := parseNumber(strings.Trim(field, ".,")); err == nil {
+			numbers = append(numbers, strconv.FormatFloat(number, 'f', -1, 64))
+		}
+	}
+	sort.Strings(numbers)
+	return strings.Join(substances, ",") + "|" + strings.Join(numbers, ",")
+}
+
+// sameQuestion reports whether two group questions are essentially the same: same substances and
+// numbers, and mostly the same words.
+func sameQuestion(a, b *recentQuestion) bool {
+	if a.fingerprint != b.fingerprint || len(a.words) < 3 || len(b.words) < 3 {
+		return false
+	}
+	shared := 0
+	for word := range a.words {
+		if b.words[word] {
+			shared++
+		}
+	}
+	return float64(shared)/float64(len(a.words)+len(b.words)-shared) >= 0.6
+}
+
+// ClaimGroupQuestion shares a claimed question with the rest of a group. If another member asked
+// essentially the same question within GroupDuplicateWindow it returns that ask instead.
+func ClaimGroupQuestion(key string, chatID, userID int64, question string) *recentQuestion {
+	recentQuestionsMu.Lock()
+	defer recentQuestionsMu.Unlock()
+
+	now := time.Now()
+	asked := &recentQuestion{askerID: userID, words: pointWords(question), fingerprint: questionFingerprint(question)}
+	earlier, _ := groupQuestions.Get(chatID)
+	var kept []*recentQuestion
+	for _, recent := range earlier {
+		if recent.failed || now.Sub(recent.at) > GroupDuplicateWindow {
+			continue
+		}
+		if recent.askerID != userID && sameQuestion(asked, recent) {
+			copied := *recent
+			return &copied
+		}
+		kept = append(kept, recent)
+	}
+	if claimed, ok := recentQuestions.Get(key); ok {
+		claimed.askerID, claimed.words, claimed.fingerprint = asked.askerID, asked.words, asked.fingerprint
+		groupQuestions.Set(chatID, append(kept, claimed))
+	}
+	return nil
+}
+
+// SetGroupAnswerExcerpt keeps the first point of an answer written into a message, to quote it
+// to members asking the same question.
+func SetGroupAnswerExcerpt(chatID int64, answerMessageID int, answer string) {
+	points := ExtractKeyPoints(answer)
+	if len(points) == 0 {
+		return
+	}
+	recentQuestionsMu.Lock()
+	defer recentQuestionsMu.Unlock()
+	questions, _ := groupQuestions.Get(chatID)
+	for _, recent := range questions {
+		if recent.answerMessageID == answerMessageID {
+			recent.excerpt = Truncate(points[0], 200)
+		}
+	}
+}
+
+// MessageLink is the t.me link of a message, or "" in basic groups, whose messages have none.
+func MessageLink(chat *tgbotapi.Chat, messageID int) string {
+	switch {
+	case chat.UserName != "":
+		return fmt.Sprintf("https://t.me/%s/%d", chat.UserName, messageID)
+	case chat.IsSuperGroup():
+		return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(strconv.FormatInt(chat.ID, 10), "-100"), messageID)
+	}
+	return ""
+}
+
+// PointToGroupAnswer replies to a member asking a question another member just asked with a link
+// to the answer and a quote of it, instead of answering it again.
+func PointToGroupAnswer(bot *tgbotapi.BotAPI, update tgbotapi.Update, recent *recentQuestion) error {
+	replyTo := recent.answerMessageID
+	if replyTo == 0 {
+		replyTo = recent.questionMessageID
+	}
+	text := html.EscapeString(GroupDuplicateInFlightMessage)
+	if recent.answered {
+		text = html.EscapeString(GroupDuplicateAnsweredMessage)
+	}
+	if link := MessageLink(update.Message.Chat, replyTo); link != "" {
+		text += fmt.Sprintf(` <a href="%s">See the answer</a>.`, link)
+	}
+	if recent.answered {
+		if recent.excerpt != "" {
+			text += "\n<blockquote>" + html.EscapeString(recent.excerpt) + "</blockquote>"
+		}
+		text += "\n" + html.EscapeString(GroupDuplicateFollowUpHint)
+	}
+
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = update.Message.MessageID
+	msg.DisableWebPagePreview = true
+	_, err := bot.Send(msg)
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// deepLinkPayloadPattern is what Telegram allows in a start parameter.
+var deepLinkPayloadPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// deepLinkRoute runs a command for a deep link. Args turns the payload's argument, if the
+// route takes one, into the command's arguments.
+type deepLinkRoute struct {
+	Command string
+	Args    func(arg string) string
+}
+
+func deepLinkArg(arg string) string { return strings.ReplaceAll(arg, "_", " ") }
+
+func deepLinkFixed(args string) func(string) string {
+	return func(string) string { return args }
+}
+
+var (
+	// deepLinkSubjectRoutes are "<subject>_<action>" payloads, e.g. "mdma_info" or "2c-b_roa"
+	deepLinkSubjectRoutes = map[string]deepLinkRoute{
+		"info":      {Command: "info", Args: deepLinkArg},
+		"roa":       {Command: "roa", Args: deepLinkArg},
+		"effects":   {Command: "effects", Args: deepLinkArg},
+		"tolerance": {Command: "tolerance", Args: deepLinkArg},
+		"define":    {Command: "define", Args: deepLinkArg},
+	}
+	// deepLinkActionRoutes are payloads without a subject, which subscribe the chat to something
+	deepLinkActionRoutes = map[string]deepLinkRoute{
+		"alerts": {Command: "alerts", Args: deepLinkFixed("on")},
+		"weekly": {Command: "weekly", Args: deepLinkFixed("on")},
+	}
+)
+
+// deepLinkAliases reads DEEP_LINK_ALIASES, comma-separated "name=payload" pairs that give
+// campaign links short names, e.g. "tips=weekly,fest=ref_festival".
+func deepLinkAliases() map[string]string {
+	aliases := map[string]string{}
+	for _, pair := range strings.Split(GetenvVar("DEEP_LINK_ALIASES", false), ",") {
+		name, payload, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && name != "" && payload != "" {
+			aliases[strings.ToLower(name)] = payload
+		}
+	}
+	return aliases
+}
+
+// DeepLink is a parsed start parameter.
+type DeepLink struct {
+	Route deepLinkRoute
+	// Arg is the subject of the link, e.g. "mdma" in "mdma_info"
+	Arg string
+	// Referral is the tag of a "ref_<tag>" link
+	Referral string
+}
+
+// ParseDeepLink resolves a start parameter to its route, after expanding DEEP_LINK_ALIASES.
+func ParseDeepLink(payload string) (DeepLink, bool) {
+	payload = strings.TrimSpace(payload)
+	if alias, ok := deepLinkAliases()[strings.ToLower(payload)]; ok {
+		payload = alias
+	}
+	if !deepLinkPayloadPattern.MatchString(payload) {
+		return DeepLink{}, false
+	}
+	payload = strings.ToLower(payload)
+
+	if tag, ok := strings.CutPrefix(payload, "ref_"); ok && tag != "" {
+		return DeepLink{Referral: tag}, true
+	}
+	if route, ok := deepLinkActionRoutes[payload]; ok {
+		return DeepLink{Route: route}, true
+	}
+	if i := strings.LastIndex(payload, "_"); i > 0 {
+		if route, ok := deepLinkSubjectRoutes[payload[i+1:]]; ok {
+			return DeepLink{Route: route, Arg: payload[:i]}, true
+		}
+	}
+	return DeepLink{}, false
+}
+
+// RecordReferral tags a user with the referral they first arrived through, and counts it
+// in today's stats.
+func RecordReferral(userID int64, tag string) error {
+	applied := false
+	err := UpdateUserSettings(userID, func(settings *UserSettings) {
+		if settings.Referral == "" {
+			settings.Referral = tag
+			settings.ReferredAt = time.Now()
+			applied = true
+		}
+	})
+	if err != nil || !applied {
+		return err
+	}
+	return RecordDeepLink("ref_" + tag)
+}
+
+// RecordDeepLink counts a followed deep link in today's stats, by route or referral tag.
+func RecordDeepLink(name string) error {
+	if dailyStats == nil {
+		return nil
+	}
+	return dailyStats.Update(statsDay(time.Now()), func(day DailyStats) DailyStats {
+		counts := map[string]int{name: 1}
+		for key, count := range day.DeepLinks {
+			counts[key] += count
+		}
+		day.DeepLinks = counts
+		return day
+	})
+}
+
+// HandleDeepLink runs the flow a start parameter points to. It reports false for payloads it
+// doesn't know and for referrals, which go on to the regular /start.
+func HandleDeepLink(bot *tgbotapi.BotAPI, update tgbotapi.Update, payload string) (bool, error) {
+	link, ok := ParseDeepLink(payload)
+	if !ok {
+		log.Printf("Unknown deep link %q", Truncate(payload, 64))
+		return false, nil
+	}
+	if link.Referral != "" {
+		if update.Message.From != nil {
+			if err := RecordReferral(update.Message.From.ID, link.Referral); err != nil {
+				log.Printf("Error recording referral: %v", err)
+			}
+		}
+		return false, nil
+	}
+
+	command, ok := FindCommand(link.Route.Command)
+	if !ok {
+		return false, fmt.Errorf("deep link route to unknown command %q", link.Route.Command)
+	}
+	if err := RecordDeepLink(link.Route.Command); err != nil {
+		log.Printf("Error recording deep link: %v", err)
+	}
+	if !Allowed(update.Message, command.Requires) {
+		return true, SendHTML(bot, update.Message.Chat.ID, PolicyDeniedMessage(update.Message.Chat, command.Requires))
+	}
+	return true, command.Handler(bot, update, link.Route.Args(link.Arg))
+}
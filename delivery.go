@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Types of messages the bot sends on its own or to celebrate, each with its own delivery options.
+const (
+	MessageSpotlight       = "spotlight"
+	MessageWeeklySummary   = "weekly_summary"
+	MessageCheckIn         = "checkin"
+	MessageAlert           = "alert"
+	MessageSupporterThanks = "supporter_thanks"
+)
+
+// scheduledMessages are sent without the recipient asking, so they go out silently during quiet
+// hours. Drug checking alerts aren't: a dangerous batch is worth waking up for.
+var scheduledMessages = map[string]bool{MessageSpotlight: true, MessageWeeklySummary: true, MessageCheckIn: true}
+
+// messageEffectIDs are the effects Telegram offers every bot, by the name used in MESSAGE_EFFECTS.
+var messageEffectIDs = map[string]string{
+	"party": "5046509860389126442",
+	"fire":  "5104841245755180586",
+	"heart": "5159385139981059251",
+	"like":  "5107584321108051014",
+}
+
+// DeliveryOptions are how a message is sent.
+type DeliveryOptions struct {
+	Silent bool
+	// EffectID is the message effect to play, private chats only
+	EffectID string
+}
+
+// messageTypeList reads a comma-separated list of message types.
+func messageTypeList(name string) map[string]bool {
+	types := map[string]bool{}
+	for _, field := range strings.Split(GetenvVar(name, false), ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			types[field] = true
+		}
+	}
+	return types
+}
+
+// quietHours reads QUIET_HOURS as "<from>-<to>" in the recipient's local hours (default "23-8").
+// "off" turns them off.
+func quietHours() (int, int, bool) {
+	value := GetenvVar("QUIET_HOURS", false)
+	switch value {
+	case "off":
+		return 0, 0, false
+	case "":
+		return 23, 8, true
+	}
+	from, to, ok := strings.Cut(value, "-")
+	start, startErr := strconv.Atoi(strings.TrimSpace(from))
+	end, endErr := strconv.Atoi(strings.TrimSpace(to))
+	if !ok || startErr != nil || endErr != nil || start < 0 || start > 23 || end < 0 || end > 23 || start == end {
+		return 23, 8, true
+	}
+	return start, end, true
+}
+
+// InQuietHours reports whether now is within the quiet hours of a chat: the user's time zone in
+// private chats, UTC in groups and channels.
+func InQuietHours(chatID int64, now time.Time) bool {
+	start, end, ok := quietHours()
+	if !ok {
+		return false
+	}
+	location := time.UTC
+	if chatID > 0 {
+		location = UserLocation(chatID)
+	}
+	hour := now.In(location).Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// Delivery returns the options for a message of a type. Types in SILENT_MESSAGES are always sent
+// silently, scheduled ones also during quiet hours. MESSAGE_EFFECTS maps types to an effect, as in
+// "supporter_thanks:party".
+func Delivery(messageType string, chatID int64, now time.Time) DeliveryOptions {
+	var options DeliveryOptions
+	options.Silent = messageTypeList("SILENT_MESSAGES")[messageType] || scheduledMessages[messageType] && InQuietHours(chatID, now)
+	if chatID <= 0 {
+		return options
+	}
+	for _, field := range strings.Split(GetenvVar("MESSAGE_EFFECTS", false), ",") {
+		name, effect, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok || strings.ToLower(name) != messageType {
+			continue
+		}
+		if id, known := messageEffectIDs[strings.ToLower(effect)]; known {
+			effect = id
+		}
+		options.EffectID = effect
+	}
+	return options
+}
+
+// SendTypedHTML sends an HTML message with the delivery options of its type. It sends through
+// MakeRequest since the bundled tgbotapi version can't set message_effect_id. A misconfigured
+// effect doesn't lose the message, it is sent again without.
+func SendTypedHTML(bot *tgbotapi.BotAPI, chatID int64, text, messageType string) (tgbotapi.Message, error) {
+	options := Delivery(messageType, chatID, time.Now())
+	message, err := sendWithDelivery(bot, chatID, text, options)
+	if err != nil && options.EffectID != "" && ClassifyTelegramError(err) == TelegramBadRequest {
+		log.Printf("Message effect %q for %s messages was refused, sending without: %v", options.EffectID, messageType, err)
+		options.EffectID = ""
+		return sendWithDelivery(bot, chatID, text, options)
+	}
+	return message, err
+}
+
+func sendWithDelivery(bot *tgbotapi.BotAPI, chatID int64, text string, options DeliveryOptions) (tgbotapi.Message, error) {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonEmpty("text", text)
+	params.AddNonEmpty("parse_mode", tgbotapi.ModeHTML)
+	params.AddBool("disable_notification", options.Silent)
+	params.AddNonEmpty("message_effect_id", options.EffectID)
+
+	var message tgbotapi.Message
+	resp, err := bot.MakeRequest("sendMessage", params)
+	if err != nil {
+		return message, err
+	}
+	err = json.Unmarshal(resp.Result, &message)
+	return message, err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// messageBufferSize is how many recent messages are kept per group, in memory only.
+	messageBufferSize = 200
+	// messageBufferTTL drops the buffer of a group that has been quiet this long.
+	messageBufferTTL  = 48 * time.Hour
+	DefaultDigestSize = 50
+)
+
+// BufferedMessage is a group message kept for /tldr.
+type BufferedMessage struct {
+	MessageID int
+	ThreadID  int
+	From      string
+	Text      string
+	At        time.Time
+}
+
+var (
+	messageBuffersMu sync.Mutex
+	messageBuffers   = map[int64][]BufferedMessage{}
+)
+
+// BufferGroupMessage remembers a group message so it can be summarized later. Commands and
+// messages without text are skipped.
+func BufferGroupMessage(update tgbotapi.Update) {
+	message := update.Message
+	if message.Chat.IsPrivate() || message.IsCommand() {
+		return
+	}
+	text, _ := MessageText(message)
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	from := "Someone"
+	if message.From != nil {
+		from = message.From.FirstName
+	}
+
+	// Message IDs grow with every message in the chat, so this re-detects every few dozen messages
+	if message.MessageID%languageDetectEvery == 0 {
+		defer func() { go DetectGroupLanguage(message.Chat.ID) }()
+	}
+
+	messageBuffersMu.Lock()
+	defer messageBuffersMu.Unlock()
+	buffer := append(messageBuffers[message.Chat.ID], BufferedMessage{
+		MessageID: message.MessageID,
+		ThreadID:  Extras(update).MessageThreadID,
+		From:      from,
+		Text:      Truncate(text, 1000),
+		At:        time.Unix(int64(message.Date), 0),
+	})
+	if len(buffer) > messageBufferSize {
+		buffer = append([]BufferedMessage{}, buffer[len(buffer)-messageBufferSize:]...)
+	}
+	messageBuffers[message.Chat.ID] = buffer
+}
+
+// evictMessageBuffers forgets groups whose newest buffered message is older than messageBufferTTL.
+func evictMessageBuffers(now time.Time) int {
+	messageBuffersMu.Lock()
+	defer messageBuffersMu.Unlock()
+	evicted := 0
+	for chatID, buffer := range messageBuffers {
+		if len(buffer) == 0 || now.Sub(buffer[len(buffer)-1].At) > messageBufferTTL {
+			delete(messageBuffers, chatID)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func messageBufferCount() int {
+	messageBuffersMu.Lock()
+	defer messageBuffersMu.Unlock()
+	return len(messageBuffers)
+}
+
+// RecentMessages returns up to limit buffered messages of a chat thread, oldest first, starting
+// at sinceMessageID when it is set.
+func RecentMessages(chatID int64, threadID int, sinceMessageID int, limit int) []BufferedMessage {
+	messageBuffersMu.Lock()
+	defer messageBuffersMu.Unlock()
+
+	var found []BufferedMessage
+	for _, message := range messageBuffers[chatID] {
+		if message.ThreadID == threadID && message.MessageID >= sinceMessageID {
+			found = append(found, message)
+		}
+	}
+	if len(found) > limit {
+		found = found[len(found)-limit:]
+	}
+	return found
+}
+
+// SummarizeDiscussion asks the backend for a catch-up digest of a group discussion.
+func SummarizeDiscussion(messages []BufferedMessage) (string, error) {
+	var b strings.Builder
+	b.WriteString("Summarize this group discussion for someone who just joined, in a few bullet points. " +
+		"Highlight any safety concerns or harm reduction advice that came up. Don't quote people verbatim.\n\n")
+	for _, message := range messages {
+		fmt.Fprintf(&b, "%s: %s\n", message.From, message.Text)
+	}
+
+	response, err := Ask(PromptRequest{
+		Question:     b.String(),
+		Temperature:  0.2,
+		Tokens:       500,
+		SystemPrompt: SystemPrompt(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.Text(), nil
+}
+
+// HandleTldrCommand summarizes the last N messages of the thread, or everything since the
+// message /tldr replies to.
+func HandleTldrCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	limit := DefaultDigestSize
+	if n, err := strconv.Atoi(strings.TrimSpace(args)); err == nil && n > 0 {
+		limit = n
+	}
+	if limit > messageBufferSize {
+		limit = messageBufferSize
+	}
+	since := 0
+	if update.Message.ReplyToMessage != nil {
+		since = update.Message.ReplyToMessage.MessageID
+		limit = messageBufferSize
+	}
+
+	messages := RecentMessages(chatID, Extras(update).MessageThreadID, since, limit)
+	if len(messages) < 3 {
+		return SendHTML(bot, chatID, "There isn't enough recent discussion here to summarize yet.")
+	}
+
+	thinking := tgbotapi.NewMessage(chatID, ThinkingMessage)
+	thinking.ReplyToMessageID = update.Message.MessageID
+	sent, err := bot.Send(thinking)
+	if err != nil {
+		return err
+	}
+	defer TrackThinking(chatID, sent.MessageID, 0)()
+	digest, err := SummarizeDiscussion(messages)
+	if err != nil {
+		log.Printf("Error summarizing discussion: %v", err)
+		return EditMessageHTML(bot, chatID, sent.MessageID, "Sorry, I couldn't summarize the discussion right now.", nil, nil)
+	}
+	header := fmt.Sprintf("<b>TL;DR of the last %d messages</b>\n\n", len(messages))
+	return EditMessageHTML(bot, chatID, sent.MessageID, header+ConvertToTelegramHTML(digest), &LinkPreviewOptions{IsDisabled: true}, nil)
+}
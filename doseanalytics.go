@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// redoseWindow groups doses into one occasion: redoses within a session aren't a spacing matter
+	redoseWindow = 12 * time.Hour
+	// analyticsPeriod is the recent period dose statistics total, compared with the one before
+	analyticsPeriod = 30 * oneDay
+)
+
+// spacingGuidance is the suggested least time between occasions for substances with specific
+// evidence, overriding the tolerance-based spacing note of the weekly summary.
+var spacingGuidance = map[string]struct {
+	Gap  time.Duration
+	Note string
+}{
+	"mdma":     {28 * oneDay, "Spacing MDMA doses 1–3 months apart lowers the risk of low moods and neurotoxicity, and keeps its effects."},
+	"ketamine": {7 * oneDay, "Using ketamine more than weekly is linked to bladder damage and dependence."},
+}
+
+// SubstanceStats are the statistics of one substance in a user's dose log.
+type SubstanceStats struct {
+	Key       string
+	Name      string
+	Doses     int
+	Occasions int
+	First     time.Time
+	Last      time.Time
+	// AverageInterval and LastInterval are between the starts of occasions
+	AverageInterval time.Duration
+	LastInterval    time.Duration
+	// Recent and Previous count the occasions in the last analyticsPeriod and the one before
+	Recent   int
+	Previous int
+	// RecentAmounts totals the doses of the last analyticsPeriod by unit
+	RecentAmounts map[string]float64
+}
+
+// DoseStats computes per-substance statistics of a dose log, most used substance first.
+func DoseStats(history []DoseEntry, now time.Time) []SubstanceStats {
+	byKey := map[string]*SubstanceStats{}
+	var order []*SubstanceStats
+	previousDose := map[string]time.Time{}
+	for _, entry := range history {
+		key, substance, ok := LookupSubstance(entry.Substance)
+		name := substance.Name
+		if !ok {
+			name = entry.Substance
+		}
+		stats := byKey[key]
+		if stats == nil {
+			stats = &SubstanceStats{Key: key, Name: name, First: entry.At, RecentAmounts: map[string]float64{}}
+			byKey[key] = stats
+			order = append(order, stats)
+		}
+		stats.Doses++
+		if at, ok := previousDose[key]; !ok || entry.At.Sub(at) >= redoseWindow {
+			if stats.Occasions > 0 {
+				stats.LastInterval = entry.At.Sub(stats.Last)
+			}
+			stats.Occasions++
+			stats.Last = entry.At
+			switch age := now.Sub(entry.At); {
+			case age < analyticsPeriod:
+				stats.Recent++
+			case age < 2*analyticsPeriod:
+				stats.Previous++
+			}
+		}
+		if now.Sub(entry.At) < analyticsPeriod {
+			stats.RecentAmounts[entry.Unit] += entry.Amount
+		}
+		previousDose[key] = entry.At
+	}
+
+	all := make([]SubstanceStats, 0, len(order))
+	for _, stats := range order {
+		if stats.Occasions > 1 {
+			stats.AverageInterval = stats.Last.Sub(stats.First) / time.Duration(stats.Occasions-1)
+		}
+		all = append(all, *stats)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Occasions > all[j].Occasions })
+	return all
+}
+
+// Trend compares how often the substance was used in the last period with the one before. It's
+// "" while the log doesn't reach back two periods.
+func (s SubstanceStats) Trend(now time.Time) string {
+	if now.Sub(s.First) < 2*analyticsPeriod {
+		return ""
+	}
+	switch {
+	case s.Recent > s.Previous:
+		return "more often than the 30 days before"
+	case s.Recent < s.Previous:
+		return "less often than the 30 days before"
+	default:
+		return "about as often as the 30 days before"
+	}
+}
+
+// SpacingWarning is a neutral note when occasions are closer together than the guidance for
+// the substance, or "".
+func (s SubstanceStats) SpacingWarning() string {
+	guidance, ok := spacingGuidance[s.Key]
+	if !ok || s.Occasions < 2 {
+		return ""
+	}
+	name := html.EscapeString(s.Name)
+	switch {
+	case s.LastInterval < guidance.Gap:
+		return fmt.Sprintf("Your last two %s occasions were %s apart, below the suggested %s. %s",
+			name, formatDays(s.LastInterval), formatDays(guidance.Gap), guidance.Note)
+	case s.AverageInterval < guidance.Gap:
+		return fmt.Sprintf("On average %s between %s occasions, below the suggested %s. %s",
+			formatDays(s.AverageInterval), name, formatDays(guidance.Gap), guidance.Note)
+	}
+	return ""
+}
+
+func formatAmounts(amounts map[string]float64) string {
+	var parts []string
+	for unit, amount := range amounts {
+		parts = append(parts, strconv.FormatFloat(amount, 'f', -1, 64)+html.EscapeString(unit))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " + ")
+}
+
+// FormatDoseStats renders the statistics of a dose log for /history stats.
+func FormatDoseStats(all []SubstanceStats, now time.Time, location *time.Location) string {
+	var b strings.Builder
+	b.WriteString("📈 <b>Your dose log</b>")
+	var warnings []string
+	for _, stats := range all {
+		fmt.Fprintf(&b, "\n\n<b>%s</b>: %s since %s", html.EscapeString(stats.Name),
+			countOf(stats.Occasions, "occasion"), stats.First.In(location).Format("Jan 2, 2006"))
+		if stats.Doses > stats.Occasions {
+			fmt.Fprintf(&b, " (%s)", countOf(stats.Doses, "dose"))
+		}
+		if stats.Occasions > 1 {
+			fmt.Fprintf(&b, "\nEvery %s on average, last gap %s", formatDays(stats.AverageInterval), formatDays(stats.LastInterval))
+		}
+		fmt.Fprintf(&b, "\nLast %s: ", formatDays(analyticsPeriod))
+		if stats.Recent == 0 {
+			b.WriteString("none")
+		} else {
+			fmt.Fprintf(&b, "%s, %s in total", countOf(stats.Recent, "occasion"), formatAmounts(stats.RecentAmounts))
+		}
+		if trend := stats.Trend(now); trend != "" {
+			b.WriteString(", " + trend)
+		}
+		if warning := stats.SpacingWarning(); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	if len(warnings) > 0 {
+		b.WriteString("\n\n<b>Spacing</b>")
+		for _, warning := range warnings {
+			b.WriteString("\n• " + warning)
+		}
+	}
+	b.WriteString("\n\n<i>Based only on what you logged. Doses less than 12 hours apart count as one occasion.</i>")
+	return b.String()
+}
+
+// HandleDoseStatsCommand shows the statistics of the user's dose log.
+func HandleDoseStatsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
+	chatID := update.Message.Chat.ID
+	history := DoseHistory(update.Message.From.ID)
+	if len(history) == 0 {
+		return SendHTML(bot, chatID, "Nothing logged yet. Use /log &lt;substance&gt; &lt;amount&gt; to add a dose.")
+	}
+	now := time.Now()
+	return SendHTML(bot, chatID, FormatDoseStats(DoseStats(history, now), now, UserLocation(update.Message.From.ID)))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	MaxDoseEntriesPerUser = 500
+	HistoryPageSize       = 10
+)
+
+// DoseEntry is one logged dose.
+type DoseEntry struct {
+	Substance string    `json:"substance"`
+	Amount    float64   `json:"amount"`
+	Unit      string    `json:"unit"`
+	Route     string    `json:"route,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+var (
+	doseLog       *JSONStore[[]DoseEntry]
+	amountPattern = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)([a-zA-Zµ]*)$`)
+)
+
+// ParseDose parses "<substance> <amount>[unit] [unit] [route]", e.g. "mdma 120mg oral".
+func ParseDose(args string) (DoseEntry, error) {
+	fields := strings.Fields(args)
+	for i, field := range fields {
+		match := amountPattern.FindStringSubmatch(field)
+		if match == nil || i == 0 {
+			continue
+		}
+		amount, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+		if err != nil {
+			break
+		}
+		entry := DoseEntry{
+			Substance: strings.ToLower(strings.Join(fields[:i], " ")),
+			Amount:    amount,
+			Unit:      strings.ToLower(match[2]),
+			At:        time.Now(),
+		}
+		rest := fields[i+1:]
+		if entry.Unit == "" && len(rest) > 0 {
+			entry.Unit, rest = strings.ToLower(rest[0]), rest[1:]
+		}
+		entry.Route = strings.ToLower(strings.Join(rest, " "))
+		return entry, nil
+	}
+	return DoseEntry{}, fmt.Errorf("usage: /log <substance> <amount><unit> [route]")
+}
+
+// DoseHistory returns a user's logged doses, oldest first.
+func DoseHistory(userID int64) []DoseEntry {
+	if doseLog == nil {
+		return nil
+	}
+	entries, _ := doseLog.Get(ChatKey(userID))
+	return entries
+}
+
+func AppendDose(userID int64, entry DoseEntry) error {
+	return doseLog.Update(ChatKey(userID), func(entries []DoseEntry) []DoseEntry {
+		entries = append(append([]DoseEntry{}, entries...), entry)
+		if len(entries) > MaxDoseEntriesPerUser {
+			entries = entries[len(entries)-MaxDoseEntriesPerUser:]
+		}
+		return entries
+	})
+}
+
+// ActiveInteractions finds substances in the history still within their duration window at
+// the time of entry, and returns the risky combinations with the newly logged substance.
+func ActiveInteractions(history []DoseEntry, entry DoseEntry) []string {
+	newKey, _, _ := LookupSubstance(entry.Substance)
+	seen := map[string]bool{newKey: true}
+
+	var warnings []string
+	for i := len(history) - 1; i >= 0; i-- {
+		previous := history[i]
+		key, substance, ok := LookupSubstance(previous.Substance)
+		if !ok || seen[key] || previous.At.Add(substance.Duration).Before(entry.At) {
+			continue
+		}
+		seen[key] = true
+
+		risk, ok := Interaction(newKey, key)
+		if !ok || !IsRiskyInteraction(risk) {
+			continue
+		}
+		ago := entry.At.Sub(previous.At).Round(time.Minute)
+		warnings = append(warnings, fmt.Sprintf("%s <b>%s</b> with %s (logged %s ago)",
+			RiskEmoji(risk), html.EscapeString(risk), html.EscapeString(substance.Name), ago))
+	}
+	return warnings
+}
+
+func FormatDose(entry DoseEntry, locale Locale) string {
+	name := entry.Substance
+	if _, substance, ok := LookupSubstance(entry.Substance); ok {
+		name = substance.Name
+	}
+	text := fmt.Sprintf("%s %s%s", html.EscapeString(name), locale.Number(entry.Amount), html.EscapeString(entry.Unit))
+	if entry.Route != "" {
+		text += " " + html.EscapeString(entry.Route)
+	}
+	return text
+}
+
+// HandleLogCommand logs a dose given inline, or starts the guided form when there are no arguments.
+func HandleLogCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if strings.TrimSpace(args) == "" {
+		return StartLogForm(bot, chatID, update.Message.From.ID)
+	}
+	entry, err := ParseDose(args)
+	if err != nil {
+		return SendHTML(bot, chatID, html.EscapeString("Usage: /log <substance> <amount><unit> [route], e.g. /log mdma 100mg oral, or just /log for a guided form"))
+	}
+	if entry.Unit == "" {
+		entry.Unit = GetUserSettings(update.Message.From.ID).DoseUnit
+	}
+	if held, err := HoldImplausibleDose(bot, chatID, 0, update.Message.From.ID, entry); held {
+		return err
+	}
+
+	reply, err := LogDose(update.Message.From.ID, entry)
+	if err != nil {
+		return err
+	}
+	return SendHTML(bot, chatID, reply)
+}
+
+// LogDose appends a dose to the user's log and returns the confirmation, with any interaction
+// warnings against doses that are still active.
+func LogDose(userID int64, entry DoseEntry) (string, error) {
+	history := DoseHistory(userID)
+	if err := AppendDose(userID, entry); err != nil {
+		return "", err
+	}
+
+	locale := UserLocale(userID)
+	reply := "✅ Logged " + FormatDose(entry, locale)
+	if _, substance, ok := LookupSubstance(entry.Substance); ok {
+		until := entry.At.Add(substance.Duration).In(UserLocation(userID))
+		reply += fmt.Sprintf("\nConsidered active until about %s %s.", until.Format("Mon"), locale.Clock(until))
+	}
+	// Only a dose that starts a new occasion can be too soon after the last one
+	key, _, _ := LookupSubstance(entry.Substance)
+	for _, stats := range DoseStats(append(history, entry), entry.At) {
+		if stats.Key == key && stats.Last.Equal(entry.At) {
+			if warning := stats.SpacingWarning(); warning != "" {
+				reply += "\n\n🗓 " + warning
+			}
+		}
+	}
+	reply += NotesReminder(userID, []string{key}, UserLocation(userID))
+	if warnings := ActiveInteractions(history, entry); len(warnings) > 0 {
+		reply += "\n\n⚠️ <b>Interaction warning</b>\n" + strings.Join(warnings, "\n") +
+			"\n\n<i>Risk levels from the " + InteractionSource + ". Consider waiting, lowering the dose, or having a sober sitter.</i>"
+	}
+	return reply, nil
+}
+
+// HandleHistoryCommand lists the most recent doses, or with "stats" the statistics of the whole log.
+func HandleHistoryCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	if strings.EqualFold(strings.TrimSpace(args), "stats") {
+		return HandleDoseStatsCommand(bot, update)
+	}
+	chatID := update.Message.Chat.ID
+	history := DoseHistory(update.Message.From.ID)
+	if len(history) == 0 {
+		return SendHTML(bot, chatID, "Nothing logged yet. Use /log &lt;substance&gt; &lt;amount&gt; to add a dose.")
+	}
+
+	var b strings.Builder
+	location := UserLocation(update.Message.From.ID)
+	locale := UserLocale(update.Message.From.ID)
+	fmt.Fprintf(&b, "<b>Recent doses</b> (%s)\n", html.EscapeString(location.String()))
+	for i := len(history) - 1; i >= 0 && i >= len(history)-HistoryPageSize; i-- {
+		at := history[i].At.In(location)
+		fmt.Fprintf(&b, "%s %s · %s\n", at.Format("2006-01-02"), locale.Clock(at), FormatDose(history[i], locale))
+	}
+	b.WriteString("\n<i>/history stats shows averages, trends and spacing.</i>")
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = DoseChartsKeyboard()
+	_, err := bot.Send(msg)
+	return err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Effect categories, in display order.
+const (
+	EffectsPhysical  = "physical"
+	EffectsCognitive = "cognitive"
+	EffectsVisual    = "visual"
+	EffectsAfter     = "after"
+)
+
+var effectCategories = []struct{ Key, Title string }{
+	{EffectsPhysical, "💪 Physical"},
+	{EffectsCognitive, "🧠 Cognitive"},
+	{EffectsVisual, "👁 Visual"},
+	{EffectsAfter, "🌅 After-effects"},
+}
+
+// substanceEffects lists commonly reported effects per category, curated from harm reduction
+// factsheets. Substances without an entry have no structured effects yet.
+var substanceEffects = map[string]map[string][]string{
+	"mdma": {
+		EffectsPhysical:  {"Stimulation and increased energy", "Jaw clenching and teeth grinding", "Raised heart rate and body temperature", "Dilated pupils", "Nausea during the come-up"},
+		EffectsCognitive: {"Euphoria", "Empathy and emotional openness", "Increased sociability", "Anxiety or restlessness at high doses"},
+		EffectsVisual:    {"Mild blurring or nystagmus (eye wiggle)"},
+		EffectsAfter:     {"Low mood for a few days (\"Tuesday blues\")", "Fatigue and trouble sleeping", "Reduced appetite", "Sore jaw"},
+	},
+	"lsd": {
+		EffectsPhysical:  {"Dilated pupils", "Mild stimulation", "Raised heart rate", "Body temperature changes"},
+		EffectsCognitive: {"Altered sense of time", "Thought loops and novel associations", "Heightened emotions", "Anxiety or paranoia in difficult trips"},
+		EffectsVisual:    {"Breathing or drifting surfaces", "Enhanced colours", "Geometric patterns with eyes closed", "Tracers"},
+		EffectsAfter:     {"Afterglow or mental clarity", "Tiredness", "Trouble sleeping the night after"},
+	},
+	"psilocybin": {
+		EffectsPhysical:  {"Nausea during the come-up", "Yawning", "Muscle weakness or heaviness", "Dilated pupils"},
+		EffectsCognitive: {"Introspection", "Emotional release, laughter or tears", "Altered sense of time", "Anxiety in difficult trips"},
+		EffectsVisual:    {"Breathing surfaces", "Organic, flowing patterns", "Enhanced colours"},
+		EffectsAfter:     {"Afterglow", "Tiredness", "Mild headache"},
+	},
+	"cannabis": {
+		EffectsPhysical:  {"Dry mouth and red eyes", "Increased appetite", "Raised heart rate", "Relaxed muscles"},
+		EffectsCognitive: {"Relaxation or euphoria", "Impaired short-term memory", "Altered sense of time", "Anxiety or paranoia, especially with high-THC strains"},
+		EffectsVisual:    {"Enhanced colours at higher doses", "Closed-eye imagery at very high doses"},
+		EffectsAfter:     {"Grogginess", "Vivid dreams when stopping after regular use"},
+	},
+	"cocaine": {
+		EffectsPhysical:  {"Stimulation", "Raised heart rate and blood pressure", "Numbness of the nose and throat", "Reduced appetite"},
+		EffectsCognitive: {"Euphoria and confidence", "Talkativeness", "Irritability or paranoia", "Strong urge to redose"},
+		EffectsVisual:    {},
+		EffectsAfter:     {"Comedown with low mood and anxiety", "Fatigue", "Nasal irritation or nosebleeds"},
+	},
+	"ketamine": {
+		EffectsPhysical:  {"Numbness", "Loss of coordination", "Nausea", "Raised heart rate"},
+		EffectsCognitive: {"Dissociation from body and surroundings", "Dream-like states", "Confusion", "\"K-hole\" at high doses"},
+		EffectsVisual:    {"Distorted sense of space", "Double vision", "Closed-eye imagery"},
+		EffectsAfter:     {"Grogginess", "Bladder pain with frequent use", "Low mood"},
+	},
+	"alcohol": {
+		EffectsPhysical:  {"Loss of coordination", "Flushing", "Nausea and vomiting at high doses", "Dehydration"},
+		EffectsCognitive: {"Disinhibition", "Relaxation", "Impaired judgement and memory", "Drowsiness"},
+		EffectsVisual:    {"Blurred or double vision at high doses"},
+		EffectsAfter:     {"Hangover with headache and nausea", "Anxiety (\"hangxiety\")", "Poor sleep"},
+	},
+	"amphetamine": {
+		EffectsPhysical:  {"Stimulation", "Raised heart rate and blood pressure", "Reduced appetite", "Jaw clenching"},
+		EffectsCognitive: {"Focus and motivation", "Euphoria", "Talkativeness", "Anxiety or irritability"},
+		EffectsVisual:    {"Dilated pupils and light sensitivity"},
+		EffectsAfter:     {"Comedown with low mood", "Trouble sleeping", "Exhaustion"},
+	},
+	"nitrous": {
+		EffectsPhysical:  {"Tingling", "Dizziness", "Loss of coordination"},
+		EffectsCognitive: {"Brief euphoria and laughter", "Dissociation", "Distorted sounds"},
+		EffectsVisual:    {"Brief visual distortions"},
+		EffectsAfter:     {"Headache", "Numbness in hands and feet with heavy use (B12 depletion)"},
+	},
+}
+
+// FormatEffects renders the effects overview with the expanded category, if any, listed in full.
+func FormatEffects(key, expanded string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	effects := substanceEffects[key]
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s effects</b>\n", html.EscapeString(substances[key].Name))
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, category := range effectCategories {
+		list := effects[category.Key]
+		if len(list) == 0 {
+			continue
+		}
+		if category.Key == expanded {
+			fmt.Fprintf(&b, "\n<b>%s</b>\n", category.Title)
+			for _, effect := range list {
+				fmt.Fprintf(&b, "• %s\n", html.EscapeString(effect))
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s · %d effects", category.Title, len(list))
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(category.Title, "eff:"+key+":"+category.Key))
+	}
+	b.WriteString("\n\n<i>Effects differ with dose, set and setting. Not everyone gets all of them.</i>")
+
+	if len(row) == 0 {
+		return b.String(), nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return b.String(), &markup
+}
+
+func HandleEffectsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return SendHTML(bot, chatID, "Usage: /effects &lt;substance&gt;, e.g. /effects mdma")
+	}
+	matches := ResolveSubstance(name)
+	if len(matches) == 0 || matches[0].Confidence < ConfidentMatch {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't know the substance <b>%s</b>.", html.EscapeString(name)))
+	}
+	key := matches[0].Key
+	if _, ok := substanceEffects[key]; !ok {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't have structured effects for <b>%s</b> yet. Try asking me about it instead.",
+			html.EscapeString(substances[key].Name)))
+	}
+
+	text, markup := FormatEffects(key, "")
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleEffectsCallback expands one category of an effects overview ("eff:<substance>:<category>").
+func HandleEffectsCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 2 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	if _, ok := substanceEffects[args[0]]; !ok {
+		return AnswerCallback(bot, query, "Unknown substance.")
+	}
+	text, markup := FormatEffects(args[0], args[1])
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, nil, markup); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
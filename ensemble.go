@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnsembleCandidate is one model's answer in an ensemble run.
+type EnsembleCandidate struct {
+	Model  string  `json:"model"`
+	Answer string  `json:"answer,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	Score  float64 `json:"score"`
+}
+
+// EnsembleRecord keeps every candidate of an ensemble answer so the selection can be evaluated
+// against the asker's rating later. It shares its key with the feedback entry.
+type EnsembleRecord struct {
+	ChatID     int64               `json:"chat_id"`
+	MessageID  int                 `json:"message_id"`
+	Question   string              `json:"question"`
+	Candidates []EnsembleCandidate `json:"candidates"`
+	Chosen     int                 `json:"chosen"`
+	Method     string              `json:"method"`
+	At         time.Time           `json:"at"`
+}
+
+// Ensemble selection methods.
+const (
+	EnsembleHeuristic = "heuristic"
+	EnsembleRanker    = "ranker"
+)
+
+var ensembleLog *JSONStore[EnsembleRecord]
+
+var (
+	citationPattern = regexp.MustCompile(`https?://|\[\d+\]|\b(source|according to|study|studies)\b`)
+	rankPattern     = regexp.MustCompile(`^\W*([AB])\b`)
+)
+
+// EnsembleModel is ENSEMBLE_MODEL, the backend model asked alongside the default one.
+func EnsembleModel() string {
+	return GetenvVar("ENSEMBLE_MODEL", false)
+}
+
+// EnsembleEnabled reports whether questions should go to both models. It needs the ensemble
+// flag, a second model and the PsyAI API, which serves both.
+func EnsembleEnabled(chatID int64, userID int64) bool {
+	return EnsembleModel() != "" && ProviderName() == ProviderPsyAI && FeatureEnabled(FlagEnsemble, chatID, userID)
+}
+
+// modelURL returns the prompt endpoint URL with its model parameter replaced.
+func modelURL(apiURL, model string) string {
+	parsed, err := url.Parse(apiURL)
+	if err != nil {
+		return apiURL
+	}
+	query := parsed.Query()
+	query.Set("model", model)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// ScoreAnswer rates an answer for ensemble selection: sourced, structured answers of a useful
+// length score higher, refusals lowest.
+func ScoreAnswer(response *PromptResponse) float64 {
+	if response == nil || response.Refused() || response.Assistant == "" {
+		return -10
+	}
+	answer := response.Assistant
+	score := 0.0
+	score += 2 * float64(min(len(citationPattern.FindAllString(strings.ToLower(answer), -1)), 3))
+	if len(ExtractKeyPoints(answer)) >= 3 {
+		score++
+	}
+	switch length := len([]rune(answer)); {
+	case length < 150:
+		score -= 2
+	case length > 3000:
+		score--
+	}
+	return score
+}
+
+// rankAnswers asks the default model which of two answers is better, returning 0 or 1, or -1
+// when the verdict can't be parsed.
+func rankAnswers(apiURL, question, a, b string) int {
+	response, err := Prompt(apiURL, PromptRequest{
+		Question: fmt.Sprintf("Question: %s\n\nAnswer A:\n%s\n\nAnswer B:\n%s\n\n"+
+			"Which answer is more accurate, safer and better sourced for a harm reduction service? Reply with only the letter A or B.",
+			question, Truncate(a, 3000), Truncate(b, 3000)),
+		Temperature: 0,
+		Tokens:      5,
+	})
+	if err != nil {
+		log.Printf("Error ranking ensemble answers: %v", err)
+		return -1
+	}
+	match := rankPattern.FindStringSubmatch(strings.ToUpper(response.Assistant))
+	if match == nil {
+		return -1
+	}
+	if match[1] == "A" {
+		return 0
+	}
+	return 1
+}
+
+// PromptEnsemble asks the default model and ENSEMBLE_MODEL in parallel and returns the better
+// answer, with a record of both. ENSEMBLE_RANKER=backend lets the default model pick between
+// them; otherwise, or when ranking fails, ScoreAnswer decides. Ties go to the default model.
+func PromptEnsemble(apiURL string, request PromptRequest) (*PromptResponse, EnsembleRecord, error) {
+	models := []string{"", EnsembleModel()}
+	if parsed, err := url.Parse(apiURL); err == nil {
+		models[0] = parsed.Query().Get("model")
+	}
+
+	responses := make([]*PromptResponse, len(models))
+	errs := make([]error, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			responses[i], errs[i] = Prompt(modelURL(apiURL, model), request)
+		}(i, model)
+	}
+	wg.Wait()
+
+	record := EnsembleRecord{Question: request.Question, Method: EnsembleHeuristic, At: time.Now()}
+	for i, model := range models {
+		candidate := EnsembleCandidate{Model: model, Score: ScoreAnswer(responses[i])}
+		if errs[i] != nil {
+			candidate.Error = errs[i].Error()
+		} else {
+			candidate.Answer = responses[i].Text()
+		}
+		record.Candidates = append(record.Candidates, candidate)
+	}
+
+	switch {
+	case errs[0] != nil && errs[1] != nil:
+		return nil, record, errs[0]
+	case errs[0] != nil:
+		record.Chosen = 1
+	case errs[1] != nil:
+		record.Chosen = 0
+	default:
+		record.Chosen = 0
+		if record.Candidates[1].Score > record.Candidates[0].Score {
+			record.Chosen = 1
+		}
+		if GetenvVar("ENSEMBLE_RANKER", false) == "backend" && !responses[0].Refused() && !responses[1].Refused() {
+			if choice := rankAnswers(apiURL, request.Question, responses[0].Assistant, responses[1].Assistant); choice >= 0 {
+				record.Chosen, record.Method = choice, EnsembleRanker
+			}
+		}
+	}
+	return responses[record.Chosen], record, nil
+}
+
+// RecordEnsemble stores an ensemble run under the answer's feedback key.
+func RecordEnsemble(chatID int64, messageID int, record EnsembleRecord) error {
+	if ensembleLog == nil {
+		return nil
+	}
+	record.ChatID, record.MessageID = chatID, messageID
+	return ensembleLog.Set(FeedbackKey(chatID, messageID), record)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// ErrorContext is the non-identifying context attached to a tracked error.
+type ErrorContext struct {
+	Command        string
+	ChatType       string
+	BackendLatency time.Duration
+}
+
+// reportedError marks an error that was already sent to error tracking.
+type reportedError struct{ error }
+
+func (e reportedError) Unwrap() error { return e.error }
+
+var (
+	errorTrackingEnabled bool
+
+	piiPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`),        // emails
+		regexp.MustCompile(`@\w{3,}`),                         // usernames
+		regexp.MustCompile(`\+?\d[\d\s().-]{7,}\d`),           // phone numbers and other long numbers
+		regexp.MustCompile(`(?i)https?://[^\s"']+\?[^\s"']+`), // URLs with query strings
+	}
+)
+
+// InitErrorTracking enables Sentry-compatible error tracking when SENTRY_DSN is set.
+func InitErrorTracking() {
+	dsn := GetenvVar("SENTRY_DSN", false)
+	if dsn == "" {
+		return
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      GetenvVar("SENTRY_ENVIRONMENT", false),
+		AttachStacktrace: true,
+		SendDefaultPII:   false,
+		BeforeSend:       scrubEvent,
+		BeforeBreadcrumb: func(breadcrumb *sentry.Breadcrumb, _ *sentry.BreadcrumbHint) *sentry.Breadcrumb {
+			breadcrumb.Message = ScrubPII(breadcrumb.Message)
+			return breadcrumb
+		},
+	})
+	if err != nil {
+		log.Printf("Error initializing error tracking: %v", err)
+		return
+	}
+	errorTrackingEnabled = true
+}
+
+// FlushErrorTracking waits for queued events to be uploaded.
+func FlushErrorTracking() {
+	if errorTrackingEnabled {
+		sentry.Flush(2 * time.Second)
+	}
+}
+
+// ScrubPII masks emails, usernames, phone numbers and query strings in text bound for error tracking.
+func ScrubPII(text string) string {
+	text = RedactSecrets(text)
+	for _, pattern := range piiPatterns {
+		text = pattern.ReplaceAllString(text, "[redacted]")
+	}
+	return text
+}
+
+func scrubEvent(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	event.Message = ScrubPII(event.Message)
+	for i := range event.Exception {
+		event.Exception[i].Value = ScrubPII(event.Exception[i].Value)
+	}
+	event.Request = nil
+	event.User = sentry.User{ID: event.User.ID}
+	return event
+}
+
+// ReportError sends err to error tracking with its context. Errors already reported are skipped,
+// as are Telegram failures that are remediated automatically (see ExpectedTelegramError),
+// and the returned error is marked so callers further up don't report it twice.
+func ReportError(err error, context ErrorContext) error {
+	var reported reportedError
+	if err == nil || !errorTrackingEnabled || errors.As(err, &reported) || ExpectedTelegramError(err) {
+		return err
+	}
+
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("command", context.Command)
+		scope.SetTag("chat_type", context.ChatType)
+		if context.BackendLatency > 0 {
+			scope.SetContext("backend", sentry.Context{"latency_ms": context.BackendLatency.Milliseconds()})
+		}
+		sentry.CaptureException(err)
+	})
+	return reportedError{err}
+}
+
+// ReportPanic sends a recovered panic value to error tracking and logs it.
+func ReportPanic(recovered interface{}, context ErrorContext) {
+	log.Printf("Recovered from panic: %v", recovered)
+	if !errorTrackingEnabled {
+		return
+	}
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("command", context.Command)
+		scope.SetTag("chat_type", context.ChatType)
+		scope.SetLevel(sentry.LevelFatal)
+		sentry.CaptureException(fmt.Errorf("panic: %v", recovered))
+	})
+}
+
+// AddBreadcrumb records a step of update handling. Callers must not pass message text.
+func AddBreadcrumb(category, message string, data map[string]interface{}) {
+	if !errorTrackingEnabled {
+		return
+	}
+	sentry.AddBreadcrumb(&sentry.Breadcrumb{
+		Category:  category,
+		Message:   message,
+		Data:      data,
+		Timestamp: time.Now(),
+	})
+}
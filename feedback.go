@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	RatingUp   = 1
+	RatingDown = -1
+)
+
+// FeedbackEntry is an answered question and the asker's rating of it.
+type FeedbackEntry struct {
+	ChatID     int64     `json:"chat_id"`
+	MessageID  int       `json:"message_id"`
+	UserID     int64     `json:"user_id"`
+	Question   string    `json:"question"`
+	Answer     string    `json:"answer"`
+	Rating     int       `json:"rating,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	AnsweredAt time.Time `json:"answered_at"`
+	RatedAt    time.Time `json:"rated_at,omitempty"`
+}
+
+var (
+	feedback *JSONStore[FeedbackEntry]
+
+	// commentPrompts maps the "what was wrong?" prompt message to the feedback it belongs to.
+	commentPrompts = NewBoundedMap[string, string]("feedback_comment_prompts", 10000, 24*time.Hour)
+)
+
+func FeedbackKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// FeedbackButtons returns the rating buttons attached under an answer.
+func FeedbackButtons(messageID int) []tgbotapi.InlineKeyboardButton {
+	id := strconv.Itoa(messageID)
+	return tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("👍", "fb:up:"+id),
+		tgbotapi.NewInlineKeyboardButtonData("👎", "fb:down:"+id),
+	)
+}
+
+// RecordAnswer keeps the exchange so a later rating can be tied back to it.
+func RecordAnswer(chatID int64, messageID int, userID int64, question, answer string) error {
+	if feedback == nil {
+		return nil
+	}
+	return feedback.Set(FeedbackKey(chatID, messageID), FeedbackEntry{
+		ChatID:     chatID,
+		MessageID:  messageID,
+		UserID:     userID,
+		Question:   question,
+		Answer:     answer,
+		AnsweredAt: time.Now(),
+	})
+}
+
+// HandleFeedbackCallback handles taps on the 👍/👎 buttons ("fb:up:<id>" / "fb:down:<id>").
+func HandleFeedbackCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 2 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	messageID, err := strconv.Atoi(args[1])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	key := FeedbackKey(query.Message.Chat.ID, messageID)
+
+	entry, ok := feedback.Get(key)
+	if !ok {
+		return AnswerCallback(bot, query, "This answer is too old to rate.")
+	}
+	if entry.UserID != 0 && entry.UserID != query.From.ID {
+		return AnswerCallback(bot, query, "Only the person who asked can rate this answer.")
+	}
+
+	rating := RatingUp
+	if args[0] == "down" {
+		rating = RatingDown
+	}
+	err = feedback.Update(key, func(entry FeedbackEntry) FeedbackEntry {
+		entry.Rating = rating
+		entry.RatedAt = time.Now()
+		return entry
+	})
+	if err != nil {
+		return err
+	}
+	EmitEvent(UserEvent(EventFeedbackReceived, query.From.ID, query.Message.Chat.ID, query.Message.Chat.Type, map[string]interface{}{"rating": rating}))
+
+	if rating == RatingUp {
+		return AnswerCallback(bot, query, "Thanks for the feedback!")
+	}
+	if err := AnswerCallback(bot, query, "Thanks, we'll look into it."); err != nil {
+		return err
+	}
+
+	prompt := tgbotapi.NewMessage(query.Message.Chat.ID, FeedbackCommentPrompt)
+	prompt.ReplyToMessageID = messageID
+	prompt.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+	sent, err := bot.Send(prompt)
+	if err != nil {
+		return err
+	}
+	commentPrompts.Set(FeedbackKey(sent.Chat.ID, sent.MessageID), key)
+	return nil
+}
+
+// HandleFeedbackComment stores a reply to a "what was wrong?" prompt. It reports whether the message was one.
+func HandleFeedbackComment(bot *tgbotapi.BotAPI, update tgbotapi.Update) (bool, error) {
+	reply := update.Message.ReplyToMessage
+	if reply == nil {
+		return false, nil
+	}
+	promptKey := FeedbackKey(reply.Chat.ID, reply.MessageID)
+	value, ok := commentPrompts.LoadAndDelete(promptKey)
+	if !ok {
+		return false, nil
+	}
+
+	err := feedback.Update(value, func(entry FeedbackEntry) FeedbackEntry {
+		entry.Comment = update.Message.Text
+		return entry
+	})
+	if err != nil {
+		return true, err
+	}
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Thanks, your comment was saved.")
+	msg.ReplyToMessageID = update.Message.MessageID
+	_, err = bot.Send(msg)
+	return true, err
+}
+
+// NegativeFeedback returns thumbs-down entries, newest first.
+func NegativeFeedback() []FeedbackEntry {
+	var entries []FeedbackEntry
+	feedback.Range(func(_ string, entry FeedbackEntry) bool {
+		if entry.Rating == RatingDown {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RatedAt.After(entries[j].RatedAt)
+	})
+	return entries
+}
+
+func HandleFeedbackCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+	if strings.TrimSpace(args) != "review" {
+		return SendHTML(bot, chatID, "Usage: /feedback review")
+	}
+
+	text, markup := FeedbackReviewPage(0)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleFeedbackReviewCallback pages through negative feedback ("fbr:<page>").
+func HandleFeedbackReviewCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if !IsBotAdmin(query.From.ID) || query.Message == nil || len(args) != 1 {
+		return AnswerCallback(bot, query, "")
+	}
+	page, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+
+	text, markup := FeedbackReviewPage(page)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = markup
+	if _, err := bot.Send(edit); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
+
+// FeedbackReviewPage renders one thumbs-down entry with navigation buttons.
+func FeedbackReviewPage(page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	entries := NegativeFeedback()
+	if len(entries) == 0 {
+		return "No negative feedback yet.", nil
+	}
+	if page < 0 {
+		page = 0
+	}
+	if page >= len(entries) {
+		page = len(entries) - 1
+	}
+	entry := entries[page]
+
+	comment := entry.Comment
+	if comment == "" {
+		comment = "(none)"
+	}
+	text := fmt.Sprintf(
+		"<b>Feedback %d/%d</b> · %s\n\n<b>Question</b>\n%s\n\n<b>Answer</b>\n%s\n\n<b>Comment</b>\n%s",
+		page+1, len(entries), entry.RatedAt.UTC().Format("2006-01-02 15:04"),
+		html.EscapeString(Truncate(entry.Question, 500)),
+		html.EscapeString(Truncate(entry.Answer, 2500)),
+		html.EscapeString(Truncate(comment, 500)),
+	)
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("◀ Newer", "fbr:"+strconv.Itoa(page-1)))
+	}
+	if page < len(entries)-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Older ▶", "fbr:"+strconv.Itoa(page+1)))
+	}
+	if len(row) == 0 {
+		return text, nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return text, &markup
+}
+
+// Truncate shortens s to at most limit runes, marking the cut with an ellipsis.
+func Truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// ScheduleFeedbackExport writes the previous day's rated feedback to a JSONL file every night at
+// FEEDBACK_EXPORT_HOUR UTC (default 3).
+func ScheduleFeedbackExport() {
+	hour, err := strconv.Atoi(GetenvVar("FEEDBACK_EXPORT_HOUR", false))
+	if err != nil || hour < 0 || hour > 23 {
+		hour = 3
+	}
+
+	ScheduleJob(Job{
+		Name:       "feedback_export",
+		Schedule:   DailyAt(hour),
+		Retries:    2,
+		RetryDelay: 10 * time.Minute,
+		Run: func(due time.Time) error {
+			path, count, err := ExportFeedback(due.Add(-24*time.Hour), due)
+			if err != nil {
+				return fmt.Errorf("error exporting feedback: %w", err)
+			}
+			log.Printf("Exported %d feedback entries to %s", count, path)
+			return nil
+		},
+	})
+}
+
+// ExportFeedback writes entries rated in [from, to) to <DATA_DIR>/exports/feedback-<date>.jsonl.
+func ExportFeedback(from, to time.Time) (string, int, error) {
+	dir := filepath.Join(DataDir(), "exports")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, fmt.Errorf("error creating export dir: %w", err)
+	}
+	path := filepath.Join(dir, "feedback-"+from.Format("2006-01-02")+".jsonl")
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating export file: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	count := 0
+	feedback.Range(func(_ string, entry FeedbackEntry) bool {
+		if entry.Rating == 0 || entry.RatedAt.Before(from) || !entry.RatedAt.Before(to) {
+			return true
+		}
+		if err = encoder.Encode(entry); err != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("error writing export file: %w", err)
+	}
+	return path, count, nil
+}
+
+// IsReplyToBot reports whether the message directly replies to something the bot sent.
+func IsReplyToBot(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	reply := message.ReplyToMessage
+	return reply != nil && reply.From != nil && reply.From.ID == bot.Self.ID
+}
+
+// RepliedTurn rebuilds the exchange behind a bot answer being replied to, falling back to
+// the visible answer text when the original question is no longer recorded.
+func RepliedTurn(reply *tgbotapi.Message) Turn {
+	if feedback != nil {
+		if entry, ok := feedback.Get(FeedbackKey(reply.Chat.ID, reply.MessageID)); ok {
+			return Turn{Question: entry.Question, Answer: entry.Answer, At: entry.AnsweredAt}
+		}
+	}
+	text, _ := MessageText(reply)
+	return Turn{Answer: text, At: time.Unix(int64(reply.Date), 0)}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Known feature flags.
+const (
+	FlagStreaming     = "streaming"
+	FlagVision        = "vision"
+	FlagSemanticCache = "semantic_cache"
+	FlagEnsemble      = "ensemble"
+)
+
+var knownFlags = []string{FlagStreaming, FlagVision, FlagSemanticCache, FlagEnsemble}
+
+// FeatureFlag decides whether an experimental capability is on for a chat and user.
+// Chat overrides win, then Enabled, then the Percentage rollout of users.
+type FeatureFlag struct {
+	Enabled    bool            `json:"enabled,omitempty"`
+	Percentage int             `json:"percentage,omitempty"`
+	Chats      map[string]bool `json:"chats,omitempty"`
+}
+
+// flagOverrides holds runtime changes made with /flags on top of FEATURE_FLAGS.
+var flagOverrides *JSONStore[FeatureFlag]
+
+// ParseFlagValue parses "on", "off" or "<n>%".
+func ParseFlagValue(value string) (FeatureFlag, error) {
+	switch value {
+	case "on", "true":
+		return FeatureFlag{Enabled: true}, nil
+	case "off", "false":
+		return FeatureFlag{}, nil
+	}
+	percentage, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || !strings.HasSuffix(value, "%") || percentage < 0 || percentage > 100 {
+		return FeatureFlag{}, fmt.Errorf("invalid flag value %q, expected on, off or a percentage", value)
+	}
+	return FeatureFlag{Percentage: percentage}, nil
+}
+
+// configFlags parses FEATURE_FLAGS, e.g. "streaming=on,vision=25%".
+func configFlags() map[string]FeatureFlag {
+	flags := map[string]FeatureFlag{}
+	if GetenvVar("STREAMING_ENABLED", false) == "true" {
+		flags[FlagStreaming] = FeatureFlag{Enabled: true}
+	}
+	for _, entry := range strings.Split(GetenvVar("FEATURE_FLAGS", false), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		flag, err := ParseFlagValue(strings.ToLower(strings.TrimSpace(value)))
+		if err != nil {
+			continue
+		}
+		flags[strings.TrimSpace(name)] = flag
+	}
+	return flags
+}
+
+// LookupFlag returns the effective definition of a flag.
+func LookupFlag(name string) FeatureFlag {
+	if flagOverrides != nil {
+		if flag, ok := flagOverrides.Get(name); ok {
+			return flag
+		}
+	}
+	return configFlags()[name]
+}
+
+// FeatureEnabled evaluates a flag for a chat and user.
+func FeatureEnabled(name string, chatID int64, userID int64) bool {
+	flag := LookupFlag(name)
+	if enabled, ok := flag.Chats[ChatKey(chatID)]; ok {
+		return enabled
+	}
+	if flag.Enabled {
+		return true
+	}
+	if flag.Percentage <= 0 {
+		return false
+	}
+	// Hashing the flag name with the user keeps each user's bucket stable but independent per flag
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + strconv.FormatInt(userID, 10)))
+	return int(h.Sum32()%100) < flag.Percentage
+}
+
+func FormatFlag(name string, flag FeatureFlag) string {
+	state := "off"
+	switch {
+	case flag.Enabled:
+		state = "on"
+	case flag.Percentage > 0:
+		state = fmt.Sprintf("%d%%", flag.Percentage)
+	}
+	text := fmt.Sprintf("<code>%s</code>: %s", html.EscapeString(name), state)
+	if len(flag.Chats) > 0 {
+		text += fmt.Sprintf(" (%d chat overrides)", len(flag.Chats))
+	}
+	return text
+}
+
+func HandleFlagsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	fields := strings.Fields(strings.ToLower(args))
+	const usage = "Usage:\n/flags\n/flags set &lt;flag&gt; on|off|&lt;n&gt;%\n/flags chat &lt;flag&gt; on|off|default\n/flags reset &lt;flag&gt;"
+
+	switch {
+	case len(fields) == 0:
+		names := append([]string{}, knownFlags...)
+		seen := map[string]bool{}
+		for _, name := range names {
+			seen[name] = true
+		}
+		for name := range configFlags() {
+			if !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+		flagOverrides.Range(func(name string, _ FeatureFlag) bool {
+			if !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+			return true
+		})
+		sort.Strings(names)
+
+		lines := []string{"<b>Feature flags</b>"}
+		for _, name := range names {
+			line := FormatFlag(name, LookupFlag(name))
+			if FeatureEnabled(name, chatID, update.Message.From.ID) {
+				line += " ✅ here"
+			}
+			lines = append(lines, line)
+		}
+		return SendHTML(bot, chatID, strings.Join(lines, "\n"))
+
+	case len(fields) == 3 && fields[0] == "set":
+		flag, err := ParseFlagValue(fields[2])
+		if err != nil {
+			return SendHTML(bot, chatID, html.EscapeString(err.Error()))
+		}
+		flag.Chats = LookupFlag(fields[1]).Chats
+		if err := flagOverrides.Set(fields[1], flag); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, FormatFlag(fields[1], flag))
+
+	case len(fields) == 3 && fields[0] == "chat":
+		flag := LookupFlag(fields[1])
+		chats := map[string]bool{}
+		for id, enabled := range flag.Chats {
+			chats[id] = enabled
+		}
+		switch fields[2] {
+		case "on":
+			chats[ChatKey(chatID)] = true
+		case "off":
+			chats[ChatKey(chatID)] = false
+		case "default":
+			delete(chats, ChatKey(chatID))
+		default:
+			return SendHTML(bot, chatID, usage)
+		}
+		flag.Chats = chats
+		if err := flagOverrides.Set(fields[1], flag); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, FormatFlag(fields[1], flag))
+
+	case len(fields) == 2 && fields[0] == "reset":
+		if err := flagOverrides.Delete(fields[1]); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, FormatFlag(fields[1], LookupFlag(fields[1])))
+
+	default:
+		return SendHTML(bot, chatID, usage)
+	}
+}
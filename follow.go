@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// followedTurns is how many exchanges of a followed thread are kept as context
+	followedTurns = 6
+	// followedMessages bounds how many message IDs a followed thread remembers
+	followedMessages = 200
+)
+
+// FollowedThread is a reply thread in a group that the bot answers without being mentioned.
+type FollowedThread struct {
+	RootID    int
+	StartedBy int64
+	// Messages are the IDs of the thread's messages; replying to any of them joins the thread
+	Messages []int
+	Turns    []Turn
+	// Active is when the thread was started or last answered in
+	Active time.Time
+}
+
+func (t FollowedThread) contains(messageID int) bool {
+	for _, id := range t.Messages {
+		if id == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// with returns a copy of the thread with the message IDs added.
+func (t FollowedThread) with(messageIDs ...int) FollowedThread {
+	messages := append([]int{}, t.Messages...)
+	for _, id := range messageIDs {
+		if id != 0 && !t.contains(id) {
+			messages = append(messages, id)
+		}
+	}
+	if len(messages) > followedMessages {
+		messages = messages[len(messages)-followedMessages:]
+	}
+	t.Messages = messages
+	return t
+}
+
+var (
+	followMu sync.Mutex
+	// followedThreads holds the single followed thread of each group
+	followedThreads = NewBoundedMap[int64, FollowedThread]("followed_threads", 10000, 24*time.Hour)
+)
+
+// followTimeout is how long a followed thread may stay quiet before the bot stops following,
+// from FOLLOW_TIMEOUT_MINUTES (default 30).
+func followTimeout() time.Duration {
+	minutes, err := strconv.Atoi(GetenvVar("FOLLOW_TIMEOUT_MINUTES", false))
+	if err != nil || minutes < 1 {
+		minutes = 30
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// FollowedThreadOf returns the followed thread a message replies into, if any.
+func FollowedThreadOf(message *tgbotapi.Message) (FollowedThread, bool) {
+	if message.ReplyToMessage == nil || message.Chat.IsPrivate() {
+		return FollowedThread{}, false
+	}
+	thread, ok := followedThreads.Get(message.Chat.ID)
+	if !ok || time.Since(thread.Active) > followTimeout() || !thread.contains(message.ReplyToMessage.MessageID) {
+		return FollowedThread{}, false
+	}
+	return thread, true
+}
+
+// RecordFollowedTurn adds an answered message and its answer to the followed thread it belongs
+// to. Privacy mode chats keep following, but without remembering what was said.
+func RecordFollowedTurn(message *tgbotapi.Message, answerID int, question, answer string) {
+	followMu.Lock()
+	defer followMu.Unlock()
+	thread, ok := FollowedThreadOf(message)
+	if !ok {
+		return
+	}
+	thread = thread.with(message.MessageID, answerID)
+	if !PrivacyEnabled(message.Chat.ID) {
+		turns := append(append([]Turn{}, thread.Turns...), Turn{Question: question, Answer: answer, At: time.Now()})
+		if len(turns) > followedTurns {
+			turns = turns[len(turns)-followedTurns:]
+		}
+		thread.Turns = turns
+	}
+	thread.Active = time.Now()
+	followedThreads.Set(message.Chat.ID, thread)
+}
+
+// HandleFollowCommand makes the bot answer every reply in a group thread without a mention.
+// Sent as a reply, it follows the thread of the replied message, otherwise it starts one at
+// the command.
+func HandleFollowCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	message := update.Message
+	if message.Chat.IsPrivate() {
+		return SendHTML(bot, message.Chat.ID, "I already answer every message here. /follow is for group threads.")
+	}
+	root := message.MessageID
+	if message.ReplyToMessage != nil {
+		root = message.ReplyToMessage.MessageID
+	}
+
+	text := fmt.Sprintf("👀 Following this thread: reply to any message in it and I'll answer without a mention. "+
+		"I stop after %s without replies, or on /unfollow.", FormatDuration(followTimeout()))
+	if previous, ok := followedThreads.Get(message.Chat.ID); ok && time.Since(previous.Active) <= followTimeout() {
+		text += "\n<i>I stopped following the previous thread.</i>"
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = root
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	thread := FollowedThread{RootID: root, Active: time.Now()}
+	if message.From != nil {
+		thread.StartedBy = message.From.ID
+	}
+	followMu.Lock()
+	followedThreads.Set(message.Chat.ID, thread.with(root, message.MessageID, sent.MessageID))
+	followMu.Unlock()
+	return nil
+}
+
+// HandleUnfollowCommand stops following the group's thread.
+func HandleUnfollowCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	followMu.Lock()
+	thread, ok := followedThreads.LoadAndDelete(chatID)
+	followMu.Unlock()
+	if !ok || time.Since(thread.Active) > followTimeout() {
+		return SendHTML(bot, chatID, "I'm not following a thread here.")
+	}
+	return SendHTML(bot, chatID, "Stopped following the thread. Mention me to ask something.")
+}
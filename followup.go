@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// FollowUpPlaceholder is shown in the input field while the follow-up prompt is open.
+const FollowUpPlaceholder = "Your follow-up question"
+
+// followUpPrompts maps an "ask a follow-up" prompt to the exchange it continues.
+var followUpPrompts = NewBoundedMap[string, Turn]("follow_up_prompts", 10000, time.Hour)
+
+// FollowUpButton opens a follow-up prompt for the answer ("fu:<answer message id>").
+func FollowUpButton(messageID int) tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardButtonData("💬", "fu:"+strconv.Itoa(messageID))
+}
+
+// HandleFollowUpCallback asks whoever tapped the button for their follow-up question with a
+// force reply, so the answer's exchange comes along as context however busy the group is.
+func HandleFollowUpCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	if err := AnswerCallback(bot, query, ""); err != nil {
+		return err
+	}
+
+	chat := query.Message.Chat
+	text := "Reply to this message with your follow-up question."
+	if !chat.IsPrivate() {
+		// Selective force replies only open for the users mentioned
+		text = fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>, reply to this message with your follow-up question.`,
+			query.From.ID, html.EscapeString(query.From.FirstName))
+	}
+	prompt := tgbotapi.NewMessage(chat.ID, text)
+	prompt.ParseMode = tgbotapi.ModeHTML
+	prompt.ReplyToMessageID = query.Message.MessageID
+	prompt.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true, InputFieldPlaceholder: FollowUpPlaceholder}
+	sent, err := bot.Send(prompt)
+	if err != nil {
+		return err
+	}
+	followUpPrompts.Set(FeedbackKey(chat.ID, sent.MessageID), RepliedTurn(query.Message))
+	return nil
+}
+
+// FollowUpTurn returns the exchange a reply to a follow-up prompt continues.
+func FollowUpTurn(message *tgbotapi.Message) (Turn, bool) {
+	if message.ReplyToMessage == nil {
+		return Turn{}, false
+	}
+	return followUpPrompts.Get(FeedbackKey(message.Chat.ID, message.ReplyToMessage.MessageID))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// footnoteCollapseChars and footnoteCollapseLines are the size from which a footnote section
+	// is collapsed; shorter ones read fine inline
+	footnoteCollapseChars = 280
+	footnoteCollapseLines = 4
+)
+
+var (
+	// sectionHeading matches a line that opens a section: a Markdown heading, a bold line or a
+	// short line ending in a colon
+	sectionHeading = regexp.MustCompile(`^\s*(?:#{1,6}\s+\S.*|\*\*[^*]+\*\*:?|[A-Z][\w ]{0,40}:)\s*$`)
+	// footnoteHeading matches the titles of sections that support the answer rather than being it
+	footnoteHeading = regexp.MustCompile(`(?i)^\W*(sources?|references?|citations?|further reading|caveats?|disclaimers?|limitations|important notes?|notes?)\b`)
+	// citationLine matches a line of a source list without a heading
+	citationLine = regexp.MustCompile(`^\s*(?:\[\d+\]|\d+\.|[-*•])?\s*.*(?:https?://|doi:)\S+`)
+)
+
+// answerSection is a run of answer lines from one heading to the next.
+type answerSection struct {
+	text     string
+	footnote bool
+}
+
+// answerSections splits a Markdown answer at its headings, marking source lists and
+// caveat or disclaimer sections as footnotes. A trailing run of citation lines counts as a
+// source list too. Headings inside code blocks don't split.
+func answerSections(markdown string) []answerSection {
+	var sections []answerSection
+	var current []string
+	footnote, inCode := false, false
+	flush := func() {
+		if len(current) > 0 {
+			sections = append(sections, answerSection{text: strings.Join(current, "\n"), footnote: footnote})
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode && sectionHeading.MatchString(line) {
+			flush()
+			footnote = footnoteHeading.MatchString(strings.Trim(line, " #*_:"))
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	last := len(sections) - 1
+	if last < 0 || sections[last].footnote {
+		return sections
+	}
+	lines := strings.Split(strings.TrimRight(sections[last].text, "\n"), "\n")
+	start := len(lines)
+	for start > 0 && citationLine.MatchString(lines[start-1]) {
+		start--
+	}
+	if len(lines)-start >= 2 && start > 0 {
+		sections[last].text = strings.Join(lines[:start], "\n")
+		sections = append(sections, answerSection{text: strings.Join(lines[start:], "\n"), footnote: true})
+	}
+	return sections
+}
+
+// collapsible reports whether a footnote section is long enough to hide.
+func (s answerSection) collapsible() bool {
+	text := strings.TrimSpace(s.text)
+	return s.footnote && (utf8.RuneCountInString(text) >= footnoteCollapseChars || strings.Count(text, "\n")+1 >= footnoteCollapseLines)
+}
+
+// AnswerPartHTML converts one part of an answer to Telegram HTML, folding long source lists and
+// caveat sections into expandable blockquotes so the answer itself stays compact.
+func AnswerPartHTML(markdown string) string {
+	sections := answerSections(markdown)
+	var b strings.Builder
+	for i, section := range sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if !section.collapsible() {
+			b.WriteString(ConvertToTelegramHTML(section.text))
+			continue
+		}
+		// Blockquotes can't be nested
+		inner := ConvertToTelegramHTML(strings.TrimSpace(section.text))
+		inner = strings.NewReplacer("<blockquote>", "", "</blockquote>", "").Replace(inner)
+		b.WriteString("<blockquote expandable>" + inner + "</blockquote>")
+	}
+	return b.String()
+}
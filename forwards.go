@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// pendingForwardTTL is how long a forward sent on its own waits for the question about it
+	pendingForwardTTL = 15 * time.Minute
+	// maxForwardedChars caps how much of a forwarded message goes into the prompt
+	maxForwardedChars = 3000
+)
+
+// ForwardReceivedMessage acknowledges a forward sent without a question.
+const ForwardReceivedMessage = "📨 Got the forwarded message. What would you like to know about it? " +
+	"Ask in your next message, or reply to the forward."
+
+// pendingForwards are forwards sent on their own in private chats, by user, waiting for the question about them.
+var pendingForwards = NewBoundedMap[int64, HistoryMessage]("pending_forwards", 10000, pendingForwardTTL)
+
+// IsUserForward reports whether a message was forwarded by a user, as opposed to the copies of
+// channel posts Telegram puts in linked discussion groups.
+func IsUserForward(message *tgbotapi.Message) bool {
+	return message != nil && message.ForwardDate != 0 && !message.IsAutomaticForward
+}
+
+// forwardOrigin names who wrote a forwarded message, as far as the forward wrapper tells.
+func forwardOrigin(message *tgbotapi.Message) string {
+	switch {
+	case message.ForwardFrom != nil:
+		name := strings.TrimSpace(message.ForwardFrom.FirstName + " " + message.ForwardFrom.LastName)
+		if message.ForwardFrom.IsBot {
+			return "the bot " + name
+		}
+		return name
+	case message.ForwardSenderName != "":
+		return message.ForwardSenderName
+	case message.ForwardFromChat != nil:
+		origin := "the channel " + message.ForwardFromChat.Title
+		if message.ForwardSignature != "" {
+			origin += " (" + message.ForwardSignature + ")"
+		}
+		return origin
+	default:
+		return "someone"
+	}
+}
+
+// ForwardedTurn is the history message carrying a forwarded message and its metadata, so the
+// backend reads it as something the user is asking about rather than as the user's own words.
+func ForwardedTurn(message *tgbotapi.Message) (HistoryMessage, bool) {
+	text, _ := MessageText(message)
+	if strings.TrimSpace(text) == "" {
+		return HistoryMessage{}, false
+	}
+	sent := time.Unix(int64(message.ForwardDate), 0).UTC().Format("2006-01-02 15:04 UTC")
+	content := fmt.Sprintf("I'm forwarding a message written by %s, sent %s. It's not my own message:\n\n%s",
+		forwardOrigin(message), sent, Truncate(text, maxForwardedChars))
+	return HistoryMessage{Role: "user", Content: content}, true
+}
+
+// HoldForward keeps a forward sent on its own in a private chat for the user's next question.
+// It reports false when the chat doesn't allow remembering it, so the forward is answered as is.
+func HoldForward(bot *tgbotapi.BotAPI, message *tgbotapi.Message) (bool, error) {
+	if !Allowed(message, CapConversationMemory) {
+		return false, nil
+	}
+	turn, ok := ForwardedTurn(message)
+	if !ok {
+		return false, nil
+	}
+	pendingForwards.Set(message.From.ID, turn)
+	reply := tgbotapi.NewMessage(message.Chat.ID, ForwardReceivedMessage)
+	reply.ReplyToMessageID = message.MessageID
+	_, err := bot.Send(reply)
+	return true, err
+}
+
+// forwardedContext is the forward a question is about: the one it replies to, or else the forward
+// the user sent just before.
+func forwardedContext(message *tgbotapi.Message) (HistoryMessage, bool) {
+	if IsUserForward(message.ReplyToMessage) {
+		return ForwardedTurn(message.ReplyToMessage)
+	}
+	if message.From == nil || !message.Chat.IsPrivate() {
+		return HistoryMessage{}, false
+	}
+	return pendingForwards.Get(message.From.ID)
+}
+
+// hasForwardedContext reports whether a question comes with a forwarded message to answer about.
+func hasForwardedContext(message *tgbotapi.Message) bool {
+	_, ok := forwardedContext(message)
+	return ok
+}
+
+// TakeForwardedContext returns the forward a question is about, using up a pending one.
+func TakeForwardedContext(message *tgbotapi.Message) (HistoryMessage, bool) {
+	turn, ok := forwardedContext(message)
+	if ok && message.From != nil && !IsUserForward(message.ReplyToMessage) {
+		pendingForwards.Delete(message.From.ID)
+	}
+	return turn, ok
+}
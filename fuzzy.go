@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// substanceAliases maps colloquial and alternative names to substance keys.
+var substanceAliases = map[string]string{
+	"2cb":              "2c-b",
+	"acid":             "lsd",
+	"adderall":         "amphetamine",
+	"alprazolam":       "benzodiazepines",
+	"amph":             "amphetamine",
+	"amphetamines":     "amphetamine",
+	"beer":             "alcohol",
+	"benzos":           "benzodiazepines",
+	"booze":            "alcohol",
+	"citalopram":       "ssris",
+	"coke":             "cocaine",
+	"diazepam":         "benzodiazepines",
+	"dextromethorphan": "dxm",
+	"ecstasy":          "mdma",
+	"ethanol":          "alcohol",
+	"fluoxetine":       "ssris",
+	"g":                "ghb",
+	"gbl":              "ghb",
+	"heroin":           "opioids",
+	"k":                "ketamine",
+	"ket":              "ketamine",
+	"laughing gas":     "nitrous",
+	"magic mushrooms":  "psilocybin",
+	"mandy":            "mdma",
+	"marijuana":        "cannabis",
+	"md":               "mdma",
+	"molly":            "mdma",
+	"morphine":         "opioids",
+	"mushrooms":        "psilocybin",
+	"n2o":              "nitrous",
+	"nos":              "nitrous",
+	"oxycodone":        "opioids",
+	"robo":             "dxm",
+	"sertraline":       "ssris",
+	"shrooms":          "psilocybin",
+	"special k":        "ketamine",
+	"speed":            "amphetamine",
+	"thc":              "cannabis",
+	"ultram":           "tramadol",
+	"weed":             "cannabis",
+	"xanax":            "benzodiazepines",
+	"xtc":              "mdma",
+}
+
+// SubstanceMatch is a candidate resolution of a user-supplied substance name.
+type SubstanceMatch struct {
+	Key        string
+	Confidence float64
+}
+
+// ConfidentMatch is the minimum confidence for resolving a name without asking the user.
+const ConfidentMatch = 0.8
+
+// ResolveSubstance returns the best matching substance keys for name, most confident first.
+// Exact names and aliases have confidence 1.
+func ResolveSubstance(name string) []SubstanceMatch {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil
+	}
+	if _, ok := substances[name]; ok {
+		return []SubstanceMatch{{Key: name, Confidence: 1}}
+	}
+	if key, ok := substanceAliases[name]; ok {
+		return []SubstanceMatch{{Key: key, Confidence: 1}}
+	}
+
+	best := map[string]float64{}
+	consider := func(candidate, key string) {
+		distance := levenshtein(name, candidate)
+		longest := len([]rune(name))
+		if n := len([]rune(candidate)); n > longest {
+			longest = n
+		}
+		confidence := 1 - float64(distance)/float64(longest)
+		if confidence > best[key] {
+			best[key] = confidence
+		}
+	}
+	for key := range substances {
+		consider(key, key)
+	}
+	for alias, key := range substanceAliases {
+		// One- and two-letter aliases match almost anything by edit distance.
+		if len(alias) > 2 {
+			consider(alias, key)
+		}
+	}
+
+	var matches []SubstanceMatch
+	for key, confidence := range best {
+		if confidence >= 0.5 {
+			matches = append(matches, SubstanceMatch{Key: key, Confidence: confidence})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Confidence != matches[j].Confidence {
+			return matches[i].Confidence > matches[j].Confidence
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	return matches
+}
+
+// levenshtein is the edit distance between two strings, counted in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
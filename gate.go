@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Content gate categories. Questions in these categories never reach the backend.
+const (
+	GateSynthesis   = "synthesis"
+	GateSourcing    = "sourcing"
+	GateTrafficking = "trafficking"
+)
+
+// gateRules match requests to make, buy or sell drugs. Rules with DrugContext only apply when
+// the question mentions a drug, so "where can I get counselling" still gets an answer. Rules with
+// SupplyObject let questions through when what they ask for is a harm reduction supply, so
+// "where can I buy MDMA test kits" is answered. Name identifies the rule in safety events.
+var gateRules = []struct {
+	Name         string
+	Category     string
+	Pattern      *regexp.Regexp
+	DrugContext  bool
+	SupplyObject bool
+}{
+	{"synthesis-words", GateSynthesis, regexp.MustCompile(`(?i)\b(synthes[iy]s|synthesi[sz]e|synth route)\b`), true, false},
+	{"how-to-make", GateSynthesis, regexp.MustCompile(`(?i)\bhow (do i|to|can i|would i|do you)\s+(make|cook|brew|produce|manufacture|extract)\s+(my own |some |your own )?` + drugNames() + `\b`), false, false},
+	{"precursors", GateSynthesis, regexp.MustCompile(`(?i)\b(safrole|pseudoephedrine reduction|p2p method)\b`), false, false},
+	{"where-to-buy", GateSourcing, regexp.MustCompile(`(?i)\bwhere (can|do|could|should) (i|you|we) (buy|get|find|order|score|cop)\b`), true, true},
+	{"darknet-markets", GateSourcing, regexp.MustCompile(`(?i)\b(darknet|dark web|darkweb) (market|vendor|shop)s?\b`), false, false},
+	{"find-dealer", GateSourcing, regexp.MustCompile(`(?i)\b(find|recommend|know) (a|any|me a) (plug|dealer|vendor)\b`), false, false},
+	{"smuggling", GateTrafficking, regexp.MustCompile(`(?i)\b(smuggl\w*|trafficking)\b`), true, false},
+	{"border-shipping", GateTrafficking, regexp.MustCompile(`(?i)\b(ship|mail|post|send)\w* .{0,40}\b(across|through) (the )?(border|customs)\b`), true, false},
+	{"how-to-sell", GateTrafficking, regexp.MustCompile(`(?i)\bhow (to|do i|can i) (sell|deal|push)\b`), true, false},
+}
+
+var (
+	drugWordPattern = regexp.MustCompile(`(?i)\b(drugs?|meth\w*|dmt|fentanyl|crack|heroin|mephedrone|mescaline|pills|substances?)\b`)
+	// harmReductionPattern marks supplies people should be able to find, like test kits or
+	// naloxone, with the drug they test for ("fentanyl test strips", "reagents for MDMA").
+	harmReductionPattern = regexp.MustCompile(`(?i)\b([\w-]+ )?(test(ing)? (kits?|strips?)|reagents?( tests?| kits?)?)( (for( testing)?|to test) [\w-]+)?\b|\b(drug checking( services?)?|naloxone( kits?)?|narcan|needle exchanges?|fentanyl strips?)\b`)
+	// objectEnd ends the object of a request: the end of the sentence or a clause about who or
+	// what it is for
+	objectEnd = regexp.MustCompile(`(?i)[.?!;\n]|\b(for|because|since|so|who|which|that|if)\b`)
+)
+
+// onlySupplies reports whether text asks for nothing but harm reduction supplies: it names at
+// least one, and no drug is left once they are removed.
+func onlySupplies(text string) bool {
+	if !harmReductionPattern.MatchString(text) {
+		return false
+	}
+	return !aboutDrugs(harmReductionPattern.ReplaceAllString(text, " "))
+}
+
+// requestObject is what follows a match up to the end of its sentence or clause, e.g. the thing
+// asked for in "where can I buy … for my friend".
+func requestObject(question string, match []int) string {
+	object := question[match[1]:]
+	if end := objectEnd.FindStringIndex(object); end != nil {
+		object = object[:end[0]]
+	}
+	return object
+}
+
+// drugNames is a regexp alternation of every known substance name and alias plus generic drug words.
+func drugNames() string {
+	names := []string{`drugs?`, `meth\w*`, `dmt`, `fentanyl`, `crack`, `mephedrone`, `mescaline`, `pills`}
+	for key := range substances {
+		names = append(names, regexp.QuoteMeta(key))
+	}
+	for alias := range substanceAliases {
+		if len(alias) > 2 {
+			names = append(names, regexp.QuoteMeta(alias))
+		}
+	}
+	return "(" + strings.Join(names, "|") + ")"
+}
+
+// aboutDrugs reports whether a question mentions a drug.
+func aboutDrugs(question string) bool {
+	return len(DetectSubstances(question)) > 0 || drugWordPattern.MatchString(question)
+}
+
+// gatedModerationCategories are moderation endpoint categories the gate acts on.
+var gatedModerationCategories = map[string]string{
+	"illicit":           GateSourcing,
+	"illicit/violent":   GateTrafficking,
+	"drug_synthesis":    GateSynthesis,
+	"drug_sourcing":     GateSourcing,
+	"drug_trafficking":  GateTrafficking,
+	"illegal_activity":  GateTrafficking,
+	"controlled_supply": GateSourcing,
+}
+
+// GateEvent is a blocked question, kept for admin review.
+type GateEvent struct {
+	ChatID   int64     `json:"chat_id"`
+	UserID   int64     `json:"user_id"`
+	Question string    `json:"question"`
+	Category string    `json:"category"`
+	Source   string    `json:"source"`
+	At       time.Time `json:"at"`
+}
+
+var gateLog *JSONStore[GateEvent]
+
+// CheckContentGate returns the blocked category of a question, what detected it ("rules",
+// "moderation" or "guard") and the rule or moderation category that matched, or "" when the
+// question may go to the backend.
+func CheckContentGate(question string) (string, string, string) {
+	drugs := aboutDrugs(question)
+	for _, rule := range gateRules {
+		if rule.DrugContext && !drugs {
+			continue
+		}
+		for _, match := range rule.Pattern.FindAllStringIndex(question, -1) {
+			if rule.SupplyObject && onlySupplies(requestObject(question, match)) {
+				continue
+			}
+			return rule.Category, "rules", rule.Name
+		}
+	}
+	// Supply questions are only exempt from sourcing: making or selling drugs is blocked whatever
+	// else the question mentions
+	supplies := onlySupplies(question)
+	if category, flagged := moderationCategory(question); category != "" && !(category == GateSourcing && supplies) {
+		return category, "moderation", flagged
+	}
+	if category := guardCategory(question); category != "" && !(category == GateSourcing && supplies) {
+		return category, "guard", category
+	}
+	return "", "", ""
+}
+
+const moderationGuardPrompt = "You are the moderation filter of a harm reduction assistant. Questions about using drugs more safely " +
+	"are allowed, even about illegal drugs: dosing, interactions, effects, testing, set and setting, and what to do in an emergency, " +
+	"as are questions about where to get test kits, naloxone or needle exchanges. " +
+	"Requests for help making, buying or selling drugs are not. " +
+	"Reply with one line only: ALLOW, BLOCK: synthesis, BLOCK: sourcing or BLOCK: trafficking."
+
+// guardCategory asks the provider itself with a guard prompt when MODERATION_GUARD is on, which
+// catches phrasings the rules miss at the cost of a model call per question. Like the moderation
+// endpoint it lets questions through when it fails.
+func guardCategory(question string) string {
+	if !strings.EqualFold(GetenvVar("MODERATION_GUARD", false), "on") {
+		return ""
+	}
+	verdict, err := guardVerdict(moderationGuardPrompt, question)
+	if err != nil {
+		log.Printf("Moderation guard failed, allowing the question: %v", err)
+		return ""
+	}
+	label, category, _ := strings.Cut(verdict, ":")
+	if !strings.EqualFold(strings.TrimSpace(label), "BLOCK") {
+		return ""
+	}
+	switch category = strings.ToLower(strings.Trim(strings.TrimSpace(category), ".")); category {
+	case GateSynthesis, GateSourcing, GateTrafficking:
+		return category
+	}
+	return ""
+}
+
+// moderationCategory asks MODERATION_URL, when set, whether a question falls in a gated category,
+// and returns it with the endpoint's own category. Moderation failures let the question through;
+// the backend moderates answers as well.
+func moderationCategory(question string) (string, string) {
+	moderationURL := GetenvVar("MODERATION_URL", false)
+	if moderationURL == "" {
+		return "", ""
+	}
+	body, err := json.Marshal(map[string]string{"input": question})
+	if err != nil {
+		return "", ""
+	}
+	resp, err := HTTPClient(5*time.Second).Post(moderationURL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", ""
+	}
+	defer resp.Body.Close()
+
+	var verdict Moderation
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&verdict) != nil || !verdict.Flagged {
+		return "", ""
+	}
+	for _, category := range verdict.Categories {
+		if gated, ok := gatedModerationCategories[strings.ToLower(category)]; ok {
+			return gated, strings.ToLower(category)
+		}
+	}
+	return "", ""
+}
+
+// RecordGateEvent logs a blocked question for /gatelog.
+func RecordGateEvent(event GateEvent) error {
+	if gateLog == nil {
+		return nil
+	}
+	event.Question = Truncate(event.Question, 500)
+	return gateLog.Set(fmt.Sprintf("%d:%d:%d", event.ChatID, event.UserID, event.At.UnixNano()), event)
+}
+
+// HandleGateLogCommand shows the most recent blocked questions to bot admins.
+func HandleGateLogCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	var events []GateEvent
+	gateLog.Range(func(_ string, event GateEvent) bool {
+		events = append(events, event)
+		return true
+	})
+	if len(events) == 0 {
+		return SendHTML(bot, chatID, "No blocked questions yet.")
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].At.After(events[j].At)
+	})
+	if len(events) > 10 {
+		events = events[:10]
+	}
+
+	lines := []string{"<b>Recently blocked questions</b>"}
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("%s · %s (%s) · user %d\n<i>%s</i>",
+			event.At.UTC().Format("2006-01-02 15:04"), event.Category, event.Source, event.UserID,
+			html.EscapeString(Truncate(event.Question, 200))))
+	}
+	return SendHTML(bot, chatID, strings.Join(lines, "\n\n"))
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCheckContentGate(t *testing.T) {
+	tests := []struct {
+		question string
+		category string
+		rule     string
+	}{
+		// Harm reduction supplies are answered
+		{"where can I buy fentanyl test strips?", "", ""},
+		{"where can I get mdma test kits", "", ""},
+		{"where can I buy reagents for testing mdma", "", ""},
+		{"where do I get naloxone for my friend who uses heroin", "", ""},
+		{"how do I use a reagent test kit on cocaine?", "", ""},
+		{"is it safe to mix mdma and alcohol?", "", ""},
+		// Mentioning a supply doesn't open the other rules
+		{"how do I synthesize mdma? which reagents do I need", GateSynthesis, "synthesis-words"},
+		{"where can I buy meth and fentanyl test strips too", GateSourcing, "where-to-buy"},
+		{"where can I get fentanyl test strips? also where can I get heroin", GateSourcing, "where-to-buy"},
+		{"how do I make meth with narcan nearby", GateSynthesis, "how-to-make"},
+		{"best darknet markets for mdma, I have test kits", GateSourcing, "darknet-markets"},
+		{"safrole supplier? I'll use drug checking after", GateSynthesis, "precursors"},
+		// The same questions without a supply word
+		{"how do I synthesize mdma?", GateSynthesis, "synthesis-words"},
+		{"where can I buy meth", GateSourcing, "where-to-buy"},
+		{"where can I buy meth for my friend who has test strips", GateSourcing, "where-to-buy"},
+		{"how do I make meth", GateSynthesis, "how-to-make"},
+	}
+	for _, test := range tests {
+		category, source, rule := CheckContentGate(test.question)
+		if category != test.category || rule != test.rule {
+			t.Errorf("CheckContentGate(%q) = %q (%s %s), want %q (%s)", test.question, category, source, rule, test.category, test.rule)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// GlossaryEntry is a curated definition of harm reduction jargon.
+type GlossaryEntry struct {
+	Term       string
+	Aliases    []string
+	Definition string
+	// See are related terms, by their glossary key
+	See []string
+}
+
+// glossary is keyed by the lowercase term.
+var glossary = map[string]GlossaryEntry{
+	"allergy dose": {"Allergy dose", []string{"allergy test", "test dose"},
+		"A tiny amount (a few mg or a fraction of a normal dose) taken first to check for an allergic or unusually strong reaction before taking more.", []string{"start low and go slow"}},
+	"volumetric dosing": {"Volumetric dosing", []string{"volumetric", "volumetric solution"},
+		"Dissolving a weighed amount in a known volume of liquid so small doses can be measured accurately with a syringe, for substances too potent to weigh on a regular scale.", []string{"allergy dose"}},
+	"serotonin syndrome": {"Serotonin syndrome", []string{"serotonin toxicity"},
+		"Dangerous overstimulation of the serotonin system, usually from combining serotonergic drugs like MDMA with MAOIs or some antidepressants. Signs include agitation, high temperature, muscle twitching and confusion: it needs emergency care.", []string{"maoi"}},
+	"maoi": {"MAOI", []string{"maois", "monoamine oxidase inhibitor"},
+		"Monoamine oxidase inhibitors block the enzyme that breaks down serotonin and other neurotransmitters. Found in some antidepressants and in ayahuasca, they make many drug combinations dangerous.", []string{"serotonin syndrome"}},
+	"redose": {"Redose", []string{"redosing", "top up", "booster"},
+		"Taking more of a substance during the same session. Redoses add up with what is still active, so they raise the risk more than the first dose did.", []string{"come up"}},
+	"come up": {"Come-up", []string{"comeup", "onset"},
+		"The period between taking a substance and feeling its full effects. A slow come-up is a common reason people redose too early.", []string{"peak", "redose"}},
+	"peak": {"Peak", nil,
+		"The part of the experience where effects are strongest. It follows the come-up and comes before the comedown.", []string{"come up", "comedown"}},
+	"comedown": {"Comedown", []string{"come down", "crash"},
+		"The period as effects wear off, often with tiredness, low mood or anxiety, especially after stimulants. Rest, food and water help more than redosing.", []string{"peak"}},
+	"tolerance": {"Tolerance", nil,
+		"Needing more of a substance for the same effect after recent use. It fades with time off, and taking an old dose after a break can be dangerous for depressants and opioids.", []string{"cross tolerance"}},
+	"cross tolerance": {"Cross-tolerance", []string{"cross-tolerance"},
+		"Tolerance to one substance that carries over to related ones, as between LSD and psilocybin. /tolerance shows common ones.", []string{"tolerance"}},
+	"reagent test": {"Reagent test", []string{"reagent", "reagents", "test kit", "drug checking"},
+		"A few drops of a reagent that change colour depending on what is in a sample. It shows whether an expected substance is present, not its purity or what else is in there.", []string{"fentanyl test strip"}},
+	"fentanyl test strip": {"Fentanyl test strip", []string{"fentanyl strips", "fts"},
+		"A dipstick that detects fentanyl and many of its analogues in a dissolved sample. A negative result lowers but doesn't remove the risk.", []string{"reagent test", "naloxone"}},
+	"naloxone": {"Naloxone", []string{"narcan"},
+		"A medicine that reverses opioid overdose for 30–90 minutes, given as a nasal spray or injection. Call emergency services as well, as the overdose can return when it wears off.", []string{"fentanyl test strip"}},
+	"set and setting": {"Set and setting", []string{"set", "setting"},
+		"Your mindset and the environment you use in. Both shape the experience, especially with psychedelics.", []string{"trip sitter"}},
+	"trip sitter": {"Trip sitter", []string{"sitter", "tripsitter"},
+		"A sober, trusted person who stays with someone using a psychedelic to keep them safe and calm.", []string{"set and setting"}},
+	"k-hole": {"K-hole", []string{"khole", "k hole"},
+		"A state of strong dissociation from a high dose of ketamine, where moving and communicating become difficult. Being somewhere safe with a sober person around matters.", nil},
+	"start low and go slow": {"Start low, go slow", []string{"start low", "go slow"},
+		"Begin with a low dose, especially with a new substance or batch, and wait for the full effects before deciding on more.", []string{"allergy dose", "come up"}},
+	"half-life": {"Half-life", []string{"half life"},
+		"The time it takes for the body to remove half of a substance. Effects often end long before the substance is fully gone, which matters for combinations.", nil},
+	"hyperthermia": {"Hyperthermia", []string{"overheating", "heatstroke"},
+		"A dangerously high body temperature, a main risk with MDMA and stimulants when dancing in the heat. Take breaks, cool down and sip water.", []string{"hyponatremia"}},
+	"hyponatremia": {"Hyponatremia", []string{"water intoxication"},
+		"Low blood sodium from drinking too much water, a risk with MDMA. Sip about 500 ml an hour when dancing, less when resting.", []string{"hyperthermia"}},
+}
+
+var (
+	// glossaryAliases maps every alias to the key of its entry
+	glossaryAliases = map[string]string{}
+	// definitionCache keeps LLM definitions of terms missing from the glossary
+	definitionCache = NewBoundedMap[string, string]("definitions", 1000, 24*time.Hour)
+)
+
+func init() {
+	for key, entry := range glossary {
+		for _, alias := range entry.Aliases {
+			glossaryAliases[alias] = key
+		}
+	}
+}
+
+func normalizeTerm(term string) string {
+	term = strings.ToLower(strings.TrimSpace(term))
+	term = strings.Trim(term, "?!.\"'")
+	return strings.Join(strings.Fields(term), " ")
+}
+
+// LookupGlossary finds a curated entry by term or alias, allowing a typo or two.
+func LookupGlossary(term string) (GlossaryEntry, bool) {
+	term = normalizeTerm(term)
+	if entry, ok := glossary[term]; ok {
+		return entry, true
+	}
+	if key, ok := glossaryAliases[term]; ok {
+		return glossary[key], true
+	}
+	if len([]rune(term)) < 5 {
+		return GlossaryEntry{}, false
+	}
+	candidates := make([]string, 0, len(glossary)+len(glossaryAliases))
+	for key := range glossary {
+		candidates = append(candidates, key)
+	}
+	for alias := range glossaryAliases {
+		candidates = append(candidates, alias)
+	}
+	sort.Strings(candidates)
+	for _, candidate := range candidates {
+		if levenshtein(term, candidate) <= 2 {
+			if key, ok := glossaryAliases[candidate]; ok {
+				return glossary[key], true
+			}
+			return glossary[candidate], true
+		}
+	}
+	return GlossaryEntry{}, false
+}
+
+// FormatGlossaryEntry renders an entry in a few lines, short enough for groups.
+func FormatGlossaryEntry(entry GlossaryEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📖 <b>%s</b>: %s", html.EscapeString(entry.Term), html.EscapeString(entry.Definition))
+	if len(entry.See) > 0 {
+		see := make([]string, len(entry.See))
+		for i, key := range entry.See {
+			see[i] = glossary[key].Term
+		}
+		fmt.Fprintf(&b, "\n<i>See also: %s</i>", html.EscapeString(strings.Join(see, ", ")))
+	}
+	return b.String()
+}
+
+// DefineWithBackend asks the backend for a short definition of a term the glossary lacks.
+func DefineWithBackend(term string) (string, error) {
+	term = normalizeTerm(term)
+	if definition, ok := definitionCache.Get(term); ok {
+		return definition, nil
+	}
+	response, err := Ask(PromptRequest{
+		Question: fmt.Sprintf("Define the term %q as used in drug harm reduction, in at most two plain sentences. "+
+			"If it isn't a harm reduction term, say so in one sentence.", term),
+		Temperature:  0.2,
+		Tokens:       150,
+		SystemPrompt: SystemPrompt(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if response.Refused() {
+		return "", fmt.Errorf("backend refused to define %q", term)
+	}
+	definition := strings.TrimSpace(response.Text())
+	definitionCache.Set(term, definition)
+	return definition, nil
+}
+
+// HandleDefineCommand explains harm reduction jargon from the glossary, falling back to the backend.
+func HandleDefineCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	term := strings.TrimSpace(args)
+	if term == "" {
+		return SendHTML(bot, chatID, "Usage: /define &lt;term&gt;, e.g. /define volumetric dosing")
+	}
+	if entry, ok := LookupGlossary(term); ok {
+		return SendHTML(bot, chatID, FormatGlossaryEntry(entry))
+	}
+
+	if category, _, _ := CheckContentGate(term); category != "" {
+		return SendHTML(bot, chatID, html.EscapeString(GatedRefusalMessage))
+	}
+	definition, err := DefineWithBackend(term)
+	if err != nil {
+		log.Printf("Error defining %q: %v", term, err)
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't have a definition for <b>%s</b>.", html.EscapeString(Truncate(term, 60))))
+	}
+	return SendHTML(bot, chatID, fmt.Sprintf("📖 <b>%s</b>: %s\n<i>Generated, not from the curated glossary.</i>",
+		html.EscapeString(Truncate(term, 60)), ConvertToTelegramHTML(definition)))
+}
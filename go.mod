@@ -3,6 +3,16 @@ module github.com/yourusername/psyai-tg-bot
 go 1.22.4
 
 require (
+	github.com/getsentry/sentry-go v0.29.1
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/joho/godotenv v1.5.1
+	github.com/spf13/cobra v1.8.1
+	golang.org/x/image v0.20.0
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
 )
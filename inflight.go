@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// staleThinkingAge is how old a left-behind thinking message can be and still be edited on
+// startup; older ones were most likely re-asked or forgotten already.
+const staleThinkingAge = 48 * time.Hour
+
+// ThinkingMessageRecord is a thinking message waiting to be replaced with an answer. Only the
+// IDs are kept, so privacy mode chats are tracked too.
+type ThinkingMessageRecord struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	UserID    int64     `json:"user_id,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// thinkingMessages persists outstanding thinking messages by chat and message ID, so a crash
+// doesn't leave them thinking forever.
+var thinkingMessages *JSONStore[ThinkingMessageRecord]
+
+func thinkingKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// TrackThinking records a thinking message until the returned function is called, once the
+// message has been replaced or given up on.
+func TrackThinking(chatID int64, messageID int, userID int64) (release func()) {
+	if thinkingMessages == nil {
+		return func() {}
+	}
+	key := thinkingKey(chatID, messageID)
+	if err := thinkingMessages.Set(key, ThinkingMessageRecord{ChatID: chatID, MessageID: messageID, UserID: userID, At: time.Now()}); err != nil {
+		log.Printf("Error tracking thinking message: %v", err)
+	}
+	return func() {
+		if err := thinkingMessages.Delete(key); err != nil {
+			log.Printf("Error releasing thinking message: %v", err)
+		}
+	}
+}
+
+// SweepThinkingMessages edits the thinking messages a previous run left behind into an apology
+// asking to re-ask. It runs on startup, before any update is handled.
+func SweepThinkingMessages(bot *tgbotapi.BotAPI) {
+	if thinkingMessages == nil {
+		return
+	}
+	var left []ThinkingMessageRecord
+	thinkingMessages.Range(func(_ string, record ThinkingMessageRecord) bool {
+		left = append(left, record)
+		return true
+	})
+	edited := 0
+	for _, record := range left {
+		if time.Since(record.At) < staleThinkingAge {
+			text := "<i>" + html.EscapeString(Localized("restarted", ReplyLanguage(record.ChatID, record.UserID))) + "</i>"
+			if err := EditMessageHTML(bot, record.ChatID, record.MessageID, text, nil, nil); err != nil {
+				log.Printf("Error editing left-behind thinking message %d in chat %d: %v", record.MessageID, record.ChatID, err)
+			} else {
+				edited++
+			}
+		}
+		if err := thinkingMessages.Delete(thinkingKey(record.ChatID, record.MessageID)); err != nil {
+			log.Printf("Error releasing thinking message: %v", err)
+		}
+	}
+	if len(left) > 0 {
+		log.Printf("Cleaned up %d thinking messages left by the last run, %d edited", len(left), edited)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxCombinationSubstances keeps the matrix readable on a phone: six substances are 15 pairs.
+const maxCombinationSubstances = 6
+
+// riskSeverity orders risk levels from harmless to dangerous. Unknown combinations rank above
+// the low risk ones, since no data isn't a reason to relax.
+var riskSeverity = map[string]int{
+	RiskLowDecrease:  1,
+	RiskLowNoSynergy: 1,
+	RiskLowSynergy:   2,
+	"":               3,
+	RiskCaution:      4,
+	RiskUnsafe:       5,
+	RiskDangerous:    6,
+}
+
+// PairRisk is the risk level of combining two substances, "" when nothing is known about it.
+type PairRisk struct {
+	A, B string
+	Risk string
+}
+
+var combinationSeparators = regexp.MustCompile(`\s*(?:[+,&/]|\s(?:and|with|plus)\s)\s*`)
+
+// ParseCombination resolves a list like "lsd + mdma + ketamine" to substance keys, in order and
+// without duplicates, returning the words it didn't recognize separately.
+func ParseCombination(text string) (keys, unknown []string) {
+	seen := map[string]bool{}
+	resolve := func(name string) bool {
+		matches := ResolveSubstance(name)
+		if len(matches) == 0 || matches[0].Confidence < ConfidentMatch {
+			return false
+		}
+		if key := matches[0].Key; !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		return true
+	}
+	for _, name := range combinationSeparators.Split(strings.ToLower(strings.TrimSpace(text)), -1) {
+		name = strings.TrimSpace(name)
+		if name == "" || resolve(name) {
+			continue
+		}
+		// "lsd mdma" without separators
+		for _, word := range strings.Fields(name) {
+			if !resolve(word) {
+				unknown = append(unknown, word)
+			}
+		}
+	}
+	return keys, unknown
+}
+
+// CombinationRisks rates every pair of the substances from the configured sources, falling back
+// to the combination chart, and returns the name of the source used.
+func CombinationRisks(keys []string) ([]PairRisk, string) {
+	var pairs []PairRisk
+	source := ""
+	for i, a := range keys {
+		risks, name, err := GetSubstanceInteractions(a)
+		if err == nil && (source == "" || source == (localSource{}).Name()) {
+			source = name
+		}
+		for _, b := range keys[i+1:] {
+			risk, ok := risks[b]
+			if !ok {
+				risk, _ = Interaction(a, b)
+			}
+			pairs = append(pairs, PairRisk{A: a, B: b, Risk: risk})
+		}
+	}
+	if source == "" || source == (localSource{}).Name() {
+		source = InteractionSource
+	}
+	return pairs, source
+}
+
+// FormatCombination renders the risk of one pair, or for more substances a matrix of every pair
+// with the most dangerous one called out first.
+func FormatCombination(keys []string, pairs []PairRisk, source string) string {
+	var b strings.Builder
+	if len(keys) == 2 {
+		pair := pairs[0]
+		fmt.Fprintf(&b, "<b>%s + %s</b>\n%s %s\n", html.EscapeString(substanceName(pair.A)), html.EscapeString(substanceName(pair.B)),
+			matrixCell(pair.Risk), riskLabel(pair.Risk))
+		if pair.Risk == "" {
+			b.WriteString("No data doesn't mean it's safe: start low and ask me about this combination.\n")
+		}
+		fmt.Fprintf(&b, "\n<i>Source: %s</i>", html.EscapeString(source))
+		return b.String()
+	}
+
+	sorted := append([]PairRisk{}, pairs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return riskSeverity[sorted[i].Risk] > riskSeverity[sorted[j].Risk] })
+	if worst := sorted[0]; IsRiskyInteraction(worst.Risk) {
+		fmt.Fprintf(&b, "⚠️ <b>Most dangerous pair: %s + %s</b> (%s %s)\n\n", html.EscapeString(substanceName(worst.A)),
+			html.EscapeString(substanceName(worst.B)), RiskEmoji(worst.Risk), worst.Risk)
+	}
+
+	risk := map[string]string{}
+	for _, pair := range pairs {
+		risk[pair.A+"+"+pair.B], risk[pair.B+"+"+pair.A] = pair.Risk, pair.Risk
+	}
+	b.WriteString("<b>Combination matrix</b>\n")
+	for i, row := range keys {
+		cells := make([]string, len(keys))
+		for j, column := range keys {
+			if i == j {
+				cells[j] = "⬛"
+			} else {
+				cells[j] = matrixCell(risk[row+"+"+column])
+			}
+		}
+		fmt.Fprintf(&b, "%s %d. %s\n", strings.Join(cells, ""), i+1, html.EscapeString(substanceName(row)))
+	}
+
+	b.WriteString("\n")
+	for _, pair := range sorted {
+		fmt.Fprintf(&b, "%s %s + %s: %s\n", matrixCell(pair.Risk), html.EscapeString(substanceName(pair.A)),
+			html.EscapeString(substanceName(pair.B)), riskLabel(pair.Risk))
+	}
+	b.WriteString("\nEvery substance you add raises the risk: even low risk pairs add up, so lower each dose and don't redose quickly.\n")
+	fmt.Fprintf(&b, "<i>Source: %s. ❔ means no data, which doesn't mean it's safe.</i>", html.EscapeString(source))
+	return b.String()
+}
+
+func matrixCell(risk string) string {
+	if risk == "" {
+		return "❔"
+	}
+	return RiskEmoji(risk)
+}
+
+func riskLabel(risk string) string {
+	if risk == "" {
+		return "no data"
+	}
+	return risk
+}
+
+const interactionsUsage = "Usage: /interactions &lt;substance&gt; + &lt;substance&gt; [+ …], e.g. /interactions lsd + mdma + ketamine"
+
+// HandleInteractionsCommand rates a combination of two or more substances.
+func HandleInteractionsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	keys, unknown := ParseCombination(args)
+	if len(unknown) > 0 {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't know <b>%s</b>.\n\n%s", html.EscapeString(strings.Join(unknown, ", ")), interactionsUsage))
+	}
+	if len(keys) < 2 {
+		return SendHTML(bot, chatID, interactionsUsage)
+	}
+	if len(keys) > maxCombinationSubstances {
+		return SendHTML(bot, chatID, fmt.Sprintf("I can compare up to %d substances at once. Combining that many is very risky, consider asking me about your plan instead.", maxCombinationSubstances))
+	}
+	pairs, source := CombinationRisks(keys)
+	return SendHTML(bot, chatID, FormatCombination(keys, pairs, source))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxLabResultBytes caps the size of uploaded lab results, below Telegram's 20 MB download limit.
+const maxLabResultBytes = 10 << 20
+
+// labResultDisclaimer goes under every lab result summary.
+const labResultDisclaimer = "<i>Read by text recognition, which can misread names and miss lines. Check the original report, " +
+	"and keep in mind a result only covers the sample that was tested, not the rest of the batch.</i>"
+
+// Adulterant is a dangerous cut or substitute that lab results are checked for.
+type Adulterant struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Warning string
+}
+
+var dangerousAdulterants = []Adulterant{
+	{"Fentanyl or an analogue", regexp.MustCompile(`(?i)\b\w*fentan[iy]l\b`), "Extremely potent opioid: fatal overdoses happen with traces. Have naloxone ready and never use alone."},
+	{"Nitazene", regexp.MustCompile(`(?i)\b\w*nitazene\b`), "Synthetic opioid often stronger than fentanyl. Several doses of naloxone may be needed."},
+	{"Xylazine", regexp.MustCompile(`(?i)\bxylazine\b`), "Sedative that naloxone doesn't reverse; causes deep sedation and severe wounds."},
+	{"PMA/PMMA", regexp.MustCompile(`(?i)\bpmma?\b|methoxy-?(meth)?amphetamine`), "Sold as MDMA but slower to kick in: redosing while waiting causes fatal overheating."},
+	{"NBOMe", regexp.MustCompile(`(?i)\bnbome\b|-nbome\b`), "Sold as LSD; active and toxic in microgram amounts, with seizures and overdose deaths reported."},
+	{"Synthetic cannabinoid", regexp.MustCompile(`(?i)\b(5f-)?(mdmb|adb|amb|jwh)-[a-z0-9-]+\b`), "Much stronger than THC and unpredictable: seizures, psychosis and heart problems."},
+	{"Designer benzodiazepine", regexp.MustCompile(`(?i)\b(bromazolam|etizolam|flualprazolam|clonazolam|flubromazolam)\b`), "Strong sedative; combined with opioids or alcohol it can stop breathing."},
+	{"Levamisole", regexp.MustCompile(`(?i)\blevamisole\b`), "Common cocaine cut that can destroy white blood cells and damage skin and blood vessels."},
+}
+
+// LabReport is what was recognized in an uploaded lab result.
+type LabReport struct {
+	// Substances are the keys of the known substances the report names
+	Substances  []string
+	Adulterants []Adulterant
+}
+
+// OCRURL is the text recognition endpoint, or "" when lab result uploads are disabled.
+func OCRURL() string {
+	return GetenvVar("OCR_URL", false)
+}
+
+// LabResultsEnabled reports whether uploads in a chat are read as lab results.
+func LabResultsEnabled(chatID, userID int64) bool {
+	return OCRURL() != "" && FeatureEnabled(FlagVision, chatID, userID)
+}
+
+// IsLabResultUpload reports whether a message carries an image or PDF that could be a lab result.
+func IsLabResultUpload(message *tgbotapi.Message) bool {
+	if len(message.Photo) > 0 {
+		return true
+	}
+	if message.Document == nil {
+		return false
+	}
+	return strings.HasPrefix(message.Document.MimeType, "image/") || message.Document.MimeType == "application/pdf"
+}
+
+// RecognizeText sends a file to OCR_URL and returns the text found in it.
+func RecognizeText(data []byte, contentType string) (string, error) {
+	request, err := http.NewRequest(http.MethodPost, OCRURL(), bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("error creating OCR request: %w", err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	resp, err := HTTPClient(60 * time.Second).Do(request)
+	if err != nil {
+		return "", fmt.Errorf("error making OCR request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected OCR status: %s", resp.Status)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", fmt.Errorf("error decoding OCR response: %w", err)
+	}
+	return result.Text, nil
+}
+
+// ParseLabReport finds the known substances and dangerous adulterants in recognized text.
+func ParseLabReport(text string) LabReport {
+	report := LabReport{Substances: DetectSubstances(text)}
+	for _, adulterant := range dangerousAdulterants {
+		if adulterant.Pattern.MatchString(text) {
+			report.Adulterants = append(report.Adulterants, adulterant)
+		}
+	}
+	return report
+}
+
+// FormatLabReport summarizes a lab report, dangerous adulterants first.
+func FormatLabReport(report LabReport) string {
+	var lines []string
+	if len(report.Adulterants) > 0 {
+		lines = append(lines, "🚨 <b>Dangerous substances detected</b>")
+		for _, adulterant := range report.Adulterants {
+			lines = append(lines, fmt.Sprintf("• <b>%s</b>: %s", html.EscapeString(adulterant.Name), html.EscapeString(adulterant.Warning)))
+		}
+		lines = append(lines, "")
+	}
+	if len(report.Substances) > 0 {
+		names := make([]string, len(report.Substances))
+		for i, key := range report.Substances {
+			names[i] = html.EscapeString(substances[key].Name)
+		}
+		lines = append(lines, "🧪 <b>Substances found:</b> "+strings.Join(names, ", "))
+	} else {
+		lines = append(lines, "🧪 I couldn't recognize any substance I know in this report.")
+	}
+	if len(report.Adulterants) == 0 {
+		lines = append(lines, "No dangerous adulterants I check for were found.")
+	}
+	lines = append(lines, "", labResultDisclaimer)
+	return strings.Join(lines, "\n")
+}
+
+func downloadFile(bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file URL: %w", err)
+	}
+	resp, err := HTTPClient(60 * time.Second).Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected file download status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxLabResultBytes))
+}
+
+// HandleLabResultUpload reads an uploaded drug-checking result (a photo, image or PDF of e.g. a
+// GC/MS report) and summarizes what it found. Text recognition is slow, so the upload is
+// acknowledged right away and the summary follows as a reply. Nothing from the upload is stored.
+func HandleLabResultUpload(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
+	message := update.Message
+	chatID := message.Chat.ID
+	fileID, size, contentType := "", 0, "image/jpeg"
+	if len(message.Photo) > 0 {
+		// Telegram lists the sizes of a photo from smallest to largest
+		photo := message.Photo[len(message.Photo)-1]
+		fileID, size = photo.FileID, photo.FileSize
+	} else {
+		fileID, size, contentType = message.Document.FileID, message.Document.FileSize, message.Document.MimeType
+	}
+	if size > maxLabResultBytes {
+		return SendHTML(bot, chatID, fmt.Sprintf("That file is too large to read; please send one under %d MB.", maxLabResultBytes>>20))
+	}
+
+	ack := tgbotapi.NewMessage(chatID, AsyncAckText(SlowLabResult))
+	ack.ReplyToMessageID = message.MessageID
+	sent, err := bot.Send(ack)
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := readLabResult(bot, message, fileID, contentType)
+		if err != nil {
+			log.Printf("Error reading lab result in chat %d: %v", chatID, err)
+			ReportError(err, ErrorContext{Command: "lab_result", ChatType: message.Chat.Type})
+		}
+		done := asyncDoneText
+		if err != nil {
+			done = "I couldn't read that file right now. Please try again later."
+			reply := tgbotapi.NewMessage(chatID, done)
+			reply.ReplyToMessageID = message.MessageID
+			reply.AllowSendingWithoutReply = true
+			bot.Send(reply)
+		}
+		if err := EditMessageHTML(bot, chatID, sent.MessageID, done, nil, nil); err != nil {
+			log.Printf("Error updating lab result acknowledgement: %v", err)
+		}
+	}()
+	return nil
+}
+
+// readLabResult downloads and reads an upload and replies with the summary.
+func readLabResult(bot *tgbotapi.BotAPI, message *tgbotapi.Message, fileID, contentType string) error {
+	chatID := message.Chat.ID
+	bot.Send(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping))
+	data, err := downloadFile(bot, fileID)
+	if err != nil {
+		return err
+	}
+	text, err := RecognizeText(data, contentType)
+	if err != nil {
+		return err
+	}
+	summary := FormatLabReport(ParseLabReport(text))
+	if strings.TrimSpace(text) == "" {
+		summary = "I couldn't find any text in that file. A sharp, straight photo of the report works best."
+	}
+
+	reply := tgbotapi.NewMessage(chatID, summary)
+	reply.ParseMode = tgbotapi.ModeHTML
+	reply.ReplyToMessageID = message.MessageID
+	reply.AllowSendingWithoutReply = true
+	_, err = bot.Send(reply)
+	return err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+)
+
+// languageStopwords are frequent short words used to guess the language of group messages.
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "is", "you", "it", "to", "of", "what", "that", "this", "have", "with", "for", "not", "are"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "du", "mit", "auf", "ein", "eine", "es", "zu", "wie"},
+	"es": {"el", "la", "que", "de", "y", "es", "en", "los", "las", "por", "con", "una", "para", "no", "pero"},
+	"fr": {"le", "la", "les", "et", "est", "que", "je", "tu", "pas", "des", "une", "pour", "avec", "dans", "ce"},
+	"pt": {"o", "a", "que", "de", "e", "é", "não", "um", "uma", "com", "para", "os", "as", "em", "você"},
+}
+
+const (
+	// languageDetectEvery is how many buffered group messages pass between detections.
+	languageDetectEvery = 25
+	minLanguageMessages = 10
+	minLanguageHits     = 15
+)
+
+// localizedStrings are the user-facing texts available in every onboarding language.
+var localizedStrings = map[string]map[string]string{
+	"disclaimer": {
+		"en": OnboardingDisclaimer,
+		"de": "PsyAI gibt Informationen zur Schadensminimierung, keine medizinische Beratung, und kann sich irren. Verlass dich im Notfall nicht darauf: Wenn jemand nicht ansprechbar ist, überhitzt oder einen Krampfanfall hat, ruf den Notruf. Nur Drug-Checking zeigt, was wirklich in deiner Substanz ist.",
+		"es": "PsyAI ofrece información de reducción de riesgos, no consejo médico, y puede equivocarse. No confíes en él en una emergencia: si alguien no responde, se sobrecalienta o tiene una convulsión, llama a emergencias. Solo el análisis de sustancias te dice qué contiene realmente.",
+		"fr": "PsyAI donne des informations de réduction des risques, pas des conseils médicaux, et peut se tromper. N'y comptez pas en cas d'urgence : si quelqu'un ne répond plus, fait une hyperthermie ou une crise convulsive, appelez les secours. Seule l'analyse de produits permet de savoir ce que contient votre substance.",
+		"pt": "O PsyAI dá informações de redução de danos, não aconselhamento médico, e pode errar. Não confie nele numa emergência: se alguém não reage, está a sobreaquecer ou tem uma convulsão, ligue para os serviços de emergência. Só a testagem de substâncias mostra o que a sua substância contém.",
+		"ru": "PsyAI даёт информацию о снижении вреда, а не медицинские советы, и может ошибаться. Не полагайтесь на него в экстренной ситуации: если человек не реагирует, перегревается или у него судороги, вызовите скорую. Только drug checking покажет, что на самом деле в вашем веществе.",
+	},
+	"privacy_notice": {
+		"en": "🔒 Privacy mode: nothing from this chat is stored.",
+		"de": "🔒 Privatsphäre-Modus: Aus diesem Chat wird nichts gespeichert.",
+		"es": "🔒 Modo privado: no se guarda nada de este chat.",
+		"fr": "🔒 Mode confidentialité : rien de ce chat n'est enregistré.",
+		"pt": "🔒 Modo de privacidade: nada deste chat é guardado.",
+		"ru": "🔒 Режим приватности: ничего из этого чата не сохраняется.",
+	},
+	"restarted": {
+		"en": "Sorry, I was restarted before I could answer this. Please ask again.",
+		"de": "Entschuldige, ich wurde neu gestartet, bevor ich antworten konnte. Bitte frag noch einmal.",
+		"es": "Lo siento, me reiniciaron antes de poder responder. Vuelve a preguntar, por favor.",
+		"fr": "Désolé, j'ai été redémarré avant de pouvoir répondre. Merci de reposer la question.",
+		"pt": "Desculpa, fui reiniciado antes de conseguir responder. Pergunta outra vez, por favor.",
+		"ru": "Извините, меня перезапустили до того, как я успел ответить. Пожалуйста, спросите ещё раз.",
+	},
+	"group_intro": {
+		"en": "👋 I'm PsyAI, a harm reduction assistant. Mention me or reply to my answers to ask a question. Commands: /info, /effects, /tolerance, /tldr.",
+		"de": "👋 Ich bin PsyAI, ein Assistent für Schadensminimierung. Erwähne mich oder antworte auf meine Nachrichten, um eine Frage zu stellen. Befehle: /info, /effects, /tolerance, /tldr.",
+		"es": "👋 Soy PsyAI, un asistente de reducción de riesgos. Mencióname o responde a mis mensajes para hacer una pregunta. Comandos: /info, /effects, /tolerance, /tldr.",
+		"fr": "👋 Je suis PsyAI, un assistant de réduction des risques. Mentionnez-moi ou répondez à mes messages pour poser une question. Commandes : /info, /effects, /tolerance, /tldr.",
+		"pt": "👋 Sou o PsyAI, um assistente de redução de danos. Menciona-me ou responde às minhas mensagens para fazer uma pergunta. Comandos: /info, /effects, /tolerance, /tldr.",
+		"ru": "👋 Я PsyAI, помощник по снижению вреда. Упомяните меня или ответьте на моё сообщение, чтобы задать вопрос. Команды: /info, /effects, /tolerance, /tldr.",
+	},
+}
+
+// Localized returns the text for key in the language, falling back to English.
+func Localized(key, code string) string {
+	if text, ok := localizedStrings[key][code]; ok {
+		return text
+	}
+	return localizedStrings[key]["en"]
+}
+
+// DetectLanguage guesses the dominant language of some messages, returning "" when there isn't
+// enough text or no language clearly dominates.
+func DetectLanguage(texts []string) string {
+	if len(texts) < minLanguageMessages {
+		return ""
+	}
+	stopwords := map[string][]string{}
+	for code, words := range languageStopwords {
+		for _, word := range words {
+			stopwords[word] = append(stopwords[word], code)
+		}
+	}
+
+	scores := map[string]int{}
+	total := 0
+	for _, text := range texts {
+		letters, cyrillic := 0, 0
+		for _, r := range text {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.Is(unicode.Cyrillic, r) {
+					cyrillic++
+				}
+			}
+		}
+		// Cyrillic text is counted as Russian; stopwords don't help there
+		if letters > 0 && cyrillic*2 > letters {
+			scores["ru"] += 3
+			total += 3
+			continue
+		}
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+			for _, code := range stopwords[word] {
+				scores[code]++
+				total++
+			}
+		}
+	}
+
+	best, bestScore := "", 0
+	for code, score := range scores {
+		if score > bestScore || (score == bestScore && code < best) {
+			best, bestScore = code, score
+		}
+	}
+	if bestScore < minLanguageHits || bestScore*2 < total {
+		return ""
+	}
+	return best
+}
+
+// DetectGroupLanguage re-detects a group's language from its buffered messages and stores it
+// when it changed.
+func DetectGroupLanguage(chatID int64) {
+	var texts []string
+	messageBuffersMu.Lock()
+	for _, message := range messageBuffers[chatID] {
+		texts = append(texts, message.Text)
+	}
+	messageBuffersMu.Unlock()
+
+	detected := DetectLanguage(texts)
+	if detected == "" || detected == GetChatSettings(chatID).DetectedLanguage {
+		return
+	}
+	if err := UpdateChatSettings(chatID, func(settings *ChatSettings) { settings.DetectedLanguage = detected }); err != nil {
+		log.Printf("Error saving detected language for chat %d: %v", chatID, err)
+	}
+}
+
+// ChatLanguage is the language set by a group's admins, otherwise the detected one.
+func ChatLanguage(chatID int64) string {
+	settings := GetChatSettings(chatID)
+	if settings.Language != "" {
+		return settings.Language
+	}
+	return settings.DetectedLanguage
+}
+
+// ReplyLanguage is the language to answer in: the group's language in groups, the user's
+// choice from /start in private chats.
+func ReplyLanguage(chatID, userID int64) string {
+	if chatID < 0 {
+		return ChatLanguage(chatID)
+	}
+	return GetUserSettings(userID).Language
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// maxResponseBytes caps how much of a backend response is read
+	maxResponseBytes = 1 << 20
+	// maxStreamedAnswerBytes is where a streamed answer stops being read; SplitAnswer cuts it
+	// shorter anyway
+	maxStreamedAnswerBytes = 64 << 10
+	// maxAnswerPartChars is the Markdown budget of one message, leaving room below Telegram's
+	// 4096 characters for the HTML tags and the notes appended to answers
+	maxAnswerPartChars = 3500
+	// maxAnswerParts is how many messages an answer is spread over before it is cut short
+	maxAnswerParts = 3
+)
+
+// ContinuedPrefix opens the follow-up messages of an answer too long for one message.
+const ContinuedPrefix = "<i>…continued</i>\n\n"
+
+// TruncatedNote ends an answer that didn't fit in maxAnswerParts messages.
+const TruncatedNote = "\n\n<i>✂️ The answer was cut short here. Ask about a specific part for more detail.</i>"
+
+// maxQuestionChars is the longest question passed to the backend, from MAX_QUESTION_CHARS
+// (default 2000).
+func maxQuestionChars() int {
+	limit, err := strconv.Atoi(GetenvVar("MAX_QUESTION_CHARS", false))
+	if err != nil || limit < 1 {
+		limit = 2000
+	}
+	return limit
+}
+
+// QuestionTooLong returns the reply for a question over the length limit, or "".
+func QuestionTooLong(question string) string {
+	length, limit := utf8.RuneCountInString(question), maxQuestionChars()
+	if length <= limit {
+		return ""
+	}
+	return fmt.Sprintf("That message is a bit long for me (%d characters). Please keep questions under %d characters, "+
+		"or split it into a few shorter ones.", length, limit)
+}
+
+// answerCut is where the part of an answer ending within the first limit runes of text is cut: at
+// the last paragraph break, else line break, sentence end or space, falling back to a hard cut.
+// It stays out of an open code block when it can.
+func answerCut(text string, limit int) int {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return len(text)
+	}
+	end := len(string(runes[:limit]))
+	cut := end
+	for _, separator := range []string{"\n\n", "\n", ". ", " "} {
+		// A cut in the first half would make for a needlessly short message
+		if i := strings.LastIndex(text[:end], separator); i > end/2 {
+			cut = i + len(separator)
+			break
+		}
+	}
+	if strings.Count(text[:cut], "```")%2 == 1 {
+		if i := strings.LastIndex(text[:cut], "```"); i > 0 {
+			cut = i
+		}
+	}
+	return cut
+}
+
+// SplitAnswer splits a Markdown answer into the parts sent as separate messages, each within
+// maxAnswerPartChars. The same answer always splits the same way. The answer is truncated after
+// maxAnswerParts parts, which SplitAnswer reports.
+func SplitAnswer(text string) (parts []string, truncated bool) {
+	text = strings.TrimSpace(text)
+	for text != "" {
+		if len(parts) == maxAnswerParts {
+			return parts, true
+		}
+		cut := answerCut(text, maxAnswerPartChars)
+		parts = append(parts, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	if len(parts) == 0 {
+		parts = []string{""}
+	}
+	return parts, false
+}
+
+// AnswerHTML converts an answer that has to fit in one message, ending it with TruncatedNote
+// when it doesn't.
+func AnswerHTML(text string) string {
+	parts, truncated := SplitAnswer(text)
+	if len(parts) > 1 || truncated {
+		return AnswerPartHTML(parts[0]) + TruncatedNote
+	}
+	return AnswerPartHTML(parts[0])
+}
+
+// SendContinuations sends the follow-up parts of a long answer as replies to its first message.
+func SendContinuations(bot *tgbotapi.BotAPI, chatID int64, messageID int, parts []string) error {
+	for _, part := range parts {
+		msg := tgbotapi.NewMessage(chatID, part)
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.ReplyToMessageID = messageID
+		msg.DisableWebPagePreview = true
+		if _, err := bot.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// LoadTestOptions configure a synthetic traffic run.
+type LoadTestOptions struct {
+	// Rate is how many questions per second are injected, spread over Users private chats
+	Rate     float64
+	Duration time.Duration
+	Users    int
+	// BackendLatency is how long the backend stub takes to answer, ±50%
+	BackendLatency time.Duration
+	// TelegramLatency is how long every Telegram API call takes
+	TelegramLatency time.Duration
+	// RateLimitShare is the share of Telegram calls answered with 429 Too Many Requests, spread
+	// evenly so a retry right after one rarely hits another
+	RateLimitShare float64
+	Streaming      bool
+	// Drain is how long to wait for outstanding answers after the last question
+	Drain time.Duration
+}
+
+// LoadTestReport is the outcome of a synthetic traffic run.
+type LoadTestReport struct {
+	Injected      int
+	Answered      int
+	Elapsed       time.Duration
+	Workers       int
+	MaxQueueDepth int
+	// Latencies are from injecting a question to the complete answer reaching Telegram
+	Latencies []time.Duration
+	// DispatchTimes are how long the dispatcher was busy with each update before taking the next
+	DispatchTimes    []time.Duration
+	BackendRequests  int64
+	TelegramCalls    map[string]int
+	TelegramLimited  int64
+	HandlerFailures  int
+	QuotaRejections  int64
+	UnansweredSample []int
+	// MinAnswerEditGap is the shortest time between two edits of answer text in one chat, which
+	// streaming keeps at StreamEditInterval or more
+	MinAnswerEditGap time.Duration
+}
+
+var (
+	loadTestQuestions = []string{
+		"What are the effects of combining caffeine and alcohol?",
+		"How long does LSD last?",
+		"Is it safe to take ibuprofen after a night out?",
+		"What should I know before trying cannabis edibles?",
+		"How can I tell if someone is overheating at a festival?",
+		"What does set and setting mean?",
+	}
+	loadTestMarker = regexp.MustCompile(`load test #(\d+)`)
+)
+
+// loadTestAnswer is the backend stub's answer to question n; its last line marks it complete.
+func loadTestAnswer(n string) string {
+	return "Synthetic answer (load test #" + n + ").\n\n" + strings.Repeat("This paragraph stands in for harm reduction advice. ", 12) +
+		"\n\n**Key points**\n- Start low\n- Go slow\n\nEnd of synthetic answer."
+}
+
+// loadTestBackend stubs the PsyAI API, answering both the prompt and the stream endpoint.
+func loadTestBackend(options LoadTestOptions, requests *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		var request PromptRequest
+		json.NewDecoder(io.LimitReader(r.Body, maxResponseBytes)).Decode(&request)
+		n := "0"
+		if match := loadTestMarker.FindStringSubmatch(request.Question); match != nil {
+			n = match[1]
+		}
+		answer := loadTestAnswer(n)
+		latency := options.BackendLatency/2 + time.Duration(rand.Int63n(int64(options.BackendLatency)+1))
+
+		if !strings.HasPrefix(r.URL.Path, "/prompt/stream") {
+			time.Sleep(latency)
+			json.NewEncoder(w).Encode(PromptResponse{Assistant: answer})
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher, _ := w.(http.Flusher)
+		words := strings.SplitAfter(answer, " ")
+		step := max(len(words)/10, 1)
+		for i := 0; i < len(words); i += step {
+			time.Sleep(latency / 10)
+			delta, _ := json.Marshal(map[string]string{"delta": strings.Join(words[i:min(i+step, len(words))], "")})
+			fmt.Fprintf(w, "data: %s\n\n", delta)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// loadTestTelegram stubs the Bot API, recording when each question's complete answer arrives.
+type loadTestTelegram struct {
+	options   LoadTestOptions
+	nextID    int64
+	served    int64
+	limited   int64
+	quota     int64
+	mu        sync.Mutex
+	calls     map[string]int
+	completed map[int]time.Time
+	// answerEdits are when answer text was edited, by chat
+	answerEdits map[int64][]time.Time
+}
+
+// rateLimited reports whether the next call is one of the RateLimitShare answered with 429.
+func (t *loadTestTelegram) rateLimited() bool {
+	n := float64(atomic.AddInt64(&t.served, 1))
+	return int(n*t.options.RateLimitShare) != int((n-1)*t.options.RateLimitShare)
+}
+
+func (t *loadTestTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	r.ParseMultipartForm(1 << 20)
+	t.mu.Lock()
+	t.calls[method]++
+	t.mu.Unlock()
+	time.Sleep(t.options.TelegramLatency)
+
+	w.Header().Set("Content-Type", "application/json")
+	if method != "getMe" && t.rateLimited() {
+		atomic.AddInt64(&t.limited, 1)
+		w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+		return
+	}
+
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = tgbotapi.User{ID: 1, IsBot: true, FirstName: "PsyAI", UserName: "psyai_loadtest_bot"}
+	case "sendMessage", "editMessageText", "sendPhoto", "sendDocument":
+		text := r.FormValue("text")
+		chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		if method == "editMessageText" && loadTestMarker.MatchString(text) {
+			t.mu.Lock()
+			t.answerEdits[chatID] = append(t.answerEdits[chatID], time.Now())
+			t.mu.Unlock()
+		}
+		if match := loadTestMarker.FindStringSubmatch(text); match != nil && strings.Contains(text, "End of synthetic answer") {
+			n, _ := strconv.Atoi(match[1])
+			t.mu.Lock()
+			if _, ok := t.completed[n]; !ok {
+				t.completed[n] = time.Now()
+			}
+			t.mu.Unlock()
+		} else if method == "sendMessage" && strings.Contains(text, "today's limit") {
+			atomic.AddInt64(&t.quota, 1)
+		}
+		messageID, _ := strconv.Atoi(r.FormValue("message_id"))
+		if messageID == 0 {
+			messageID = int(atomic.AddInt64(&t.nextID, 1))
+		}
+		result = tgbotapi.Message{MessageID: messageID, Date: int(time.Now().Unix()), Chat: &tgbotapi.Chat{ID: chatID, Type: "private"},
+			From: &tgbotapi.User{ID: 1, IsBot: true, UserName: "psyai_loadtest_bot"}, Text: text}
+	}
+	raw, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+// RunLoadTest injects synthetic questions through HandleUpdate, the real dispatcher, with a
+// stubbed Telegram API and backend, and measures how the worker pool and rate limits cope. It
+// runs on throwaway stores in a temporary DATA_DIR.
+func RunLoadTest(options LoadTestOptions) (*LoadTestReport, error) {
+	if options.Rate <= 0 || options.Duration <= 0 || options.Users < 1 {
+		return nil, fmt.Errorf("rate, duration and users must be positive")
+	}
+	dir, err := os.MkdirTemp("", "psyai-loadtest-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var backendRequests int64
+	backend := loadTestBackend(options, &backendRequests)
+	defer backend.Close()
+	telegram := &loadTestTelegram{options: options, calls: map[string]int{}, completed: map[int]time.Time{}, answerEdits: map[int64][]time.Time{}}
+	telegramServer := httptest.NewServer(telegram)
+	defer telegramServer.Close()
+
+	for name, value := range map[string]string{
+		"DATA_DIR":          dir,
+		"LLM_PROVIDER":      ProviderPsyAI,
+		"BASE_URL_BETA":     backend.URL,
+		"STREAMING_ENABLED": strconv.FormatBool(options.Streaming),
+	} {
+		os.Setenv(name, value)
+	}
+	if err := OpenStores(); err != nil {
+		return nil, err
+	}
+	bot, err := tgbotapi.NewBotAPIWithClient("loadtest", telegramServer.URL+"/bot%s/%s", TelegramClient())
+	if err != nil {
+		return nil, err
+	}
+	askPool = NewWorkerPool(WorkerCountFromEnv())
+
+	report := &LoadTestReport{Workers: WorkerCountFromEnv()}
+	sampling := make(chan struct{})
+	var samplerDone sync.WaitGroup
+	samplerDone.Add(1)
+	go func() {
+		defer samplerDone.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sampling:
+				return
+			case <-ticker.C:
+				report.MaxQueueDepth = max(report.MaxQueueDepth, askPool.QueueDepth())
+			}
+		}
+	}()
+
+	// Updates are handled one at a time, like RunBot does, so a slow dispatcher delays injection
+	injected := map[int]time.Time{}
+	interval := time.Duration(float64(time.Second) / options.Rate)
+	start := time.Now()
+	for n := 1; time.Since(start) < options.Duration; n++ {
+		if wait := time.Until(start.Add(time.Duration(n-1) * interval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		userID := int64(900000000 + (n-1)%options.Users + 1)
+		// The run's start makes questions unique, so a second run isn't answered from the cache
+		question := fmt.Sprintf("%s (load test #%d, run %x)", loadTestQuestions[n%len(loadTestQuestions)], n, start.UnixNano())
+		update := tgbotapi.Update{UpdateID: n, Message: &tgbotapi.Message{
+			MessageID: n,
+			From:      &tgbotapi.User{ID: userID, FirstName: "Load", LanguageCode: "en"},
+			Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+			Date:      int(time.Now().Unix()),
+			Text:      question,
+		}}
+		injected[n] = time.Now()
+		HandleUpdate(bot, update)
+		report.DispatchTimes = append(report.DispatchTimes, time.Since(injected[n]))
+	}
+	report.Injected = len(injected)
+
+	deadline := time.Now().Add(options.Drain)
+	for time.Now().Before(deadline) {
+		telegram.mu.Lock()
+		done := len(telegram.completed)
+		telegram.mu.Unlock()
+		if int64(done)+atomic.LoadInt64(&telegram.quota) >= int64(report.Injected) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	report.Elapsed = time.Since(start)
+	close(sampling)
+	samplerDone.Wait()
+
+	telegram.mu.Lock()
+	defer telegram.mu.Unlock()
+	for n, at := range injected {
+		if completed, ok := telegram.completed[n]; ok {
+			report.Latencies = append(report.Latencies, completed.Sub(at))
+		} else if len(report.UnansweredSample) < 5 {
+			report.UnansweredSample = append(report.UnansweredSample, n)
+		}
+	}
+	report.Answered = len(report.Latencies)
+	report.TelegramCalls = telegram.calls
+	for _, edits := range telegram.answerEdits {
+		for i := 1; i < len(edits); i++ {
+			if gap := edits[i].Sub(edits[i-1]); report.MinAnswerEditGap == 0 || gap < report.MinAnswerEditGap {
+				report.MinAnswerEditGap = gap
+			}
+		}
+	}
+	report.TelegramLimited = atomic.LoadInt64(&telegram.limited)
+	report.QuotaRejections = atomic.LoadInt64(&telegram.quota)
+	report.BackendRequests = atomic.LoadInt64(&backendRequests)
+	_, report.HandlerFailures, _ = RecentHandlerRuns("ask", start)
+	return report, nil
+}
+
+// durationPercentile is the p-th percentile of durations, which it sorts.
+func durationPercentile(durations []time.Duration, p int) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[min(len(durations)*p/100, len(durations)-1)]
+}
+
+// FormatLoadTestReport renders a report for the terminal.
+func FormatLoadTestReport(report *LoadTestReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Injected %d questions, %d answered (%.1f/s) in %s with %d workers\n",
+		report.Injected, report.Answered, float64(report.Answered)/report.Elapsed.Seconds(), report.Elapsed.Round(time.Millisecond), report.Workers)
+	fmt.Fprintf(&b, "Answer latency: p50 %s, p95 %s, p99 %s, max %s\n",
+		durationPercentile(report.Latencies, 50).Round(time.Millisecond), durationPercentile(report.Latencies, 95).Round(time.Millisecond),
+		durationPercentile(report.Latencies, 99).Round(time.Millisecond), durationPercentile(report.Latencies, 100).Round(time.Millisecond))
+	fmt.Fprintf(&b, "Dispatcher busy per update: p50 %s, p95 %s\n",
+		durationPercentile(report.DispatchTimes, 50).Round(time.Millisecond), durationPercentile(report.DispatchTimes, 95).Round(time.Millisecond))
+	fmt.Fprintf(&b, "Max queue depth: %d\n", report.MaxQueueDepth)
+	fmt.Fprintf(&b, "Backend requests: %d, failed answers: %d, quota rejections: %d\n",
+		report.BackendRequests, report.HandlerFailures, report.QuotaRejections)
+	methods := make([]string, 0, len(report.TelegramCalls))
+	total := 0
+	for method, count := range report.TelegramCalls {
+		methods = append(methods, fmt.Sprintf("%s %d", method, count))
+		total += count
+	}
+	sort.Strings(methods)
+	fmt.Fprintf(&b, "Telegram calls: %d (%s), %d answered with 429\n", total, strings.Join(methods, ", "), report.TelegramLimited)
+	if report.MinAnswerEditGap > 0 {
+		fmt.Fprintf(&b, "Closest answer edits in one chat: %s apart\n", report.MinAnswerEditGap.Round(time.Millisecond))
+	}
+	if len(report.UnansweredSample) > 0 {
+		fmt.Fprintf(&b, "Unanswered, e.g. #%v\n", report.UnansweredSample)
+	}
+	return b.String()
+}
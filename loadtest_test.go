@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// runLoadTest runs the harness with its environment changes undone after the test.
+func runLoadTest(tb testing.TB, options LoadTestOptions) *LoadTestReport {
+	tb.Helper()
+	for _, name := range []string{"DATA_DIR", "LLM_PROVIDER", "BASE_URL_BETA", "STREAMING_ENABLED"} {
+		tb.Setenv(name, "")
+	}
+	report, err := RunLoadTest(options)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Logf("\n%s", FormatLoadTestReport(report))
+	return report
+}
+
+func TestLoadTestAnswersEveryUpdate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test takes several seconds")
+	}
+	for _, streaming := range []bool{false, true} {
+		options := LoadTestOptions{
+			Rate:            10,
+			Duration:        time.Second,
+			Users:           5,
+			BackendLatency:  50 * time.Millisecond,
+			TelegramLatency: time.Millisecond,
+			Streaming:       streaming,
+			Drain:           30 * time.Second,
+		}
+		report := runLoadTest(t, options)
+
+		// The dispatcher paces injection, so it can't run ahead of the configured rate
+		if limit := int(options.Rate*options.Duration.Seconds()) + 1; report.Injected == 0 || report.Injected > limit {
+			t.Errorf("streaming=%v: injected %d updates, want 1-%d", streaming, report.Injected, limit)
+		}
+		if handled := report.Answered + int(report.QuotaRejections); handled != report.Injected {
+			t.Errorf("streaming=%v: %d of %d updates handled, unanswered e.g. %v", streaming, handled, report.Injected, report.UnansweredSample)
+		}
+		if report.HandlerFailures != 0 {
+			t.Errorf("streaming=%v: %d failed answers", streaming, report.HandlerFailures)
+		}
+		// Streamed edits share the chat's edit slots; allow for the stub's request jitter
+		if streaming && report.MinAnswerEditGap > 0 && report.MinAnswerEditGap < StreamEditInterval-100*time.Millisecond {
+			t.Errorf("answer edits %s apart in one chat, want at least %s", report.MinAnswerEditGap, StreamEditInterval)
+		}
+	}
+}
+
+func TestLoadTestSurvivesTelegramRateLimits(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test takes several seconds")
+	}
+	report := runLoadTest(t, LoadTestOptions{
+		Rate:            10,
+		Duration:        time.Second,
+		Users:           10,
+		BackendLatency:  50 * time.Millisecond,
+		TelegramLatency: time.Millisecond,
+		RateLimitShare:  0.2,
+		Streaming:       true,
+		Drain:           30 * time.Second,
+	})
+	if report.TelegramLimited == 0 {
+		t.Fatal("no call was rate limited")
+	}
+	// Rate limited sends, thinking messages included, are retried after the flood wait
+	if handled := report.Answered + int(report.QuotaRejections); handled != report.Injected {
+		t.Errorf("%d of %d updates handled through %d rate limited calls, unanswered e.g. %v",
+			handled, report.Injected, report.TelegramLimited, report.UnansweredSample)
+	}
+	if report.HandlerFailures != 0 {
+		t.Errorf("%d failed answers", report.HandlerFailures)
+	}
+}
+
+func BenchmarkDispatcher(b *testing.B) {
+	report := runLoadTest(b, LoadTestOptions{
+		Rate:     float64(b.N),
+		Duration: time.Second,
+		Users:    max(b.N/5, 1),
+		Drain:    30 * time.Second,
+	})
+	b.ReportMetric(float64(report.Answered)/report.Elapsed.Seconds(), "answers/s")
+	b.ReportMetric(float64(durationPercentile(report.DispatchTimes, 95).Microseconds()), "dispatch-p95-µs")
+}
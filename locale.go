@@ -0,0 +1,93 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale is how numbers and times are written for a reader.
+type Locale struct {
+	// DecimalComma writes 0,5 rather than 0.5
+	DecimalComma bool
+	// Hour12 writes 3:04 PM rather than 15:04
+	Hour12 bool
+}
+
+// decimalPointLanguages write decimals with a point, the others with a comma.
+var decimalPointLanguages = map[string]bool{"en": true, "zh": true, "ja": true, "ko": true, "he": true, "hi": true, "th": true, "ms": true}
+
+// decimalPointRegions write decimals with a point whatever the language, like Spanish in Mexico.
+var decimalPointRegions = map[string]bool{"us": true, "gb": true, "ie": true, "au": true, "nz": true, "ca": true, "mx": true, "ch": true, "in": true, "ph": true}
+
+// hour12Regions use a 12-hour clock. English without a region gets the 24-hour clock the bot
+// always used, as Telegram rarely reports one.
+var hour12Regions = map[string]bool{"us": true, "ca": true, "au": true, "nz": true, "in": true, "ph": true, "pk": true, "eg": true, "sa": true}
+
+// LocaleFor returns the locale of a language code, refined by a region when known.
+func LocaleFor(language, region string) Locale {
+	language, region = strings.ToLower(language), strings.ToLower(region)
+	locale := Locale{DecimalComma: language != "" && !decimalPointLanguages[language], Hour12: hour12Regions[region]}
+	if decimalPointRegions[region] {
+		locale.DecimalComma = false
+	}
+	return locale
+}
+
+// splitLocale splits a language tag such as "pt-BR" or "en_US" into language and region.
+func splitLocale(tag string) (string, string) {
+	language, region, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	return language, region
+}
+
+// UserLocale is the locale of a user: the language chosen during /start, or the one of their
+// Telegram client, with the region their client reports.
+func UserLocale(userID int64) Locale {
+	language, region := splitLocale(GetUserProfile(userID).Locale)
+	if chosen := GetUserSettings(userID).Language; chosen != "" {
+		language = chosen
+	}
+	return LocaleFor(language, region)
+}
+
+// ChatLocale is the user's locale in private chats and the group's language in groups, where
+// everyone reads the message.
+func ChatLocale(chatID, userID int64) Locale {
+	if chatID < 0 {
+		return LocaleFor(ChatLanguage(chatID), "")
+	}
+	return UserLocale(userID)
+}
+
+// Number writes a number with the locale's decimal separator.
+func (l Locale) Number(value float64) string {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if l.DecimalComma {
+		text = strings.Replace(text, ".", ",", 1)
+	}
+	return text
+}
+
+var decimalPointPattern = regexp.MustCompile(`(\d)\.(\d)`)
+
+// Amounts rewrites the decimals of a text such as a dose range ("0.5-1.5 g").
+func (l Locale) Amounts(text string) string {
+	if !l.DecimalComma {
+		return text
+	}
+	return decimalPointPattern.ReplaceAllString(text, "$1,$2")
+}
+
+// Clock writes a time of day.
+func (l Locale) Clock(t time.Time) string {
+	if l.Hour12 {
+		return t.Format("3:04 PM")
+	}
+	return t.Format("15:04")
+}
+
+// Hour writes a full hour of the day (0-23).
+func (l Locale) Hour(hour int) string {
+	return l.Clock(time.Date(2000, 1, 1, hour, 0, 0, 0, time.UTC))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Steps of the guided /log form.
+const (
+	LogStepSubstance = "substance"
+	LogStepRoute     = "route"
+	LogStepAmount    = "amount"
+	LogStepTime      = "time"
+)
+
+// logForm is a user's dose being entered through the guided form.
+type logForm struct {
+	MessageID int
+	Step      string
+	Substance string
+	Route     string
+	// Amount is the digits typed on the keypad so far
+	Amount string
+	Unit   string
+	// Typed is the last substance name the user searched for
+	Typed string
+}
+
+var (
+	// logForms are the open forms by user. Forms left alone for half an hour are dropped.
+	logForms = NewBoundedMap[int64, *logForm]("log_forms", 10000, 30*time.Minute)
+
+	logFormSubstances = []string{"mdma", "cannabis", "alcohol", "cocaine", "ketamine", "lsd", "psilocybin", "amphetamine"}
+	logFormRoutes     = []string{"oral", "insufflated", "smoked", "sublingual"}
+	logFormUnits      = []string{"mg", "µg", "g", "ml"}
+	logFormTimes      = []int{0, 15, 30, 60, 120}
+)
+
+// StartLogForm sends the first step of the guided form and remembers it for the user.
+func StartLogForm(bot *tgbotapi.BotAPI, chatID int64, userID int64) error {
+	form := &logForm{Step: LogStepSubstance, Unit: GetUserSettings(userID).DoseUnit}
+	if form.Unit == "" {
+		form.Unit = "mg"
+	}
+	text, markup := form.render(nil)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = markup
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return err
+	}
+	form.MessageID = sent.MessageID
+	logForms.Set(userID, form)
+	return nil
+}
+
+func cancelRow() []tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✖️ Cancel", "lf:x"))
+}
+
+// render returns the text and buttons of the form's current step. matches are the results of a
+// typed substance search, if any.
+func (f *logForm) render(matches []string) (string, tgbotapi.InlineKeyboardMarkup) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var b strings.Builder
+	b.WriteString("<b>Log a dose</b>\n")
+	if f.Substance != "" {
+		fmt.Fprintf(&b, "Substance: %s\n", html.EscapeString(f.substanceName()))
+	}
+	if f.Route != "" {
+		fmt.Fprintf(&b, "Route: %s\n", html.EscapeString(f.Route))
+	}
+
+	switch f.Step {
+	case LogStepSubstance:
+		keys := logFormSubstances
+		if matches != nil {
+			keys = matches
+		}
+		if f.Typed != "" {
+			fmt.Fprintf(&b, "\nResults for <i>%s</i>. Pick one, or type another name:", html.EscapeString(f.Typed))
+		} else {
+			b.WriteString("\nWhich substance? Pick one or type its name:")
+		}
+		var row []tgbotapi.InlineKeyboardButton
+		for _, key := range keys {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(substances[key].Name, "lf:s:"+key))
+			if len(row) == 4 {
+				rows, row = append(rows, row), nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		if f.Typed != "" {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Log as \""+Truncate(f.Typed, 30)+"\"", "lf:s:_")))
+		}
+	case LogStepRoute:
+		b.WriteString("\nHow did you take it?")
+		var row []tgbotapi.InlineKeyboardButton
+		for _, route := range f.routes() {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(route, "lf:r:"+route))
+		}
+		rows = append(rows, row, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Skip", "lf:r:-")))
+	case LogStepAmount:
+		amount := f.Amount
+		if amount == "" {
+			amount = "_"
+		}
+		fmt.Fprintf(&b, "\nHow much? <code>%s %s</code>", amount, html.EscapeString(f.Unit))
+		for _, digits := range []string{"123", "456", "789"} {
+			var row []tgbotapi.InlineKeyboardButton
+			for _, digit := range digits {
+				row = append(row, tgbotapi.NewInlineKeyboardButtonData(string(digit), "lf:d:"+string(digit)))
+			}
+			rows = append(rows, row)
+		}
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(".", "lf:d:."),
+				tgbotapi.NewInlineKeyboardButtonData("0", "lf:d:0"),
+				tgbotapi.NewInlineKeyboardButtonData("⌫", "lf:b"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Unit: "+f.Unit, "lf:u"),
+				tgbotapi.NewInlineKeyboardButtonData("✅ Next", "lf:ok"),
+			))
+	case LogStepTime:
+		fmt.Fprintf(&b, "Amount: %s %s\n\nWhen?", f.Amount, html.EscapeString(f.Unit))
+		var row []tgbotapi.InlineKeyboardButton
+		for _, minutes := range logFormTimes {
+			label := "Now"
+			if minutes > 0 {
+				label = FormatDuration(time.Duration(minutes)*time.Minute) + " ago"
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, "lf:t:"+strconv.Itoa(minutes)))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, cancelRow())
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (f *logForm) substanceName() string {
+	if substance, ok := substances[f.Substance]; ok {
+		return substance.Name
+	}
+	return f.Substance
+}
+
+// routes offers the substance's curated routes, falling back to the common ones.
+func (f *logForm) routes() []string {
+	var routes []string
+	for _, route := range substanceRoutes[f.Substance] {
+		routes = append(routes, strings.ToLower(route.Name))
+	}
+	if len(routes) == 0 {
+		return logFormRoutes
+	}
+	return routes
+}
+
+// HandleLogFormReply treats a private message as a substance search when the user's form is
+// waiting for one. It reports whether the message was consumed.
+func HandleLogFormReply(bot *tgbotapi.BotAPI, update tgbotapi.Update) (bool, error) {
+	message := update.Message
+	if !message.Chat.IsPrivate() || message.From == nil || message.IsCommand() || message.Text == "" {
+		return false, nil
+	}
+	form, ok := logForms.Get(message.From.ID)
+	if !ok || form.Step != LogStepSubstance {
+		return false, nil
+	}
+
+	form.Typed = strings.ToLower(strings.TrimSpace(message.Text))
+	matches := []string{}
+	for _, match := range ResolveSubstance(form.Typed) {
+		if len(matches) == 4 {
+			break
+		}
+		matches = append(matches, match.Key)
+	}
+	text, markup := form.render(matches)
+	return true, EditMessageHTML(bot, message.Chat.ID, form.MessageID, text, nil, &markup)
+}
+
+// HandleLogFormCallback advances the guided form ("lf:<action>[:<value>]").
+func HandleLogFormCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) == 0 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	form, ok := logForms.Get(query.From.ID)
+	if !ok || form.MessageID != query.Message.MessageID {
+		return AnswerCallback(bot, query, "This form has expired, send /log to start again.")
+	}
+	chatID := query.Message.Chat.ID
+	value := ""
+	if len(args) > 1 {
+		value = args[1]
+	}
+
+	switch args[0] {
+	case "x":
+		logForms.Delete(query.From.ID)
+		if err := EditMessageHTML(bot, chatID, form.MessageID, "Cancelled, nothing was logged.", nil, nil); err != nil {
+			return err
+		}
+		return AnswerCallback(bot, query, "")
+	case "s":
+		form.Substance = value
+		if value == "_" {
+			form.Substance = form.Typed
+		}
+		if form.Substance == "" {
+			return AnswerCallback(bot, query, "")
+		}
+		form.Step = LogStepRoute
+	case "r":
+		if value != "-" {
+			form.Route = value
+		}
+		form.Step = LogStepAmount
+	case "d":
+		if (value == "." && strings.Contains(form.Amount, ".")) || len(form.Amount) >= 8 {
+			return AnswerCallback(bot, query, "")
+		}
+		form.Amount += value
+	case "b":
+		if form.Amount != "" {
+			form.Amount = form.Amount[:len(form.Amount)-1]
+		}
+	case "u":
+		for i, unit := range logFormUnits {
+			if unit == form.Unit {
+				form.Unit = logFormUnits[(i+1)%len(logFormUnits)]
+				break
+			}
+		}
+		if form.Unit == "" {
+			form.Unit = logFormUnits[0]
+		}
+	case "ok":
+		if amount, err := strconv.ParseFloat(form.Amount, 64); err != nil || amount <= 0 {
+			return AnswerCallback(bot, query, "Enter an amount first.")
+		}
+		form.Step = LogStepTime
+	case "t":
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return AnswerCallback(bot, query, "")
+		}
+		return finishLogForm(bot, query, form, time.Duration(minutes)*time.Minute)
+	default:
+		return AnswerCallback(bot, query, "")
+	}
+
+	// Setting it again restarts the form's half hour
+	logForms.Set(query.From.ID, form)
+	text, markup := form.render(nil)
+	if err := EditMessageHTML(bot, chatID, form.MessageID, text, nil, &markup); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
+
+func finishLogForm(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, form *logForm, ago time.Duration) error {
+	amount, err := strconv.ParseFloat(form.Amount, 64)
+	if err != nil {
+		return AnswerCallback(bot, query, "Enter an amount first.")
+	}
+	logForms.Delete(query.From.ID)
+	entry := DoseEntry{
+		Substance: form.Substance,
+		Amount:    amount,
+		Unit:      form.Unit,
+		Route:     form.Route,
+		At:        time.Now().Add(-ago),
+	}
+	if held, err := HoldImplausibleDose(bot, query.Message.Chat.ID, form.MessageID, query.From.ID, entry); held {
+		if err != nil {
+			return err
+		}
+		return AnswerCallback(bot, query, "")
+	}
+	reply, err := LogDose(query.From.ID, entry)
+	if err != nil {
+		return err
+	}
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, form.MessageID, reply, nil, nil); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "Logged")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// mentionPromptSubstanceCooldown keeps a group from being offered the same factsheet twice
+	mentionPromptSubstanceCooldown = 6 * time.Hour
+	// mentionPromptChatCooldown is the least time between two prompts in a group
+	mentionPromptChatCooldown = 5 * time.Minute
+)
+
+// mentionPromptsSent are the recent prompts by "<chat>:<substance>", and by "<chat>" alone.
+var mentionPromptsSent = NewBoundedMap[string, time.Time]("mention_prompts", 20000, mentionPromptSubstanceCooldown)
+
+// PromptSubstanceInfo offers the factsheets of the substances a group message mentions, when the
+// group turned mention prompts on. Nothing is posted until someone taps a button ("info:<key>"),
+// and each substance is offered at most every few hours.
+func PromptSubstanceInfo(bot *tgbotapi.BotAPI, message *tgbotapi.Message, text string) error {
+	if message.From != nil && message.From.IsBot {
+		return nil
+	}
+	chatID := message.Chat.ID
+	if !GetChatSettings(chatID).MentionPrompts {
+		return nil
+	}
+	now := time.Now()
+	chatKey := ChatKey(chatID)
+	if last, ok := mentionPromptsSent.Get(chatKey); ok && now.Sub(last) < mentionPromptChatCooldown {
+		return nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, key := range DetectSubstances(text) {
+		substanceKey := fmt.Sprintf("%d:%s", chatID, key)
+		if _, recent := mentionPromptsSent.Get(substanceKey); recent {
+			continue
+		}
+		mentionPromptsSent.Set(substanceKey, now)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("ℹ️ Info on "+substances[key].Name+"?", "info:"+key))
+		if len(row) == 2 {
+			break
+		}
+	}
+	if len(row) == 0 {
+		return nil
+	}
+	mentionPromptsSent.Set(chatKey, now)
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData("✕", "mp:x"))
+
+	msg := tgbotapi.NewMessage(chatID, "💊 Want the factsheet?")
+	msg.ReplyToMessageID = message.MessageID
+	msg.DisableNotification = true
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleMentionPromptCallback dismisses a substance prompt ("mp:x").
+func HandleMentionPromptCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || args[0] != "x" || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(query.Message.Chat.ID, query.Message.MessageID)); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// handlerWindowSize is how many recent runs per handler the percentiles are computed over.
+	handlerWindowSize = 200
+	// handlerMinSamples avoids alerting on a handful of runs.
+	handlerMinSamples  = 20
+	handlerAlertPeriod = time.Hour
+)
+
+type handlerSample struct {
+	Duration time.Duration
+	Failed   bool
+	Detail   string
+	At       time.Time
+}
+
+// handlerWindow keeps the most recent runs of one handler plus lifetime totals.
+type handlerWindow struct {
+	samples   []handlerSample
+	next      int
+	runs      int
+	errors    int
+	lastAlert time.Time
+}
+
+// HandlerStats summarizes a handler's recent runs.
+type HandlerStats struct {
+	Name      string        `json:"name"`
+	Runs      int           `json:"runs"`
+	Errors    int           `json:"errors"`
+	P50       time.Duration `json:"p50_ns"`
+	P95       time.Duration `json:"p95_ns"`
+	ErrorRate float64       `json:"error_rate"`
+}
+
+var (
+	handlerMetricsMu sync.Mutex
+	handlerMetrics   = map[string]*handlerWindow{}
+)
+
+// slowHandlerThreshold is the p95 above which a handler is reported: ASK_P95_MS (default 30s)
+// for answering questions, HANDLER_P95_MS (default 3s) for everything else.
+func slowHandlerThreshold(name string) time.Duration {
+	variable, fallback := "HANDLER_P95_MS", 3000
+	if name == "ask" {
+		variable, fallback = "ASK_P95_MS", 30000
+	}
+	ms, err := strconv.Atoi(GetenvVar(variable, false))
+	if err != nil || ms <= 0 {
+		ms = fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// handlerErrorRateThreshold is HANDLER_ERROR_RATE, the share of failed runs that is reported (default 0.2).
+func handlerErrorRateThreshold() float64 {
+	rate, err := strconv.ParseFloat(GetenvVar("HANDLER_ERROR_RATE", false), 64)
+	if err != nil || rate <= 0 {
+		return 0.2
+	}
+	return rate
+}
+
+func (w *handlerWindow) add(sample handlerSample) {
+	if len(w.samples) < handlerWindowSize {
+		w.samples = append(w.samples, sample)
+	} else {
+		w.samples[w.next] = sample
+		w.next = (w.next + 1) % handlerWindowSize
+	}
+	w.runs++
+	if sample.Failed {
+		w.errors++
+	}
+}
+
+func (w *handlerWindow) stats(name string) HandlerStats {
+	durations := make([]time.Duration, len(w.samples))
+	failed := 0
+	for i, sample := range w.samples {
+		durations[i] = sample.Duration
+		if sample.Failed {
+			failed++
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	stats := HandlerStats{Name: name, Runs: w.runs, Errors: w.errors}
+	if len(durations) > 0 {
+		stats.P50 = durations[len(durations)/2]
+		stats.P95 = durations[(len(durations)*95)/100]
+		stats.ErrorRate = float64(failed) / float64(len(durations))
+	}
+	return stats
+}
+
+// examples returns the most recent failed runs, or the slowest ones when nothing failed.
+func (w *handlerWindow) examples(limit int) []handlerSample {
+	samples := append([]handlerSample{}, w.samples...)
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Failed != samples[j].Failed {
+			return samples[i].Failed
+		}
+		if samples[i].Failed {
+			return samples[i].At.After(samples[j].At)
+		}
+		return samples[i].Duration > samples[j].Duration
+	})
+	if len(samples) > limit {
+		samples = samples[:limit]
+	}
+	return samples
+}
+
+// RecordHandlerRun adds a run to the handler's metrics and alerts the admins, at most once an
+// hour per handler, when its p95 latency or error rate crosses the thresholds.
+func RecordHandlerRun(bot *tgbotapi.BotAPI, name string, duration time.Duration, err error, detail string) {
+	sample := handlerSample{Duration: duration, Failed: err != nil, Detail: Truncate(detail, 120), At: time.Now()}
+	if err != nil {
+		sample.Detail = Truncate(err.Error(), 120)
+	}
+
+	handlerMetricsMu.Lock()
+	window, ok := handlerMetrics[name]
+	if !ok {
+		window = &handlerWindow{}
+		handlerMetrics[name] = window
+	}
+	window.add(sample)
+	stats := window.stats(name)
+	alert := len(window.samples) >= handlerMinSamples && time.Since(window.lastAlert) > handlerAlertPeriod &&
+		(stats.P95 > slowHandlerThreshold(name) || stats.ErrorRate > handlerErrorRateThreshold())
+	var examples []handlerSample
+	if alert {
+		window.lastAlert = time.Now()
+		examples = window.examples(3)
+	}
+	handlerMetricsMu.Unlock()
+
+	if alert && bot != nil {
+		go AlertAdmins(bot, formatHandlerAlert(stats, examples))
+	}
+}
+
+// RecentHandlerRuns summarizes the runs of a handler since a time, among the last
+// handlerWindowSize: how many there were, how many failed and their average duration.
+func RecentHandlerRuns(name string, since time.Time) (runs, failed int, average time.Duration) {
+	handlerMetricsMu.Lock()
+	defer handlerMetricsMu.Unlock()
+	window, ok := handlerMetrics[name]
+	if !ok {
+		return 0, 0, 0
+	}
+	var total time.Duration
+	for _, sample := range window.samples {
+		if sample.At.Before(since) {
+			continue
+		}
+		runs++
+		total += sample.Duration
+		if sample.Failed {
+			failed++
+		}
+	}
+	if runs > 0 {
+		average = total / time.Duration(runs)
+	}
+	return runs, failed, average
+}
+
+// HandlerMetrics returns the stats of every handler that has run, busiest first.
+func HandlerMetrics() []HandlerStats {
+	handlerMetricsMu.Lock()
+	defer handlerMetricsMu.Unlock()
+	all := make([]HandlerStats, 0, len(handlerMetrics))
+	for name, window := range handlerMetrics {
+		all = append(all, window.stats(name))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Runs > all[j].Runs })
+	return all
+}
+
+func formatHandlerAlert(stats HandlerStats, examples []handlerSample) string {
+	lines := []string{fmt.Sprintf("🐢 <b>Handler %s is unhealthy</b>\np95 %s (limit %s) · p50 %s · errors %.0f%% (limit %.0f%%) over the last %d runs",
+		html.EscapeString(stats.Name), stats.P95.Round(time.Millisecond), slowHandlerThreshold(stats.Name),
+		stats.P50.Round(time.Millisecond), stats.ErrorRate*100, handlerErrorRateThreshold()*100, min(stats.Runs, handlerWindowSize))}
+	for _, example := range examples {
+		status := "slow"
+		if example.Failed {
+			status = "failed"
+		}
+		lines = append(lines, fmt.Sprintf("• %s %s after %s: <i>%s</i>", example.At.UTC().Format("15:04:05"), status,
+			example.Duration.Round(time.Millisecond), html.EscapeString(example.Detail)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// AlertAdmins sends an operational alert to ADMIN_CHAT_ID, or privately to every bot admin
+// when no admin chat is configured.
+func AlertAdmins(bot *tgbotapi.BotAPI, text string) {
+	targets := AdminUserIDs()
+	if chatID, err := strconv.ParseInt(GetenvVar("ADMIN_CHAT_ID", false), 10, 64); err == nil {
+		targets = []int64{chatID}
+	}
+	for _, chatID := range targets {
+		if err := SendHTML(bot, chatID, text); err != nil {
+			log.Printf("Error alerting admin chat %d: %v", chatID, err)
+		}
+	}
+}
+
+// HandleHandlerMetrics serves the handler metrics as JSON on the internal server.
+func HandleHandlerMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HandlerMetrics())
+}
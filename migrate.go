@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Migration upgrades the stores in DataDir from Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Run         func() error
+}
+
+var migrations = []Migration{
+	{Version: 1, Description: "rewrite every store in the current encoding", Run: func() error {
+		for _, store := range persistentStores() {
+			if err := store.Save(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// SchemaVersion is the last migration applied to DataDir, or 0.
+func SchemaVersion() int {
+	value, _ := botConfig.Get("schema_version")
+	version, _ := strconv.Atoi(value)
+	return version
+}
+
+// Migrate applies pending migrations in order. Stores must be open and the bot stopped.
+func Migrate() (int, error) {
+	applied := 0
+	for _, migration := range migrations {
+		if migration.Version <= SchemaVersion() {
+			continue
+		}
+		log.Printf("Applying migration %d: %s", migration.Version, migration.Description)
+		if err := migration.Run(); err != nil {
+			return applied, fmt.Errorf("migration %d failed: %w", migration.Version, err)
+		}
+		if err := botConfig.Set("schema_version", strconv.Itoa(migration.Version)); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+	return applied, nil
+}
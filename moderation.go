@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Kinds of moderation actions taken in groups.
+const (
+	ModerationSpam    = "spam"
+	ModerationRefusal = "refusal"
+	ModerationBan     = "ban"
+)
+
+// moderationDigestSize is how many actions the digest lists, each with its own button.
+const moderationDigestSize = 10
+
+// ModerationAction is something the bot did to a group member: deleting their message, refusing
+// their question or banning them.
+type ModerationAction struct {
+	ChatID    int64  `json:"chat_id"`
+	ChatTitle string `json:"chat_title,omitempty"`
+	Kind      string `json:"kind"`
+	UserID    int64  `json:"user_id"`
+	User      string `json:"user,omitempty"`
+	Rule      string `json:"rule,omitempty"`
+	// Text is the deleted message or refused question, kept only when the group's privacy mode is off
+	Text     string    `json:"text,omitempty"`
+	At       time.Time `json:"at"`
+	Reversed bool      `json:"reversed,omitempty"`
+}
+
+var moderationLog *JSONStore[ModerationAction]
+
+func moderationKey(chatID int64, at time.Time) string {
+	return fmt.Sprintf("%d:%d", chatID, at.UnixNano())
+}
+
+// RecordModeration logs an action for the group admins' daily digest.
+func RecordModeration(action ModerationAction) error {
+	if moderationLog == nil {
+		return nil
+	}
+	if action.At.IsZero() {
+		action.At = time.Now()
+	}
+	action.Text = Truncate(action.Text, 500)
+	if PrivacyEnabled(action.ChatID) {
+		action.Text = ""
+	}
+	return moderationLog.Set(moderationKey(action.ChatID, action.At), action)
+}
+
+// recentModeration counts a group's unreversed actions of a kind against a member since a time.
+func recentModeration(chatID, userID int64, kind string, since time.Time) int {
+	if moderationLog == nil {
+		return 0
+	}
+	count := 0
+	moderationLog.Range(func(_ string, action ModerationAction) bool {
+		if action.ChatID == chatID && action.UserID == userID && action.Kind == kind && !action.Reversed && !action.At.Before(since) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// spamBanThreshold is SPAM_BAN_AFTER: how many spam messages from the same member within a day
+// get them banned. 0, the default, never bans.
+func spamBanThreshold() int {
+	threshold, err := strconv.Atoi(GetenvVar("SPAM_BAN_AFTER", false))
+	if err != nil || threshold < 0 {
+		return 0
+	}
+	return threshold
+}
+
+// BanRepeatSpammer bans the sender of a deleted spam message once they reach SPAM_BAN_AFTER
+// deletions in 24 hours. It reports whether they were banned.
+func BanRepeatSpammer(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	threshold := spamBanThreshold()
+	if threshold == 0 || recentModeration(message.Chat.ID, message.From.ID, ModerationSpam, time.Now().Add(-24*time.Hour)) < threshold {
+		return false
+	}
+	ban := tgbotapi.BanChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: message.Chat.ID, UserID: message.From.ID}}
+	if _, err := bot.Request(ban); err != nil {
+		log.Printf("Error banning repeat spammer %d in chat %d: %v", message.From.ID, message.Chat.ID, err)
+		return false
+	}
+	log.Printf("Banned repeat spammer %d in chat %d", message.From.ID, message.Chat.ID)
+	action := ModerationAction{ChatID: message.Chat.ID, ChatTitle: message.Chat.Title, Kind: ModerationBan, UserID: message.From.ID,
+		User: spamSender(message.From), Rule: fmt.Sprintf("%d spam messages in 24 hours", threshold)}
+	if err := RecordModeration(action); err != nil {
+		log.Printf("Error recording ban: %v", err)
+	}
+	return true
+}
+
+// IsSpamExempt reports whether group admins marked a member's deleted message as not spam.
+func IsSpamExempt(settings ChatSettings, userID int64) bool {
+	for _, id := range settings.SpamExempt {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ModerationSince groups the actions taken since a time by chat, oldest first.
+func ModerationSince(since time.Time) map[int64][]ModerationAction {
+	byChat := map[int64][]ModerationAction{}
+	if moderationLog == nil {
+		return byChat
+	}
+	moderationLog.Range(func(_ string, action ModerationAction) bool {
+		if !action.At.Before(since) {
+			byChat[action.ChatID] = append(byChat[action.ChatID], action)
+		}
+		return true
+	})
+	for _, actions := range byChat {
+		sort.Slice(actions, func(i, j int) bool { return actions[i].At.Before(actions[j].At) })
+	}
+	return byChat
+}
+
+// FormatModerationDigest summarizes a group's actions, listing the latest with a button each to
+// reverse a false positive: "md:<chat>:<nanos>:<ok|unban|report>".
+func FormatModerationDigest(actions []ModerationAction) (string, tgbotapi.InlineKeyboardMarkup) {
+	counts := map[string]int{}
+	for _, action := range actions {
+		counts[action.Kind]++
+	}
+	title := actions[len(actions)-1].ChatTitle
+	if title == "" {
+		title = "your group"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🛡 <b>Moderation in %s</b> · last 24 hours\n", html.EscapeString(title))
+	if n := counts[ModerationSpam]; n > 0 {
+		fmt.Fprintf(&b, "🚫 %s deleted\n", countOf(n, "spam message"))
+	}
+	if n := counts[ModerationRefusal]; n > 0 {
+		fmt.Fprintf(&b, "🙅 %s refused\n", countOf(n, "question"))
+	}
+	if n := counts[ModerationBan]; n > 0 {
+		fmt.Fprintf(&b, "⛔ %s banned\n", countOf(n, "member"))
+	}
+
+	listed := actions
+	if len(listed) > moderationDigestSize {
+		listed = listed[len(listed)-moderationDigestSize:]
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, action := range listed {
+		var line, label, op string
+		switch action.Kind {
+		case ModerationSpam:
+			line, label, op = "🚫 Deleted a message from", "Not spam", "ok"
+		case ModerationRefusal:
+			line, label, op = "🙅 Refused a question from", "Wrong refusal", "report"
+		case ModerationBan:
+			line, label, op = "⛔ Banned", "Unban", "unban"
+		default:
+			continue
+		}
+		fmt.Fprintf(&b, "\n%d. %s UTC %s %s", i+1, action.At.UTC().Format("15:04"), line, html.EscapeString(action.User))
+		if action.Rule != "" {
+			fmt.Fprintf(&b, " <i>(%s)</i>", html.EscapeString(action.Rule))
+		}
+		if action.Reversed {
+			b.WriteString(" ↩️ reversed")
+		}
+		if action.Text != "" {
+			fmt.Fprintf(&b, "\n<blockquote>%s</blockquote>", html.EscapeString(Truncate(action.Text, 200)))
+		}
+		if action.Reversed {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("↩️ %d. %s", i+1, label), fmt.Sprintf("md:%s:%s", moderationKey(action.ChatID, action.At), op))))
+	}
+	if hidden := len(actions) - len(listed); hidden > 0 {
+		fmt.Fprintf(&b, "\n\n…and %d earlier.", hidden)
+	}
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ChatAdminIDs lists the human admins of a group.
+func ChatAdminIDs(bot *tgbotapi.BotAPI, chatID int64) ([]int64, error) {
+	admins, err := bot.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, admin := range admins {
+		if admin.User != nil && !admin.User.IsBot {
+			ids = append(ids, admin.User.ID)
+		}
+	}
+	return ids, nil
+}
+
+// SendModerationDigests DMs the admins of every group the bot moderated in the last day.
+func SendModerationDigests(bot *tgbotapi.BotAPI, now time.Time) {
+	for chatID, actions := range ModerationSince(now.Add(-24 * time.Hour)) {
+		admins, err := ChatAdminIDs(bot, chatID)
+		if err != nil {
+			log.Printf("Error listing admins of chat %d: %v", chatID, err)
+			continue
+		}
+		text, markup := FormatModerationDigest(actions)
+		for _, adminID := range admins {
+			msg := tgbotapi.NewMessage(adminID, text)
+			msg.ParseMode = tgbotapi.ModeHTML
+			msg.DisableNotification = InQuietHours(adminID, now)
+			if len(markup.InlineKeyboard) > 0 {
+				msg.ReplyMarkup = markup
+			}
+			if _, err := bot.Send(msg); err != nil {
+				log.Printf("Error sending moderation digest of chat %d to admin %d: %v", chatID, adminID, err)
+			}
+		}
+	}
+}
+
+// ScheduleModerationDigests sends the digests daily at MODERATION_DIGEST_HOUR (UTC, default 9).
+func ScheduleModerationDigests(bot *tgbotapi.BotAPI) {
+	hour, err := strconv.Atoi(GetenvVar("MODERATION_DIGEST_HOUR", false))
+	if err != nil || hour < 0 || hour > 23 {
+		hour = 9
+	}
+
+	ScheduleJob(Job{
+		Name:     "moderation_digests",
+		Schedule: DailyAt(hour),
+		Run: func(now time.Time) error {
+			SendModerationDigests(bot, now)
+			return nil
+		},
+	})
+}
+
+// HandleModerationCallback reverses a false positive from the digest ("md:<chat>:<nanos>:<op>"):
+// "ok" exempts the member from the spam filter and reposts their message when it was kept, "unban"
+// lifts a ban and "report" tells the bot admins a question was wrongly refused. Only admins of the
+// group can reverse its actions.
+func HandleModerationCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 3 || query.Message == nil || moderationLog == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	key := args[0] + ":" + args[1]
+	action, ok := moderationLog.Get(key)
+	if !ok {
+		return AnswerCallback(bot, query, "This action is too old to reverse.")
+	}
+	if action.Reversed {
+		return AnswerCallback(bot, query, "Already reversed.")
+	}
+	if !IsChatAdmin(bot, &tgbotapi.Chat{ID: chatID}, query.From.ID) {
+		return AnswerCallback(bot, query, "Only admins of the group can reverse this.")
+	}
+
+	var toast string
+	switch args[2] {
+	case "ok":
+		err = UpdateChatSettings(chatID, func(settings *ChatSettings) {
+			if !IsSpamExempt(*settings, action.UserID) {
+				settings.SpamExempt = append(append([]int64{}, settings.SpamExempt...), action.UserID)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		toast = "Done, the spam filter will ignore " + action.User + " in this group."
+		if action.Text != "" {
+			restored := fmt.Sprintf("↩️ A message from %s was removed by mistake:\n<blockquote>%s</blockquote>",
+				html.EscapeString(action.User), html.EscapeString(action.Text))
+			if err := SendHTML(bot, chatID, restored); err != nil {
+				log.Printf("Error restoring message in chat %d: %v", chatID, err)
+			} else {
+				toast += " I reposted their message."
+			}
+		}
+	case "unban":
+		unban := tgbotapi.UnbanChatMemberConfig{ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: action.UserID}, OnlyIfBanned: true}
+		if _, err := bot.Request(unban); err != nil {
+			log.Printf("Error unbanning %d in chat %d: %v", action.UserID, chatID, err)
+			return AnswerCallback(bot, query, "I couldn't unban them, am I still an admin with permission to ban members?")
+		}
+		toast = "Unbanned " + action.User + ", they can rejoin the group."
+	case "report":
+		report := fmt.Sprintf("🙅 <b>Wrong refusal reported</b> by an admin of %s\nCategory: %s",
+			html.EscapeString(action.ChatTitle), html.EscapeString(action.Rule))
+		if action.Text != "" {
+			report += "\n<blockquote>" + html.EscapeString(action.Text) + "</blockquote>"
+		}
+		AlertAdmins(bot, report)
+		toast = "Thanks, I told the bot's maintainers so they can fix the rule."
+	default:
+		return AnswerCallback(bot, query, "")
+	}
+
+	if err := moderationLog.Update(key, func(action ModerationAction) ModerationAction {
+		action.Reversed = true
+		return action
+	}); err != nil {
+		return err
+	}
+	if markup := query.Message.ReplyMarkup; markup != nil {
+		remaining := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+		for _, row := range markup.InlineKeyboard {
+			if row[0].CallbackData == nil || *row[0].CallbackData != query.Data {
+				remaining.InlineKeyboard = append(remaining.InlineKeyboard, row)
+			}
+		}
+		edit := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, remaining)
+		if _, err := bot.Request(edit); err != nil {
+			log.Printf("Error updating moderation digest buttons: %v", err)
+		}
+	}
+	return AnswerCallback(bot, query, toast)
+}
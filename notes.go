@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxNoteChars    = 500
+	maxNotesPerUser = 100
+	// notesShownWithAnswer is how many of the newest notes accompany an answer or a logged dose
+	notesShownWithAnswer = 3
+)
+
+// SubstanceNote is a private note a user attached to a substance.
+type SubstanceNote struct {
+	Substance string    `json:"substance"`
+	Text      string    `json:"text"`
+	At        time.Time `json:"at"`
+}
+
+// substanceNotes holds each user's notes, sealed with AES-GCM, by ChatKey. Nothing about them,
+// not even which substances they are about, is readable from the store file or its backups.
+var substanceNotes *JSONStore[string]
+
+var (
+	notesKeyOnce sync.Once
+	notesAEAD    cipher.AEAD
+	notesKeyErr  error
+)
+
+// notesCipher is AES-256-GCM with NOTES_ENCRYPTION_KEY, 32 base64-encoded bytes. Without it a
+// key is generated in <DATA_DIR>/notes.key, which backups leave out: set the variable in
+// production, or notes can't be read after restoring a backup elsewhere.
+func notesCipher() (cipher.AEAD, error) {
+	notesKeyOnce.Do(func() {
+		var key []byte
+		if encoded := GetenvVar("NOTES_ENCRYPTION_KEY", false); encoded != "" {
+			key, notesKeyErr = base64.StdEncoding.DecodeString(encoded)
+			if notesKeyErr != nil || len(key) != 32 {
+				notesKeyErr = fmt.Errorf("NOTES_ENCRYPTION_KEY must be 32 base64-encoded bytes")
+				return
+			}
+		} else if key, notesKeyErr = generatedNotesKey(); notesKeyErr != nil {
+			return
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			notesKeyErr = err
+			return
+		}
+		notesAEAD, notesKeyErr = cipher.NewGCM(block)
+	})
+	return notesAEAD, notesKeyErr
+}
+
+func generatedNotesKey() ([]byte, error) {
+	path := filepath.Join(DataDir(), "notes.key")
+	if encoded, err := os.ReadFile(path); err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(DataDir(), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+		return nil, fmt.Errorf("error writing notes key: %w", err)
+	}
+	log.Printf("Generated a notes encryption key in %s; set NOTES_ENCRYPTION_KEY to keep notes readable across restores", path)
+	return key, nil
+}
+
+// UserNotes decrypts the user's notes, oldest first.
+func UserNotes(userID int64) ([]SubstanceNote, error) {
+	if substanceNotes == nil {
+		return nil, nil
+	}
+	sealed, ok := substanceNotes.Get(ChatKey(userID))
+	if !ok {
+		return nil, nil
+	}
+	aead, err := notesCipher()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return nil, errors.New("corrupt notes")
+	}
+	// The user key is authenticated too, so sealed notes can't be moved to another user
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(ChatKey(userID)))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting notes: %w", err)
+	}
+	var notes []SubstanceNote
+	if err := json.Unmarshal(plain, &notes); err != nil {
+		return nil, fmt.Errorf("error decoding notes: %w", err)
+	}
+	return notes, nil
+}
+
+// saveNotes encrypts and stores the user's notes, deleting the entry when there are none left.
+func saveNotes(userID int64, notes []SubstanceNote) error {
+	if len(notes) == 0 {
+		return substanceNotes.Delete(ChatKey(userID))
+	}
+	aead, err := notesCipher()
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(ChatKey(userID)))
+	return substanceNotes.Set(ChatKey(userID), base64.StdEncoding.EncodeToString(sealed))
+}
+
+// NotesFor returns the user's notes on a substance key, newest first.
+func NotesFor(userID int64, key string) []SubstanceNote {
+	notes, err := UserNotes(userID)
+	if err != nil {
+		log.Printf("Error reading notes: %v", err)
+		return nil
+	}
+	var matching []SubstanceNote
+	for i := len(notes) - 1; i >= 0; i-- {
+		if notes[i].Substance == key {
+			matching = append(matching, notes[i])
+		}
+	}
+	return matching
+}
+
+// NotesReminder shows the newest notes of the user on the substances, or "" when there are none.
+func NotesReminder(userID int64, keys []string, location *time.Location) string {
+	var lines []string
+	for _, key := range keys {
+		notes := NotesFor(userID, key)
+		for i, note := range notes {
+			if i == notesShownWithAnswer {
+				lines = append(lines, fmt.Sprintf("…and %d more, see /note %s", len(notes)-i, key))
+				break
+			}
+			lines = append(lines, fmt.Sprintf("• <b>%s</b> (%s): %s", html.EscapeString(substanceName(key)),
+				note.At.In(location).Format("Jan 2"), html.EscapeString(note.Text)))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n\n📝 <b>Your notes</b>\n" + strings.Join(lines, "\n")
+}
+
+func substanceName(key string) string {
+	if substance, ok := substances[key]; ok {
+		return substance.Name
+	}
+	return key
+}
+
+const noteUsage = "Usage:\n/note &lt;substance&gt; &lt;text&gt; — add a private note, e.g. /note mdma 80mg was too much last time\n" +
+	"/note — list your notes\n/note &lt;substance&gt; — your notes on a substance\n/note delete &lt;number&gt;\n/note clear"
+
+// HandleNoteCommand adds, lists and deletes the user's private substance notes. They're shown
+// again when the user asks about or logs the substance.
+func HandleNoteCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	userID := update.Message.From.ID
+	notes, err := UserNotes(userID)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		return SendHTML(bot, chatID, FormatNotes(notes, "", UserLocation(userID)))
+	case fields[0] == "clear" && len(fields) == 1:
+		if err := saveNotes(userID, nil); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "🗑 All your notes are deleted.")
+	case fields[0] == "delete" && len(fields) == 2:
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n > len(notes) {
+			return SendHTML(bot, chatID, "There's no note with that number, see /note.")
+		}
+		notes = append(append([]SubstanceNote{}, notes[:n-1]...), notes[n:]...)
+		if err := saveNotes(userID, notes); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "🗑 Note deleted.")
+	}
+
+	key, _, ok := LookupSubstance(fields[0])
+	if !ok {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't know the substance <b>%s</b>.\n\n%s", html.EscapeString(fields[0]), noteUsage))
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args), fields[0]))
+	if text == "" {
+		return SendHTML(bot, chatID, FormatNotes(notes, key, UserLocation(userID)))
+	}
+	if utf8.RuneCountInString(text) > maxNoteChars {
+		return SendHTML(bot, chatID, fmt.Sprintf("Notes can be up to %d characters.", maxNoteChars))
+	}
+	if len(notes) >= maxNotesPerUser {
+		return SendHTML(bot, chatID, fmt.Sprintf("You have %d notes, the most I keep. Delete some with /note delete &lt;number&gt;.", len(notes)))
+	}
+	notes = append(append([]SubstanceNote{}, notes...), SubstanceNote{Substance: key, Text: text, At: time.Now()})
+	if err := saveNotes(userID, notes); err != nil {
+		return err
+	}
+	return SendHTML(bot, chatID, fmt.Sprintf("📝 Noted for <b>%s</b>. I'll show it when you ask about or log it.", html.EscapeString(substanceName(key))))
+}
+
+// FormatNotes lists the notes numbered for /note delete, only those on key when it isn't "".
+func FormatNotes(notes []SubstanceNote, key string, location *time.Location) string {
+	var lines []string
+	for i, note := range notes {
+		if key != "" && note.Substance != key {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%d. <b>%s</b> (%s): %s", i+1, html.EscapeString(substanceName(note.Substance)),
+			note.At.In(location).Format("Jan 2, 2006"), html.EscapeString(note.Text)))
+	}
+	if len(lines) == 0 {
+		return "No notes yet.\n\n" + noteUsage
+	}
+	return "📝 <b>Your notes</b>\n" + strings.Join(lines, "\n") + "\n\n<i>Only you can see these. /note delete &lt;number&gt; removes one.</i>"
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// onboardingLanguages are the answer languages offered by /start, keyed by language code.
+var onboardingLanguages = []struct{ Code, Name string }{
+	{"en", "English"},
+	{"de", "Deutsch"},
+	{"es", "Español"},
+	{"fr", "Français"},
+	{"pt", "Português"},
+	{"ru", "Русский"},
+}
+
+// onboardingZones are the time zones offered as buttons; anything else goes through /timezone.
+var onboardingZones = []string{"Europe/London", "Europe/Berlin", "America/New_York", "America/Los_Angeles", "Australia/Sydney"}
+
+var onboardingUnits = []string{"mg", "µg", "g"}
+
+// LanguageName returns the display name of a language code, or the code itself.
+func LanguageName(code string) string {
+	for _, language := range onboardingLanguages {
+		if language.Code == code {
+			return language.Name
+		}
+	}
+	return code
+}
+
+// HandleStartCommand runs the onboarding wizard in private chats. Groups get the deployment's
+// START_TEXT, since settings chosen there would only apply to whoever tapped the buttons. A
+// deep link payload (t.me/<bot>?start=mdma_info) goes to its flow instead.
+func HandleStartCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, payload string) error {
+	if strings.TrimSpace(payload) != "" {
+		if handled, err := HandleDeepLink(bot, update, payload); handled {
+			return err
+		}
+	}
+	if !update.Message.Chat.IsPrivate() || update.Message.From == nil {
+		return SendGroupIntro(bot, update.Message.Chat.ID)
+	}
+
+	if err := TouchUserProfile(update.Message.From); err != nil {
+		log.Printf("Error saving user profile: %v", err)
+	}
+	text, markup := OnboardingStep("lang", GetUserSettings(update.Message.From.ID))
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = markup
+	_, err := bot.Send(msg)
+	return err
+}
+
+// SendGroupIntro introduces the bot to a group in the group's language.
+func SendGroupIntro(bot *tgbotapi.BotAPI, chatID int64) error {
+	if language := ChatLanguage(chatID); language != "" && language != "en" {
+		return SendHTML(bot, chatID, html.EscapeString(Localized("group_intro", language)+"\n\n"+Localized("disclaimer", language)))
+	}
+	msg := tgbotapi.NewMessage(chatID, GetenvVar("START_TEXT", true))
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	_, err := bot.Send(msg)
+	return err
+}
+
+// OnboardingStep renders one step of the wizard. Buttons carry "ob:<step>:<choice>" and the
+// callback saves the choice and moves on to the next step.
+func OnboardingStep(step string, settings UserSettings) (string, *tgbotapi.InlineKeyboardMarkup) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var text string
+
+	switch step {
+	case "lang":
+		text = "👋 <b>Welcome to PsyAI</b>, a harm reduction assistant.\n\n<b>1/5</b> Which language should I answer in?"
+		var row []tgbotapi.InlineKeyboardButton
+		for _, language := range onboardingLanguages {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(language.Name, "ob:lang:"+language.Code))
+			if len(row) == 3 {
+				rows, row = append(rows, row), nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	case "disclaimer":
+		text = "<b>2/5 Before we start</b>\n\n" + html.EscapeString(Localized("disclaimer", settings.Language))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✅ I understand", "ob:disclaimer:ok")))
+	case "consent":
+		text = "<b>3/5 Your data</b>\n\n" + ConsentText()
+		return text, ConsentKeyboard("ob:consent")
+	case "units":
+		text = "<b>4/5</b> Which unit do you usually dose in? /log uses it when you leave the unit out."
+		var row []tgbotapi.InlineKeyboardButton
+		for _, unit := range onboardingUnits {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(unit, "ob:units:"+unit))
+		}
+		rows = append(rows, row)
+	case "tz":
+		text = "<b>4/5</b> Which time zone are you in? It's used for the times in your dose log.\n\n" +
+			"Not listed? Skip and use /timezone Region/City or share your location later."
+		for _, zone := range onboardingZones {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(zone, "ob:tz:"+zone)))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("UTC / skip", "ob:tz:")))
+	default:
+		zone := settings.TimeZone
+		if zone == "" {
+			zone = "UTC"
+		}
+		unit := settings.DoseUnit
+		if unit == "" {
+			unit = "not set"
+		}
+		text = fmt.Sprintf("<b>5/5 You're set up</b>\nLanguage: %s · Unit: %s · Time zone: %s\n\n"+
+			"Just send me a question, or try:\n"+
+			"/info &lt;substance&gt; — factsheet and dosing\n"+
+			"/log &lt;substance&gt; &lt;amount&gt; — log a dose and check interactions\n"+
+			"/history — your logged doses\n"+
+			"/tolerance &lt;substance&gt; — tolerance after a break\n"+
+			"/saved — answers you bookmarked\n"+
+			"/consent — what I store about you\n"+
+			"/menu — quick actions keyboard\n\n"+
+			"Run /start again any time to change these.",
+			html.EscapeString(LanguageName(settings.Language)), html.EscapeString(unit), html.EscapeString(zone))
+		return text, nil
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return text, &markup
+}
+
+// HandleOnboardingCallback saves a wizard choice ("ob:<step>:<choice>") and shows the next step.
+func HandleOnboardingCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) < 2 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	step, choice := args[0], args[1]
+
+	var next string
+	var change func(settings *UserSettings)
+	switch step {
+	case "lang":
+		next = "disclaimer"
+		change = func(settings *UserSettings) { settings.Language = choice }
+	case "disclaimer":
+		next = "consent"
+		change = func(settings *UserSettings) { settings.DisclaimerAcceptedAt = time.Now() }
+	case "consent":
+		if choice == "yes" {
+			if err := RecordConsent(query.From, true); err != nil {
+				return err
+			}
+		}
+		next = "units"
+		change = func(*UserSettings) {}
+	case "units":
+		next = "tz"
+		change = func(settings *UserSettings) { settings.DoseUnit = choice }
+	case "tz":
+		if choice != "" {
+			if _, err := time.LoadLocation(choice); err != nil {
+				return AnswerCallback(bot, query, "Unknown time zone.")
+			}
+		}
+		next = "done"
+		change = func(settings *UserSettings) { settings.TimeZone = choice }
+	default:
+		return AnswerCallback(bot, query, "")
+	}
+
+	if err := UpdateUserSettings(query.From.ID, change); err != nil {
+		return err
+	}
+	text, markup := OnboardingStep(next, GetUserSettings(query.From.ID))
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, &LinkPreviewOptions{IsDisabled: true}, markup); err != nil {
+		return err
+	}
+	// An edited message can't carry a reply keyboard, so the quick actions come separately
+	if next == "done" && QuickActionsShown(query.From.ID) {
+		if err := SendQuickActions(bot, query.Message.Chat.ID); err != nil {
+			log.Printf("Error sending quick actions: %v", err)
+		}
+	}
+	return AnswerCallback(bot, query, "")
+}
+
+// LanguageInstruction is appended to the system prompt when answers should be in a language
+// other than English.
+func LanguageInstruction(code string) string {
+	if code == "" || code == "en" {
+		return ""
+	}
+	return "\n\nAlways answer in " + LanguageName(code) + ", whatever language the question is in."
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// openaiProvider talks to the OpenAI API directly. Questions are checked with its moderation
+// endpoint first, standing in for the PsyAI API's own moderation, unless OPENAI_MODERATION=off.
+type openaiProvider struct {
+	baseURL        string
+	apiKey         string
+	model          string
+	embeddingModel string
+	moderate       bool
+}
+
+// newOpenAIProvider reads OPENAI_API_KEY, OPENAI_MODEL (default gpt-4o-mini),
+// OPENAI_EMBEDDING_MODEL (default text-embedding-3-small) and OPENAI_BASE_URL, which points the
+// provider at a compatible API.
+func newOpenAIProvider() openaiProvider {
+	p := openaiProvider{
+		baseURL:        strings.TrimRight(GetenvVar("OPENAI_BASE_URL", false), "/"),
+		apiKey:         GetenvVar("OPENAI_API_KEY", false),
+		model:          GetenvVar("OPENAI_MODEL", false),
+		embeddingModel: GetenvVar("OPENAI_EMBEDDING_MODEL", false),
+		moderate:       GetenvVar("OPENAI_MODERATION", false) != "off",
+	}
+	if p.baseURL == "" {
+		p.baseURL = "https://api.openai.com/v1"
+	}
+	if p.model == "" {
+		p.model = "gpt-4o-mini"
+	}
+	if p.embeddingModel == "" {
+		p.embeddingModel = "text-embedding-3-small"
+	}
+	return p
+}
+
+func (p openaiProvider) Name() string { return ProviderOpenAI }
+
+func (p openaiProvider) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + p.apiKey}
+}
+
+type openaiChatRequest struct {
+	Model       string           `json:"model"`
+	Messages    []HistoryMessage `json:"messages"`
+	Temperature float64          `json:"temperature"`
+	MaxTokens   int              `json:"max_tokens,omitempty"`
+	Stream      bool             `json:"stream,omitempty"`
+}
+
+func (p openaiProvider) chatRequest(request PromptRequest, stream bool) openaiChatRequest {
+	messages := chatMessages(request)
+	if request.SystemPrompt != "" {
+		messages = append([]HistoryMessage{{Role: "system", Content: request.SystemPrompt}}, messages...)
+	}
+	return openaiChatRequest{Model: p.model, Messages: messages, Temperature: request.Temperature, MaxTokens: request.Tokens, Stream: stream}
+}
+
+// flagged moderates the question when moderation is on, returning the verdict if it was flagged.
+func (p openaiProvider) flagged(ctx context.Context, request PromptRequest) (*Moderation, error) {
+	if !p.moderate {
+		return nil, nil
+	}
+	moderation, err := p.Moderate(ctx, request.Question)
+	if err != nil || !moderation.Flagged {
+		return nil, err
+	}
+	return moderation, nil
+}
+
+func (p openaiProvider) Ask(ctx context.Context, request PromptRequest) (*PromptResponse, error) {
+	if moderation, err := p.flagged(ctx, request); err != nil || moderation != nil {
+		return &PromptResponse{Moderation: moderation}, err
+	}
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+				Refusal string `json:"refusal"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postProviderJSON(ctx, p.baseURL+"/chat/completions", p.headers(), p.chatRequest(request, false), &result); err != nil {
+		return nil, err
+	}
+	if len(result.Choices) == 0 {
+		return nil, &SchemaError{Reason: "no choices in chat completion"}
+	}
+	message := result.Choices[0].Message
+	response := &PromptResponse{Assistant: message.Content, Refusal: message.Refusal}
+	if err := response.Validate(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (p openaiProvider) Stream(ctx context.Context, request PromptRequest, onDelta func(answer string), onStatus func(status string)) (*PromptResponse, error) {
+	if moderation, err := p.flagged(ctx, request); err != nil || moderation != nil {
+		return &PromptResponse{Moderation: moderation}, err
+	}
+	return streamProvider(ctx, p.baseURL+"/chat/completions", p.headers(), p.chatRequest(request, true), onDelta,
+		func(data string) (string, bool, error) {
+			if data == "[DONE]" {
+				return "", true, nil
+			}
+			var chunk struct {
+				Choices []struct {
+					Delta struct {
+						Content string `json:"content"`
+					} `json:"delta"`
+				} `json:"choices"`
+				Error *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return "", false, &SchemaError{Reason: "stream event: " + err.Error()}
+			}
+			if chunk.Error != nil {
+				return "", false, &BackendError{Status: 200, Message: chunk.Error.Message}
+			}
+			if len(chunk.Choices) == 0 {
+				return "", false, nil
+			}
+			return chunk.Choices[0].Delta.Content, false, nil
+		})
+}
+
+func (p openaiProvider) Moderate(ctx context.Context, text string) (*Moderation, error) {
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	body := map[string]string{"model": "omni-moderation-latest", "input": text}
+	if err := postProviderJSON(ctx, p.baseURL+"/moderations", p.headers(), body, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Results) == 0 {
+		return nil, &SchemaError{Reason: "no moderation results"}
+	}
+	moderation := &Moderation{Flagged: result.Results[0].Flagged}
+	for category, flagged := range result.Results[0].Categories {
+		if flagged {
+			moderation.Categories = append(moderation.Categories, category)
+		}
+	}
+	sort.Strings(moderation.Categories)
+	return moderation, nil
+}
+
+func (p openaiProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	body := map[string]interface{}{"model": p.embeddingModel, "input": texts}
+	if err := postProviderJSON(ctx, p.baseURL+"/embeddings", p.headers(), body, &result); err != nil {
+		return nil, err
+	}
+	embeddings := make([][]float64, len(texts))
+	for _, item := range result.Data {
+		if item.Index >= 0 && item.Index < len(embeddings) {
+			embeddings[item.Index] = item.Embedding
+		}
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// StarsCurrency is Telegram Stars, which need no payment provider.
+const StarsCurrency = "XTR"
+
+// Entitlement is a user's supporter status, extended by each payment.
+type Entitlement struct {
+	Until    time.Time `json:"until"`
+	Payments []Payment `json:"payments"`
+}
+
+// Payment is a successful invoice payment, kept for refunds and support requests.
+type Payment struct {
+	ChargeID string    `json:"charge_id"`
+	Currency string    `json:"currency"`
+	Amount   int       `json:"amount"`
+	At       time.Time `json:"at"`
+}
+
+var entitlements *JSONStore[Entitlement]
+
+// SupporterOffer is the invoice configured through the environment: SUPPORTER_PRICE in the
+// currency's smallest unit (whole Stars by default), PAYMENT_CURRENCY, PAYMENT_PROVIDER_TOKEN
+// for currencies other than Stars, and SUPPORTER_DAYS of supporter status per payment.
+type SupporterOffer struct {
+	Price         int
+	Currency      string
+	ProviderToken string
+	Days          int
+}
+
+// SupporterOfferFromEnv returns the configured offer, or false when payments are disabled.
+func SupporterOfferFromEnv() (SupporterOffer, bool) {
+	price, err := strconv.Atoi(GetenvVar("SUPPORTER_PRICE", false))
+	if err != nil || price <= 0 {
+		return SupporterOffer{}, false
+	}
+	offer := SupporterOffer{
+		Price:         price,
+		Currency:      strings.ToUpper(GetenvVar("PAYMENT_CURRENCY", false)),
+		ProviderToken: GetenvVar("PAYMENT_PROVIDER_TOKEN", false),
+		Days:          30,
+	}
+	if offer.Currency == "" {
+		offer.Currency = StarsCurrency
+	}
+	if offer.Currency != StarsCurrency && offer.ProviderToken == "" {
+		log.Printf("SUPPORTER_PRICE is set but PAYMENT_PROVIDER_TOKEN is missing for %s", offer.Currency)
+		return SupporterOffer{}, false
+	}
+	if days, err := strconv.Atoi(GetenvVar("SUPPORTER_DAYS", false)); err == nil && days > 0 {
+		offer.Days = days
+	}
+	return offer, true
+}
+
+// IsSupporter reports whether the user has an active supporter entitlement.
+func IsSupporter(userID int64) bool {
+	if entitlements == nil || userID == 0 {
+		return false
+	}
+	entitlement, ok := entitlements.Get(ChatKey(userID))
+	return ok && entitlement.Until.After(time.Now())
+}
+
+// DailyQuestionLimit is how many questions the user may ask per UTC day, 0 meaning unlimited.
+// DAILY_QUESTION_LIMIT applies to everyone; supporters get SUPPORTER_QUESTION_LIMIT instead.
+func DailyQuestionLimit(userID int64) int {
+	name := "DAILY_QUESTION_LIMIT"
+	if IsSupporter(userID) {
+		name = "SUPPORTER_QUESTION_LIMIT"
+	}
+	limit, err := strconv.Atoi(GetenvVar(name, false))
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// QuestionsToday counts the user's questions since UTC midnight from the stats aggregate.
+func QuestionsToday(userID int64) int {
+	if dailyStats == nil {
+		return 0
+	}
+	day, _ := dailyStats.Get(statsDay(time.Now()))
+	return day.Users[HashUserID(userID)]
+}
+
+// OverQuota reports whether the user used up today's questions.
+func OverQuota(userID int64) bool {
+	if userID == 0 {
+		return false
+	}
+	limit := DailyQuestionLimit(userID)
+	return limit > 0 && QuestionsToday(userID) >= limit
+}
+
+// QuotaMessage explains the limit and, when payments are on, how to raise it.
+func QuotaMessage(userID int64) string {
+	text := fmt.Sprintf("You've reached today's limit of %d questions. It resets at midnight UTC.", DailyQuestionLimit(userID))
+	if _, ok := SupporterOfferFromEnv(); ok && !IsSupporter(userID) {
+		text += " Supporters get more questions and streamed answers, see /premium."
+	}
+	return text
+}
+
+func supporterPayload(userID int64, offer SupporterOffer) string {
+	return fmt.Sprintf("supporter:%d:%d:%s", userID, offer.Price, offer.Currency)
+}
+
+// HandlePremiumCommand sends the supporter invoice.
+func HandlePremiumCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	offer, ok := SupporterOfferFromEnv()
+	if !ok {
+		return SendHTML(bot, chatID, "Supporting PsyAI isn't available on this deployment.")
+	}
+	if update.Message.From == nil {
+		return nil
+	}
+	userID := update.Message.From.ID
+
+	description := fmt.Sprintf("%d days of higher question limits and streamed answers. Thank you for keeping PsyAI running!", offer.Days)
+	if IsSupporter(userID) {
+		entitlement, _ := entitlements.Get(ChatKey(userID))
+		description = fmt.Sprintf("You're a supporter until %s. Paying again adds %d days.",
+			entitlement.Until.In(UserLocation(userID)).Format("2006-01-02"), offer.Days)
+	}
+	invoice := tgbotapi.NewInvoice(chatID, "PsyAI supporter", description, supporterPayload(userID, offer),
+		offer.ProviderToken, "", offer.Currency, []tgbotapi.LabeledPrice{{Label: "Supporter", Amount: offer.Price}})
+	invoice.SuggestedTipAmounts = []int{}
+	_, err := bot.Send(invoice)
+	return err
+}
+
+// HandlePreCheckoutQuery confirms a checkout if the invoice still matches the current offer.
+func HandlePreCheckoutQuery(bot *tgbotapi.BotAPI, query *tgbotapi.PreCheckoutQuery) error {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+	offer, ok := SupporterOfferFromEnv()
+	if !ok || query.InvoicePayload != supporterPayload(query.From.ID, offer) ||
+		query.TotalAmount != offer.Price || query.Currency != offer.Currency {
+		answer.OK = false
+		answer.ErrorMessage = "This offer has changed. Please send /premium again for a new invoice."
+	}
+	_, err := bot.Request(answer)
+	return err
+}
+
+// HandleSuccessfulPayment records a payment and extends the payer's supporter status.
+func HandleSuccessfulPayment(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
+	message := update.Message
+	payment := message.SuccessfulPayment
+	if message.From == nil {
+		return nil
+	}
+	offer, _ := SupporterOfferFromEnv()
+	days := offer.Days
+	if days == 0 {
+		days = 30
+	}
+
+	var until time.Time
+	err := entitlements.Update(ChatKey(message.From.ID), func(entitlement Entitlement) Entitlement {
+		start := time.Now()
+		if entitlement.Until.After(start) {
+			start = entitlement.Until
+		}
+		entitlement.Until = start.AddDate(0, 0, days)
+		entitlement.Payments = append(append([]Payment{}, entitlement.Payments...), Payment{
+			ChargeID: payment.TelegramPaymentChargeID,
+			Currency: payment.Currency,
+			Amount:   payment.TotalAmount,
+			At:       time.Now(),
+		})
+		until = entitlement.Until
+		return entitlement
+	})
+	if err != nil {
+		return err
+	}
+	if err := TouchUserProfile(message.From); err != nil {
+		log.Printf("Error updating the tier of user %d: %v", message.From.ID, err)
+	}
+	_, err = SendTypedHTML(bot, message.Chat.ID, fmt.Sprintf("💚 Thank you! You're a supporter until %s.",
+		until.In(UserLocation(message.From.ID)).Format("2006-01-02")), MessageSupporterThanks)
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"html"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const personaKey = "persona"
+
+// botConfig holds runtime-editable deployment settings that override the environment.
+var botConfig *JSONStore[string]
+
+// SystemPrompt returns the persona sent with every backend request: the admin override
+// if one was set with /persona, otherwise the base64-encoded SYSTEM_PROMPT variable.
+func SystemPrompt() string {
+	if botConfig != nil {
+		if persona, ok := botConfig.Get(personaKey); ok {
+			return persona
+		}
+	}
+	return GetenvVar("SYSTEM_PROMPT", true)
+}
+
+func HandlePersonaCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	subcommand, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	switch subcommand {
+	case "", "show":
+		persona := SystemPrompt()
+		if persona == "" {
+			return SendHTML(bot, chatID, "No persona configured. Set one with /persona set &lt;text&gt;")
+		}
+		return SendHTML(bot, chatID, "<b>Current persona</b>\n<pre>"+html.EscapeString(persona)+"</pre>")
+	case "set":
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return SendHTML(bot, chatID, "Usage: /persona set &lt;text&gt;")
+		}
+		if err := botConfig.Set(personaKey, text); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "Persona updated. It applies to the next question.")
+	case "reset":
+		if err := botConfig.Delete(personaKey); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "Persona reset to the deployment default.")
+	default:
+		return SendHTML(bot, chatID, "Usage: /persona show|set &lt;text&gt;|reset")
+	}
+}
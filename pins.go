@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// PinnedAlert is an alert message the bot pinned in a group, unpinned once it expires.
+type PinnedAlert struct {
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	AlertID   string    `json:"alert_id"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	Expires   time.Time `json:"expires"`
+}
+
+// pinnedAlerts tracks the pinned alerts by "<chat>:<message>".
+var pinnedAlerts *JSONStore[PinnedAlert]
+
+func pinnedAlertKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// alertPinDuration is how long pushed alerts stay pinned, from ALERT_PIN_HOURS (default 72).
+func alertPinDuration() time.Duration {
+	hours, err := strconv.Atoi(GetenvVar("ALERT_PIN_HOURS", false))
+	if err != nil || hours < 1 {
+		hours = 72
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func canPinMessages(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: bot.Self.ID},
+	})
+	if err != nil {
+		return false
+	}
+	return member.IsCreator() || (member.IsAdministrator() && member.CanPinMessages)
+}
+
+// PinAlert pins a pushed alert and remembers it so it is unpinned once it expires.
+func PinAlert(bot *tgbotapi.BotAPI, chatID int64, messageID int, alertID string) error {
+	pin := tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: messageID, DisableNotification: true}
+	if _, err := bot.Request(pin); err != nil {
+		return fmt.Errorf("error pinning alert: %w", err)
+	}
+	now := time.Now()
+	return pinnedAlerts.Set(pinnedAlertKey(chatID, messageID), PinnedAlert{
+		ChatID:    chatID,
+		MessageID: messageID,
+		AlertID:   alertID,
+		PinnedAt:  now,
+		Expires:   now.Add(alertPinDuration()),
+	})
+}
+
+// PinnedAlertCount returns how many alerts are pinned in a chat.
+func PinnedAlertCount(chatID int64) int {
+	if pinnedAlerts == nil {
+		return 0
+	}
+	count := 0
+	pinnedAlerts.Range(func(_ string, pinned PinnedAlert) bool {
+		if pinned.ChatID == chatID {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// UnpinExpiredAlerts unpins the alerts past their expiry. Alerts that can't be unpinned, because
+// the message is gone or the bot lost its rights, are forgotten all the same.
+func UnpinExpiredAlerts(bot *tgbotapi.BotAPI, now time.Time) {
+	var expired []PinnedAlert
+	pinnedAlerts.Range(func(_ string, pinned PinnedAlert) bool {
+		if now.After(pinned.Expires) {
+			expired = append(expired, pinned)
+		}
+		return true
+	})
+	for _, pinned := range expired {
+		unpin := tgbotapi.UnpinChatMessageConfig{ChatID: pinned.ChatID, MessageID: pinned.MessageID}
+		if _, err := bot.Request(unpin); err != nil {
+			log.Printf("Error unpinning alert in chat %d: %v", pinned.ChatID, err)
+		}
+		if err := pinnedAlerts.Delete(pinnedAlertKey(pinned.ChatID, pinned.MessageID)); err != nil {
+			log.Printf("Error forgetting pinned alert: %v", err)
+		}
+	}
+}
+
+// ScheduleAlertPinExpiry unpins expired alerts every ten minutes.
+func ScheduleAlertPinExpiry(bot *tgbotapi.BotAPI) {
+	ScheduleJob(Job{
+		Name:       "alert_pin_expiry",
+		Schedule:   Every(10 * time.Minute),
+		RunAtStart: true,
+		Run: func(time.Time) error {
+			UnpinExpiredAlerts(bot, time.Now())
+			return nil
+		},
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Stages of the answer pipeline, which run in this order.
+const (
+	StageModeration = "moderation"
+	StageRetrieval  = "retrieval"
+	StageAnswer     = "answer"
+	StageRecheck    = "recheck"
+)
+
+var pipelineStages = []string{StageModeration, StageRetrieval, StageAnswer, StageRecheck}
+
+// EnabledStages reads PROMPT_PIPELINE, a comma-separated list of stages (default
+// "moderation,retrieval,answer"). Moderation and answering always run; the recheck stage is a
+// guard prompt costing a model call per answer, so it is opt-in.
+func EnabledStages() map[string]bool {
+	configured := GetenvVar("PROMPT_PIPELINE", false)
+	if configured == "" {
+		configured = StageModeration + "," + StageRetrieval + "," + StageAnswer
+	}
+	enabled := map[string]bool{StageModeration: true, StageAnswer: true}
+	for _, name := range strings.Split(configured, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, stage := range pipelineStages {
+			known = known || stage == name
+		}
+		if !known {
+			log.Printf("Ignoring unknown PROMPT_PIPELINE stage %q", name)
+			continue
+		}
+		enabled[name] = true
+	}
+	return enabled
+}
+
+// StageResult is how a pipeline stage went.
+type StageResult struct {
+	Stage    string
+	Outcome  string
+	Duration time.Duration
+	Err      error
+}
+
+// Pipeline times the stages answering one question. Each stage also counts as a handler run
+// named "pipeline:<stage>", so slow or failing stages show up in the handler metrics and alerts.
+type Pipeline struct {
+	bot     *tgbotapi.BotAPI
+	chatID  int64
+	enabled map[string]bool
+	results []StageResult
+}
+
+func NewPipeline(bot *tgbotapi.BotAPI, chatID int64) *Pipeline {
+	return &Pipeline{bot: bot, chatID: chatID, enabled: EnabledStages()}
+}
+
+// Enabled reports whether a stage is configured to run.
+func (p *Pipeline) Enabled(stage string) bool {
+	return p.enabled[stage]
+}
+
+// Start begins a stage and returns the function that records its outcome.
+func (p *Pipeline) Start(stage string) func(outcome string, err error) {
+	started := time.Now()
+	return func(outcome string, err error) {
+		result := StageResult{Stage: stage, Outcome: outcome, Duration: time.Since(started), Err: err}
+		p.results = append(p.results, result)
+		RecordHandlerRun(p.bot, "pipeline:"+stage, result.Duration, err, outcome)
+	}
+}
+
+// Finish logs every stage that ran with its outcome and latency, on one line.
+func (p *Pipeline) Finish() {
+	if len(p.results) == 0 {
+		return
+	}
+	parts := make([]string, len(p.results))
+	for i, result := range p.results {
+		outcome := result.Outcome
+		if result.Err != nil {
+			outcome = "error: " + result.Err.Error()
+		}
+		parts[i] = fmt.Sprintf("%s=%s (%s)", result.Stage, outcome, result.Duration.Round(time.Millisecond))
+	}
+	log.Printf("Pipeline in chat %d: %s", p.chatID, strings.Join(parts, " "))
+}
+
+const recheckGuardPrompt = "You review answers of a harm reduction assistant before they are sent. Reply UNSAFE: <reason in a few words> " +
+	"if the answer recommends a dangerous dose, calls a dangerous combination safe, advises against seeking emergency help, or " +
+	"helps make, buy or sell drugs. Otherwise reply SAFE. Reply with one line only."
+
+// guardVerdict asks the provider a guard prompt and returns the first line of its reply.
+func guardVerdict(systemPrompt, input string) (string, error) {
+	response, err := Ask(PromptRequest{Question: input, Temperature: 0, Tokens: 30, SystemPrompt: systemPrompt})
+	if err != nil {
+		return "", err
+	}
+	verdict, _, _ := strings.Cut(strings.TrimSpace(response.Text()), "\n")
+	return strings.TrimSpace(verdict), nil
+}
+
+// RecheckAnswer runs the safety re-check guard, returning the reason when the answer is flagged.
+func RecheckAnswer(question, answer string) (string, bool, error) {
+	verdict, err := guardVerdict(recheckGuardPrompt, "Question:\n"+question+"\n\nAnswer:\n"+answer)
+	if err != nil {
+		return "", false, err
+	}
+	label, reason, _ := strings.Cut(verdict, ":")
+	if !strings.EqualFold(strings.TrimSpace(label), "UNSAFE") {
+		return "", false, nil
+	}
+	return strings.TrimSpace(reason), true, nil
+}
+
+// RecheckNote tells the reader the re-check flagged the answer.
+func RecheckNote(reason string) string {
+	note := "\n\n⚠️ <i>An automatic safety check flagged this answer"
+	if reason != "" {
+		note += " (" + html.EscapeString(Truncate(reason, 120)) + ")"
+	}
+	return note + ". Please double-check it against a factsheet before relying on it.</i>"
+}
+
+// maxRetrievedSubstances bounds the reference data added to one question.
+const maxRetrievedSubstances = 4
+
+// RetrieveReferenceData collects what the configured substance sources know about the substances
+// in the question, to ground the answer, and returns the keys it found.
+func RetrieveReferenceData(question string) (string, []string) {
+	keys := DetectSubstances(question)
+	if len(keys) > maxRetrievedSubstances {
+		keys = keys[:maxRetrievedSubstances]
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	var lines []string
+	for _, key := range keys {
+		line := substanceName(key)
+		if duration := substances[key].Duration; duration > 0 {
+			line += fmt.Sprintf(": active for about %s after a dose", FormatDuration(duration))
+		}
+		lines = append(lines, line)
+		if doses, source, err := GetSubstanceDoses(key); err == nil {
+			for _, dose := range doses {
+				lines = append(lines, fmt.Sprintf("  %s, %s: %s (%s)", dose.Route, dose.Level, dose.Amount, source))
+			}
+		}
+	}
+	if len(keys) > 1 {
+		pairs, source := CombinationRisks(keys)
+		for _, pair := range pairs {
+			lines = append(lines, fmt.Sprintf("%s + %s: %s (%s)", substanceName(pair.A), substanceName(pair.B), riskLabel(pair.Risk), source))
+		}
+	}
+	return "\n\nReference data from the bot's curated sources. Prefer it over your own recollection where they disagree:\n" +
+		strings.Join(lines, "\n"), keys
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Capability is something the bot may or may not do depending on the kind of chat.
+type Capability string
+
+const (
+	CapAsk                Capability = "ask"
+	CapBookmarks          Capability = "bookmarks"
+	CapCheckIns           Capability = "check_ins"
+	CapConversationMemory Capability = "conversation_memory"
+	CapDigest             Capability = "digest"
+	CapDoseLog            Capability = "dose_log"
+	CapLabResults         Capability = "lab_results"
+	CapLocation           Capability = "location"
+	CapModeration         Capability = "moderation"
+	CapNotes              Capability = "notes"
+	CapSessions           Capability = "sessions"
+)
+
+// chatPolicies says which capabilities are available per chat type. Anything personal (dose
+// logs, remembered conversations) stays in private chats so it is never shown to a group.
+var chatPolicies = map[string]map[Capability]bool{
+	"private": {
+		CapAsk:                true,
+		CapBookmarks:          true,
+		CapConversationMemory: true,
+		CapDoseLog:            true,
+		CapLabResults:         true,
+		CapLocation:           true,
+		CapNotes:              true,
+		CapSessions:           true,
+	},
+	"group": {
+		CapAsk:        true,
+		CapCheckIns:   true,
+		CapDigest:     true,
+		CapModeration: true,
+	},
+	"supergroup": {
+		CapAsk:        true,
+		CapCheckIns:   true,
+		CapDigest:     true,
+		CapModeration: true,
+	},
+	"channel": {},
+}
+
+// personalCapabilities are tied to a user, so they need a sender on the message.
+var personalCapabilities = map[Capability]bool{
+	CapBookmarks:          true,
+	CapConversationMemory: true,
+	CapDoseLog:            true,
+	CapLocation:           true,
+	CapNotes:              true,
+	CapSessions:           true,
+}
+
+// policyDeniedMessages explain to the user why a command was refused.
+var policyDeniedMessages = map[Capability]string{
+	CapDoseLog:    "Dose logging and history are only available in a private chat with me.",
+	CapSessions:   "Sessions are only available in a private chat with me.",
+	CapBookmarks:  "Your saved answers are only available in a private chat with me.",
+	CapNotes:      "Your notes are only available in a private chat with me.",
+	CapDigest:     "/tldr summarizes group discussions, so it only works in groups.",
+	CapModeration: "The spam filter only works in groups.",
+	CapCheckIns:   "Check-in polls are for groups.",
+}
+
+// Allowed reports whether the policy for the message's chat permits capability.
+func Allowed(message *tgbotapi.Message, capability Capability) bool {
+	if capability == "" {
+		return true
+	}
+	if personalCapabilities[capability] && message.From == nil {
+		return false
+	}
+	if storingCapabilities[capability] && PrivacyEnabled(message.Chat.ID) {
+		return false
+	}
+	if consentCapabilities[capability] && !HasConsent(message.From.ID) {
+		return false
+	}
+	return chatPolicies[message.Chat.Type][capability]
+}
+
+// PolicyDeniedMessage is the reply sent when a command needs a capability the chat doesn't have.
+func PolicyDeniedMessage(chat *tgbotapi.Chat, capability Capability) string {
+	if chatPolicies[chat.Type][capability] && storingCapabilities[capability] && PrivacyEnabled(chat.ID) {
+		return "🔒 Privacy mode is on in this chat, so I can't store anything for this. Use /privacy off to turn it off."
+	}
+	if chatPolicies[chat.Type][capability] && consentCapabilities[capability] && chat.IsPrivate() && !HasConsent(chat.ID) {
+		return ConsentNeededMessage(chat.ID)
+	}
+	if message, ok := policyDeniedMessages[capability]; ok {
+		return message
+	}
+	return "This isn't available in this chat."
+}
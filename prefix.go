@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// CommandPrefixes are the alternative command prefixes from COMMAND_PREFIXES, e.g. "!,?", for
+// clients that mangle slash commands. Slash commands always work.
+func CommandPrefixes() []string {
+	var prefixes []string
+	for _, prefix := range strings.Split(GetenvVar("COMMAND_PREFIXES", false), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && prefix != "/" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	// Longest first, so "??" isn't read as "?" followed by "?command"
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return prefixes
+}
+
+// NormalizeCommandPrefix rewrites a command written with an alternative prefix, like "!tolerance
+// lsd", into a slash command so the rest of the bot handles it like any other. Only registered
+// commands and aliases are rewritten, so a question starting with "?" stays a question.
+func NormalizeCommandPrefix(message *tgbotapi.Message) {
+	if message.IsCommand() || message.Text == "" {
+		return
+	}
+	for _, prefix := range CommandPrefixes() {
+		rest, ok := strings.CutPrefix(message.Text, prefix)
+		if !ok {
+			continue
+		}
+		word, _, _ := strings.Cut(rest, " ")
+		name, _, _ := strings.Cut(strings.ToLower(word), "@")
+		if _, ok := FindCommand(ResolveCommand(message.Chat.ID, name)); !ok || name == "" {
+			continue
+		}
+
+		// Entities are in UTF-16 code units, so the ones after the prefix shift by its length
+		shift := 1 - len(utf16.Encode([]rune(prefix)))
+		entities := []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 1 + len(utf16.Encode([]rune(word)))}}
+		for _, entity := range message.Entities {
+			entity.Offset += shift
+			if entity.Offset >= 0 {
+				entities = append(entities, entity)
+			}
+		}
+		message.Text = "/" + rest
+		message.Entities = entities
+		return
+	}
+}
+
+// ForOtherBot reports whether a command is addressed to another bot, as in "/start@otherbot".
+// Without a username it is for everyone, including this bot.
+func ForOtherBot(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	if !message.IsCommand() {
+		return false
+	}
+	_, target, ok := strings.Cut(message.CommandWithAt(), "@")
+	return ok && !strings.EqualFold(target, bot.Self.UserName)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Link preview modes for answers.
+const (
+	PreviewDefault = "on"    // let Telegram pick, which previews the first link
+	PreviewOff     = "off"   // never show a preview
+	PreviewFirst   = "first" // preview the first link in the answer
+	PreviewLast    = "last"  // preview the last link in the answer
+)
+
+// LinkPreviewOptions mirrors Telegram's link_preview_options object.
+type LinkPreviewOptions struct {
+	IsDisabled       bool   `json:"is_disabled,omitempty"`
+	URL              string `json:"url,omitempty"`
+	PreferSmallMedia bool   `json:"prefer_small_media,omitempty"`
+	PreferLargeMedia bool   `json:"prefer_large_media,omitempty"`
+	ShowAboveText    bool   `json:"show_above_text,omitempty"`
+}
+
+var (
+	hrefPattern       = regexp.MustCompile(`<a href="(https?://[^"]+)"`)
+	previewTagPattern = regexp.MustCompile(`(?i)(^|\s)#(nopreview|preview)\b`)
+)
+
+func IsValidPreviewMode(mode string) bool {
+	switch mode {
+	case PreviewDefault, PreviewOff, PreviewFirst, PreviewLast:
+		return true
+	}
+	return false
+}
+
+// ExtractPreviewTag strips a per-message #nopreview / #preview tag from the question
+// and returns the mode it selects, or "" when the chat setting should apply.
+func ExtractPreviewTag(question string) (string, string) {
+	match := previewTagPattern.FindStringSubmatch(question)
+	if match == nil {
+		return question, ""
+	}
+	mode := PreviewOff
+	if strings.ToLower(match[2]) == "preview" {
+		mode = PreviewFirst
+	}
+	return strings.TrimSpace(previewTagPattern.ReplaceAllString(question, "$1")), mode
+}
+
+// LinkPreviewFor returns the preview options for an HTML answer, or nil to keep Telegram's default.
+func LinkPreviewFor(mode string, html string) *LinkPreviewOptions {
+	switch mode {
+	case PreviewOff:
+		return &LinkPreviewOptions{IsDisabled: true}
+	case PreviewFirst, PreviewLast:
+		links := hrefPattern.FindAllStringSubmatch(html, -1)
+		if len(links) == 0 {
+			return nil
+		}
+		link := links[0][1]
+		if mode == PreviewLast {
+			link = links[len(links)-1][1]
+		}
+		return &LinkPreviewOptions{URL: link}
+	}
+	return nil
+}
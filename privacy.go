@@ -0,0 +1,182 @@
+package main
+
+import (
+	"html"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// PrivacyNotice is appended to answers in chats with privacy mode on.
+func PrivacyNotice(language string) string {
+	return "\n\n<i>" + html.EscapeString(Localized("privacy_notice", language)) + "</i>"
+}
+
+// storingCapabilities keep data from the chat after the reply is sent, so privacy mode turns them off.
+var storingCapabilities = map[Capability]bool{
+	CapConversationMemory: true,
+	CapDoseLog:            true,
+	CapNotes:              true,
+	CapSessions:           true,
+}
+
+// PrivacyEnabled reports whether nothing from the chat may be stored: no conversation memory,
+// no recorded answers, no question text in logs. It is on by default in groups (negative chat
+// IDs) and can be changed per chat with /privacy.
+func PrivacyEnabled(chatID int64) bool {
+	switch GetChatSettings(chatID).Privacy {
+	case "on":
+		return true
+	case "off":
+		return false
+	default:
+		return chatID < 0
+	}
+}
+
+func HandlePrivacyCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chat := update.Message.Chat
+	value := strings.ToLower(strings.TrimSpace(args))
+
+	if value == "" {
+		if PrivacyEnabled(chat.ID) {
+			return SendHTML(bot, chat.ID, "🔒 Privacy mode is <b>on</b>: I don't store questions, answers or conversation history from this chat. "+
+				"Rating, saving and reading out answers need a stored answer, so they're off too.\nUse /privacy off to change this.")
+		}
+		return SendHTML(bot, chat.ID, "Privacy mode is <b>off</b>: I remember recent turns and keep answers for ratings and bookmarks.\nUse /privacy on to stop storing anything from this chat.")
+	}
+	if value != "on" && value != "off" {
+		return SendHTML(bot, chat.ID, "Usage: /privacy on|off")
+	}
+	if update.Message.From == nil || !IsChatAdmin(bot, chat, update.Message.From.ID) {
+		return SendHTML(bot, chat.ID, "Only group admins can change privacy mode.")
+	}
+
+	if err := UpdateChatSettings(chat.ID, func(settings *ChatSettings) { settings.Privacy = value }); err != nil {
+		return err
+	}
+	if value == "off" {
+		return SendHTML(bot, chat.ID, "Privacy mode is off. New questions and answers from this chat are stored again.")
+	}
+	// Forget what was kept before privacy mode was turned on
+	cleared, err := ForgetChatData(chat.ID)
+	if err != nil {
+		return err
+	}
+	text := "🔒 Privacy mode is on. I won't store anything new from this chat."
+	if len(cleared) > 0 {
+		text = "🔒 Privacy mode is on. I've deleted this chat's " + joinNames(cleared) + ", and won't store anything new from it."
+	}
+	return SendHTML(bot, chat.ID, text)
+}
+
+// ForgetChatData deletes everything stored from a chat and returns what it deleted. In private
+// chats that includes the user's memory, dose log, notes and bookmarks; in groups, the answers
+// members bookmarked from the group.
+func ForgetChatData(chatID int64) ([]string, error) {
+	var cleared []string
+	note := func(what string, count int) {
+		if count > 0 {
+			cleared = append(cleared, what)
+		}
+	}
+
+	if chatID > 0 {
+		if _, ok := conversations.Get(ChatKey(chatID)); ok {
+			if err := conversations.Delete(ChatKey(chatID)); err != nil {
+				return cleared, err
+			}
+			note("conversation memory", 1)
+		}
+		if archived, _ := archivedSessions.Get(ChatKey(chatID)); len(archived) > 0 {
+			if err := DeleteArchivedSessions(chatID); err != nil {
+				return cleared, err
+			}
+			note("archived sessions", 1)
+		}
+		if entries, _ := doseLog.Get(ChatKey(chatID)); len(entries) > 0 {
+			if err := doseLog.Delete(ChatKey(chatID)); err != nil {
+				return cleared, err
+			}
+			note("dose log", 1)
+		}
+		if _, ok := substanceNotes.Get(ChatKey(chatID)); ok {
+			if err := substanceNotes.Delete(ChatKey(chatID)); err != nil {
+				return cleared, err
+			}
+			note("substance notes", 1)
+		}
+	}
+
+	// Bookmarks are kept by user, so look for the chat's answers in everyone's
+	var bookmarkedBy []string
+	bookmarks.Range(func(key string, saved []Bookmark) bool {
+		for _, bookmark := range saved {
+			if bookmark.ChatID == chatID {
+				bookmarkedBy = append(bookmarkedBy, key)
+				break
+			}
+		}
+		return true
+	})
+	for _, key := range bookmarkedBy {
+		err := bookmarks.Update(key, func(saved []Bookmark) []Bookmark {
+			var kept []Bookmark
+			for _, bookmark := range saved {
+				if bookmark.ChatID != chatID {
+					kept = append(kept, bookmark)
+				}
+			}
+			return kept
+		})
+		if err != nil {
+			return cleared, err
+		}
+	}
+	note("bookmarks", len(bookmarkedBy))
+
+	count, err := feedback.DeleteWhere(func(_ string, entry FeedbackEntry) bool { return entry.ChatID == chatID })
+	if err != nil {
+		return cleared, err
+	}
+	note("stored answers", count)
+	if ensembleLog != nil {
+		count, err := ensembleLog.DeleteWhere(func(_ string, record EnsembleRecord) bool { return record.ChatID == chatID })
+		if err != nil {
+			return cleared, err
+		}
+		note("answer comparisons", count)
+	}
+	if heldAnswers != nil {
+		count, err := heldAnswers.DeleteWhere(func(_ string, held HeldAnswer) bool { return held.ChatID == chatID })
+		if err != nil {
+			return cleared, err
+		}
+		note("answers held for review", count)
+	}
+	if deadLetters != nil {
+		count, err := deadLetters.DeleteWhere(func(_ string, letter DeadLetter) bool { return letter.ChatID == chatID })
+		if err != nil {
+			return cleared, err
+		}
+		note("undelivered answers", count)
+	}
+	if moderationLog != nil {
+		// The actions stay for the admins' digest, without the messages they were about
+		var quoted []ModerationAction
+		moderationLog.Range(func(_ string, action ModerationAction) bool {
+			if action.ChatID == chatID && action.Text != "" {
+				quoted = append(quoted, action)
+			}
+			return true
+		})
+		for _, action := range quoted {
+			action.Text = ""
+			if err := moderationLog.Set(moderationKey(action.ChatID, action.At), action); err != nil {
+				return cleared, err
+			}
+		}
+		note("quoted messages in the moderation log", len(quoted))
+	}
+	return cleared, nil
+}
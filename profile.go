@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Tiers of users.
+const (
+	TierFree      = "free"
+	TierSupporter = "supporter"
+)
+
+// ConsentRecord is one acceptance or withdrawal of the privacy policy.
+type ConsentRecord struct {
+	Version   string    `json:"version"`
+	At        time.Time `json:"at"`
+	Withdrawn bool      `json:"withdrawn,omitempty"`
+}
+
+// UserProfile is what the bot knows about a user as a person rather than their preferences,
+// which stay in their user settings under the same key.
+type UserProfile struct {
+	FirstSeen time.Time `json:"first_seen"`
+	// Locale is the language code of the user's Telegram client
+	Locale string `json:"locale,omitempty"`
+	Tier   string `json:"tier,omitempty"`
+	// Consents is the history of privacy policy acceptances and withdrawals, newest last
+	Consents []ConsentRecord `json:"consents,omitempty"`
+}
+
+var users *JSONStore[UserProfile]
+
+// PrivacyPolicyVersion is PRIVACY_POLICY_VERSION (default "1"). Bumping it asks everyone to
+// accept the policy again before their data is stored.
+func PrivacyPolicyVersion() string {
+	if version := GetenvVar("PRIVACY_POLICY_VERSION", false); version != "" {
+		return version
+	}
+	return "1"
+}
+
+func GetUserProfile(userID int64) UserProfile {
+	if users == nil {
+		return UserProfile{}
+	}
+	profile, _ := users.Get(ChatKey(userID))
+	return profile
+}
+
+// TouchUserProfile creates the user's profile, or refreshes its locale and tier.
+func TouchUserProfile(user *tgbotapi.User) error {
+	if users == nil || user == nil {
+		return nil
+	}
+	return users.Update(ChatKey(user.ID), func(profile UserProfile) UserProfile {
+		if profile.FirstSeen.IsZero() {
+			profile.FirstSeen = time.Now()
+		}
+		if user.LanguageCode != "" {
+			profile.Locale = user.LanguageCode
+		}
+		profile.Tier = TierFree
+		if IsSupporter(user.ID) {
+			profile.Tier = TierSupporter
+		}
+		return profile
+	})
+}
+
+// LatestConsent is the user's most recent consent record.
+func (p UserProfile) LatestConsent() (ConsentRecord, bool) {
+	if len(p.Consents) == 0 {
+		return ConsentRecord{}, false
+	}
+	return p.Consents[len(p.Consents)-1], true
+}
+
+// HasConsent reports whether the user accepted the current privacy policy and hasn't withdrawn.
+func HasConsent(userID int64) bool {
+	consent, ok := GetUserProfile(userID).LatestConsent()
+	return ok && !consent.Withdrawn && consent.Version == PrivacyPolicyVersion()
+}
+
+// RecordConsent records the user accepting the current privacy policy, or withdrawing their consent.
+func RecordConsent(user *tgbotapi.User, accepted bool) error {
+	if err := TouchUserProfile(user); err != nil {
+		return err
+	}
+	return users.Update(ChatKey(user.ID), func(profile UserProfile) UserProfile {
+		record := ConsentRecord{Version: PrivacyPolicyVersion(), At: time.Now(), Withdrawn: !accepted}
+		profile.Consents = append(append([]ConsentRecord{}, profile.Consents...), record)
+		return profile
+	})
+}
+
+// consentCapabilities store personal data, so they need the user's consent.
+var consentCapabilities = map[Capability]bool{
+	CapBookmarks:          true,
+	CapConversationMemory: true,
+	CapDoseLog:            true,
+	CapNotes:              true,
+	CapSessions:           true,
+}
+
+// ConsentText explains what is stored, for the /start step and /consent.
+func ConsentText() string {
+	text := "I only store your data if you agree: your dose log, conversation history, notes and saved answers. " +
+		"Without consent I still answer your questions, but remember nothing."
+	if url := GetenvVar("PRIVACY_POLICY_URL", false); url != "" {
+		text += fmt.Sprintf("\n\n<a href=\"%s\">Privacy policy</a> (version %s)", html.EscapeString(url), html.EscapeString(PrivacyPolicyVersion()))
+	}
+	return text
+}
+
+// ConsentNeededMessage is the refusal of a feature that stores personal data without consent.
+func ConsentNeededMessage(userID int64) string {
+	if consent, ok := GetUserProfile(userID).LatestConsent(); ok && !consent.Withdrawn {
+		return "🔒 The privacy policy has changed since you accepted it. Please review it with /consent before I store anything for you."
+	}
+	return "🔒 This stores personal data, so I need your consent first. Use /consent to review and accept the privacy policy."
+}
+
+// ConsentKeyboard accepts ("<prefix>:yes") or declines ("<prefix>:no"): "ob:consent" during
+// onboarding, "cs" for /consent.
+func ConsentKeyboard(prefix string) *tgbotapi.InlineKeyboardMarkup {
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ I agree", prefix+":yes"),
+		tgbotapi.NewInlineKeyboardButtonData("Not now", prefix+":no")))
+	return &markup
+}
+
+const consentUsage = "/consent — review the privacy policy and your consent\n/consent withdraw — stop storing your data"
+
+// HandleConsentCommand shows the user's consent and lets them accept or withdraw it.
+func HandleConsentCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	user := update.Message.From
+	if user == nil {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+	case "withdraw":
+		if !HasConsent(user.ID) {
+			return SendHTML(bot, chatID, "You haven't given consent, so nothing new is stored.")
+		}
+		if err := RecordConsent(user, false); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "Consent withdrawn. I won't store anything new for you. Use /privacy to check what is kept in this chat.")
+	default:
+		return SendHTML(bot, chatID, consentUsage)
+	}
+
+	status := "You haven't accepted the privacy policy."
+	profile := GetUserProfile(user.ID)
+	if consent, ok := profile.LatestConsent(); ok {
+		location := UserLocation(user.ID)
+		switch {
+		case consent.Withdrawn:
+			status = fmt.Sprintf("You withdrew your consent on %s.", consent.At.In(location).Format("2006-01-02"))
+		case consent.Version != PrivacyPolicyVersion():
+			status = fmt.Sprintf("You accepted version %s on %s, the policy has changed since.", html.EscapeString(consent.Version), consent.At.In(location).Format("2006-01-02"))
+		default:
+			status = fmt.Sprintf("✅ You accepted version %s on %s.", html.EscapeString(consent.Version), consent.At.In(location).Format("2006-01-02"))
+		}
+	}
+	msg := tgbotapi.NewMessage(chatID, "🔐 <b>Your data</b>\n"+status+"\n\n"+ConsentText()+"\n\n"+consentUsage)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	if !HasConsent(user.ID) {
+		msg.ReplyMarkup = ConsentKeyboard("cs")
+	}
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleConsentCallback records the answer to /consent ("cs:<yes|no>").
+func HandleConsentCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	text := "No problem, I won't store anything for you. You can change your mind with /consent."
+	if args[0] == "yes" {
+		if err := RecordConsent(query.From, true); err != nil {
+			return err
+		}
+		text = fmt.Sprintf("✅ Thanks, you accepted version %s of the privacy policy. /consent withdraw stops it any time.", html.EscapeString(PrivacyPolicyVersion()))
+	}
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, &LinkPreviewOptions{IsDisabled: true}, nil); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Progress stages shown in the thinking message while a question is answered.
+const (
+	StageReceived   = "received"
+	StageRetrieving = "retrieving"
+	StageGenerating = "generating"
+	StageSlow       = "slow"
+)
+
+// progressTimeline advances the stage by elapsed time when the backend doesn't report progress.
+var progressTimeline = []struct {
+	After time.Duration
+	Stage string
+}{
+	{2 * time.Second, StageRetrieving},
+	{8 * time.Second, StageGenerating},
+	{30 * time.Second, StageSlow},
+}
+
+var stageOrder = map[string]int{StageReceived: 0, StageRetrieving: 1, StageGenerating: 2, StageSlow: 3}
+
+// ProgressText describes a stage, mentioning what the question is about where that helps.
+func ProgressText(stage, question string) string {
+	var names []string
+	for _, key := range DetectSubstances(question) {
+		names = append(names, substances[key].Name)
+	}
+	questions := len(SplitQuestions(question))
+
+	switch stage {
+	case StageRetrieving:
+		switch {
+		case len(names) >= 2:
+			return fmt.Sprintf("🔎 Checking how %s interact...", joinNames(names))
+		case len(names) == 1:
+			return fmt.Sprintf("🔎 Looking up %s...", names[0])
+		default:
+			return "🔎 Searching harm reduction sources..."
+		}
+	case StageGenerating:
+		if questions > 1 {
+			return fmt.Sprintf("✍️ Writing answers to your %d questions...", questions)
+		}
+		return "✍️ Writing the answer..."
+	case StageSlow:
+		return "⏳ Still working, this one is taking a while..."
+	default:
+		return ThinkingMessage
+	}
+}
+
+func joinNames(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// ProgressNotice keeps the thinking message updated with the answer's progress until Stop.
+type ProgressNotice struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int
+	question  string
+
+	// mu is held while an edit is sent, so once Stop returns no progress edit can land on top of the answer
+	mu     sync.Mutex
+	stage  string
+	done   bool
+	timers []*time.Timer
+}
+
+// StartProgress starts advancing the thinking message through the stages by elapsed time.
+func StartProgress(bot *tgbotapi.BotAPI, chatID int64, messageID int, question string) *ProgressNotice {
+	p := &ProgressNotice{bot: bot, chatID: chatID, messageID: messageID, question: question, stage: StageReceived}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, step := range progressTimeline {
+		stage := step.Stage
+		p.timers = append(p.timers, time.AfterFunc(step.After, func() { p.Stage(stage) }))
+	}
+	return p
+}
+
+// Stage shows a stage reported by the backend or the timeline. Stages never go backwards.
+func (p *ProgressNotice) Stage(stage string) {
+	if _, ok := stageOrder[stage]; !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done || stageOrder[stage] <= stageOrder[p.stage] {
+		return
+	}
+	p.stage = stage
+
+	edit := tgbotapi.NewEditMessageText(p.chatID, p.messageID, ProgressText(stage, p.question))
+	if _, err := p.bot.Send(edit); err != nil {
+		log.Printf("Error updating progress: %v", err)
+	}
+}
+
+// Stop ends the progress updates before the message is replaced with the answer.
+func (p *ProgressNotice) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+	for _, timer := range p.timers {
+		timer.Stop()
+	}
+}
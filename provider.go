@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// LLM providers selectable with LLM_PROVIDER.
+const (
+	ProviderPsyAI     = "psyai"
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+)
+
+// ErrProviderUnsupported is returned by provider methods the provider has no API for.
+var ErrProviderUnsupported = errors.New("not supported by this provider")
+
+// Provider answers questions. The PsyAI API adds retrieval and moderation on top of a model;
+// the direct providers let the bot run without it, with the bot's own system prompt only.
+type Provider interface {
+	Name() string
+	// Ask returns the whole answer at once
+	Ask(ctx context.Context, request PromptRequest) (*PromptResponse, error)
+	// Stream calls onDelta with the answer so far as it is generated, and onStatus with progress
+	// stages when the provider reports them. Cancelling ctx returns the answer so far, Stopped.
+	Stream(ctx context.Context, request PromptRequest, onDelta func(answer string), onStatus func(status string)) (*PromptResponse, error)
+	// Moderate checks a text against the provider's content policy
+	Moderate(ctx context.Context, text string) (*Moderation, error)
+	// Embed returns one embedding vector per text
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// ProviderName is LLM_PROVIDER, psyai by default.
+func ProviderName() string {
+	switch name := strings.ToLower(GetenvVar("LLM_PROVIDER", false)); name {
+	case "", ProviderPsyAI:
+		return ProviderPsyAI
+	case ProviderOpenAI, ProviderAnthropic:
+		return name
+	default:
+		log.Printf("Unknown LLM_PROVIDER %q, using %s", name, ProviderPsyAI)
+		return ProviderPsyAI
+	}
+}
+
+// CurrentProvider is the provider selected by LLM_PROVIDER, configured from the environment.
+func CurrentProvider() Provider {
+	switch ProviderName() {
+	case ProviderOpenAI:
+		return newOpenAIProvider()
+	case ProviderAnthropic:
+		return newAnthropicProvider()
+	default:
+		return psyaiProvider{baseURL: GetenvVar("BASE_URL_BETA", false)}
+	}
+}
+
+// CheckProvider reports provider settings that would make every question fail.
+func CheckProvider() error {
+	switch ProviderName() {
+	case ProviderOpenAI:
+		if GetenvVar("OPENAI_API_KEY", false) == "" {
+			return errors.New("LLM_PROVIDER=openai needs OPENAI_API_KEY")
+		}
+	case ProviderAnthropic:
+		if GetenvVar("ANTHROPIC_API_KEY", false) == "" || GetenvVar("ANTHROPIC_MODEL", false) == "" {
+			return errors.New("LLM_PROVIDER=anthropic needs ANTHROPIC_API_KEY and ANTHROPIC_MODEL")
+		}
+	default:
+		if GetenvVar("BASE_URL_BETA", false) == "" {
+			return errors.New("LLM_PROVIDER=psyai needs BASE_URL_BETA")
+		}
+	}
+	return nil
+}
+
+// Ask answers a request with the current provider.
+func Ask(request PromptRequest) (*PromptResponse, error) {
+	return CurrentProvider().Ask(context.Background(), request)
+}
+
+// psyaiProvider is the PsyAI API at BASE_URL_BETA.
+type psyaiProvider struct {
+	baseURL string
+}
+
+func (p psyaiProvider) Name() string { return ProviderPsyAI }
+
+func (p psyaiProvider) Ask(ctx context.Context, request PromptRequest) (*PromptResponse, error) {
+	return promptContext(ctx, p.baseURL+ApiPromptEndpoint, request)
+}
+
+func (p psyaiProvider) Stream(ctx context.Context, request PromptRequest, onDelta func(answer string), onStatus func(status string)) (*PromptResponse, error) {
+	return StreamPrompt(ctx, p.baseURL+ApiStreamEndpoint, request, onDelta, onStatus)
+}
+
+// Moderate isn't available on its own: the PsyAI API moderates questions as part of answering them.
+func (p psyaiProvider) Moderate(ctx context.Context, text string) (*Moderation, error) {
+	return nil, ErrProviderUnsupported
+}
+
+func (p psyaiProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	return nil, ErrProviderUnsupported
+}
+
+// chatMessages is the conversation of a request as chat messages, ending with the question.
+func chatMessages(request PromptRequest) []HistoryMessage {
+	return append(append([]HistoryMessage{}, request.History...), HistoryMessage{Role: "user", Content: request.Question})
+}
+
+// postProviderJSON posts a JSON body and decodes the JSON response, turning error statuses into
+// a BackendError with the message of the provider's {"error": {"message": ...}} body.
+func postProviderJSON(ctx context.Context, url string, headers map[string]string, body, result interface{}) error {
+	resp, err := providerRequest(ctx, backendClient(), url, headers, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return fmt.Errorf("error reading API response: %w", err)
+	}
+	if len(raw) > maxResponseBytes {
+		return fmt.Errorf("API response exceeds %d bytes", maxResponseBytes)
+	}
+	if resp.StatusCode >= 400 {
+		return providerError(resp, raw)
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		schemaErr := &SchemaError{Reason: err.Error()}
+		logSchemaMismatch(schemaErr, raw)
+		return schemaErr
+	}
+	return nil
+}
+
+func providerRequest(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making API request: %w", err)
+	}
+	return resp, nil
+}
+
+func providerError(resp *http.Response, raw []byte) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := resp.Status
+	if json.Unmarshal(raw, &body) == nil && body.Error.Message != "" {
+		message = body.Error.Message
+	}
+	return &BackendError{Status: resp.StatusCode, Message: message}
+}
+
+// streamProvider posts a streaming request and calls handle with the data of every server-sent
+// event until it reports done. With the answer that accumulates it handles cancellation and
+// the answer size cap the same way as StreamPrompt.
+func streamProvider(ctx context.Context, url string, headers map[string]string, body interface{}, onDelta func(answer string),
+	handle func(data string) (delta string, done bool, err error)) (*PromptResponse, error) {
+	headers["Accept"] = "text/event-stream"
+	resp, err := providerRequest(ctx, BackendHTTPClient(0), url, headers, body)
+	if err != nil && ctx.Err() != nil {
+		return &PromptResponse{Stopped: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, providerError(resp, raw)
+	}
+
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		delta, done, err := handle(data)
+		if err != nil {
+			return nil, err
+		}
+		if delta != "" {
+			answer.WriteString(delta)
+			onDelta(answer.String())
+		}
+		if done || answer.Len() > maxStreamedAnswerBytes {
+			break
+		}
+	}
+	response := &PromptResponse{Assistant: answer.String()}
+	if ctx.Err() != nil {
+		response.Stopped = true
+		return response, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading stream: %w", err)
+	}
+	if err := response.Validate(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AskJob is a unit of work for the worker pool. OnPosition, when set, is told the job's
+// 1-based queue position and estimated wait whenever it changes while the job is waiting.
+type AskJob struct {
+	Run        func()
+	OnPosition func(position int, wait time.Duration)
+}
+
+// WorkerPool runs jobs on a fixed number of workers, queueing the rest in FIFO order.
+type WorkerPool struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queue       []*AskJob
+	workers     int
+	busy        int
+	avgDuration time.Duration
+}
+
+var askPool *WorkerPool
+
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	pool := &WorkerPool{workers: workers, avgDuration: 10 * time.Second}
+	pool.cond = sync.NewCond(&pool.mu)
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+// WorkerCountFromEnv reads ASK_WORKERS, defaulting to 4.
+func WorkerCountFromEnv() int {
+	workers, err := strconv.Atoi(GetenvVar("ASK_WORKERS", false))
+	if err != nil || workers < 1 {
+		return 4
+	}
+	return workers
+}
+
+// Submit queues the job and returns its queue position, or 0 when a worker is free to start it right away.
+func (p *WorkerPool) Submit(job *AskJob) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue = append(p.queue, job)
+	p.cond.Signal()
+
+	if p.busy+len(p.queue) <= p.workers {
+		return 0
+	}
+	return len(p.queue)
+}
+
+// EstimatedWait is the expected time until the job at position starts.
+func (p *WorkerPool) EstimatedWait(position int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.estimatedWait(position)
+}
+
+func (p *WorkerPool) estimatedWait(position int) time.Duration {
+	rounds := (position + p.workers - 1) / p.workers
+	return time.Duration(rounds) * p.avgDuration
+}
+
+// QueueDepth is the number of jobs waiting for a worker.
+func (p *WorkerPool) QueueDepth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+func (p *WorkerPool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 {
+			p.cond.Wait()
+		}
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+		p.busy++
+		p.notifyPositions()
+		p.mu.Unlock()
+
+		start := time.Now()
+		p.run(job)
+		elapsed := time.Since(start)
+
+		p.mu.Lock()
+		p.busy--
+		// Exponential moving average keeps the estimate responsive to backend slowdowns.
+		p.avgDuration = (p.avgDuration*4 + elapsed) / 5
+		p.mu.Unlock()
+	}
+}
+
+func (p *WorkerPool) run(job *AskJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			ReportPanic(r, ErrorContext{Command: "ask"})
+		}
+	}()
+	job.Run()
+}
+
+// notifyPositions tells waiting jobs their new positions. Callers must hold the lock.
+func (p *WorkerPool) notifyPositions() {
+	for i, waiting := range p.queue {
+		if waiting.OnPosition == nil {
+			continue
+		}
+		position, wait := i+1, p.estimatedWait(i+1)
+		go waiting.OnPosition(position, wait)
+	}
+}
+
+// QueueNotice keeps a queued user's thinking message updated with their position.
+type QueueNotice struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int
+
+	mu       sync.Mutex
+	started  bool
+	position int
+	shownAt  time.Time
+}
+
+// Show edits the thinking message to the new position. Updates are dropped once the job
+// has started, when they arrive out of order, or when they come faster than every few seconds.
+func (n *QueueNotice) Show(position int, wait time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.started || (n.position != 0 && position >= n.position) {
+		return
+	}
+	if position != 1 && time.Since(n.shownAt) < QueueNoticeInterval {
+		return
+	}
+	n.position = position
+	n.shownAt = time.Now()
+
+	text := fmt.Sprintf(QueuedMessage, position, wait.Round(time.Second))
+	edit := tgbotapi.NewEditMessageText(n.chatID, n.messageID, text)
+	if _, err := n.bot.Send(edit); err != nil {
+		log.Printf("Error updating queue position: %v", err)
+	}
+}
+
+// Start marks the job as running and restores the thinking text if a queue notice was shown.
+func (n *QueueNotice) Start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.started = true
+	if n.position == 0 {
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(n.chatID, n.messageID, ThinkingMessage)
+	n.bot.Send(edit)
+}
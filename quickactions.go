@@ -0,0 +1,187 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Quick actions of the private chat keyboard.
+const (
+	QuickDoseInfo    = "info"
+	QuickInteraction = "interactions"
+	QuickLogDose     = "log"
+	QuickEmergency   = "emergency"
+)
+
+// quickActions are the reply keyboard buttons, two per row. Tapping one sends its label as a
+// message, which is how it is recognized.
+var quickActions = []struct {
+	Action string
+	Label  string
+}{
+	{QuickDoseInfo, "💊 Dose info"},
+	{QuickInteraction, "🔀 Check interaction"},
+	{QuickLogDose, "📝 Log a dose"},
+	{QuickEmergency, "🆘 Emergency resources"},
+}
+
+// quickPrompts are the actions waiting for the user to type a substance or combination, by user.
+// An unanswered prompt is dropped after ten minutes.
+var quickPrompts = NewBoundedMap[int64, string]("quick_prompts", 10000, 10*time.Minute)
+
+// persistentReplyKeyboard adds is_persistent, which the bundled tgbotapi version lacks, so the
+// keyboard stays visible instead of collapsing behind an icon.
+type persistentReplyKeyboard struct {
+	tgbotapi.ReplyKeyboardMarkup
+	IsPersistent bool `json:"is_persistent"`
+}
+
+// QuickActionsKeyboard is the persistent reply keyboard of private chats.
+func QuickActionsKeyboard() persistentReplyKeyboard {
+	var rows [][]tgbotapi.KeyboardButton
+	for i := 0; i < len(quickActions); i += 2 {
+		row := tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(quickActions[i].Label))
+		if i+1 < len(quickActions) {
+			row = append(row, tgbotapi.NewKeyboardButton(quickActions[i+1].Label))
+		}
+		rows = append(rows, row)
+	}
+	keyboard := tgbotapi.NewReplyKeyboard(rows...)
+	keyboard.InputFieldPlaceholder = "Ask a question or pick an action"
+	return persistentReplyKeyboard{ReplyKeyboardMarkup: keyboard, IsPersistent: true}
+}
+
+// QuickActionsShown reports whether a user wants the keyboard, which is on unless they hid it
+// with /menu off.
+func QuickActionsShown(userID int64) bool {
+	return !GetUserSettings(userID).HideQuickActions
+}
+
+// SendQuickActions shows the keyboard with a short explanation.
+func SendQuickActions(bot *tgbotapi.BotAPI, chatID int64) error {
+	msg := tgbotapi.NewMessage(chatID, "The buttons below the text box are shortcuts for common questions. /menu off hides them.")
+	msg.ReplyMarkup = QuickActionsKeyboard()
+	_, err := bot.Send(msg)
+	return err
+}
+
+// quickAction returns the action of a tapped button.
+func quickAction(text string) (string, bool) {
+	for _, action := range quickActions {
+		if text == action.Label {
+			return action.Action, true
+		}
+	}
+	return "", false
+}
+
+// emergencyResources is EMERGENCY_RESOURCES (HTML) when set, for instance local crisis lines of
+// the community running the bot.
+func emergencyResources() string {
+	if text := GetenvVar("EMERGENCY_RESOURCES", false); text != "" {
+		return text
+	}
+	return "🆘 <b>If someone is in danger, call emergency services now</b>: 112 in Europe, 911 in North America, 999 in the UK, 000 in Australia.\n\n" +
+		"While you wait:\n" +
+		"• Stay with them, and tell the responders what was taken and when\n" +
+		"• If they're unresponsive but breathing, put them in the recovery position\n" +
+		"• If they're overheating, move them somewhere cool and wet their skin\n" +
+		"• For a suspected opioid overdose, give naloxone if you have it\n\n" +
+		"Paramedics are there to help, not to get anyone in trouble. For anything less urgent, describe what's happening and I'll help."
+}
+
+// HandleQuickAction starts the guided flow of a quick action button in a private chat, or takes
+// the answer to its prompt. It reports whether the message was consumed.
+func HandleQuickAction(bot *tgbotapi.BotAPI, update tgbotapi.Update) (bool, error) {
+	message := update.Message
+	if !message.Chat.IsPrivate() || message.From == nil || message.Text == "" {
+		return false, nil
+	}
+	chatID, userID := message.Chat.ID, message.From.ID
+	text := strings.TrimSpace(message.Text)
+
+	action, ok := quickAction(text)
+	if !ok {
+		pending, waiting := quickPrompts.LoadAndDelete(userID)
+		if !waiting || message.IsCommand() {
+			return false, nil
+		}
+		// Anything that isn't a substance is an ordinary question
+		switch pending {
+		case QuickDoseInfo:
+			if matches := ResolveSubstance(text); len(matches) == 0 || matches[0].Confidence < ConfidentMatch {
+				return false, nil
+			}
+			return true, HandleInfoCommand(bot, update, text)
+		case QuickInteraction:
+			if !strings.Contains(text, "+") {
+				text = strings.Join(strings.Fields(strings.ReplaceAll(text, ",", " ")), " + ")
+			}
+			if keys, _ := ParseCombination(text); len(keys) == 0 {
+				return false, nil
+			}
+			return true, HandleInteractionsCommand(bot, update, text)
+		}
+		return false, nil
+	}
+
+	quickPrompts.Delete(userID)
+	switch action {
+	case QuickDoseInfo:
+		quickPrompts.Set(userID, QuickDoseInfo)
+		var rows [][]tgbotapi.InlineKeyboardButton
+		var row []tgbotapi.InlineKeyboardButton
+		for _, key := range logFormSubstances {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(substances[key].Name, "info:"+key))
+			if len(row) == 4 {
+				rows, row = append(rows, row), nil
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+		msg := tgbotapi.NewMessage(chatID, "💊 Which substance? Pick one or type its name.")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+		_, err := bot.Send(msg)
+		return true, err
+	case QuickInteraction:
+		quickPrompts.Set(userID, QuickInteraction)
+		return true, SendHTML(bot, chatID, "🔀 Which substances are you combining? Type them separated by + or commas, e.g. <i>mdma + alcohol</i>.")
+	case QuickLogDose:
+		if !Allowed(message, CapDoseLog) {
+			return true, SendHTML(bot, chatID, PolicyDeniedMessage(message.Chat, CapDoseLog))
+		}
+		return true, StartLogForm(bot, chatID, userID)
+	case QuickEmergency:
+		return true, SendHTML(bot, chatID, emergencyResources())
+	}
+	return false, nil
+}
+
+// HandleMenuCommand shows or hides the quick actions keyboard.
+func HandleMenuCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if !update.Message.Chat.IsPrivate() || update.Message.From == nil {
+		return SendHTML(bot, chatID, "The quick actions keyboard is only available in a private chat with me.")
+	}
+	userID := update.Message.From.ID
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "", "on":
+		if err := UpdateUserSettings(userID, func(settings *UserSettings) { settings.HideQuickActions = false }); err != nil {
+			return err
+		}
+		return SendQuickActions(bot, chatID)
+	case "off":
+		if err := UpdateUserSettings(userID, func(settings *UserSettings) { settings.HideQuickActions = true }); err != nil {
+			return err
+		}
+		msg := tgbotapi.NewMessage(chatID, "Quick actions hidden. /menu brings them back.")
+		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(false)
+		_, err := bot.Send(msg)
+		return err
+	default:
+		return SendHTML(bot, chatID, "Usage: /menu on|off")
+	}
+}
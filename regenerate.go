@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	bulletPattern   = regexp.MustCompile(`^\s*(?:[-*•]|\d{1,2}[.)])\s+(.+)$`)
+	sentencePattern = regexp.MustCompile(`[^.!?]+[.!?]?`)
+)
+
+// ExtractKeyPoints returns the bullet points of a Markdown answer, or its sentences when it has
+// no list.
+func ExtractKeyPoints(markdown string) []string {
+	var bullets, prose []string
+	for _, line := range strings.Split(markdown, "\n") {
+		if match := bulletPattern.FindStringSubmatch(line); match != nil {
+			bullets = append(bullets, strings.TrimSpace(match[1]))
+		} else if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			prose = append(prose, line)
+		}
+	}
+	if len(bullets) > 0 {
+		return bullets
+	}
+
+	var sentences []string
+	for _, sentence := range sentencePattern.FindAllString(strings.Join(prose, " "), -1) {
+		if sentence = strings.TrimSpace(sentence); len(strings.Fields(sentence)) >= 4 {
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+func pointWords(point string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(point), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 2 {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// similarPoints reports whether two key points say roughly the same thing (word overlap).
+func similarPoints(a, b map[string]bool) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	return float64(shared)/float64(len(a)+len(b)-shared) >= 0.5
+}
+
+// DiffKeyPoints returns the key points only in the new answer and those only in the old one.
+func DiffKeyPoints(before, after string) (added, removed []string) {
+	oldPoints, newPoints := ExtractKeyPoints(before), ExtractKeyPoints(after)
+	oldWords := make([]map[string]bool, len(oldPoints))
+	for i, point := range oldPoints {
+		oldWords[i] = pointWords(point)
+	}
+	newWords := make([]map[string]bool, len(newPoints))
+	for i, point := range newPoints {
+		newWords[i] = pointWords(point)
+	}
+
+	matches := func(words map[string]bool, others []map[string]bool) bool {
+		for _, other := range others {
+			if similarPoints(words, other) {
+				return true
+			}
+		}
+		return false
+	}
+	for i, point := range newPoints {
+		if !matches(newWords[i], oldWords) {
+			added = append(added, point)
+		}
+	}
+	for i, point := range oldPoints {
+		if !matches(oldWords[i], newWords) {
+			removed = append(removed, point)
+		}
+	}
+	return added, removed
+}
+
+// FormatChangeNote summarizes what a regenerated answer changed.
+func FormatChangeNote(added, removed []string) string {
+	if len(added) == 0 && len(removed) == 0 {
+		return "\n\n<i>🔄 Regenerated: same key points as before.</i>"
+	}
+	var b strings.Builder
+	b.WriteString("\n\n<i>🔄 What changed</i>")
+	list := func(symbol string, points []string) {
+		for i, point := range points {
+			if i == 3 {
+				fmt.Fprintf(&b, "\n%s … and %d more", symbol, len(points)-3)
+				break
+			}
+			fmt.Fprintf(&b, "\n%s %s", symbol, html.EscapeString(Truncate(point, 100)))
+		}
+	}
+	list("➕", added)
+	list("➖", removed)
+	return b.String()
+}
+
+// HandleRegenerateCallback answers the question behind an answer again ("rg:<message id>") and
+// notes how the new answer differs.
+func HandleRegenerateCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	messageID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	chatID := query.Message.Chat.ID
+	entry, ok := feedback.Get(FeedbackKey(chatID, messageID))
+	if !ok {
+		return AnswerCallback(bot, query, "This answer is too old to regenerate.")
+	}
+	if entry.UserID != 0 && entry.UserID != query.From.ID {
+		return AnswerCallback(bot, query, "Only the person who asked can regenerate this answer.")
+	}
+	if OverQuota(query.From.ID) {
+		return AnswerCallback(bot, query, QuotaMessage(query.From.ID))
+	}
+	if err := AnswerCallback(bot, query, "Regenerating…"); err != nil {
+		return err
+	}
+
+	// The backend call runs on the worker pool so the update loop isn't held up
+	run := func() {
+		if err := regenerateAnswer(bot, chatID, messageID, entry); err != nil {
+			log.Printf("Error regenerating answer: %v", err)
+		}
+	}
+	if askPool == nil {
+		run()
+		return nil
+	}
+	askPool.Submit(&AskJob{Run: run})
+	return nil
+}
+
+func regenerateAnswer(bot *tgbotapi.BotAPI, chatID int64, messageID int, entry FeedbackEntry) error {
+	request := PromptRequest{
+		Question: entry.Question,
+		// A little warmer than the first answer, otherwise regenerating rarely changes anything
+		Temperature:  0.6,
+		SystemPrompt: SystemPrompt() + LanguageInstruction(ReplyLanguage(chatID, entry.UserID)),
+	}
+	ApplyStyle(&request, AnswerStyle(chatID, entry.UserID))
+	started := time.Now()
+	response, err := Ask(request)
+	AuditExchange(chatID, entry.UserID, "regenerate", request, response, err, started)
+	if err != nil {
+		return err
+	}
+	rawAnswer := response.Text()
+	added, removed := DiffKeyPoints(entry.Answer, rawAnswer)
+
+	if err := RecordAnswer(chatID, messageID, entry.UserID, entry.Question, rawAnswer); err != nil {
+		log.Printf("Error recording regenerated answer: %v", err)
+	}
+	answer := AnswerHTML(rawAnswer) + FormatChangeNote(added, removed)
+	keyboard := AnswerKeyboard(messageID)
+	mode := GetChatSettings(chatID).LinkPreview
+	return EditMessageHTML(bot, chatID, messageID, answer, LinkPreviewFor(mode, answer), &keyboard)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	tableCellPadding = 6
+	tableScale       = 2
+	maxTableRows     = 60
+	maxTableCellLen  = 40
+)
+
+var (
+	tableSeparatorPattern = regexp.MustCompile(`^\|?\s*:?-{2,}:?\s*(\|\s*:?-{2,}:?\s*)*\|?$`)
+	displayMathPattern    = regexp.MustCompile(`(?s)\$\$(.+?)\$\$|\\\[(.+?)\\\]`)
+	cellMarkupPattern     = regexp.MustCompile("[*_`~]+")
+)
+
+// ExtractTables returns the Markdown tables in an answer as rows of cells, skipping code blocks.
+func ExtractTables(markdown string) [][][]string {
+	var tables [][][]string
+	var current [][]string
+	inCode := false
+
+	flush := func() {
+		// A table needs a header row, the separator, and at least one body row
+		if len(current) >= 2 {
+			tables = append(tables, current)
+		}
+		current = nil
+	}
+
+	lines := strings.Split(markdown, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			flush()
+			continue
+		}
+		if inCode || !strings.HasPrefix(line, "|") {
+			flush()
+			continue
+		}
+		if tableSeparatorPattern.MatchString(line) {
+			continue
+		}
+		// Only start a table at a header row that is followed by a separator
+		if current == nil && (i+1 >= len(lines) || !tableSeparatorPattern.MatchString(strings.TrimSpace(lines[i+1]))) {
+			continue
+		}
+		current = append(current, splitTableRow(line))
+	}
+	flush()
+	return tables
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = Truncate(strings.TrimSpace(cellMarkupPattern.ReplaceAllString(cell, "")), maxTableCellLen)
+	}
+	return cells
+}
+
+// RenderTablePNG rasterizes a table with a monospace font, header row in bold.
+func RenderTablePNG(rows [][]string) ([]byte, error) {
+	if len(rows) > maxTableRows {
+		rows = rows[:maxTableRows]
+	}
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	widths := make([]int, columns)
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := len([]rune(cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	face := basicfont.Face7x13
+	charWidth := face.Advance
+	rowHeight := face.Height + 2*tableCellPadding
+	columnX := make([]int, columns+1)
+	for i, width := range widths {
+		columnX[i+1] = columnX[i] + width*charWidth + 2*tableCellPadding
+	}
+	width, height := columnX[columns]+1, len(rows)*rowHeight+1
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	headerBackground := image.NewUniform(color.RGBA{0xee, 0xee, 0xf2, 0xff})
+	draw.Draw(img, image.Rect(0, 0, width, rowHeight), headerBackground, image.Point{}, draw.Src)
+
+	grid := color.RGBA{0xbb, 0xbb, 0xc4, 0xff}
+	for r := 0; r <= len(rows); r++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, r*rowHeight, grid)
+		}
+	}
+	for _, x := range columnX {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, grid)
+		}
+	}
+
+	drawer := &font.Drawer{Dst: img, Src: image.Black, Face: face}
+	for r, row := range rows {
+		baseline := r*rowHeight + tableCellPadding + face.Ascent
+		for c, cell := range row {
+			x := columnX[c] + tableCellPadding
+			drawer.Dot = fixed.P(x, baseline)
+			drawer.DrawString(cell)
+			if r == 0 {
+				// Faux bold: draw the header again one pixel to the right
+				drawer.Dot = fixed.P(x+1, baseline)
+				drawer.DrawString(cell)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, tableScale)); err != nil {
+		return nil, fmt.Errorf("error encoding table image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleImage enlarges img by an integer factor with nearest-neighbour sampling, keeping the bitmap font crisp.
+func scaleImage(img *image.RGBA, factor int) *image.RGBA {
+	bounds := img.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*factor, bounds.Dy()*factor))
+	for y := 0; y < scaled.Bounds().Dy(); y++ {
+		for x := 0; x < scaled.Bounds().Dx(); x++ {
+			scaled.Set(x, y, img.At(x/factor, y/factor))
+		}
+	}
+	return scaled
+}
+
+// ExtractFormulas returns display math ($$...$$ or \[...\]) from an answer. Inline $...$ is
+// left alone since it is indistinguishable from prices.
+func ExtractFormulas(markdown string) []string {
+	var formulas []string
+	for _, match := range displayMathPattern.FindAllStringSubmatch(markdown, -1) {
+		formula := strings.TrimSpace(match[1] + match[2])
+		if formula != "" {
+			formulas = append(formulas, formula)
+		}
+	}
+	return formulas
+}
+
+// LatexRenderURL is the LaTeX-to-PNG service; the URL-escaped formula is appended to it,
+// e.g. "https://latex.example.com/png?". Formulas aren't rendered when it is empty.
+func LatexRenderURL() string {
+	return GetenvVar("LATEX_RENDER_URL", false)
+}
+
+func RenderFormulaPNG(formula string) ([]byte, error) {
+	resp, err := HTTPClient(15 * time.Second).Get(LatexRenderURL() + url.QueryEscape(formula))
+	if err != nil {
+		return nil, fmt.Errorf("error rendering formula: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected LaTeX renderer status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+}
+
+// AnswerImages renders the tables and formulas in an answer, in that order.
+func AnswerImages(markdown string) [][]byte {
+	var images [][]byte
+	for _, table := range ExtractTables(markdown) {
+		rendered, err := RenderTablePNG(table)
+		if err != nil {
+			continue
+		}
+		images = append(images, rendered)
+	}
+	if LatexRenderURL() != "" {
+		for _, formula := range ExtractFormulas(markdown) {
+			rendered, err := RenderFormulaPNG(formula)
+			if err != nil {
+				log.Printf("Error rendering formula: %v", err)
+				continue
+			}
+			images = append(images, rendered)
+		}
+	}
+	return images
+}
+
+// SendAnswerImages attaches rendered tables and formulas under the answer message.
+func SendAnswerImages(bot *tgbotapi.BotAPI, chatID int64, answerMessageID int, markdown string) error {
+	images := AnswerImages(markdown)
+	if len(images) == 0 {
+		return nil
+	}
+	if len(images) == 1 {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "answer.png", Bytes: images[0]})
+		photo.ReplyToMessageID = answerMessageID
+		_, err := bot.Send(photo)
+		return err
+	}
+
+	// Media groups hold at most 10 items
+	if len(images) > 10 {
+		images = images[:10]
+	}
+	var media []interface{}
+	for i, rendered := range images {
+		media = append(media, tgbotapi.NewInputMediaPhoto(tgbotapi.FileBytes{Name: fmt.Sprintf("answer-%d.png", i+1), Bytes: rendered}))
+	}
+	group := tgbotapi.NewMediaGroup(chatID, media)
+	group.ReplyToMessageID = answerMessageID
+	_, err := bot.SendMediaGroup(group)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// RouteInfo describes one route of administration of a substance.
+type RouteInfo struct {
+	// Name matches the route names used by the dosage sources, e.g. "Oral" or "Insufflated".
+	Name            string
+	Bioavailability string
+	Onset           string
+	Tips            []string
+}
+
+// substanceRoutes lists the commonly used routes per substance, most common first, curated from
+// harm reduction factsheets. Bioavailability figures are rough ranges from the literature.
+var substanceRoutes = map[string][]RouteInfo{
+	"mdma": {
+		{"Oral", "high, most of the dose is absorbed", "30–60 min, up to 2h on a full stomach", []string{"Wait at least 2 hours before redosing: a slow come-up isn't a weak pill", "Crush or weigh crystals instead of guessing by eye"}},
+		{"Insufflated", "similar to oral, but absorbed unevenly", "10–20 min, harsher and shorter", []string{"Very painful and damaging to the nose", "Makes the dose harder to control"}},
+	},
+	"lsd": {
+		{"Sublingual", "about 70%", "20–60 min", []string{"Hold the blotter under the tongue for 10–15 minutes", "Bitter or numbing blotters may be NBOMe: test them"}},
+		{"Oral", "about 70%", "30–90 min", []string{"Wait at least 2 hours before considering more, onset varies a lot"}},
+	},
+	"psilocybin": {
+		{"Oral", "about 50%", "20–60 min", []string{"Weigh dried mushrooms, potency varies between batches", "Ginger or tea can ease the come-up nausea"}},
+		{"Lemon tek", "same, absorbed faster", "10–20 min, more intense and shorter", []string{"Use a lower dose than you would eat"}},
+	},
+	"cannabis": {
+		{"Smoked", "about 10–35%", "seconds to minutes", []string{"Vaporizing avoids most of the tar and carbon monoxide", "Avoid mixing with tobacco"}},
+		{"Vaporized", "about 10–35%", "seconds to minutes", []string{"Start with a lower temperature and a single draw"}},
+		{"Oral", "about 5–20%", "30 min to 2h", []string{"Wait at least 2 hours before eating more: edibles are the most common way to take too much", "Effects last much longer than smoking"}},
+	},
+	"cocaine": {
+		{"Insufflated", "about 30–60%", "5–15 min", []string{"Use your own straw or tube, shared ones spread hepatitis C", "Rinse your nose with water afterwards"}},
+		{"Smoked", "about 70% (as crack or freebase)", "seconds", []string{"Use a heat-resistant pipe with a mouthpiece to avoid burns", "The very short high drives compulsive redosing"}},
+		{"Oral", "about 30%", "10–30 min", []string{"Rubbing on the gums numbs them but gives little effect"}},
+	},
+	"ketamine": {
+		{"Insufflated", "about 45–50%", "5–15 min", []string{"Start with a small bump, the window between a dose and a K-hole is narrow", "Sit or lie down somewhere safe, falls are common"}},
+		{"Oral", "about 15–20%", "15–30 min", []string{"Much of the dose is converted to norketamine, so effects differ from snorting"}},
+		{"Intramuscular", "above 90%", "2–5 min", []string{"Use new, sterile needles only and never share", "Doses are far smaller than snorted doses"}},
+	},
+	"amphetamine": {
+		{"Oral", "about 75–90%", "30–60 min", []string{"Eat, drink water and plan to sleep well before the next day", "Wrapping in paper (\"bombing\") is gentler on the nose"}},
+		{"Insufflated", "high", "5–15 min", []string{"Use your own straw, rinse your nose afterwards", "Redosing through the night makes the comedown much worse"}},
+	},
+	"2c-b": {
+		{"Oral", "not well studied", "45–75 min", []string{"Dose by weight, the difference between light and strong is a few milligrams"}},
+		{"Insufflated", "not well studied", "5–10 min", []string{"Extremely painful, and dosing is much harder to control"}},
+	},
+	"ghb": {
+		{"Oral", "about 25%, higher on an empty stomach", "15–30 min", []string{"Measure with a syringe or pipette, never by guessing", "Wait at least 2 hours before redosing, note the time of every dose", "Never combine with alcohol or other depressants"}},
+	},
+	"opioids": {
+		{"Smoked", "varies, roughly 40–50% for heroin", "seconds", []string{"Smoking carries a lower overdose risk than injecting", "Have naloxone nearby and don't use alone"}},
+		{"Insufflated", "varies, roughly 50% for heroin", "5–10 min", []string{"Use your own straw", "Test for fentanyl with test strips"}},
+		{"Intravenous", "100%", "seconds", []string{"Use new, sterile equipment every time and never share", "Start with a test shot of a new batch", "Have naloxone ready and someone with you"}},
+	},
+}
+
+// FormatRoutes renders the routes of a substance with a dosage button per route.
+func FormatRoutes(key string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s: routes of administration</b>\n", html.EscapeString(substances[key].Name))
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, route := range substanceRoutes[key] {
+		fmt.Fprintf(&b, "\n<b>%s</b>\nBioavailability: %s\nOnset: %s\n", html.EscapeString(route.Name),
+			html.EscapeString(route.Bioavailability), html.EscapeString(route.Onset))
+		for _, tip := range route.Tips {
+			fmt.Fprintf(&b, "• %s\n", html.EscapeString(tip))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⚖️ "+route.Name+" dosage", "roa:"+key+":"+strconv.Itoa(i))))
+	}
+	b.WriteString("\n<i>Switching routes changes the dose needed: never reuse a dose from another route.</i>")
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return b.String(), &markup
+}
+
+// FormatRouteDoses renders the dosage of one route from the configured sources.
+func FormatRouteDoses(key string, route RouteInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s · %s dosage</b>\n", html.EscapeString(substances[key].Name), html.EscapeString(route.Name))
+	doses, source, err := GetSubstanceDoses(key)
+	found := false
+	if err == nil {
+		for _, dose := range doses {
+			if strings.EqualFold(dose.Route, route.Name) {
+				fmt.Fprintf(&b, "%s: %s\n", html.EscapeString(dose.Level), html.EscapeString(dose.Amount))
+				found = true
+			}
+		}
+	}
+	if !found {
+		b.WriteString("I don't have dosage data for this route. Ask me about it instead, and start low.\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "<i>Source: %s</i>", html.EscapeString(source))
+	return b.String()
+}
+
+func HandleRoaCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(args)
+	if name == "" {
+		return SendHTML(bot, chatID, "Usage: /roa &lt;substance&gt;, e.g. /roa ketamine")
+	}
+	matches := ResolveSubstance(name)
+	if len(matches) == 0 || matches[0].Confidence < ConfidentMatch {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't know the substance <b>%s</b>.", html.EscapeString(name)))
+	}
+	key := matches[0].Key
+	if _, ok := substanceRoutes[key]; !ok {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't have route information for <b>%s</b> yet. Try asking me about it instead.",
+			html.EscapeString(substances[key].Name)))
+	}
+
+	text, markup := FormatRoutes(key)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = *markup
+	_, err := bot.Send(msg)
+	return err
+}
+
+// HandleRoaCallback shows the dosage of a route ("roa:<substance>:<route index>") or goes back
+// to the overview ("roa:<substance>").
+func HandleRoaCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) == 0 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	routes, ok := substanceRoutes[args[0]]
+	if !ok {
+		return AnswerCallback(bot, query, "Unknown substance.")
+	}
+
+	text, markup := FormatRoutes(args[0])
+	if len(args) == 2 {
+		index, err := strconv.Atoi(args[1])
+		if err != nil || index < 0 || index >= len(routes) {
+			return AnswerCallback(bot, query, "")
+		}
+		text = FormatRouteDoses(args[0], routes[index])
+		back := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ All routes", "roa:"+args[0])))
+		markup = &back
+	}
+	if err := EditMessageHTML(bot, query.Message.Chat.ID, query.Message.MessageID, text, nil, markup); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "")
+}
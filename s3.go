@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Client is a minimal path-style client for S3-compatible object storage (AWS, MinIO, R2, ...).
+type S3Client struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3ClientFromEnv configures a client from S3_ENDPOINT, S3_REGION, S3_ACCESS_KEY and S3_SECRET_KEY.
+func S3ClientFromEnv() *S3Client {
+	region := GetenvVar("S3_REGION", false)
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Client{
+		Endpoint:  strings.TrimSuffix(GetenvVar("S3_ENDPOINT", false), "/"),
+		Region:    region,
+		AccessKey: GetenvVar("S3_ACCESS_KEY", false),
+		SecretKey: GetenvVar("S3_SECRET_KEY", false),
+	}
+}
+
+func (c *S3Client) PutObject(bucket, key string, body []byte) error {
+	resp, err := c.do("PUT", bucket, key, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("error uploading s3://%s/%s: %s %s", bucket, key, resp.Status, message)
+	}
+	return nil
+}
+
+func (c *S3Client) GetObject(bucket, key string) ([]byte, error) {
+	resp, err := c.do("GET", bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("error downloading s3://%s/%s: %s %s", bucket, key, resp.Status, message)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *S3Client) DeleteObject(bucket, key string) error {
+	resp, err := c.do("DELETE", bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("error deleting s3://%s/%s: %s %s", bucket, key, resp.Status, message)
+	}
+	return nil
+}
+
+// do sends a request signed with AWS Signature Version 4.
+func (c *S3Client) do(method, bucket, key string, body []byte) (*http.Response, error) {
+	if c.Endpoint == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT is not configured")
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT: %w", err)
+	}
+
+	path := "/" + bucket + "/" + strings.TrimPrefix(key, "/")
+	req, err := http.NewRequest(method, c.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 request: %w", err)
+	}
+	req.Header.Set("Host", endpoint.Host)
+	signAWSv4(req, body, "s3", c.Region, c.AccessKey, c.SecretKey, time.Now())
+
+	return HTTPClient(5 * time.Minute).Do(req)
+}
+
+// signAWSv4 signs a request without query parameters with AWS Signature Version 4. The
+// signature covers the Host header and every X-Amz-* header.
+func signAWSv4(req *http.Request, body []byte, service, region, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.Header.Get("Host")
+			}
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := (&url.URL{Path: req.URL.Path}).EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, "", canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), day)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where a safety event came from.
+const (
+	SafetyOriginGate    = "gate"
+	SafetyOriginBackend = "backend"
+	// SafetyOriginDoseCheck counts answers whose doses diverged from the factsheet
+	SafetyOriginDoseCheck = "dosecheck"
+	// SafetyOriginRecheck counts answers the safety re-check flagged
+	SafetyOriginRecheck = "recheck"
+)
+
+// SafetyEvent is a refused request, logged as one JSON line and counted in the daily stats.
+type SafetyEvent struct {
+	At       time.Time `json:"at"`
+	Origin   string    `json:"origin"`
+	Category string    `json:"category"`
+	// Rule is the gate rule or moderation category that matched
+	Rule     string `json:"rule"`
+	User     string `json:"user"`
+	ChatType string `json:"chat_type"`
+}
+
+// safetyKey is how events are counted in DailyStats.Safety.
+func (e SafetyEvent) safetyKey() string {
+	return e.Origin + "/" + e.Category + "/" + e.Rule
+}
+
+// RecordSafetyEvent logs a refusal without the question itself and adds it to today's stats.
+func RecordSafetyEvent(userID int64, event SafetyEvent) error {
+	event.User = HashUserID(userID)
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if line, err := json.Marshal(event); err == nil {
+		log.Printf("Safety event: %s", line)
+	}
+	if dailyStats == nil {
+		return nil
+	}
+	return dailyStats.Update(statsDay(event.At), func(day DailyStats) DailyStats {
+		counts := map[string]int{event.safetyKey(): 1}
+		for key, count := range day.Safety {
+			counts[key] += count
+		}
+		day.Safety = counts
+		return day
+	})
+}
+
+// BackendRefusalEvent describes a refusal by the backend, by its moderation categories when
+// it flagged the question.
+func BackendRefusalEvent(response *PromptResponse, chatType string) SafetyEvent {
+	event := SafetyEvent{Origin: SafetyOriginBackend, Category: "refusal", Rule: "refusal", ChatType: chatType}
+	if response.Moderation != nil && response.Moderation.Flagged {
+		event.Category = "moderation"
+		event.Rule = "unspecified"
+		if len(response.Moderation.Categories) > 0 {
+			categories := append([]string{}, response.Moderation.Categories...)
+			sort.Strings(categories)
+			event.Rule = strings.ToLower(strings.Join(categories, "+"))
+		}
+	}
+	return event
+}
+
+// FormatSafetyStats lists safety events by origin and category, with the most frequent rules.
+func FormatSafetyStats(safety map[string]int) string {
+	if len(safety) == 0 {
+		return ""
+	}
+	total := 0
+	categories := map[string]int{}
+	type ruleCount struct {
+		key   string
+		count int
+	}
+	var rules []ruleCount
+	for key, count := range safety {
+		total += count
+		origin, rest, _ := strings.Cut(key, "/")
+		category, _, _ := strings.Cut(rest, "/")
+		categories[origin+"/"+category] += count
+		rules = append(rules, ruleCount{key, count})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].count != rules[j].count {
+			return rules[i].count > rules[j].count
+		}
+		return rules[i].key < rules[j].key
+	})
+
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s (%d)", html.EscapeString(name), categories[name])
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Safety events: %d · %s\n", total, strings.Join(parts, ", "))
+	top := make([]string, 0, 3)
+	for _, rule := range rules[:min(len(rules), 3)] {
+		top = append(top, fmt.Sprintf("%s (%d)", html.EscapeString(rule.key), rule.count))
+	}
+	fmt.Fprintf(&b, "Top rules: %s\n", strings.Join(top, ", "))
+	return b.String()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlTagPattern       = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z][a-zA-Z0-9-]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?)*)\s*/?>`)
+	htmlAttributePattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9-]*)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+	htmlEntityPattern    = regexp.MustCompile(`^&(?:amp|lt|gt|quot|#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+	namedEntityPattern   = regexp.MustCompile(`^&[a-zA-Z][a-zA-Z0-9]{1,31};`)
+	codeLanguagePattern  = regexp.MustCompile(`^language-[a-zA-Z0-9_+#-]{1,30}$`)
+	emojiIDPattern       = regexp.MustCompile(`^[0-9]{1,20}$`)
+)
+
+// htmlTagAliases are the synonyms Telegram accepts, written in their short form.
+var htmlTagAliases = map[string]string{"strong": "b", "em": "i", "ins": "u", "strike": "s", "del": "s"}
+
+// telegramTags are the tags Telegram's HTML parse mode supports.
+var telegramTags = map[string]bool{
+	"b": true, "i": true, "u": true, "s": true, "tg-spoiler": true, "span": true, "a": true,
+	"code": true, "pre": true, "blockquote": true, "tg-emoji": true,
+}
+
+// htmlSafeSchemes are the link schemes kept in href attributes.
+var htmlSafeSchemes = map[string]bool{"http": true, "https": true, "tg": true, "mailto": true}
+
+// openTag is a tag left open by the sanitizer. Dropped tags were recognized but not written, so
+// their closing tag must be swallowed too.
+type openTag struct {
+	name    string
+	open    string
+	dropped bool
+}
+
+// SanitizeTelegramHTML makes text safe to send with Telegram's HTML parse mode. Supported tags
+// keep only the attributes Telegram understands, <br> becomes a line break, other tags and stray
+// <, > and & are escaped, links with unsafe schemes lose their tag but keep their text, and tags
+// are balanced: crossing tags are closed and reopened, unclosed ones are closed at the end.
+func SanitizeTelegramHTML(text string) string {
+	var b strings.Builder
+	var stack []openTag
+
+	// literal is true inside code and pre, where Telegram allows no other tags
+	literal := func() bool {
+		for _, tag := range stack {
+			if !tag.dropped && (tag.name == "code" || tag.name == "pre") {
+				return true
+			}
+		}
+		return false
+	}
+	inside := func(name string) bool {
+		for _, tag := range stack {
+			if !tag.dropped && tag.name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for len(text) > 0 {
+		switch text[0] {
+		case '&':
+			if entity := htmlEntityPattern.FindString(text); entity != "" {
+				b.WriteString(entity)
+				text = text[len(entity):]
+			} else if entity := namedEntityPattern.FindString(text); entity != "" && html.UnescapeString(entity) != entity {
+				// Telegram only knows the four named entities above, so others are written out
+				b.WriteString(html.EscapeString(html.UnescapeString(entity)))
+				text = text[len(entity):]
+			} else {
+				b.WriteString("&amp;")
+				text = text[1:]
+			}
+			continue
+		case '>':
+			b.WriteString("&gt;")
+			text = text[1:]
+			continue
+		case '<':
+		default:
+			next := strings.IndexAny(text, "<>&")
+			if next < 0 {
+				next = len(text)
+			}
+			b.WriteString(text[:next])
+			text = text[next:]
+			continue
+		}
+
+		match := htmlTagPattern.FindStringSubmatch(text)
+		if match == nil {
+			b.WriteString("&lt;")
+			text = text[1:]
+			continue
+		}
+		raw, closing, attributes := match[0], match[1] == "/", match[3]
+		name := strings.ToLower(match[2])
+		if alias, ok := htmlTagAliases[name]; ok {
+			name = alias
+		}
+		text = text[len(raw):]
+
+		if name == "br" && !literal() {
+			b.WriteString("\n")
+			continue
+		}
+		if !telegramTags[name] {
+			b.WriteString(html.EscapeString(raw))
+			continue
+		}
+
+		if closing {
+			index := -1
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].name == name {
+					index = i
+					break
+				}
+			}
+			// Inside code only the innermost tag can be closed, anything else is text
+			if literal() && index != len(stack)-1 && !(name == "pre" && index == len(stack)-2 && stack[len(stack)-1].name == "code") {
+				b.WriteString(html.EscapeString(raw))
+				continue
+			}
+			if index < 0 {
+				continue
+			}
+			for i := len(stack) - 1; i >= index; i-- {
+				if !stack[i].dropped {
+					b.WriteString("</" + stack[i].name + ">")
+				}
+			}
+			reopened := stack[index+1:]
+			stack = stack[:index]
+			for _, tag := range reopened {
+				if !tag.dropped {
+					b.WriteString(tag.open)
+				}
+				stack = append(stack, tag)
+			}
+			continue
+		}
+
+		if literal() {
+			// Telegram only allows <code class="language-…"> directly inside <pre>
+			top := stack[len(stack)-1]
+			if !(name == "code" && top.name == "pre" && !top.dropped) {
+				b.WriteString(html.EscapeString(raw))
+				continue
+			}
+		}
+		open, ok := sanitizedOpenTag(name, attributes, stack)
+		if ok && name == "blockquote" && inside("blockquote") {
+			ok = false
+		}
+		stack = append(stack, openTag{name: name, open: open, dropped: !ok})
+		if ok {
+			b.WriteString(open)
+		}
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		if !stack[i].dropped {
+			b.WriteString("</" + stack[i].name + ">")
+		}
+	}
+	return b.String()
+}
+
+// sanitizedOpenTag rewrites an opening tag with only its allowed attributes. It reports false for
+// tags that can't be kept: links without a safe URL, spans that aren't spoilers and custom emoji
+// without an ID.
+func sanitizedOpenTag(name, attributes string, stack []openTag) (string, bool) {
+	values := map[string]string{}
+	for _, match := range htmlAttributePattern.FindAllStringSubmatch(attributes, -1) {
+		value := match[2]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.ToLower(match[1])] = html.UnescapeString(value)
+	}
+
+	switch name {
+	case "a":
+		href := strings.TrimSpace(values["href"])
+		parsed, err := url.Parse(href)
+		if href == "" || err != nil || !htmlSafeSchemes[strings.ToLower(parsed.Scheme)] {
+			return "", false
+		}
+		return `<a href="` + html.EscapeString(href) + `">`, true
+	case "span":
+		if values["class"] != "tg-spoiler" {
+			return "", false
+		}
+		return `<span class="tg-spoiler">`, true
+	case "code":
+		language := values["class"]
+		if len(stack) > 0 && stack[len(stack)-1].name == "pre" && codeLanguagePattern.MatchString(language) {
+			return `<code class="` + html.EscapeString(language) + `">`, true
+		}
+		return "<code>", true
+	case "blockquote":
+		if _, ok := values["expandable"]; ok {
+			return "<blockquote expandable>", true
+		}
+		return "<blockquote>", true
+	case "tg-emoji":
+		id := values["emoji-id"]
+		if !emojiIDPattern.MatchString(id) {
+			return "", false
+		}
+		return `<tg-emoji emoji-id="` + id + `">`, true
+	default:
+		return "<" + name + ">", true
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// schedulerTick is how often the scheduler looks for due jobs.
+const schedulerTick = 15 * time.Second
+
+// Schedule decides when a job is next due after a time.
+type Schedule interface {
+	Next(after time.Time) time.Time
+	String() string
+}
+
+// Every runs a job at every multiple of the interval, e.g. on the hour for time.Hour.
+type Every time.Duration
+
+func (e Every) Next(after time.Time) time.Time {
+	return after.Truncate(time.Duration(e)).Add(time.Duration(e))
+}
+
+func (e Every) String() string { return "every " + FormatDuration(time.Duration(e)) }
+
+// DailyAt runs a job every day at an hour, UTC.
+type DailyAt int
+
+func (d DailyAt) Next(after time.Time) time.Time {
+	after = after.UTC()
+	next := time.Date(after.Year(), after.Month(), after.Day(), int(d), 0, 0, 0, time.UTC)
+	if !next.After(after) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+func (d DailyAt) String() string { return fmt.Sprintf("daily at %02d:00 UTC", int(d)) }
+
+// Job is a recurring task run by the scheduler. Run gets the time the run was due, before
+// jitter, so jobs covering a period (like the nightly feedback export) can tell which one.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	// Jitter is the most a run is randomly delayed by, to spread load on shared services
+	Jitter time.Duration
+	// Retries is how often a failed run is tried again, after RetryDelay doubling each time
+	Retries    int
+	RetryDelay time.Duration
+	// RunAtStart runs the job as soon as the scheduler starts the first time, instead of waiting
+	// for its schedule
+	RunAtStart bool
+	Run        func(due time.Time) error
+}
+
+// JobState is what the scheduler persists about a job, so schedules, retries and cancellations
+// survive restarts.
+type JobState struct {
+	// Due is when the pending run was due, NextRun when it will start after jitter or backoff
+	Due       time.Time `json:"due"`
+	NextRun   time.Time `json:"next_run"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+	Cancelled bool      `json:"cancelled,omitempty"`
+}
+
+var (
+	jobStates *JSONStore[JobState]
+
+	jobsMu      sync.Mutex
+	jobs        = map[string]*Job{}
+	runningJobs = map[string]bool{}
+)
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// ScheduleJob registers a job. A job scheduled for the first time starts at its next due time,
+// or right away with RunAtStart; otherwise it picks up the persisted state, running at once if
+// it was due while the bot was down.
+func ScheduleJob(job Job) {
+	jobsMu.Lock()
+	jobs[job.Name] = &job
+	jobsMu.Unlock()
+
+	if _, ok := jobStates.Get(job.Name); ok {
+		return
+	}
+	now := time.Now()
+	state := JobState{Due: job.Schedule.Next(now)}
+	if job.RunAtStart {
+		state.Due = now
+	}
+	state.NextRun = state.Due.Add(jitter(job.Jitter))
+	if err := jobStates.Set(job.Name, state); err != nil {
+		log.Printf("Error saving state of job %s: %v", job.Name, err)
+	}
+}
+
+// StartScheduler runs due jobs until the process exits, each job at most once at a time.
+func StartScheduler() {
+	go func() {
+		for {
+			RunDueJobs(time.Now())
+			time.Sleep(schedulerTick)
+		}
+	}()
+}
+
+// RunDueJobs starts the jobs whose next run has come.
+func RunDueJobs(now time.Time) {
+	jobsMu.Lock()
+	var due []*Job
+	for name, job := range jobs {
+		state, ok := jobStates.Get(name)
+		if !ok || state.Cancelled || runningJobs[name] || state.NextRun.After(now) {
+			continue
+		}
+		runningJobs[name] = true
+		due = append(due, job)
+	}
+	jobsMu.Unlock()
+
+	for _, job := range due {
+		go runJob(job)
+	}
+}
+
+func runJob(job *Job) {
+	defer func() {
+		jobsMu.Lock()
+		delete(runningJobs, job.Name)
+		jobsMu.Unlock()
+	}()
+
+	state, _ := jobStates.Get(job.Name)
+	runErr := job.Run(state.Due)
+	if runErr != nil {
+		log.Printf("Job %s failed: %v", job.Name, runErr)
+	}
+	now := time.Now()
+
+	err := jobStates.Update(job.Name, func(state JobState) JobState {
+		state.LastRun = now
+		if runErr == nil {
+			state.LastError = ""
+			state.Attempt = 0
+			state.Failures = 0
+		} else {
+			state.LastError = runErr.Error()
+			state.Failures++
+			if state.Attempt < job.Retries {
+				state.NextRun = now.Add(job.RetryDelay << state.Attempt)
+				state.Attempt++
+				return state
+			}
+			state.Attempt = 0
+		}
+		state.Due = job.Schedule.Next(now)
+		state.NextRun = state.Due.Add(jitter(job.Jitter))
+		return state
+	})
+	if err != nil {
+		log.Printf("Error saving state of job %s: %v", job.Name, err)
+	}
+}
+
+// CancelJob stops a job from running until it is resumed; it stays cancelled across restarts.
+func CancelJob(name string) error {
+	return updateJob(name, func(state *JobState) { state.Cancelled = true })
+}
+
+// ResumeJob lets a cancelled job run again from its next due time.
+func ResumeJob(name string) error {
+	job, ok := findJob(name)
+	if !ok {
+		return fmt.Errorf("no job named %q", name)
+	}
+	return updateJob(name, func(state *JobState) {
+		state.Cancelled = false
+		state.Attempt = 0
+		state.Due = job.Schedule.Next(time.Now())
+		state.NextRun = state.Due
+	})
+}
+
+// RunJobNow makes a job due at once.
+func RunJobNow(name string) error {
+	return updateJob(name, func(state *JobState) {
+		state.Due = time.Now()
+		state.NextRun = state.Due
+	})
+}
+
+func findJob(name string) (*Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[name]
+	return job, ok
+}
+
+func updateJob(name string, fn func(state *JobState)) error {
+	if _, ok := findJob(name); !ok {
+		return fmt.Errorf("no job named %q", name)
+	}
+	return jobStates.Update(name, func(state JobState) JobState {
+		fn(&state)
+		return state
+	})
+}
+
+// FormatJobs lists the registered jobs with their schedule and state.
+func FormatJobs(now time.Time) string {
+	jobsMu.Lock()
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	running := map[string]bool{}
+	for name := range runningJobs {
+		running[name] = true
+	}
+	jobsMu.Unlock()
+	if len(names) == 0 {
+		return "No scheduled jobs."
+	}
+	sort.Strings(names)
+
+	lines := []string{"<b>Scheduled jobs</b>"}
+	for _, name := range names {
+		job, _ := findJob(name)
+		state, _ := jobStates.Get(name)
+		status := "next in " + FormatDuration(max(state.NextRun.Sub(now), 0))
+		switch {
+		case state.Cancelled:
+			status = "cancelled"
+		case running[name]:
+			status = "running"
+		case state.Attempt > 0:
+			status = fmt.Sprintf("retry %d/%d in %s", state.Attempt, job.Retries, FormatDuration(max(state.NextRun.Sub(now), 0)))
+		}
+		line := fmt.Sprintf("<code>%s</code> · %s · %s", name, job.Schedule, status)
+		if !state.LastRun.IsZero() {
+			line += " · last " + state.LastRun.UTC().Format("2006-01-02 15:04")
+		}
+		if state.LastError != "" {
+			line += fmt.Sprintf("\n<i>%d failures: %s</i>", state.Failures, html.EscapeString(Truncate(state.LastError, 150)))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, jobsUsage)
+	return strings.Join(lines, "\n\n")
+}
+
+const jobsUsage = "Usage:\n/jobs — list scheduled jobs\n/jobs cancel &lt;name&gt;\n/jobs resume &lt;name&gt;\n/jobs run &lt;name&gt;"
+
+// HandleJobsCommand lets bot admins list, cancel, resume and trigger scheduled jobs.
+func HandleJobsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return SendHTML(bot, chatID, FormatJobs(time.Now()))
+	}
+	if len(fields) != 2 {
+		return SendHTML(bot, chatID, jobsUsage)
+	}
+	var err error
+	var reply string
+	switch fields[0] {
+	case "cancel":
+		err = CancelJob(fields[1])
+		reply = "Cancelled. /jobs resume " + fields[1] + " to run it again."
+	case "resume":
+		err = ResumeJob(fields[1])
+		reply = "Resumed."
+	case "run":
+		err = RunJobNow(fields[1])
+		reply = "The job will run within a few seconds."
+	default:
+		return SendHTML(bot, chatID, jobsUsage)
+	}
+	if err != nil {
+		return SendHTML(bot, chatID, html.EscapeString(err.Error()))
+	}
+	return SendHTML(bot, chatID, html.EscapeString(reply))
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// minRedactedLength keeps short values like "true" or "30" from being redacted everywhere.
+const minRedactedLength = 8
+
+var (
+	secretsMu sync.RWMutex
+	// fileSecrets caches the values read from *_FILE paths; rotating one needs a restart
+	fileSecrets = map[string]string{}
+	// managerSecrets are the values loaded from SECRETS_PROVIDER at startup
+	managerSecrets = map[string]string{}
+	redactions     []string
+)
+
+// lookupEnv returns a configuration value: the environment variable itself, else the contents
+// of the file named by <key>_FILE (Docker and Kubernetes secrets), else the value loaded from
+// the secret manager.
+func lookupEnv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return readSecretFile(key, path)
+	}
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return managerSecrets[key]
+}
+
+func readSecretFile(key, path string) string {
+	secretsMu.RLock()
+	value, ok := fileSecrets[key]
+	secretsMu.RUnlock()
+	if ok {
+		return value
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Error reading %s_FILE: %v", key, err)
+	}
+	value = strings.TrimRight(string(data), "\r\n")
+	secretsMu.Lock()
+	fileSecrets[key] = value
+	secretsMu.Unlock()
+	addRedaction(value)
+	return value
+}
+
+// isSecretName reports whether a variable holds a credential that must never be logged.
+func isSecretName(name string) bool {
+	if name == "TELETOKEN" {
+		return true
+	}
+	for _, suffix := range []string{"_TOKEN", "_KEY", "_SECRET", "_PASSWORD", "_DSN"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func addRedaction(value string) {
+	if len(value) < minRedactedLength {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, known := range redactions {
+		if known == value {
+			return
+		}
+	}
+	redactions = append(redactions, value)
+}
+
+// RedactSecrets replaces every known secret value in text, e.g. the bot token in the URL of a
+// failed Telegram request.
+func RedactSecrets(text string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range redactions {
+		text = strings.ReplaceAll(text, secret, "[redacted]")
+	}
+	return text
+}
+
+// redactingWriter is the log output, with secrets redacted.
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, RedactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// LoadSecrets loads the secrets from SECRETS_PROVIDER (vault or aws) and redacts every secret
+// value from the log, ours and tgbotapi's, from then on. Values set in the environment or through *_FILE win over the
+// secret manager's.
+func LoadSecrets() error {
+	var loaded map[string]string
+	var err error
+	switch provider := strings.ToLower(lookupEnv("SECRETS_PROVIDER")); provider {
+	case "":
+	case "vault":
+		loaded, err = loadVaultSecrets()
+	case "aws":
+		loaded, err = loadAWSSecrets()
+	default:
+		err = fmt.Errorf("unknown SECRETS_PROVIDER %q, expected vault or aws", provider)
+	}
+	if err != nil {
+		return err
+	}
+	if len(loaded) > 0 {
+		secretsMu.Lock()
+		managerSecrets = loaded
+		secretsMu.Unlock()
+		log.Printf("Loaded %d secrets from %s", len(loaded), lookupEnv("SECRETS_PROVIDER"))
+	}
+
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		name = strings.TrimSuffix(name, "_FILE")
+		if isSecretName(name) {
+			addRedaction(lookupEnv(name))
+		}
+	}
+	for name, value := range loaded {
+		if isSecretName(name) {
+			addRedaction(value)
+		}
+	}
+	log.SetOutput(redactingWriter{out: os.Stderr})
+	// tgbotapi has its own logger, which prints every request's parameters in debug mode
+	tgbotapi.SetLogger(log.New(redactingWriter{out: os.Stderr}, "", log.LstdFlags))
+	return nil
+}
+
+// loadVaultSecrets reads the key/value secret at VAULT_SECRET_PATH (e.g. "secret/data/psyai")
+// from VAULT_ADDR with VAULT_TOKEN, in KV version 1 or 2 format.
+func loadVaultSecrets() (map[string]string, error) {
+	addr, token, path := strings.TrimRight(lookupEnv("VAULT_ADDR"), "/"), lookupEnv("VAULT_TOKEN"), lookupEnv("VAULT_SECRET_PATH")
+	if addr == "" || token == "" || path == "" {
+		return nil, fmt.Errorf("SECRETS_PROVIDER=vault needs VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH")
+	}
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := lookupEnv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := HTTPClient(15 * time.Second).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets from Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected Vault status: %s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding Vault response: %w", err)
+	}
+	data := body.Data
+	// KV version 2 nests the values in data.data, next to data.metadata
+	if nested, ok := data["data"]; ok && data["metadata"] != nil {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return nil, fmt.Errorf("error decoding Vault secret: %w", err)
+		}
+	}
+	return stringValues(data), nil
+}
+
+// loadAWSSecrets reads AWS_SECRET_ID from AWS Secrets Manager, a secret whose value is a JSON
+// object of variable names and values. It signs with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN in AWS_REGION (default us-east-1).
+func loadAWSSecrets() (map[string]string, error) {
+	secretID, accessKey, secretKey := lookupEnv("AWS_SECRET_ID"), lookupEnv("AWS_ACCESS_KEY_ID"), lookupEnv("AWS_SECRET_ACCESS_KEY")
+	if secretID == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("SECRETS_PROVIDER=aws needs AWS_SECRET_ID, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	region := lookupEnv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager."+region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Secrets Manager request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if sessionToken := lookupEnv("AWS_SESSION_TOKEN"); sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+	signAWSv4(req, body, "secretsmanager", region, accessKey, secretKey, time.Now())
+
+	resp, err := HTTPClient(15 * time.Second).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets from Secrets Manager: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected Secrets Manager status: %s", resp.Status)
+	}
+	var result struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding Secrets Manager response: %w", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(result.SecretString), &values); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object of variables", secretID)
+	}
+	return stringValues(values), nil
+}
+
+// stringValues keeps the string values of a JSON object, ignoring nested ones.
+func stringValues(raw map[string]json.RawMessage) map[string]string {
+	values := map[string]string{}
+	for key, value := range raw {
+		var text string
+		if json.Unmarshal(value, &text) == nil {
+			values[key] = text
+		}
+	}
+	return values
+}
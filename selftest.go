@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Startup self-test checks.
+const (
+	SelfTestConfig     = "config"
+	SelfTestTelegram   = "telegram"
+	SelfTestBackend    = "backend"
+	SelfTestMigrations = "migrations"
+)
+
+// selfTestTimeout bounds each network check, so a hanging service fails the check instead of
+// holding up the start.
+const selfTestTimeout = 10 * time.Second
+
+// SelfTestResult is how one startup check went. A failed check only stops the start when it is
+// critical.
+type SelfTestResult struct {
+	Name     string
+	Critical bool
+	Detail   string
+	Err      error
+	Duration time.Duration
+}
+
+// optionalSelfTests are the checks in SELFTEST_OPTIONAL (comma-separated), which only warn when
+// they fail, e.g. "backend" to start while the backend is down for maintenance.
+func optionalSelfTests() map[string]bool {
+	optional := map[string]bool{}
+	for _, name := range strings.Split(GetenvVar("SELFTEST_OPTIONAL", false), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			optional[name] = true
+		}
+	}
+	return optional
+}
+
+// RunSelfTest validates the configuration, pings Telegram and the backend and checks the stores'
+// schema version. Stores must be open.
+func RunSelfTest(bot *tgbotapi.BotAPI, mode string) []SelfTestResult {
+	optional := optionalSelfTests()
+	checks := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{SelfTestConfig, func() (string, error) { return checkConfig(mode) }},
+		{SelfTestTelegram, func() (string, error) { return checkTelegram(bot) }},
+		{SelfTestBackend, checkBackend},
+		{SelfTestMigrations, checkMigrations},
+	}
+	results := make([]SelfTestResult, len(checks))
+	for i, check := range checks {
+		started := time.Now()
+		detail, err := check.run()
+		results[i] = SelfTestResult{Name: check.name, Critical: !optional[check.name], Detail: detail, Err: err, Duration: time.Since(started)}
+	}
+	return results
+}
+
+// checkConfig reports every configuration problem at once rather than the first one.
+func checkConfig(mode string) (string, error) {
+	var problems []string
+	if err := CheckProvider(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if value := GetenvVar("ADMIN_CHAT_ID", false); value != "" {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			problems = append(problems, fmt.Sprintf("ADMIN_CHAT_ID must be a chat ID, got %q", value))
+		}
+	}
+	if mode == "webhook" {
+		if parsed, err := url.Parse(GetenvVar("WEBHOOK_URL", false)); err != nil || parsed.Scheme != "https" {
+			problems = append(problems, "WEBHOOK_URL must be an https URL")
+		}
+		if _, err := webhookSecret(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, err := webhookAllowlist(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return fmt.Sprintf("provider %s, %s mode", ProviderName(), mode), nil
+}
+
+func checkTelegram(bot *tgbotapi.BotAPI) (string, error) {
+	me, err := bot.GetMe()
+	if err != nil {
+		return "", fmt.Errorf("getMe: %w", err)
+	}
+	return "@" + me.UserName, nil
+}
+
+// checkBackend asks the PsyAI backend's /health endpoint. The other providers have no health
+// endpoint, their key is checked by the first question.
+func checkBackend() (string, error) {
+	if ProviderName() != ProviderPsyAI {
+		return "skipped for LLM_PROVIDER=" + ProviderName(), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(GetenvVar("BASE_URL_BETA", false), "/")+"/health", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := BackendHTTPClient(selfTestTimeout).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GET /health: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET /health: %s", resp.Status)
+	}
+	return "healthy", nil
+}
+
+// checkMigrations fails while migrations are pending, since the stores would be read in an
+// encoding the code no longer writes.
+func checkMigrations() (string, error) {
+	latest := migrations[len(migrations)-1].Version
+	if version := SchemaVersion(); version < latest {
+		return "", fmt.Errorf("stores are at schema version %d, run the migrate command to upgrade to %d", version, latest)
+	}
+	return fmt.Sprintf("schema version %d", latest), nil
+}
+
+// SelfTestFailures names the critical checks that failed.
+func SelfTestFailures(results []SelfTestResult) []string {
+	var failed []string
+	for _, result := range results {
+		if result.Critical && result.Err != nil {
+			failed = append(failed, result.Name)
+		}
+	}
+	return failed
+}
+
+// FormatSelfTest summarizes the self-test for the admin chat.
+func FormatSelfTest(results []SelfTestResult) string {
+	title := "🩺 <b>Self-test passed</b>, serving users"
+	if failed := SelfTestFailures(results); len(failed) > 0 {
+		title = fmt.Sprintf("🩺 <b>Self-test failed</b> (%s), not starting", strings.Join(failed, ", "))
+	}
+	lines := []string{title}
+	for _, result := range results {
+		icon, detail := "✅", result.Detail
+		if result.Err != nil {
+			icon, detail = "❌", result.Err.Error()
+			if !result.Critical {
+				icon = "⚠️"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s <i>(%s)</i>", icon, result.Name, html.EscapeString(detail), result.Duration.Round(time.Millisecond)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SelfTest runs the startup self-test, logs it and sends the summary to the admin chat. It returns
+// an error when a critical check failed, and the bot shouldn't serve users.
+func SelfTest(bot *tgbotapi.BotAPI, mode string) error {
+	results := RunSelfTest(bot, mode)
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("Self-test %s failed (critical: %t): %v", result.Name, result.Critical, result.Err)
+		}
+	}
+	AlertAdmins(bot, FormatSelfTest(results))
+	if failed := SelfTestFailures(results); len(failed) > 0 {
+		return fmt.Errorf("self-test failed: %s (run with --skip-selftest to start anyway)", strings.Join(failed, ", "))
+	}
+	log.Print("Self-test passed")
+	return nil
+}
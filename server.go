@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var startedAt = time.Now()
+
+// NewInternalMux builds the handler for the internal (operator-only) HTTP server.
+func NewInternalMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	token := GetenvVar("DIAGNOSTICS_TOKEN", false)
+	if token == "" {
+		log.Print("DIAGNOSTICS_TOKEN not set, diagnostics endpoints disabled")
+		return mux
+	}
+
+	mux.Handle("/debug/pprof/", RequireToken(token, http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", RequireToken(token, http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", RequireToken(token, http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", RequireToken(token, http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", RequireToken(token, http.HandlerFunc(pprof.Trace)))
+	mux.Handle("/debug/runtime", RequireToken(token, http.HandlerFunc(HandleRuntimeStats)))
+	mux.Handle("/debug/handlers", RequireToken(token, http.HandlerFunc(HandleHandlerMetrics)))
+	mux.Handle("/debug/telegram", RequireToken(token, http.HandlerFunc(HandleTelegramErrors)))
+
+	return mux
+}
+
+// StartInternalServer serves the internal mux on addr in the background.
+func StartInternalServer(addr string, mux *http.ServeMux) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Internal HTTP server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Internal HTTP server stopped: %v", err)
+		}
+	}()
+}
+
+// RequireToken rejects requests that don't carry the token as a bearer header or ?token= parameter.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if given == "" {
+			given = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func HandleRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := map[string]interface{}{
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"goroutines":     runtime.NumGoroutine(),
+		"heap_alloc":     mem.HeapAlloc,
+		"heap_inuse":     mem.HeapInuse,
+		"heap_objects":   mem.HeapObjects,
+		"heap_sys":       mem.HeapSys,
+		"total_alloc":    mem.TotalAlloc,
+		"sys":            mem.Sys,
+		"num_gc":         mem.NumGC,
+		"last_gc":        time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339),
+		"entries":        MemorySizes(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	DefaultSessionName = "default"
+	MaxSessionTurns    = 10
+	MaxSessionsPerUser = 20
+)
+
+// Turn is one question/answer exchange kept as conversation memory.
+type Turn struct {
+	Question string    `json:"question"`
+	Answer   string    `json:"answer"`
+	At       time.Time `json:"at"`
+}
+
+// Session is a named conversation thread with its own memory.
+type Session struct {
+	// Summary condenses turns that no longer fit in the history budget.
+	Summary   string    `json:"summary,omitempty"`
+	Turns     []Turn    `json:"turns"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserSessions holds all of a user's DM conversation threads.
+type UserSessions struct {
+	Active   string             `json:"active"`
+	Sessions map[string]Session `json:"sessions"`
+}
+
+var (
+	conversations      *JSONStore[UserSessions]
+	sessionNamePattern = regexp.MustCompile(`^[\p{L}\p{N} _-]{1,32}$`)
+)
+
+// cloned returns a copy whose session map can be modified without affecting readers of the stored value.
+func (u UserSessions) cloned() UserSessions {
+	sessions := make(map[string]Session, len(u.Sessions))
+	for name, session := range u.Sessions {
+		sessions[name] = session
+	}
+	u.Sessions = sessions
+	return u
+}
+
+func (u UserSessions) ActiveName() string {
+	if u.Active == "" {
+		return DefaultSessionName
+	}
+	return u.Active
+}
+
+// ActiveSession returns the user's active session.
+func ActiveSession(userID int64) Session {
+	if conversations == nil {
+		return Session{}
+	}
+	user, _ := conversations.Get(ChatKey(userID))
+	return user.Sessions[user.ActiveName()]
+}
+
+// RecordTurn appends an exchange to the user's active session. Older turns are folded into
+// the session summary by CompactSession; maxStoredTurns only applies if that keeps failing.
+func RecordTurn(userID int64, question, answer string) error {
+	if conversations == nil {
+		return nil
+	}
+	return conversations.Update(ChatKey(userID), func(user UserSessions) UserSessions {
+		user = user.cloned()
+		now := time.Now()
+		session, ok := user.Sessions[user.ActiveName()]
+		if !ok {
+			session = Session{CreatedAt: now}
+		}
+		turns := append([]Turn{}, session.Turns...)
+		turns = append(turns, Turn{Question: question, Answer: answer, At: now})
+		if len(turns) > maxStoredTurns {
+			turns = turns[len(turns)-maxStoredTurns:]
+		}
+		session.Turns = turns
+		session.UpdatedAt = now
+		user.Sessions[user.ActiveName()] = session
+		return user
+	})
+}
+
+// SessionHistory is the session's summary followed by its turns, as sent to the backend.
+func SessionHistory(session Session) []HistoryMessage {
+	history := HistoryMessages(session.Turns)
+	if session.Summary == "" {
+		return history
+	}
+	summary := HistoryMessage{Role: "system", Content: "Summary of the earlier conversation: " + session.Summary}
+	return append([]HistoryMessage{summary}, history...)
+}
+
+// HistoryMessages converts turns into the role/content list sent to the backend.
+func HistoryMessages(turns []Turn) []HistoryMessage {
+	history := make([]HistoryMessage, 0, len(turns)*2)
+	for _, turn := range turns {
+		if turn.Question != "" {
+			history = append(history, HistoryMessage{Role: "user", Content: turn.Question})
+		}
+		history = append(history, HistoryMessage{Role: "assistant", Content: turn.Answer})
+	}
+	return history
+}
+
+func HandleSessionCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	userKey := ChatKey(update.Message.From.ID)
+
+	subcommand, name, _ := strings.Cut(strings.TrimSpace(args), " ")
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	var reply string
+	var err error
+	switch subcommand {
+	case "new":
+		reply, err = newSession(userKey, name)
+	case "use":
+		reply, err = useSession(userKey, name)
+	case "delete":
+		reply, err = deleteSession(userKey, name)
+	case "list", "":
+		user, _ := conversations.Get(userKey)
+		reply = FormatSessionList(user)
+	default:
+		reply = "Usage: /session new|use|delete &lt;name&gt;, or /session list"
+	}
+	if err != nil {
+		return err
+	}
+	return SendHTML(bot, chatID, reply)
+}
+
+func newSession(userKey, name string) (string, error) {
+	if !sessionNamePattern.MatchString(name) {
+		return "Session names are 1-32 letters, numbers, spaces, dashes or underscores.", nil
+	}
+	user, _ := conversations.Get(userKey)
+	if _, exists := user.Sessions[name]; exists {
+		return fmt.Sprintf("Session <b>%s</b> already exists. Switch to it with /session use %s", html.EscapeString(name), html.EscapeString(name)), nil
+	}
+	if len(user.Sessions) >= MaxSessionsPerUser {
+		return fmt.Sprintf("You already have %d sessions. Delete one with /session delete &lt;name&gt; first.", MaxSessionsPerUser), nil
+	}
+
+	err := conversations.Update(userKey, func(user UserSessions) UserSessions {
+		user = user.cloned()
+		now := time.Now()
+		user.Sessions[name] = Session{CreatedAt: now, UpdatedAt: now}
+		user.Active = name
+		return user
+	})
+	return fmt.Sprintf("Started session <b>%s</b>. New questions will use its memory.", html.EscapeString(name)), err
+}
+
+func useSession(userKey, name string) (string, error) {
+	if name == "" {
+		name = DefaultSessionName
+	}
+	user, _ := conversations.Get(userKey)
+	if _, exists := user.Sessions[name]; !exists && name != DefaultSessionName {
+		return fmt.Sprintf("No session named <b>%s</b>. See /session list", html.EscapeString(name)), nil
+	}
+
+	err := conversations.Update(userKey, func(user UserSessions) UserSessions {
+		user.Active = name
+		return user
+	})
+	return fmt.Sprintf("Switched to session <b>%s</b>.", html.EscapeString(name)), err
+}
+
+func deleteSession(userKey, name string) (string, error) {
+	user, _ := conversations.Get(userKey)
+	if _, exists := user.Sessions[name]; !exists {
+		return fmt.Sprintf("No session named <b>%s</b>. See /session list", html.EscapeString(name)), nil
+	}
+
+	err := conversations.Update(userKey, func(user UserSessions) UserSessions {
+		user = user.cloned()
+		delete(user.Sessions, name)
+		if user.Active == name {
+			user.Active = DefaultSessionName
+		}
+		return user
+	})
+	return fmt.Sprintf("Deleted session <b>%s</b>.", html.EscapeString(name)), err
+}
+
+func FormatSessionList(user UserSessions) string {
+	names := make([]string, 0, len(user.Sessions)+1)
+	for name := range user.Sessions {
+		names = append(names, name)
+	}
+	if _, ok := user.Sessions[DefaultSessionName]; !ok {
+		names = append(names, DefaultSessionName)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("<b>Your sessions</b>\n")
+	for _, name := range names {
+		marker := "▫️"
+		if name == user.ActiveName() {
+			marker = "▶️"
+		}
+		turns := 0
+		if session, ok := user.Sessions[name]; ok {
+			turns = len(session.Turns)
+		}
+		fmt.Fprintf(&b, "%s %s (%d questions)\n", marker, html.EscapeString(name), turns)
+	}
+	b.WriteString("\n/session new &lt;name&gt; · /session use &lt;name&gt;")
+	return b.String()
+}
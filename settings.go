@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ChatSettings holds per-chat preferences changed through /settings.
+type ChatSettings struct {
+	LinkPreview string            `json:"link_preview,omitempty"`
+	Aliases     map[string]string `json:"aliases,omitempty"`
+	TopicID     int               `json:"topic_id,omitempty"`
+	Trigger     string            `json:"trigger,omitempty"`
+	// Privacy is "on" or "off", empty for the chat type default (see PrivacyEnabled)
+	Privacy string `json:"privacy,omitempty"`
+	// Language is set by group admins and wins over DetectedLanguage, guessed from recent messages
+	Language         string `json:"language,omitempty"`
+	DetectedLanguage string `json:"detected_language,omitempty"`
+	// Alerts subscribes the chat to drug checking alerts, limited to AlertRegions when set
+	Alerts       bool     `json:"alerts,omitempty"`
+	AlertRegions []string `json:"alert_regions,omitempty"`
+	// PinAlerts pins pushed alerts until they expire after ALERT_PIN_HOURS
+	PinAlerts bool `json:"pin_alerts,omitempty"`
+	// AddedAt is when the bot was last added to the group. Inactive chats removed or blocked the
+	// bot and get no pushes until it is back.
+	AddedAt       time.Time `json:"added_at,omitempty"`
+	Inactive      bool      `json:"inactive,omitempty"`
+	InactiveSince time.Time `json:"inactive_since,omitempty"`
+	// SpamFilter deletes messages matching the spam rules, including the group's own SpamRules
+	SpamFilter bool     `json:"spam_filter,omitempty"`
+	SpamRules  []string `json:"spam_rules,omitempty"`
+	// SpamExempt are members whose deleted messages the admins marked as not spam
+	SpamExempt []int64 `json:"spam_exempt,omitempty"`
+	// MentionPrompts offers factsheet buttons when messages mention a substance
+	MentionPrompts bool `json:"mention_prompts,omitempty"`
+	// Style is the answer style set with /style, empty for concise
+	Style string `json:"style,omitempty"`
+}
+
+var chatSettings *JSONStore[ChatSettings]
+
+func ChatKey(chatID int64) string {
+	return strconv.FormatInt(chatID, 10)
+}
+
+func GetChatSettings(chatID int64) ChatSettings {
+	if chatSettings == nil {
+		return ChatSettings{}
+	}
+	settings, _ := chatSettings.Get(ChatKey(chatID))
+	return settings
+}
+
+func UpdateChatSettings(chatID int64, fn func(settings *ChatSettings)) error {
+	return chatSettings.Update(ChatKey(chatID), func(settings ChatSettings) ChatSettings {
+		fn(&settings)
+		return settings
+	})
+}
+
+// IsChatAdmin reports whether userID may change settings in chatID. Everyone is an admin of their own DM.
+func IsChatAdmin(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, userID int64) bool {
+	if chat.IsPrivate() {
+		return true
+	}
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: userID},
+	})
+	if err != nil {
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+const settingsUsage = "Usage:\n/settings preview on|off|first|last\n/settings alias &lt;alias&gt; &lt;command&gt;\n/settings unalias &lt;alias&gt;\n/settings topic here|off\n/settings trigger &lt;#hashtag or prefix&gt;|off\n/settings language &lt;en|de|es|fr|pt|ru&gt;|auto\n/settings mentions on|off"
+
+var commandNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+func FormatChatSettings(settings ChatSettings) string {
+	preview := settings.LinkPreview
+	if preview == "" {
+		preview = PreviewDefault
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>Chat settings</b>\npreview: <code>%s</code>", preview)
+	if settings.Language != "" {
+		fmt.Fprintf(&b, "\nlanguage: <code>%s</code>", settings.Language)
+	} else if settings.DetectedLanguage != "" {
+		fmt.Fprintf(&b, "\nlanguage: <code>%s</code> (detected)", settings.DetectedLanguage)
+	}
+	if settings.Privacy != "" {
+		fmt.Fprintf(&b, "\nprivacy: <code>%s</code>", settings.Privacy)
+	}
+	if settings.TopicID != 0 {
+		fmt.Fprintf(&b, "\ntopic: only topic <code>%d</code>", settings.TopicID)
+	}
+	if settings.Trigger != "" {
+		fmt.Fprintf(&b, "\ntrigger: <code>%s</code>", html.EscapeString(settings.Trigger))
+	}
+	if settings.Alerts {
+		fmt.Fprintf(&b, "\nalerts: <code>on</code>")
+		if len(settings.AlertRegions) > 0 {
+			fmt.Fprintf(&b, " (%s)", html.EscapeString(strings.Join(settings.AlertRegions, ", ")))
+		}
+		if settings.PinAlerts {
+			b.WriteString(", pinned")
+		}
+	}
+	if settings.SpamFilter {
+		fmt.Fprintf(&b, "\nspam filter: <code>on</code>")
+		if len(settings.SpamRules) > 0 {
+			fmt.Fprintf(&b, " (%d group rules)", len(settings.SpamRules))
+		}
+	}
+	if settings.MentionPrompts {
+		fmt.Fprintf(&b, "\nmentions: <code>on</code>")
+	}
+	if settings.Style != "" {
+		fmt.Fprintf(&b, "\nstyle: <code>%s</code>", settings.Style)
+	}
+	if len(settings.Aliases) > 0 {
+		aliases := make([]string, 0, len(settings.Aliases))
+		for alias, target := range settings.Aliases {
+			aliases = append(aliases, fmt.Sprintf("/%s → /%s", alias, target))
+		}
+		sort.Strings(aliases)
+		b.WriteString("\naliases:\n" + strings.Join(aliases, "\n"))
+	}
+	return b.String()
+}
+
+func HandleSettingsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chat := update.Message.Chat
+	fields := strings.Fields(strings.ToLower(args))
+
+	if len(fields) == 0 {
+		return SendHTML(bot, chat.ID, FormatChatSettings(GetChatSettings(chat.ID)))
+	}
+	if update.Message.From == nil || !IsChatAdmin(bot, chat, update.Message.From.ID) {
+		return SendHTML(bot, chat.ID, "Only group admins can change settings.")
+	}
+
+	reply, err := applySetting(chat.ID, fields, Extras(update).MessageThreadID)
+	if err != nil {
+		return err
+	}
+	return SendHTML(bot, chat.ID, reply)
+}
+
+// applySetting changes one setting and returns the reply to show.
+func applySetting(chatID int64, fields []string, threadID int) (string, error) {
+	var update func(settings *ChatSettings)
+
+	switch {
+	case fields[0] == "preview" && len(fields) == 2 && IsValidPreviewMode(fields[1]):
+		update = func(settings *ChatSettings) {
+			settings.LinkPreview = fields[1]
+		}
+	case fields[0] == "alias" && len(fields) == 3:
+		alias, target := strings.TrimPrefix(fields[1], "/"), strings.TrimPrefix(fields[2], "/")
+		if !commandNamePattern.MatchString(alias) {
+			return "Aliases can only use a-z, 0-9 and underscores (up to 32 characters).", nil
+		}
+		if _, exists := FindCommand(alias); exists {
+			return fmt.Sprintf("/%s is already a command.", alias), nil
+		}
+		if _, exists := FindCommand(target); !exists {
+			return fmt.Sprintf("/%s is not a command I know.", target), nil
+		}
+		update = func(settings *ChatSettings) {
+			aliases := map[string]string{alias: target}
+			for name, existing := range settings.Aliases {
+				if name != alias {
+					aliases[name] = existing
+				}
+			}
+			settings.Aliases = aliases
+		}
+	case fields[0] == "topic" && len(fields) == 2 && fields[1] == "here":
+		if threadID == 0 {
+			return "Send this command inside the forum topic the bot should stay in.", nil
+		}
+		update = func(settings *ChatSettings) {
+			settings.TopicID = threadID
+		}
+	case fields[0] == "topic" && len(fields) == 2 && fields[1] == "off":
+		update = func(settings *ChatSettings) {
+			settings.TopicID = 0
+		}
+	case fields[0] == "trigger" && len(fields) == 2:
+		trigger := fields[1]
+		if trigger == "off" {
+			trigger = ""
+		}
+		update = func(settings *ChatSettings) {
+			settings.Trigger = trigger
+		}
+	case fields[0] == "language" && len(fields) == 2:
+		language := fields[1]
+		if language == "auto" {
+			language = ""
+		} else if LanguageName(language) == language {
+			return settingsUsage, nil
+		}
+		update = func(settings *ChatSettings) {
+			settings.Language = language
+		}
+	case fields[0] == "mentions" && len(fields) == 2 && (fields[1] == "on" || fields[1] == "off"):
+		update = func(settings *ChatSettings) {
+			settings.MentionPrompts = fields[1] == "on"
+		}
+	case fields[0] == "unalias" && len(fields) == 2:
+		alias := strings.TrimPrefix(fields[1], "/")
+		update = func(settings *ChatSettings) {
+			aliases := map[string]string{}
+			for name, existing := range settings.Aliases {
+				if name != alias {
+					aliases[name] = existing
+				}
+			}
+			settings.Aliases = aliases
+		}
+	default:
+		return settingsUsage, nil
+	}
+
+	if err := UpdateChatSettings(chatID, update); err != nil {
+		return "", err
+	}
+	return FormatChatSettings(GetChatSettings(chatID)), nil
+}
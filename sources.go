@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrSubstanceNotFound means a source has no data for the requested substance.
+var ErrSubstanceNotFound = errors.New("substance not found")
+
+// SubstanceInfo is the general description of a substance.
+type SubstanceInfo struct {
+	Name     string
+	Summary  string
+	Duration time.Duration
+}
+
+// DoseLevel is one dosage tier for a route of administration, e.g. Oral / Common / "75-150µg".
+type DoseLevel struct {
+	Route  string
+	Level  string
+	Amount string
+}
+
+// SubstanceSource provides substance data. Keys are the canonical keys from the substances table.
+type SubstanceSource interface {
+	Name() string
+	GetInfo(key string) (*SubstanceInfo, error)
+	GetDoses(key string) ([]DoseLevel, error)
+	// GetInteractions maps other substance keys to a risk level.
+	GetInteractions(key string) (map[string]string, error)
+}
+
+// localSource serves the curated tables bundled with the bot. It never needs the network.
+type localSource struct{}
+
+func (localSource) Name() string { return "curated data" }
+
+func (localSource) GetInfo(key string) (*SubstanceInfo, error) {
+	substance, ok := substances[key]
+	if !ok {
+		return nil, ErrSubstanceNotFound
+	}
+	return &SubstanceInfo{Name: substance.Name, Duration: substance.Duration}, nil
+}
+
+func (localSource) GetDoses(key string) ([]DoseLevel, error) {
+	return nil, ErrSubstanceNotFound
+}
+
+func (localSource) GetInteractions(key string) (map[string]string, error) {
+	if _, ok := substances[key]; !ok {
+		return nil, ErrSubstanceNotFound
+	}
+	risks := map[string]string{}
+	for other := range substances {
+		if risk, ok := Interaction(key, other); ok && other != key {
+			risks[other] = risk
+		}
+	}
+	return risks, nil
+}
+
+func getJSON(client *http.Client, rawURL string, target interface{}) error {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSubstanceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// tripsitNames maps keys to the names TripSit uses where they differ.
+var tripsitNames = map[string]string{
+	"amphetamine": "amphetamines",
+	"psilocybin":  "mushrooms",
+}
+
+// tripsitSource reads the public TripSit drug API.
+type tripsitSource struct {
+	baseURL string
+}
+
+type tripsitDrug struct {
+	PrettyName string `json:"pretty_name"`
+	Properties struct {
+		Summary string `json:"summary"`
+	} `json:"properties"`
+	FormattedDose map[string]map[string]string `json:"formatted_dose"`
+	Combos        map[string]struct {
+		Status string `json:"status"`
+	} `json:"combos"`
+}
+
+func (s tripsitSource) Name() string { return "TripSit" }
+
+func (s tripsitSource) drug(key string) (*tripsitDrug, error) {
+	name := key
+	if alias, ok := tripsitNames[key]; ok {
+		name = alias
+	}
+	var response struct {
+		Err  interface{}   `json:"err"`
+		Data []tripsitDrug `json:"data"`
+	}
+	if err := getJSON(HTTPClient(10*time.Second), s.baseURL+"/getDrug?name="+url.QueryEscape(name), &response); err != nil {
+		return nil, err
+	}
+	// TripSit answers unknown drugs with 200 and an error field
+	if response.Err != nil || len(response.Data) == 0 {
+		return nil, ErrSubstanceNotFound
+	}
+	return &response.Data[0], nil
+}
+
+func (s tripsitSource) GetInfo(key string) (*SubstanceInfo, error) {
+	drug, err := s.drug(key)
+	if err != nil {
+		return nil, err
+	}
+	return &SubstanceInfo{Name: drug.PrettyName, Summary: drug.Properties.Summary}, nil
+}
+
+func (s tripsitSource) GetDoses(key string) ([]DoseLevel, error) {
+	drug, err := s.drug(key)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]string, 0, len(drug.FormattedDose))
+	for route := range drug.FormattedDose {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	var doses []DoseLevel
+	for _, route := range routes {
+		levels := drug.FormattedDose[route]
+		for _, level := range []string{"Threshold", "Light", "Common", "Strong", "Heavy"} {
+			if amount, ok := levels[level]; ok {
+				doses = append(doses, DoseLevel{Route: route, Level: level, Amount: amount})
+			}
+		}
+	}
+	if len(doses) == 0 {
+		return nil, ErrSubstanceNotFound
+	}
+	return doses, nil
+}
+
+func (s tripsitSource) GetInteractions(key string) (map[string]string, error) {
+	drug, err := s.drug(key)
+	if err != nil {
+		return nil, err
+	}
+	risks := map[string]string{}
+	for name, combo := range drug.Combos {
+		other, _, _ := LookupSubstance(name)
+		risks[other] = combo.Status
+	}
+	return risks, nil
+}
+
+// psyaiSource reads the PsyAI backend's /substances/<key> endpoint.
+type psyaiSource struct {
+	baseURL string
+}
+
+type psyaiSubstance struct {
+	Name          string            `json:"name"`
+	Summary       string            `json:"summary"`
+	DurationHours float64           `json:"duration_hours"`
+	Doses         []psyaiDose       `json:"doses"`
+	Interactions  map[string]string `json:"interactions"`
+}
+
+type psyaiDose struct {
+	Route  string `json:"route"`
+	Level  string `json:"level"`
+	Amount string `json:"amount"`
+}
+
+func (s psyaiSource) Name() string { return "PsyAI" }
+
+func (s psyaiSource) substance(key string) (*psyaiSubstance, error) {
+	var substance psyaiSubstance
+	if err := getJSON(BackendHTTPClient(10*time.Second), s.baseURL+"/substances/"+url.PathEscape(key), &substance); err != nil {
+		return nil, err
+	}
+	return &substance, nil
+}
+
+func (s psyaiSource) GetInfo(key string) (*SubstanceInfo, error) {
+	substance, err := s.substance(key)
+	if err != nil {
+		return nil, err
+	}
+	return &SubstanceInfo{
+		Name:     substance.Name,
+		Summary:  substance.Summary,
+		Duration: time.Duration(substance.DurationHours * float64(time.Hour)),
+	}, nil
+}
+
+func (s psyaiSource) GetDoses(key string) ([]DoseLevel, error) {
+	substance, err := s.substance(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(substance.Doses) == 0 {
+		return nil, ErrSubstanceNotFound
+	}
+	doses := make([]DoseLevel, len(substance.Doses))
+	for i, dose := range substance.Doses {
+		doses[i] = DoseLevel{Route: dose.Route, Level: dose.Level, Amount: dose.Amount}
+	}
+	return doses, nil
+}
+
+func (s psyaiSource) GetInteractions(key string) (map[string]string, error) {
+	substance, err := s.substance(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(substance.Interactions) == 0 {
+		return nil, ErrSubstanceNotFound
+	}
+	return substance.Interactions, nil
+}
+
+// SubstanceSources returns the configured sources in priority order. SUBSTANCE_SOURCES is a
+// comma-separated list of "psyai", "tripsit" and "local" (default "local").
+func SubstanceSources() []SubstanceSource {
+	names := GetenvVar("SUBSTANCE_SOURCES", false)
+	if names == "" {
+		names = "local"
+	}
+	var sources []SubstanceSource
+	for _, name := range strings.Split(names, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "psyai":
+			sources = append(sources, psyaiSource{baseURL: GetenvVar("BASE_URL_BETA", false)})
+		case "tripsit":
+			baseURL := GetenvVar("TRIPSIT_API_URL", false)
+			if baseURL == "" {
+				baseURL = "https://tripbot.tripsit.me/api/tripsit"
+			}
+			sources = append(sources, tripsitSource{baseURL: strings.TrimSuffix(baseURL, "/")})
+		case "local":
+			sources = append(sources, localSource{})
+		}
+	}
+	if len(sources) == 0 {
+		sources = append(sources, localSource{})
+	}
+	return sources
+}
+
+// fromSources returns the first successful result in priority order, with the source's name.
+func fromSources[T any](key string, get func(SubstanceSource) (T, error)) (T, string, error) {
+	var zero T
+	for _, source := range SubstanceSources() {
+		result, err := get(source)
+		if err == nil {
+			return result, source.Name(), nil
+		}
+		if !errors.Is(err, ErrSubstanceNotFound) {
+			log.Printf("Error reading %s from %s: %v", key, source.Name(), err)
+		}
+	}
+	return zero, "", ErrSubstanceNotFound
+}
+
+func GetSubstanceInfo(key string) (*SubstanceInfo, string, error) {
+	return fromSources(key, func(source SubstanceSource) (*SubstanceInfo, error) { return source.GetInfo(key) })
+}
+
+func GetSubstanceDoses(key string) ([]DoseLevel, string, error) {
+	return fromSources(key, func(source SubstanceSource) ([]DoseLevel, error) { return source.GetDoses(key) })
+}
+
+func GetSubstanceInteractions(key string) (map[string]string, string, error) {
+	return fromSources(key, func(source SubstanceSource) (map[string]string, error) { return source.GetInteractions(key) })
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SpamRule names a pattern of scam or vendor messages.
+type SpamRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// defaultSpamRules catch the usual vendor ads and crypto scams. They are deliberately narrow:
+// people asking where to get test kits or talking about a dealer must not be deleted.
+var defaultSpamRules = []SpamRule{
+	{"vendor contact", regexp.MustCompile(`(?i)\b(dm|pm|inbox|message|text|hit) me (up )?(for|to (buy|order|get)) (the |my )?(menu|prices?|price ?list|products?|supply|plugs?|orders?|stuff|goods)\b`)},
+	{"vendor menu", regexp.MustCompile(`(?i)\b(menu|price ?list|prices)\b.{0,60}\b(dm|pm|inbox|wickr|telegram|whatsapp|signal|session)\b`)},
+	{"vendor ad", regexp.MustCompile(`(?i)\b(plug|vendor|supplier|connect) (is )?(available|here|online|active)\b`)},
+	{"discreet shipping", regexp.MustCompile(`(?i)\b(discreet|stealth|worldwide|overnight) (shipping|delivery|packaging)\b`)},
+	{"selling", regexp.MustCompile(`(?i)\b(selling|for sale|in stock|available now)\b.{0,40}\b(carts?|pills|tabs|molly|mdma|coke|cocaine|ket|ketamine|shrooms|acid|lsd|xanax|oxy\w*|percs?|fent\w*|meth|weed|bud)\b`)},
+	{"crypto scam", regexp.MustCompile(`(?i)\b(invest|double|earn|profit)\w*\b.{0,50}\b(bitcoin|btc|usdt|crypto|eth)\b.{0,50}\b(daily|guaranteed|within|hours|returns?)\b`)},
+}
+
+var (
+	spamRulesOnce sync.Once
+	spamRules     []SpamRule
+)
+
+// SpamRules are the default rules plus one regular expression per line of SPAM_RULES_FILE.
+func SpamRules() []SpamRule {
+	spamRulesOnce.Do(func() {
+		spamRules = defaultSpamRules
+		path := GetenvVar("SPAM_RULES_FILE", false)
+		if path == "" {
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("Error reading SPAM_RULES_FILE: %v", err)
+			return
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			pattern, err := regexp.Compile("(?i)" + text)
+			if err != nil {
+				log.Printf("Skipping invalid spam rule on line %d of %s: %v", line, path, err)
+				continue
+			}
+			spamRules = append(spamRules, SpamRule{Name: "rule " + strconv.Itoa(line), Pattern: pattern})
+		}
+	})
+	return spamRules
+}
+
+// MatchSpam returns the first rule, global or from the chat's own list, that matches text.
+func MatchSpam(text string, chatRules []string) (SpamRule, bool) {
+	for _, rule := range SpamRules() {
+		if rule.Pattern.MatchString(text) {
+			return rule, true
+		}
+	}
+	for i, expression := range chatRules {
+		pattern, err := regexp.Compile("(?i)" + expression)
+		if err == nil && pattern.MatchString(text) {
+			return SpamRule{Name: fmt.Sprintf("group rule %d", i+1), Pattern: pattern}, true
+		}
+	}
+	return SpamRule{}, false
+}
+
+// CheckSpam deletes a group message matching a spam rule and notifies the group's admins. It
+// reports whether the message was spam, in which case nothing else should handle it.
+func CheckSpam(bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
+	message := update.Message
+	if message.Chat.IsPrivate() || message.From == nil || message.From.ID == bot.Self.ID {
+		return false
+	}
+	settings := GetChatSettings(message.Chat.ID)
+	if !settings.SpamFilter {
+		return false
+	}
+	text, _ := MessageText(message)
+	rule, ok := MatchSpam(text, settings.SpamRules)
+	if !ok || IsSpamExempt(settings, message.From.ID) || IsChatAdmin(bot, message.Chat, message.From.ID) {
+		return false
+	}
+
+	log.Printf("Spam rule %q matched message %d in chat %d", rule.Name, message.MessageID, message.Chat.ID)
+	deleted := true
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)); err != nil {
+		log.Printf("Error deleting spam message in chat %d: %v", message.Chat.ID, err)
+		deleted = false
+	}
+	if deleted {
+		action := ModerationAction{ChatID: message.Chat.ID, ChatTitle: message.Chat.Title, Kind: ModerationSpam, UserID: message.From.ID,
+			User: spamSender(message.From), Rule: rule.Name, Text: text}
+		if err := RecordModeration(action); err != nil {
+			log.Printf("Error recording spam deletion: %v", err)
+		}
+	}
+	report := formatSpamReport(message, text, rule, deleted)
+	if deleted && BanRepeatSpammer(bot, message) {
+		report += "\n\n⛔ They were banned for repeated spam, see the daily moderation digest to undo it."
+	}
+	go NotifyChatAdmins(bot, message.Chat, report)
+	return true
+}
+
+func formatSpamReport(message *tgbotapi.Message, text string, rule SpamRule, deleted bool) string {
+	action := "Deleted"
+	if !deleted {
+		action = "Couldn't delete (am I an admin with permission to delete messages?)"
+	}
+	return fmt.Sprintf("🚫 <b>%s a likely scam message in %s</b>\nFrom: %s\nRule: %s\n\n<blockquote>%s</blockquote>",
+		action, html.EscapeString(message.Chat.Title), html.EscapeString(spamSender(message.From)), html.EscapeString(rule.Name),
+		html.EscapeString(Truncate(text, 500)))
+}
+
+// spamSender names a member by first name and username.
+func spamSender(user *tgbotapi.User) string {
+	sender := user.FirstName
+	if user.UserName != "" {
+		sender += " (@" + user.UserName + ")"
+	}
+	return sender
+}
+
+// NotifyChatAdmins sends text privately to every human admin of a group. Admins who never started
+// the bot can't be messaged, so failures are only logged.
+func NotifyChatAdmins(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, text string) {
+	admins, err := ChatAdminIDs(bot, chat.ID)
+	if err != nil {
+		log.Printf("Error listing admins of chat %d: %v", chat.ID, err)
+		return
+	}
+	for _, adminID := range admins {
+		if err := SendHTML(bot, adminID, text); err != nil {
+			log.Printf("Error notifying admin %d of chat %d: %v", adminID, chat.ID, err)
+		}
+	}
+}
+
+const spamUsage = "Usage:\n/spam on|off\n/spam add &lt;regular expression&gt;\n/spam remove &lt;number&gt;"
+
+// HandleSpamCommand shows and changes a group's spam filter.
+func HandleSpamCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chat := update.Message.Chat
+	fields := strings.Fields(args)
+	settings := GetChatSettings(chat.ID)
+
+	if len(fields) == 0 {
+		status := "off"
+		if settings.SpamFilter {
+			status = "on"
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "The spam filter is <b>%s</b>. It checks %d built-in rules", status, len(SpamRules()))
+		if len(settings.SpamRules) > 0 {
+			b.WriteString(" plus this group's rules:")
+			for i, rule := range settings.SpamRules {
+				fmt.Fprintf(&b, "\n%d. <code>%s</code>", i+1, html.EscapeString(rule))
+			}
+		} else {
+			b.WriteString(".")
+		}
+		b.WriteString("\n\n" + spamUsage)
+		return SendHTML(bot, chat.ID, b.String())
+	}
+	if update.Message.From == nil || !IsChatAdmin(bot, chat, update.Message.From.ID) {
+		return SendHTML(bot, chat.ID, "Only group admins can change the spam filter.")
+	}
+
+	var reply string
+	var change func(settings *ChatSettings)
+	switch strings.ToLower(fields[0]) {
+	case "on":
+		if !canDeleteMessages(bot, chat) {
+			return SendHTML(bot, chat.ID, "Make me an admin with permission to delete messages first.")
+		}
+		change = func(settings *ChatSettings) { settings.SpamFilter = true }
+		reply = "Spam filter on. I'll delete likely scam and vendor messages and tell the admins privately."
+	case "off":
+		change = func(settings *ChatSettings) { settings.SpamFilter = false }
+		reply = "Spam filter off."
+	case "add":
+		expression := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args), fields[0]))
+		if expression == "" {
+			return SendHTML(bot, chat.ID, spamUsage)
+		}
+		if _, err := regexp.Compile(expression); err != nil {
+			return SendHTML(bot, chat.ID, "That isn't a valid regular expression: "+html.EscapeString(err.Error()))
+		}
+		change = func(settings *ChatSettings) { settings.SpamRules = append(settings.SpamRules, expression) }
+		reply = "Added the rule."
+	case "remove":
+		index, err := strconv.Atoi(strings.Join(fields[1:], ""))
+		if err != nil || index < 1 || index > len(settings.SpamRules) {
+			return SendHTML(bot, chat.ID, spamUsage)
+		}
+		change = func(settings *ChatSettings) {
+			if index <= len(settings.SpamRules) {
+				settings.SpamRules = append(append([]string{}, settings.SpamRules[:index-1]...), settings.SpamRules[index:]...)
+			}
+		}
+		reply = "Removed the rule."
+	default:
+		return SendHTML(bot, chat.ID, spamUsage)
+	}
+
+	if err := UpdateChatSettings(chat.ID, change); err != nil {
+		return err
+	}
+	return SendHTML(bot, chat.ID, reply)
+}
+
+func canDeleteMessages(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: bot.Self.ID},
+	})
+	if err != nil {
+		return false
+	}
+	return member.IsCreator() || (member.IsAdministrator() && member.CanDeleteMessages)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// spotlightLog records when each substance was last featured, keyed by substance key.
+var spotlightLog *JSONStore[time.Time]
+
+// spotlightRepeatDays is how long a featured substance is skipped, from SPOTLIGHT_REPEAT_DAYS.
+func spotlightRepeatDays() int {
+	days, err := strconv.Atoi(GetenvVar("SPOTLIGHT_REPEAT_DAYS", false))
+	if err != nil || days < 0 {
+		return 30
+	}
+	return days
+}
+
+// PickSpotlightSubstance picks a random substance not featured in the last days, falling back
+// to the one featured longest ago once every substance has had its turn.
+func PickSpotlightSubstance(days int, now time.Time) string {
+	keys := make([]string, 0, len(substances))
+	for key := range substances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cutoff := now.AddDate(0, 0, -days)
+	var fresh []string
+	oldest, oldestAt := "", now
+	for _, key := range keys {
+		featured, ok := spotlightLog.Get(key)
+		if !ok || featured.Before(cutoff) {
+			fresh = append(fresh, key)
+		}
+		if ok && featured.Before(oldestAt) {
+			oldest, oldestAt = key, featured
+		}
+	}
+	if len(fresh) > 0 {
+		return fresh[rand.Intn(len(fresh))]
+	}
+	return oldest
+}
+
+// ComposeSpotlight builds the post: an introduction written by the backend followed by the factsheet.
+func ComposeSpotlight(key string) (string, error) {
+	question := fmt.Sprintf(
+		"Write a short, engaging educational spotlight about %s for a harm reduction channel: what it is, "+
+			"one thing people often get wrong about it, and one practical safety tip. No more than 120 words.",
+		substances[key].Name,
+	)
+	response, err := Ask(PromptRequest{
+		Question:     question,
+		Temperature:  0.5,
+		Tokens:       300,
+		SystemPrompt: SystemPrompt(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return "🔦 <b>Substance spotlight</b>\n\n" + ConvertToTelegramHTML(response.Text()) + "\n\n" + FormatSubstanceCard(key, Locale{}), nil
+}
+
+// spotlightMessage addresses SPOTLIGHT_CHANNEL, which is a chat ID or an @channel username.
+func spotlightMessage(channel, text string) tgbotapi.MessageConfig {
+	var msg tgbotapi.MessageConfig
+	if chatID, err := strconv.ParseInt(channel, 10, 64); err == nil {
+		msg = tgbotapi.NewMessage(chatID, text)
+	} else {
+		msg = tgbotapi.NewMessageToChannel(channel, text)
+	}
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	msg.DisableNotification = Delivery(MessageSpotlight, msg.ChatID, time.Now()).Silent
+	return msg
+}
+
+// PostSpotlight composes and posts today's spotlight, returning the featured substance.
+func PostSpotlight(bot *tgbotapi.BotAPI, channel string) (string, error) {
+	key := PickSpotlightSubstance(spotlightRepeatDays(), time.Now())
+	if key == "" {
+		return "", fmt.Errorf("no substances to feature")
+	}
+	text, err := ComposeSpotlight(key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := bot.Send(spotlightMessage(channel, text)); err != nil {
+		return "", err
+	}
+	return key, spotlightLog.Set(key, time.Now())
+}
+
+// ScheduleSpotlights posts a spotlight to SPOTLIGHT_CHANNEL every day at SPOTLIGHT_HOUR (UTC).
+func ScheduleSpotlights(bot *tgbotapi.BotAPI) {
+	channel := GetenvVar("SPOTLIGHT_CHANNEL", false)
+	if channel == "" {
+		return
+	}
+	hour, err := strconv.Atoi(GetenvVar("SPOTLIGHT_HOUR", false))
+	if err != nil || hour < 0 || hour > 23 {
+		hour = 12
+	}
+
+	ScheduleJob(Job{
+		Name:       "spotlight",
+		Schedule:   DailyAt(hour),
+		Retries:    2,
+		RetryDelay: 10 * time.Minute,
+		Run: func(time.Time) error {
+			key, err := PostSpotlight(bot, channel)
+			if err != nil {
+				return fmt.Errorf("error posting substance spotlight: %w", err)
+			}
+			log.Printf("Posted substance spotlight for %s", key)
+			return nil
+		},
+	})
+}
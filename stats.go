@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const statsRetentionDays = 30
+
+// DailyStats aggregates one UTC day of question handling.
+type DailyStats struct {
+	Questions  int            `json:"questions"`
+	Errors     int            `json:"errors"`
+	CacheHits  int            `json:"cache_hits"`
+	LatencyMs  int64          `json:"latency_ms"`
+	Users      map[string]int `json:"users"`
+	Substances map[string]int `json:"substances"`
+	// Safety counts refusals by "<origin>/<category>/<rule>" (see SafetyEvent)
+	Safety map[string]int `json:"safety,omitempty"`
+	// DeepLinks counts /start deep links followed, by route or "ref_<tag>"
+	DeepLinks map[string]int `json:"deep_links,omitempty"`
+}
+
+// AskEvent describes one handled question for the stats aggregator.
+type AskEvent struct {
+	UserID     int64
+	Latency    time.Duration
+	Failed     bool
+	Cached     bool
+	Substances []string
+}
+
+var dailyStats *JSONStore[DailyStats]
+
+// HashUserID pseudonymizes a Telegram user ID for analytics.
+func HashUserID(userID int64) string {
+	sum := sha256.Sum256([]byte("psyai:" + strconv.FormatInt(userID, 10)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func statsDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// RecordAskEvent adds a handled question to today's aggregate.
+func RecordAskEvent(event AskEvent) error {
+	if dailyStats == nil {
+		return nil
+	}
+	now := time.Now()
+	err := dailyStats.Update(statsDay(now), func(day DailyStats) DailyStats {
+		users := map[string]int{HashUserID(event.UserID): 1}
+		for user, count := range day.Users {
+			users[user] += count
+		}
+		mentioned := map[string]int{}
+		for substance, count := range day.Substances {
+			mentioned[substance] = count
+		}
+		for _, substance := range event.Substances {
+			mentioned[substance]++
+		}
+
+		day.Questions++
+		day.LatencyMs += event.Latency.Milliseconds()
+		if event.Failed {
+			day.Errors++
+		}
+		if event.Cached {
+			day.CacheHits++
+		}
+		day.Users, day.Substances = users, mentioned
+		return day
+	})
+	if err != nil {
+		return err
+	}
+	return dailyStats.Delete(statsDay(now.AddDate(0, 0, -statsRetentionDays)))
+}
+
+// StatsSummary combines the daily aggregates for the last days days, including today.
+func StatsSummary(days int) (DailyStats, int) {
+	total := DailyStats{Users: map[string]int{}, Substances: map[string]int{}, Safety: map[string]int{}, DeepLinks: map[string]int{}}
+	now := time.Now()
+	for i := 0; i < days; i++ {
+		day, ok := dailyStats.Get(statsDay(now.AddDate(0, 0, -i)))
+		if !ok {
+			continue
+		}
+		total.Questions += day.Questions
+		total.Errors += day.Errors
+		total.CacheHits += day.CacheHits
+		total.LatencyMs += day.LatencyMs
+		for user, count := range day.Users {
+			total.Users[user] += count
+		}
+		for substance, count := range day.Substances {
+			total.Substances[substance] += count
+		}
+		for key, count := range day.Safety {
+			total.Safety[key] += count
+		}
+		for key, count := range day.DeepLinks {
+			total.DeepLinks[key] += count
+		}
+	}
+	return total, len(total.Users)
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(whole)
+}
+
+func FormatStats(label string, stats DailyStats, uniqueUsers int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n", label)
+	fmt.Fprintf(&b, "Unique users: %d\n", uniqueUsers)
+	fmt.Fprintf(&b, "Questions: %d\n", stats.Questions)
+	if stats.Questions > 0 {
+		fmt.Fprintf(&b, "Avg latency: %.1fs\n", float64(stats.LatencyMs)/float64(stats.Questions)/1000)
+	}
+	fmt.Fprintf(&b, "Error rate: %.1f%%\n", percent(stats.Errors, stats.Questions))
+	fmt.Fprintf(&b, "Cache hit rate: %.1f%%\n", percent(stats.CacheHits, stats.Questions))
+
+	type substanceCount struct {
+		name  string
+		count int
+	}
+	var top []substanceCount
+	for name, count := range stats.Substances {
+		top = append(top, substanceCount{name, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].name < top[j].name
+	})
+	if len(top) > 5 {
+		top = top[:5]
+	}
+	if len(top) > 0 {
+		names := make([]string, len(top))
+		for i, substance := range top {
+			names[i] = fmt.Sprintf("%s (%d)", html.EscapeString(substance.name), substance.count)
+		}
+		fmt.Fprintf(&b, "Top substances: %s\n", strings.Join(names, ", "))
+	}
+	b.WriteString(FormatSafetyStats(stats.Safety))
+	if len(stats.DeepLinks) > 0 {
+		links := make([]string, 0, len(stats.DeepLinks))
+		for name, count := range stats.DeepLinks {
+			links = append(links, fmt.Sprintf("%s (%d)", html.EscapeString(name), count))
+		}
+		sort.Strings(links)
+		fmt.Fprintf(&b, "Deep links: %s\n", strings.Join(links, ", "))
+	}
+	return b.String()
+}
+
+// StatsCSV renders one row per retained day.
+func StatsCSV() []byte {
+	var days []string
+	dailyStats.Range(func(day string, _ DailyStats) bool {
+		days = append(days, day)
+		return true
+	})
+	sort.Strings(days)
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"date", "unique_users", "questions", "errors", "avg_latency_ms", "cache_hits", "safety_events"})
+	for _, date := range days {
+		day, _ := dailyStats.Get(date)
+		avg := int64(0)
+		if day.Questions > 0 {
+			avg = day.LatencyMs / int64(day.Questions)
+		}
+		safety := 0
+		for _, count := range day.Safety {
+			safety += count
+		}
+		writer.Write([]string{
+			date,
+			strconv.Itoa(len(day.Users)),
+			strconv.Itoa(day.Questions),
+			strconv.Itoa(day.Errors),
+			strconv.FormatInt(avg, 10),
+			strconv.Itoa(day.CacheHits),
+			strconv.Itoa(safety),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes()
+}
+
+func HandleStatsCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil || !IsBotAdmin(update.Message.From.ID) {
+		return SendHTML(bot, chatID, "This command is only available to bot admins.")
+	}
+
+	if strings.TrimSpace(args) == "csv" {
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+			Name:  "psyai-stats-" + statsDay(time.Now()) + ".csv",
+			Bytes: StatsCSV(),
+		})
+		_, err := bot.Send(doc)
+		return err
+	}
+
+	day, dayUsers := StatsSummary(1)
+	week, weekUsers := StatsSummary(7)
+	text := FormatStats("Today (UTC)", day, dayUsers) + "\n" + FormatStats("Last 7 days", week, weekUsers) +
+		"\n" + FormatTelegramErrorCounts() + "\n<i>/stats csv for daily rows</i>"
+	return SendHTML(bot, chatID, text)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// statusWindow is the period /status reports answer latency and failures over.
+const statusWindow = time.Hour
+
+// BotStatus is what /status tells users about how the bot is doing.
+type BotStatus struct {
+	Answers   int
+	Failed    int
+	Average   time.Duration
+	Queued    int
+	QueueWait time.Duration
+	Streaming bool
+}
+
+// CurrentStatus collects the recent answer metrics and queue state, with streaming as it
+// applies to the user.
+func CurrentStatus(chatID, userID int64, now time.Time) BotStatus {
+	status := BotStatus{Streaming: StreamingEnabled(chatID, userID)}
+	status.Answers, status.Failed, status.Average = RecentHandlerRuns("ask", now.Add(-statusWindow))
+	if askPool != nil {
+		status.Queued = askPool.QueueDepth()
+		if status.Queued > 0 {
+			status.QueueWait = askPool.EstimatedWait(status.Queued)
+		}
+	}
+	return status
+}
+
+// health sums up the status in one line. Answers count as slow when they average more than
+// half the ASK_P95_MS alert threshold.
+func (s BotStatus) health() string {
+	switch {
+	case s.Answers > 0 && float64(s.Failed)/float64(s.Answers) > handlerErrorRateThreshold():
+		return "🔴 <b>The answer service is having trouble.</b> Many questions fail right now; please try again in a while."
+	case s.Answers > 0 && s.Average > slowHandlerThreshold("ask")/2:
+		return "🟡 <b>Answers are slower than usual.</b> Your question will still be answered."
+	case askPool != nil && s.Queued > askPool.workers:
+		return "🟡 <b>It's busy right now.</b> Questions wait in line for a bit."
+	case s.Answers == 0:
+		return "⚪ <b>No questions were answered in the last hour</b>, so there's nothing to measure yet."
+	default:
+		return "🟢 <b>Everything is running normally.</b>"
+	}
+}
+
+// FormatStatus renders the status for /status.
+func FormatStatus(status BotStatus) string {
+	lines := []string{status.health(), ""}
+	if status.Answers > 0 {
+		lines = append(lines, fmt.Sprintf("⏱ Average answer time: %s (%d answers in the last hour)", status.Average.Round(time.Second), status.Answers))
+		if status.Failed > 0 {
+			lines = append(lines, fmt.Sprintf("⚠️ Failed answers: %d of %d", status.Failed, status.Answers))
+		}
+	}
+	if status.Queued > 0 {
+		lines = append(lines, fmt.Sprintf("🧍 Waiting in line: %d (about %s)", status.Queued, status.QueueWait.Round(time.Second)))
+	} else {
+		lines = append(lines, "🧍 No questions waiting")
+	}
+	if status.Streaming {
+		lines = append(lines, "✍️ Streaming is on for you: answers appear while they are written")
+	} else {
+		lines = append(lines, "✍️ Streaming is off: answers appear once they are complete")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HandleStatusCommand tells users whether slow answers are expected right now.
+func HandleStatusCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	var userID int64
+	if update.Message.From != nil {
+		userID = update.Message.From.ID
+	}
+	chatID := update.Message.Chat.ID
+	return SendHTML(bot, chatID, FormatStatus(CurrentStatus(chatID, userID, time.Now())))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// StoppedNote ends an answer whose generation was stopped with the Stop button.
+const StoppedNote = "\n\n<i>⏹ Stopped</i>"
+
+// activeStream is a streamed answer that can still be stopped.
+type activeStream struct {
+	userID int64
+	cancel context.CancelFunc
+}
+
+// activeStreams are the answers being streamed, by "<chat>:<message>".
+var activeStreams = NewBoundedMap[string, *activeStream]("active_streams", 10000, 10*time.Minute)
+
+func activeStreamKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// StartStoppableStream returns the context a streamed answer runs under until the asker taps
+// Stop, and the function to call once the stream is over.
+func StartStoppableStream(chatID int64, messageID int, userID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	key := activeStreamKey(chatID, messageID)
+	activeStreams.Set(key, &activeStream{userID: userID, cancel: cancel})
+	return ctx, func() {
+		activeStreams.Delete(key)
+		cancel()
+	}
+}
+
+// StopKeyboard is shown under an answer while it streams ("st:<message>").
+func StopKeyboard(messageID int) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⏹ Stop", "st:"+strconv.Itoa(messageID))))
+}
+
+// HandleStopCallback cancels a streaming answer. The partial answer is kept and finalized by
+// the answering goroutine.
+func HandleStopCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	messageID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	stream, ok := activeStreams.Get(activeStreamKey(query.Message.Chat.ID, messageID))
+	if !ok {
+		return AnswerCallback(bot, query, "This answer is already finished.")
+	}
+	if stream.userID != 0 && stream.userID != query.From.ID {
+		return AnswerCallback(bot, query, "Only the person who asked can stop this answer.")
+	}
+	stream.cancel()
+	return AnswerCallback(bot, query, "Stopping")
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DataDir returns the directory persistent stores are written to.
+func DataDir() string {
+	if dir := GetenvVar("DATA_DIR", false); dir != "" {
+		return dir
+	}
+	return "data"
+}
+
+// persistentStore is what migrations and metrics need from a JSONStore of any type.
+type persistentStore interface {
+	Len() int
+	Save() error
+}
+
+var (
+	storesMu   sync.Mutex
+	openStores = map[string]persistentStore{}
+)
+
+// OpenStores loads every persistent store from DataDir.
+func OpenStores() error {
+	storesMu.Lock()
+	openStores = map[string]persistentStore{}
+	storesMu.Unlock()
+	var err error
+	if chatSettings, err = NewJSONStore[ChatSettings]("chat_settings"); err != nil {
+		return err
+	}
+	if conversations, err = NewJSONStore[UserSessions]("conversations"); err != nil {
+		return err
+	}
+	if feedback, err = NewJSONStore[FeedbackEntry]("feedback"); err != nil {
+		return err
+	}
+	if botConfig, err = NewJSONStore[string]("bot_config"); err != nil {
+		return err
+	}
+	if doseLog, err = NewJSONStore[[]DoseEntry]("dose_log"); err != nil {
+		return err
+	}
+	if dailyStats, err = NewJSONStore[DailyStats]("daily_stats"); err != nil {
+		return err
+	}
+	if flagOverrides, err = NewJSONStore[FeatureFlag]("feature_flags"); err != nil {
+		return err
+	}
+	if userSettings, err = NewJSONStore[UserSettings]("user_settings"); err != nil {
+		return err
+	}
+	if gateLog, err = NewJSONStore[GateEvent]("gate_log"); err != nil {
+		return err
+	}
+	if bookmarks, err = NewJSONStore[[]Bookmark]("bookmarks"); err != nil {
+		return err
+	}
+	if deadLetters, err = NewJSONStore[DeadLetter]("dead_letters"); err != nil {
+		return err
+	}
+	if entitlements, err = NewJSONStore[Entitlement]("entitlements"); err != nil {
+		return err
+	}
+	if spotlightLog, err = NewJSONStore[time.Time]("spotlight_log"); err != nil {
+		return err
+	}
+	if seenAlerts, err = NewJSONStore[time.Time]("seen_alerts"); err != nil {
+		return err
+	}
+	if ensembleLog, err = NewJSONStore[EnsembleRecord]("ensemble_log"); err != nil {
+		return err
+	}
+	if pinnedAlerts, err = NewJSONStore[PinnedAlert]("pinned_alerts"); err != nil {
+		return err
+	}
+	if heldAnswers, err = NewJSONStore[HeldAnswer]("held_answers"); err != nil {
+		return err
+	}
+	if answerCache, err = NewJSONStore[CachedAnswer]("answer_cache"); err != nil {
+		return err
+	}
+	if jobStates, err = NewJSONStore[JobState]("scheduler_jobs"); err != nil {
+		return err
+	}
+	if thinkingMessages, err = NewJSONStore[ThinkingMessageRecord]("thinking_messages"); err != nil {
+		return err
+	}
+	if substanceNotes, err = NewJSONStore[string]("substance_notes"); err != nil {
+		return err
+	}
+	if checkIns, err = NewJSONStore[CheckIn]("check_ins"); err != nil {
+		return err
+	}
+	if users, err = NewJSONStore[UserProfile]("users"); err != nil {
+		return err
+	}
+	if moderationLog, err = NewJSONStore[ModerationAction]("moderation_log"); err != nil {
+		return err
+	}
+	if archivedSessions, err = NewJSONStore[[]ArchivedSession]("archived_sessions"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// JSONStore is a string-keyed map kept in memory and persisted as a JSON file under DataDir.
+type JSONStore[T any] struct {
+	mu   sync.RWMutex
+	path string
+	data map[string]T
+}
+
+func NewJSONStore[T any](name string) (*JSONStore[T], error) {
+	store := &JSONStore[T]{
+		path: filepath.Join(DataDir(), name+".json"),
+		data: map[string]T{},
+	}
+
+	raw, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		registerStore(name, store)
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading store %s: %w", name, err)
+	}
+	if err := json.Unmarshal(raw, &store.data); err != nil {
+		return nil, fmt.Errorf("error decoding store %s: %w", name, err)
+	}
+	registerStore(name, store)
+	return store, nil
+}
+
+// registerStore records an opened store so migrations and metrics cover it without a list to maintain.
+func registerStore(name string, store persistentStore) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	openStores[name] = store
+}
+
+// persistentStores are the opened stores by name, for migrations and metrics.
+func persistentStores() map[string]persistentStore {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	stores := make(map[string]persistentStore, len(openStores))
+	for name, store := range openStores {
+		stores[name] = store
+	}
+	return stores
+}
+
+func (s *JSONStore[T]) Get(key string) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func (s *JSONStore[T]) Set(key string, value T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return s.save()
+}
+
+// Update applies fn to the current value (zero value if missing) and stores the result.
+func (s *JSONStore[T]) Update(key string, fn func(value T) T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = fn(s.data[key])
+	return s.save()
+}
+
+func (s *JSONStore[T]) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	delete(s.data, key)
+	return s.save()
+}
+
+// DeleteWhere deletes every entry fn matches, saving once, and returns how many it deleted.
+func (s *JSONStore[T]) DeleteWhere(fn func(key string, value T) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for key, value := range s.data {
+		if fn(key, value) {
+			delete(s.data, key)
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, s.save()
+}
+
+// Compact rewrites every entry with fn, deleting those for which it returns false, and returns
+// how many entries it deleted or changed. fn must not modify the value it is given in place.
+func (s *JSONStore[T]) Compact(fn func(value T) (T, bool)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := 0
+	for key, value := range s.data {
+		next, keep := fn(value)
+		if !keep {
+			delete(s.data, key)
+			changed++
+			continue
+		}
+		before, _ := json.Marshal(value)
+		after, _ := json.Marshal(next)
+		if string(before) != string(after) {
+			s.data[key] = next
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	return changed, s.save()
+}
+
+// Range calls fn for every entry until fn returns false. fn must not modify the store.
+func (s *JSONStore[T]) Range(fn func(key string, value T) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, value := range s.data {
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// Save rewrites the store file in the current encoding.
+func (s *JSONStore[T]) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (s *JSONStore[T]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data)
+}
+
+// save writes the store atomically. Callers must hold the write lock.
+func (s *JSONStore[T]) save() error {
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return fmt.Errorf("error encoding store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("error creating data dir: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("error writing store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
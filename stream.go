@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// StreamingEnabled reports whether answers should be streamed into the thinking message.
+// Supporters always get streamed answers.
+func StreamingEnabled(chatID int64, userID int64) bool {
+	return FeatureEnabled(FlagStreaming, chatID, userID) || IsSupporter(userID)
+}
+
+// StreamPrompt posts the request to a server-sent events endpoint and calls onDelta with the
+// answer so far as each fragment arrives. Events look like `data: {"delta": "..."}` and end
+// with `data: [DONE]`; an event may instead carry "error", "refusal" or "moderation", or a
+// progress "status" ("retrieving", "generating") passed to onStatus when it is set. Cancelling
+// ctx ends the request and returns the answer so far, marked Stopped.
+func StreamPrompt(ctx context.Context, apiURL string, request PromptRequest, onDelta func(answer string), onStatus func(status string)) (*PromptResponse, error) {
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := BackendHTTPClient(0).Do(req)
+	if err != nil && ctx.Err() != nil {
+		return &PromptResponse{Stopped: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error making API request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &BackendError{Status: resp.StatusCode, Message: resp.Status}
+	}
+
+	var answer strings.Builder
+	response := &PromptResponse{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+
+		var event struct {
+			Delta      string      `json:"delta"`
+			Error      string      `json:"error"`
+			Refusal    string      `json:"refusal"`
+			Moderation *Moderation `json:"moderation"`
+			Status     string      `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			schemaErr := &SchemaError{Reason: "stream event: " + err.Error()}
+			logSchemaMismatch(schemaErr, []byte(data))
+			return nil, schemaErr
+		}
+		if event.Error != "" {
+			return nil, &BackendError{Status: resp.StatusCode, Message: event.Error}
+		}
+		if event.Refusal != "" {
+			response.Refusal = event.Refusal
+		}
+		if event.Moderation != nil {
+			response.Moderation = event.Moderation
+		}
+		if event.Status != "" && onStatus != nil {
+			onStatus(event.Status)
+		}
+		if event.Delta != "" {
+			answer.WriteString(event.Delta)
+			onDelta(answer.String())
+		}
+		// More than fits in the messages of an answer; closing the connection stops the backend
+		if answer.Len() > maxStreamedAnswerBytes {
+			break
+		}
+	}
+	response.Assistant = answer.String()
+	// Closing the connection is how the backend learns to stop generating
+	if ctx.Err() != nil {
+		response.Stopped = true
+		return response, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	if err := response.Validate(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+var (
+	chatEditMu   sync.Mutex
+	chatNextEdit = map[int64]time.Time{}
+)
+
+func chatEditSlotCount() int {
+	chatEditMu.Lock()
+	defer chatEditMu.Unlock()
+	return len(chatNextEdit)
+}
+
+// reserveChatEdit claims the next edit slot for a chat and returns how long to wait for it.
+// Slots are shared by every stream in the chat so Telegram sees at most one edit per StreamEditInterval.
+func reserveChatEdit(chatID int64) time.Duration {
+	chatEditMu.Lock()
+	defer chatEditMu.Unlock()
+
+	now := time.Now()
+	if len(chatNextEdit) > 1000 {
+		for id, next := range chatNextEdit {
+			if next.Before(now) {
+				delete(chatNextEdit, id)
+			}
+		}
+	}
+	slot := chatNextEdit[chatID]
+	if slot.Before(now) {
+		slot = now
+	}
+	chatNextEdit[chatID] = slot.Add(StreamEditInterval)
+	return slot.Sub(now)
+}
+
+// EditCoalescer turns a burst of partial answers into throttled edits of one message.
+type EditCoalescer struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int
+	// markup is shown under the partial answers
+	markup *tgbotapi.InlineKeyboardMarkup
+
+	mu        sync.Mutex
+	pending   string
+	scheduled bool
+	done      bool
+}
+
+func NewEditCoalescer(bot *tgbotapi.BotAPI, chatID int64, messageID int, markup *tgbotapi.InlineKeyboardMarkup) *EditCoalescer {
+	return &EditCoalescer{bot: bot, chatID: chatID, messageID: messageID, markup: markup}
+}
+
+// Update records the latest partial answer, scheduling an edit if none is pending.
+func (c *EditCoalescer) Update(partial string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return
+	}
+	c.pending = partial
+	if c.scheduled {
+		return
+	}
+	c.scheduled = true
+	time.AfterFunc(reserveChatEdit(c.chatID), c.send)
+}
+
+func (c *EditCoalescer) send() {
+	c.mu.Lock()
+	text := c.pending
+	c.scheduled = false
+	done := c.done
+	c.mu.Unlock()
+
+	if done || strings.TrimSpace(text) == "" {
+		return
+	}
+	// Partial Markdown may have unbalanced markers, so intermediate edits are sent as plain text.
+	edit := tgbotapi.NewEditMessageText(c.chatID, c.messageID, Truncate(text, maxAnswerPartChars)+StreamCursor)
+	edit.ReplyMarkup = c.markup
+	if _, err := c.bot.Send(edit); err != nil {
+		log.Printf("Error sending streamed edit: %v", err)
+	}
+}
+
+// Flush stops further partial edits and sends the final HTML answer once the chat's slot is free.
+func (c *EditCoalescer) Flush(html string, preview *LinkPreviewOptions, markup *tgbotapi.InlineKeyboardMarkup) error {
+	c.mu.Lock()
+	c.done = true
+	c.mu.Unlock()
+
+	time.Sleep(reserveChatEdit(c.chatID))
+	return EditMessageHTML(c.bot, c.chatID, c.messageID, html, preview, markup)
+}
+
+// deferChatEdits holds back the chat's edit slots until Telegram's flood wait is over.
+func deferChatEdits(chatID int64, wait time.Duration) {
+	chatEditMu.Lock()
+	defer chatEditMu.Unlock()
+	if until := time.Now().Add(wait); chatNextEdit[chatID].Before(until) {
+		chatNextEdit[chatID] = until
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Answer styles selectable with /style.
+const (
+	StyleConcise  = "concise"
+	StyleStandard = "standard"
+	StyleDetailed = "detailed"
+)
+
+// styleProfiles adjust the system prompt and token budget of answers.
+var styleProfiles = map[string]struct {
+	Instruction string
+	Tokens      int
+}{
+	StyleConcise:  {"\n\nKeep answers short: a few sentences or up to five bullet points, with the single most important safety point first. Skip background the user didn't ask for.", 400},
+	StyleStandard: {"", 1000},
+	StyleDetailed: {"\n\nGive thorough answers: explain the reasoning and mechanisms, cover dosing, timing, interactions and risk factors where relevant, and say where the evidence is limited.", 2000},
+}
+
+// AnswerStyle is the style answers in the chat are given in. Groups default to concise answers,
+// private chats to standard ones.
+func AnswerStyle(chatID, userID int64) string {
+	if chatID < 0 {
+		if style := GetChatSettings(chatID).Style; style != "" {
+			return style
+		}
+		return StyleConcise
+	}
+	if style := GetUserSettings(userID).Style; style != "" {
+		return style
+	}
+	return StyleStandard
+}
+
+// ApplyStyle sets the token budget of a request and adds the style's instruction to its prompt.
+func ApplyStyle(request *PromptRequest, style string) {
+	profile, ok := styleProfiles[style]
+	if !ok {
+		profile = styleProfiles[StyleStandard]
+	}
+	request.SystemPrompt += profile.Instruction
+	request.Tokens = profile.Tokens
+}
+
+const styleUsage = "Usage: /style concise|standard|detailed, or /style default"
+
+// HandleStyleCommand shows or changes the answer style: the user's own in private chats, the
+// group's for group admins.
+func HandleStyleCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chat := update.Message.Chat
+	var userID int64
+	if update.Message.From != nil {
+		userID = update.Message.From.ID
+	}
+	value := strings.ToLower(strings.TrimSpace(args))
+	if value == "" {
+		return SendHTML(bot, chat.ID, fmt.Sprintf("Answers here are <b>%s</b>.\n%s", AnswerStyle(chat.ID, userID), styleUsage))
+	}
+	if _, ok := styleProfiles[value]; !ok && value != "default" {
+		return SendHTML(bot, chat.ID, styleUsage)
+	}
+	if value == "default" {
+		value = ""
+	}
+
+	var err error
+	if chat.IsPrivate() {
+		err = UpdateUserSettings(userID, func(settings *UserSettings) { settings.Style = value })
+	} else {
+		if userID == 0 || !IsChatAdmin(bot, chat, userID) {
+			return SendHTML(bot, chat.ID, "Only group admins can change the answer style.")
+		}
+		err = UpdateChatSettings(chat.ID, func(settings *ChatSettings) { settings.Style = value })
+	}
+	if err != nil {
+		return err
+	}
+	return SendHTML(bot, chat.ID, fmt.Sprintf("From now on answers here are <b>%s</b>.", AnswerStyle(chat.ID, userID)))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Interaction risk levels, following the TripSit combination chart.
+const (
+	RiskDangerous     = "Dangerous"
+	RiskUnsafe        = "Unsafe"
+	RiskCaution       = "Caution"
+	RiskLowSynergy    = "Low Risk & Synergy"
+	RiskLowNoSynergy  = "Low Risk & No Synergy"
+	RiskLowDecrease   = "Low Risk & Decrease"
+	InteractionSource = "TripSit combination chart"
+)
+
+// Substance is the curated data the bot knows about a substance without asking the backend.
+type Substance struct {
+	Name string
+	// Duration is how long after a dose the substance should still be considered active,
+	// including the washout period for long-acting medications.
+	Duration time.Duration
+}
+
+var substances = map[string]Substance{
+	"2c-b":            {Name: "2C-B", Duration: 8 * time.Hour},
+	"alcohol":         {Name: "Alcohol", Duration: 6 * time.Hour},
+	"amphetamine":     {Name: "Amphetamine", Duration: 8 * time.Hour},
+	"benzodiazepines": {Name: "Benzodiazepines", Duration: 12 * time.Hour},
+	"cannabis":        {Name: "Cannabis", Duration: 6 * time.Hour},
+	"cocaine":         {Name: "Cocaine", Duration: 2 * time.Hour},
+	"dxm":             {Name: "DXM", Duration: 8 * time.Hour},
+	"ghb":             {Name: "GHB", Duration: 4 * time.Hour},
+	"ketamine":        {Name: "Ketamine", Duration: 2 * time.Hour},
+	"lsd":             {Name: "LSD", Duration: 12 * time.Hour},
+	"maois":           {Name: "MAOIs", Duration: 14 * 24 * time.Hour},
+	"mdma":            {Name: "MDMA", Duration: 6 * time.Hour},
+	"nitrous":         {Name: "Nitrous", Duration: 30 * time.Minute},
+	"opioids":         {Name: "Opioids", Duration: 8 * time.Hour},
+	"psilocybin":      {Name: "Psilocybin", Duration: 6 * time.Hour},
+	"ssris":           {Name: "SSRIs", Duration: 14 * 24 * time.Hour},
+	"tramadol":        {Name: "Tramadol", Duration: 8 * time.Hour},
+}
+
+// interactions is keyed by the two substance keys in alphabetical order, joined with "+".
+var interactions = map[string]string{
+	"2c-b+lsd":                    RiskLowSynergy,
+	"2c-b+maois":                  RiskUnsafe,
+	"2c-b+mdma":                   RiskLowSynergy,
+	"2c-b+tramadol":               RiskUnsafe,
+	"alcohol+amphetamine":         RiskCaution,
+	"alcohol+benzodiazepines":     RiskDangerous,
+	"alcohol+cannabis":            RiskCaution,
+	"alcohol+cocaine":             RiskUnsafe,
+	"alcohol+dxm":                 RiskDangerous,
+	"alcohol+ghb":                 RiskDangerous,
+	"alcohol+ketamine":            RiskDangerous,
+	"alcohol+mdma":                RiskCaution,
+	"alcohol+nitrous":             RiskCaution,
+	"alcohol+opioids":             RiskDangerous,
+	"alcohol+tramadol":            RiskDangerous,
+	"amphetamine+benzodiazepines": RiskLowDecrease,
+	"amphetamine+cannabis":        RiskCaution,
+	"amphetamine+cocaine":         RiskCaution,
+	"amphetamine+dxm":             RiskUnsafe,
+	"amphetamine+maois":           RiskDangerous,
+	"amphetamine+mdma":            RiskCaution,
+	"amphetamine+tramadol":        RiskDangerous,
+	"benzodiazepines+cannabis":    RiskLowSynergy,
+	"benzodiazepines+dxm":         RiskCaution,
+	"benzodiazepines+ghb":         RiskDangerous,
+	"benzodiazepines+ketamine":    RiskCaution,
+	"benzodiazepines+lsd":         RiskLowDecrease,
+	"benzodiazepines+mdma":        RiskLowDecrease,
+	"benzodiazepines+opioids":     RiskDangerous,
+	"benzodiazepines+psilocybin":  RiskLowDecrease,
+	"benzodiazepines+tramadol":    RiskDangerous,
+	"cannabis+cocaine":            RiskCaution,
+	"cannabis+ketamine":           RiskLowSynergy,
+	"cannabis+lsd":                RiskCaution,
+	"cannabis+mdma":               RiskLowSynergy,
+	"cannabis+psilocybin":         RiskCaution,
+	"cocaine+dxm":                 RiskUnsafe,
+	"cocaine+maois":               RiskDangerous,
+	"cocaine+mdma":                RiskCaution,
+	"cocaine+opioids":             RiskDangerous,
+	"cocaine+tramadol":            RiskDangerous,
+	"dxm+ghb":                     RiskDangerous,
+	"dxm+ketamine":                RiskCaution,
+	"dxm+maois":                   RiskDangerous,
+	"dxm+mdma":                    RiskDangerous,
+	"dxm+opioids":                 RiskDangerous,
+	"dxm+ssris":                   RiskDangerous,
+	"dxm+tramadol":                RiskDangerous,
+	"ghb+ketamine":                RiskDangerous,
+	"ghb+opioids":                 RiskDangerous,
+	"ghb+tramadol":                RiskDangerous,
+	"ketamine+mdma":               RiskLowSynergy,
+	"ketamine+nitrous":            RiskLowSynergy,
+	"ketamine+opioids":            RiskDangerous,
+	"ketamine+tramadol":           RiskDangerous,
+	"lsd+mdma":                    RiskLowSynergy,
+	"lsd+nitrous":                 RiskLowSynergy,
+	"lsd+psilocybin":              RiskLowSynergy,
+	"lsd+ssris":                   RiskLowDecrease,
+	"lsd+tramadol":                RiskUnsafe,
+	"maois+mdma":                  RiskDangerous,
+	"maois+opioids":               RiskDangerous,
+	"maois+ssris":                 RiskDangerous,
+	"maois+tramadol":              RiskDangerous,
+	"mdma+nitrous":                RiskLowSynergy,
+	"mdma+psilocybin":             RiskLowSynergy,
+	"mdma+ssris":                  RiskLowDecrease,
+	"mdma+tramadol":               RiskDangerous,
+	"nitrous+psilocybin":          RiskLowSynergy,
+	"opioids+tramadol":            RiskDangerous,
+	"psilocybin+ssris":            RiskLowDecrease,
+	"psilocybin+tramadol":         RiskUnsafe,
+	"ssris+tramadol":              RiskDangerous,
+}
+
+// LookupSubstance returns the canonical key and data for a substance name or alias.
+func LookupSubstance(name string) (string, Substance, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if canonical, ok := substanceAliases[key]; ok {
+		key = canonical
+	}
+	substance, ok := substances[key]
+	return key, substance, ok
+}
+
+// Interaction returns the risk level of combining two substance keys.
+func Interaction(a, b string) (string, bool) {
+	if a > b {
+		a, b = b, a
+	}
+	risk, ok := interactions[a+"+"+b]
+	return risk, ok
+}
+
+// IsRiskyInteraction reports whether a risk level deserves a warning.
+func IsRiskyInteraction(risk string) bool {
+	return risk == RiskDangerous || risk == RiskUnsafe || risk == RiskCaution
+}
+
+// RiskEmoji gives a quick visual cue for a risk level.
+func RiskEmoji(risk string) string {
+	switch risk {
+	case RiskDangerous:
+		return "🟥"
+	case RiskUnsafe:
+		return "🟧"
+	case RiskCaution:
+		return "🟨"
+	default:
+		return "🟩"
+	}
+}
+
+var substanceWordPattern = regexp.MustCompile(`[\p{L}\p{N}-]+`)
+
+// DetectSubstances returns the keys of known substances mentioned in text.
+func DetectSubstances(text string) []string {
+	var found []string
+	seen := map[string]bool{}
+	for _, word := range substanceWordPattern.FindAllString(strings.ToLower(text), -1) {
+		// Short aliases like "k" or "md" are too ambiguous in free text.
+		if canonical, ok := substanceAliases[word]; ok && len(word) > 2 {
+			word = canonical
+		}
+		if _, ok := substances[word]; ok && !seen[word] {
+			seen[word] = true
+			found = append(found, word)
+		}
+	}
+	return found
+}
+
+// FormatSubstanceCard renders what the configured sources know about a substance key.
+func FormatSubstanceCard(key string, locale Locale) string {
+	var b strings.Builder
+	name := substances[key].Name
+	info, _, err := GetSubstanceInfo(key)
+	if err == nil && info.Name != "" {
+		name = info.Name
+	}
+	fmt.Fprintf(&b, "<b>%s</b>\n", html.EscapeString(name))
+	if err == nil && info.Summary != "" {
+		fmt.Fprintf(&b, "%s\n", html.EscapeString(info.Summary))
+	}
+	duration := substances[key].Duration
+	if err == nil && info.Duration > 0 {
+		duration = info.Duration
+	}
+	if duration > 0 {
+		fmt.Fprintf(&b, "Considered active for about %s after a dose.\n", FormatDuration(duration))
+	}
+
+	if doses, source, err := GetSubstanceDoses(key); err == nil {
+		b.WriteString("\n<b>Dosage</b>\n")
+		for _, dose := range doses {
+			fmt.Fprintf(&b, "%s · %s: %s\n", html.EscapeString(dose.Route), html.EscapeString(dose.Level), html.EscapeString(locale.Amounts(dose.Amount)))
+		}
+		fmt.Fprintf(&b, "<i>Source: %s</i>\n", html.EscapeString(source))
+	}
+
+	risks, source, err := GetSubstanceInteractions(key)
+	if err != nil {
+		return b.String()
+	}
+	byRisk := map[string][]string{}
+	for other, risk := range risks {
+		if other == key || !IsRiskyInteraction(risk) {
+			continue
+		}
+		otherName := other
+		if data, ok := substances[other]; ok {
+			otherName = data.Name
+		}
+		byRisk[risk] = append(byRisk[risk], otherName)
+	}
+	if len(byRisk) > 0 {
+		if source == (localSource{}).Name() {
+			source = InteractionSource
+		}
+		b.WriteString("\n<b>Risky combinations</b>\n")
+		for _, risk := range []string{RiskDangerous, RiskUnsafe, RiskCaution} {
+			if names := byRisk[risk]; len(names) > 0 {
+				sort.Strings(names)
+				fmt.Fprintf(&b, "%s %s: %s\n", RiskEmoji(risk), risk, html.EscapeString(strings.Join(names, ", ")))
+			}
+		}
+		fmt.Fprintf(&b, "<i>Source: %s</i>", html.EscapeString(source))
+	}
+	return b.String()
+}
+
+// FormatDuration renders durations as "6h", "30m" or "14 days".
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d >= time.Hour:
+		return fmt.Sprintf("%.1fh", d.Hours())
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// recentTurnsKept is how many of the latest turns stay verbatim when older ones are summarized.
+	recentTurnsKept = 4
+	// maxStoredTurns caps memory if summarization keeps failing.
+	maxStoredTurns = 3 * MaxSessionTurns
+
+	summarySystemPrompt = "You condense conversations between a user and a harm reduction assistant. " +
+		"Keep substances, doses, timings, health conditions and open questions. Be brief and factual."
+)
+
+// compacting tracks users whose session is being summarized, so only one summary runs at a time.
+var compacting sync.Map
+
+// HistoryTokenBudget is the approximate token budget for conversation memory (HISTORY_TOKEN_BUDGET, default 2000).
+func HistoryTokenBudget() int {
+	budget, err := strconv.Atoi(GetenvVar("HISTORY_TOKEN_BUDGET", false))
+	if err != nil || budget <= 0 {
+		return 2000
+	}
+	return budget
+}
+
+// EstimateTokens approximates the token count of text at four characters per token.
+func EstimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+func sessionTokens(session Session) int {
+	tokens := EstimateTokens(session.Summary)
+	for _, turn := range session.Turns {
+		tokens += EstimateTokens(turn.Question) + EstimateTokens(turn.Answer)
+	}
+	return tokens
+}
+
+// NeedsCompaction reports whether a session's memory is over budget and has turns old enough to summarize.
+func NeedsCompaction(session Session) bool {
+	if len(session.Turns) <= recentTurnsKept {
+		return false
+	}
+	return len(session.Turns) > MaxSessionTurns || sessionTokens(session) > HistoryTokenBudget()
+}
+
+// SummarizeTurns asks the backend to fold turns into the running summary.
+func SummarizeTurns(previous string, turns []Turn) (string, error) {
+	var b strings.Builder
+	b.WriteString("Summarize the conversation below in at most 150 words.\n\n")
+	if previous != "" {
+		fmt.Fprintf(&b, "Summary of the conversation so far:\n%s\n\n", previous)
+	}
+	for _, turn := range turns {
+		if turn.Question != "" {
+			fmt.Fprintf(&b, "User: %s\n", turn.Question)
+		}
+		fmt.Fprintf(&b, "Assistant: %s\n", Truncate(turn.Answer, 2000))
+	}
+
+	response, err := Ask(PromptRequest{
+		Question:     b.String(),
+		Temperature:  0.2,
+		Tokens:       300,
+		SystemPrompt: summarySystemPrompt,
+	})
+	if err != nil {
+		return "", err
+	}
+	if response.Refused() {
+		return "", fmt.Errorf("backend refused to summarize")
+	}
+	return strings.TrimSpace(response.Text()), nil
+}
+
+// CompactSession summarizes the older turns of the user's active session when it is over budget,
+// keeping the summary and the latest recentTurnsKept turns.
+func CompactSession(userID int64) {
+	if conversations == nil {
+		return
+	}
+	if _, busy := compacting.LoadOrStore(userID, true); busy {
+		return
+	}
+	defer compacting.Delete(userID)
+
+	user, _ := conversations.Get(ChatKey(userID))
+	name := user.ActiveName()
+	session := user.Sessions[name]
+	if !NeedsCompaction(session) {
+		return
+	}
+	older := session.Turns[:len(session.Turns)-recentTurnsKept]
+	summary, err := SummarizeTurns(session.Summary, older)
+	if err != nil {
+		log.Printf("Error summarizing conversation: %v", err)
+		return
+	}
+
+	err = conversations.Update(ChatKey(userID), func(user UserSessions) UserSessions {
+		current, ok := user.Sessions[name]
+		// Turns may have been added meanwhile, or the session reset; only drop what was summarized
+		if !ok || len(current.Turns) < len(older) || !current.Turns[len(older)-1].At.Equal(older[len(older)-1].At) {
+			return user
+		}
+		user = user.cloned()
+		current.Summary = summary
+		current.Turns = append([]Turn{}, current.Turns[len(older):]...)
+		user.Sessions[name] = current
+		return user
+	})
+	if err != nil {
+		log.Printf("Error saving conversation summary: %v", err)
+	}
+}
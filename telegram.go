@@ -0,0 +1,50 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// EditMessageHTML edits a message with fields the bundled tgbotapi version doesn't expose yet.
+func EditMessageHTML(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string, preview *LinkPreviewOptions, markup *tgbotapi.InlineKeyboardMarkup) error {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_id", messageID)
+	params.AddNonEmpty("text", text)
+	params.AddNonEmpty("parse_mode", tgbotapi.ModeHTML)
+	if err := params.AddInterface("link_preview_options", preview); err != nil {
+		return err
+	}
+	if err := params.AddInterface("reply_markup", markup); err != nil {
+		return err
+	}
+
+	_, err := bot.MakeRequest("editMessageText", params)
+	return err
+}
+
+// SendHTML sends a plain HTML-formatted message to the chat.
+func SendHTML(bot *tgbotapi.BotAPI, chatID int64, text string) error {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	_, err := bot.Send(msg)
+	return err
+}
+
+// MessageText returns the text of a message and its entities, falling back to the caption
+// and caption entities for photos and documents.
+func MessageText(message *tgbotapi.Message) (string, []tgbotapi.MessageEntity) {
+	if message.Text == "" && message.Caption != "" {
+		return message.Caption, message.CaptionEntities
+	}
+	return message.Text, message.Entities
+}
+
+// SetWebhook registers a webhook with a secret token, which the bundled tgbotapi version can't
+// send. Telegram echoes the token in the X-Telegram-Bot-Api-Secret-Token header of every update.
+func SetWebhook(bot *tgbotapi.BotAPI, publicURL, secretToken string) error {
+	params := tgbotapi.Params{}
+	params.AddNonEmpty("url", publicURL)
+	params.AddNonEmpty("secret_token", secretToken)
+	_, err := bot.MakeRequest("setWebhook", params)
+	return err
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Categories Telegram API failures are counted under.
+const (
+	TelegramBlocked     = "blocked"
+	TelegramParse       = "parse"
+	TelegramFlood       = "flood"
+	TelegramMigrated    = "migrated"
+	TelegramNotModified = "not_modified"
+	TelegramNotFound    = "not_found"
+	TelegramBadRequest  = "bad_request"
+	TelegramServer      = "server"
+)
+
+// classifyTelegramFailure sorts a Bot API error response into a category.
+func classifyTelegramFailure(code int, description string, parameters tgbotapi.ResponseParameters) string {
+	description = strings.ToLower(description)
+	switch {
+	case parameters.MigrateToChatID != 0:
+		return TelegramMigrated
+	case code == 429 || parameters.RetryAfter > 0:
+		return TelegramFlood
+	case code == 403 || strings.Contains(description, "chat not found"):
+		return TelegramBlocked
+	case strings.Contains(description, "can't parse entities") || strings.Contains(description, "unsupported start tag"):
+		return TelegramParse
+	case strings.Contains(description, "message is not modified"):
+		return TelegramNotModified
+	case strings.Contains(description, "not found"):
+		return TelegramNotFound
+	case code >= 500:
+		return TelegramServer
+	}
+	return TelegramBadRequest
+}
+
+// ClassifyTelegramError returns the category of a Bot API error, or "" for other errors.
+func ClassifyTelegramError(err error) string {
+	apiErr, ok := telegramError(err)
+	if !ok {
+		return ""
+	}
+	return classifyTelegramFailure(apiErr.Code, apiErr.Message, apiErr.ResponseParameters)
+}
+
+// ExpectedTelegramError reports whether err is a Bot API failure that is remediated
+// automatically or harmless, so it isn't worth an error report.
+func ExpectedTelegramError(err error) bool {
+	switch ClassifyTelegramError(err) {
+	case TelegramBlocked, TelegramMigrated, TelegramNotModified:
+		return true
+	}
+	return false
+}
+
+// TelegramErrorStats counts the failures of one category since startup.
+type TelegramErrorStats struct {
+	Category string         `json:"category"`
+	Count    int            `json:"count"`
+	Methods  map[string]int `json:"methods"`
+	Last     string         `json:"last"`
+	LastAt   time.Time      `json:"last_at"`
+}
+
+var (
+	telegramErrorsMu sync.Mutex
+	telegramErrors   = map[string]*TelegramErrorStats{}
+)
+
+func recordTelegramFailure(category, method, description string) {
+	telegramErrorsMu.Lock()
+	defer telegramErrorsMu.Unlock()
+	stats, ok := telegramErrors[category]
+	if !ok {
+		stats = &TelegramErrorStats{Category: category, Methods: map[string]int{}}
+		telegramErrors[category] = stats
+	}
+	stats.Count++
+	stats.Methods[method]++
+	stats.Last = Truncate(description, 160)
+	stats.LastAt = time.Now()
+}
+
+// TelegramErrorCounts returns the failure counts of every category seen, most frequent first.
+func TelegramErrorCounts() []TelegramErrorStats {
+	telegramErrorsMu.Lock()
+	defer telegramErrorsMu.Unlock()
+	all := make([]TelegramErrorStats, 0, len(telegramErrors))
+	for _, stats := range telegramErrors {
+		methods := make(map[string]int, len(stats.Methods))
+		for method, count := range stats.Methods {
+			methods[method] = count
+		}
+		copied := *stats
+		copied.Methods = methods
+		all = append(all, copied)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Count > all[j].Count })
+	return all
+}
+
+// FormatTelegramErrorCounts summarizes the failure counts on one line for /stats.
+func FormatTelegramErrorCounts() string {
+	counts := TelegramErrorCounts()
+	if len(counts) == 0 {
+		return "Telegram API errors since start: none"
+	}
+	parts := make([]string, len(counts))
+	for i, stats := range counts {
+		parts[i] = fmt.Sprintf("%s %d", stats.Category, stats.Count)
+	}
+	return "Telegram API errors since start: " + strings.Join(parts, " · ")
+}
+
+// HandleTelegramErrors serves the failure counts as JSON on the internal server.
+func HandleTelegramErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TelegramErrorCounts())
+}
+
+// telegramObserver sees every Bot API response, so failures are counted, logged and remediated
+// once, whichever code path made the request. It runs on the caller's goroutine, so it must never
+// take a store lock: remediations that update stores are queued instead.
+type telegramObserver struct {
+	next http.RoundTripper
+}
+
+func (o telegramObserver) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.next.RoundTrip(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return resp, nil
+	}
+
+	var failure tgbotapi.APIResponse
+	if json.Unmarshal(body, &failure) != nil || failure.Ok {
+		return resp, nil
+	}
+	var parameters tgbotapi.ResponseParameters
+	if failure.Parameters != nil {
+		parameters = *failure.Parameters
+	}
+	handleTelegramFailure(path.Base(req.URL.Path), requestChatID(req), failure.ErrorCode, failure.Description, parameters)
+	return resp, nil
+}
+
+// requestChatID reads the chat_id parameter of a form-encoded request, 0 when there's none.
+// Uploads are multipart and not inspected.
+func requestChatID(req *http.Request) int64 {
+	if req.GetBody == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return 0
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return 0
+	}
+	defer body.Close()
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return 0
+	}
+	values, err := url.ParseQuery(string(raw))
+	if err != nil {
+		return 0
+	}
+	chatID, _ := strconv.ParseInt(values.Get("chat_id"), 10, 64)
+	return chatID
+}
+
+// telegramRemediation is a chat state change a failed Bot API call calls for.
+type telegramRemediation struct {
+	category    string
+	chatID      int64
+	description string
+	parameters  tgbotapi.ResponseParameters
+}
+
+// telegramRemediationQueueSize bounds the remediations waiting to be applied. A full queue drops
+// them: a blocked chat is deactivated by the next failed send all the same.
+const telegramRemediationQueueSize = 256
+
+var (
+	telegramRemediations     chan telegramRemediation
+	telegramRemediationsOnce sync.Once
+)
+
+// queueTelegramRemediation hands a remediation to a background worker without blocking. The
+// observer runs inside every bot.Send, and callers may hold a store lock while sending, for
+// instance in a Range, so the chat settings it updates are never touched on the request path.
+func queueTelegramRemediation(remediation telegramRemediation) {
+	telegramRemediationsOnce.Do(func() {
+		telegramRemediations = make(chan telegramRemediation, telegramRemediationQueueSize)
+		go func() {
+			for remediation := range telegramRemediations {
+				applyTelegramRemediation(remediation)
+			}
+		}()
+	})
+	select {
+	case telegramRemediations <- remediation:
+	default:
+		log.Printf("Telegram remediation queue full, dropped %s for chat %d", remediation.category, remediation.chatID)
+	}
+}
+
+// handleTelegramFailure counts and logs a failed Bot API call and applies the remediation for
+// its category: chats that blocked or removed the bot are deactivated and migrated groups are
+// moved to their supergroup, both in the background, and flood waits hold back stream edits in
+// the chat right away.
+func handleTelegramFailure(method string, chatID int64, code int, description string, parameters tgbotapi.ResponseParameters) {
+	category := classifyTelegramFailure(code, description, parameters)
+	recordTelegramFailure(category, method, description)
+	log.Printf("Telegram API error: category=%s method=%s chat=%d code=%d retry_after=%d: %s",
+		category, method, chatID, code, parameters.RetryAfter, description)
+	if chatID == 0 {
+		return
+	}
+
+	switch category {
+	case TelegramBlocked, TelegramMigrated:
+		queueTelegramRemediation(telegramRemediation{category: category, chatID: chatID, description: description, parameters: parameters})
+	case TelegramFlood:
+		// Only touches the in-memory edit slots, never a store
+		deferChatEdits(chatID, time.Duration(parameters.RetryAfter)*time.Second)
+	}
+}
+
+// applyTelegramRemediation updates the stored state of a chat after a failed call.
+func applyTelegramRemediation(remediation telegramRemediation) {
+	chatID := remediation.chatID
+	switch remediation.category {
+	case TelegramBlocked:
+		if chatSettings == nil || !ChatActive(chatID) {
+			return
+		}
+		if err := MarkChatInactive(chatID, time.Now()); err != nil {
+			log.Printf("Error deactivating chat %d: %v", chatID, err)
+			return
+		}
+		log.Printf("Deactivated chat %d: %s", chatID, remediation.description)
+	case TelegramMigrated:
+		to := remediation.parameters.MigrateToChatID
+		if err := MigrateChat(chatID, to); err != nil {
+			log.Printf("Error migrating chat %d to %d: %v", chatID, to, err)
+		}
+	}
+}
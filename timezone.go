@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+	// Embedded so time zones work on hosts without a zoneinfo database
+	_ "time/tzdata"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UserSettings holds per-user preferences that follow the user across chats.
+type UserSettings struct {
+	TimeZone string `json:"time_zone,omitempty"`
+	// Language is the code of the language answers are given in, chosen during /start
+	Language string `json:"language,omitempty"`
+	// DoseUnit is used by /log when the amount has no unit
+	DoseUnit             string    `json:"dose_unit,omitempty"`
+	DisclaimerAcceptedAt time.Time `json:"disclaimer_accepted_at,omitempty"`
+	// WeeklySummary opts into the weekly DM summarizing logged doses
+	WeeklySummary       bool      `json:"weekly_summary,omitempty"`
+	WeeklySummarySentAt time.Time `json:"weekly_summary_sent_at,omitempty"`
+	// Referral is the tag of the "ref_<tag>" deep link the user first started the bot with
+	Referral   string    `json:"referral,omitempty"`
+	ReferredAt time.Time `json:"referred_at,omitempty"`
+	// Style is the answer style set with /style, empty for standard
+	Style string `json:"style,omitempty"`
+	// HideQuickActions hides the quick actions keyboard of private chats
+	HideQuickActions bool `json:"hide_quick_actions,omitempty"`
+}
+
+var userSettings *JSONStore[UserSettings]
+
+func GetUserSettings(userID int64) UserSettings {
+	if userSettings == nil {
+		return UserSettings{}
+	}
+	settings, _ := userSettings.Get(ChatKey(userID))
+	return settings
+}
+
+func UpdateUserSettings(userID int64, fn func(settings *UserSettings)) error {
+	return userSettings.Update(ChatKey(userID), func(settings UserSettings) UserSettings {
+		fn(&settings)
+		return settings
+	})
+}
+
+// UserLocation returns the user's time zone, UTC when none is set.
+func UserLocation(userID int64) *time.Location {
+	name := GetUserSettings(userID).TimeZone
+	if name == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// ZoneFromLocation approximates the time zone of a point from its longitude. Without a
+// boundary database this is a fixed offset, so it doesn't follow daylight saving time.
+func ZoneFromLocation(longitude float64) string {
+	offset := int(math.Round(longitude / 15))
+	if offset == 0 {
+		return "UTC"
+	}
+	// Etc/GMT zones have inverted signs: Etc/GMT-2 is UTC+2
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+const timezoneUsage = "Usage: /timezone &lt;zone&gt;, e.g. /timezone Europe/Berlin, or share your location in a private chat."
+
+func HandleTimezoneCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil {
+		return nil
+	}
+	userID := update.Message.From.ID
+	name := strings.TrimSpace(args)
+
+	if name == "" {
+		text := fmt.Sprintf("Your time zone is <b>%s</b>.\n%s", html.EscapeString(UserLocation(userID).String()), timezoneUsage)
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		// Location requests only work in private chats
+		if update.Message.Chat.IsPrivate() {
+			button := tgbotapi.NewKeyboardButtonLocation("📍 Share location")
+			keyboard := tgbotapi.NewOneTimeReplyKeyboard(tgbotapi.NewKeyboardButtonRow(button))
+			msg.ReplyMarkup = keyboard
+		}
+		_, err := bot.Send(msg)
+		return err
+	}
+
+	if strings.EqualFold(name, "utc") || strings.EqualFold(name, "reset") {
+		name = ""
+	} else if _, err := time.LoadLocation(name); err != nil || !strings.Contains(name, "/") {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't know the time zone <b>%s</b>. %s", html.EscapeString(name), timezoneUsage))
+	}
+	if err := UpdateUserSettings(userID, func(settings *UserSettings) { settings.TimeZone = name }); err != nil {
+		return err
+	}
+	return SendHTML(bot, chatID, fmt.Sprintf("Time zone set to <b>%s</b>. Your current time is %s.",
+		html.EscapeString(UserLocation(userID).String()), UserLocale(userID).Clock(time.Now().In(UserLocation(userID)))))
+}
+
+// HandleSharedLocation sets the user's time zone from a location shared in a private chat.
+func HandleSharedLocation(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
+	message := update.Message
+	zone := ZoneFromLocation(message.Location.Longitude)
+	if err := UpdateUserSettings(message.From.ID, func(settings *UserSettings) { settings.TimeZone = zone }); err != nil {
+		return err
+	}
+	reply := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+		"Time zone set to %s from your location (your time is %s). It won't adjust for daylight saving time; use /timezone Region/City for that.",
+		zone, UserLocale(message.From.ID).Clock(time.Now().In(UserLocation(message.From.ID)))))
+	reply.ReplyMarkup = tgbotapi.NewRemoveKeyboard(false)
+	if QuickActionsShown(message.From.ID) {
+		reply.ReplyMarkup = QuickActionsKeyboard()
+	}
+	_, err := bot.Send(reply)
+	return err
+}
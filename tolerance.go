@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	ToleranceHigh     = "High"
+	TolerancePartial  = "Partial"
+	ToleranceBaseline = "Back to baseline"
+)
+
+// ToleranceProfile is curated, approximate tolerance data for a substance. Tolerance is
+// roughly halved after HalfReset and back to baseline after FullReset.
+type ToleranceProfile struct {
+	HalfReset time.Duration
+	FullReset time.Duration
+	// CrossTolerance lists substances whose use also raises tolerance to this one.
+	CrossTolerance []string
+	// DropsFaster marks substances where resuming an old dose after a break is a known overdose risk.
+	DropsFaster bool
+}
+
+const oneDay = 24 * time.Hour
+
+var toleranceProfiles = map[string]ToleranceProfile{
+	"2c-b":            {HalfReset: 5 * oneDay, FullReset: 14 * oneDay, CrossTolerance: []string{"lsd", "psilocybin"}},
+	"alcohol":         {HalfReset: 7 * oneDay, FullReset: 30 * oneDay, CrossTolerance: []string{"benzodiazepines", "ghb"}, DropsFaster: true},
+	"amphetamine":     {HalfReset: 3 * oneDay, FullReset: 7 * oneDay, CrossTolerance: []string{"cocaine", "mdma"}},
+	"benzodiazepines": {HalfReset: 7 * oneDay, FullReset: 21 * oneDay, CrossTolerance: []string{"alcohol", "ghb"}, DropsFaster: true},
+	"cannabis":        {HalfReset: 2 * oneDay, FullReset: 21 * oneDay},
+	"cocaine":         {HalfReset: 1 * oneDay, FullReset: 3 * oneDay, CrossTolerance: []string{"amphetamine"}},
+	"dxm":             {HalfReset: 7 * oneDay, FullReset: 14 * oneDay, CrossTolerance: []string{"ketamine", "nitrous"}},
+	"ghb":             {HalfReset: 2 * oneDay, FullReset: 7 * oneDay, CrossTolerance: []string{"alcohol", "benzodiazepines"}, DropsFaster: true},
+	"ketamine":        {HalfReset: 3 * oneDay, FullReset: 14 * oneDay, CrossTolerance: []string{"dxm", "nitrous"}},
+	"lsd":             {HalfReset: 5 * oneDay, FullReset: 14 * oneDay, CrossTolerance: []string{"2c-b", "psilocybin"}},
+	"mdma":            {HalfReset: 30 * oneDay, FullReset: 90 * oneDay, CrossTolerance: []string{"amphetamine"}},
+	"nitrous":         {HalfReset: 1 * oneDay, FullReset: 3 * oneDay, CrossTolerance: []string{"dxm", "ketamine"}},
+	"opioids":         {HalfReset: 3 * oneDay, FullReset: 7 * oneDay, CrossTolerance: []string{"tramadol"}, DropsFaster: true},
+	"psilocybin":      {HalfReset: 5 * oneDay, FullReset: 14 * oneDay, CrossTolerance: []string{"2c-b", "lsd"}},
+	"tramadol":        {HalfReset: 3 * oneDay, FullReset: 7 * oneDay, CrossTolerance: []string{"opioids"}, DropsFaster: true},
+}
+
+// ToleranceStatus estimates the tolerance left after a break.
+func ToleranceStatus(profile ToleranceProfile, since time.Duration) string {
+	switch {
+	case since < profile.HalfReset:
+		return ToleranceHigh
+	case since < profile.FullReset:
+		return TolerancePartial
+	default:
+		return ToleranceBaseline
+	}
+}
+
+// lastUse returns when a user last logged a substance key.
+func lastUse(history []DoseEntry, key string) (time.Time, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		if entryKey, _, _ := LookupSubstance(history[i].Substance); entryKey == key {
+			return history[i].At, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func formatDays(d time.Duration) string {
+	days := int(d / oneDay)
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// FormatTolerance renders the curated estimate and cross-tolerance warnings.
+func FormatTolerance(key string, since time.Duration, history []DoseEntry) string {
+	profile := toleranceProfiles[key]
+	name := substances[key].Name
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s tolerance</b> · %s since last use\n", html.EscapeString(name), formatDays(since))
+	fmt.Fprintf(&b, "Estimated status: <b>%s</b>\n", ToleranceStatus(profile, since))
+	fmt.Fprintf(&b, "Roughly halves after %s, back to baseline after about %s.\n", formatDays(profile.HalfReset), formatDays(profile.FullReset))
+	if profile.DropsFaster {
+		b.WriteString("\n⚠️ Tolerance drops faster than it builds. Going back to your old dose after a break is a common cause of overdose, so start lower.\n")
+	}
+
+	if len(profile.CrossTolerance) > 0 {
+		var names []string
+		for _, other := range profile.CrossTolerance {
+			names = append(names, substances[other].Name)
+		}
+		fmt.Fprintf(&b, "\n<b>Cross-tolerance</b> with %s.\n", html.EscapeString(strings.Join(names, ", ")))
+		for _, other := range profile.CrossTolerance {
+			at, ok := lastUse(history, other)
+			if !ok || time.Since(at) >= profile.FullReset {
+				continue
+			}
+			fmt.Fprintf(&b, "⚠️ You logged %s %s ago, which also raises your tolerance to %s.\n",
+				html.EscapeString(substances[other].Name), formatDays(time.Since(at)), html.EscapeString(name))
+		}
+	}
+	b.WriteString("\n<i>Estimates vary between people and doses.</i>")
+	return b.String()
+}
+
+// ToleranceExplanation asks the backend for a short, plain-language explanation of the estimate.
+func ToleranceExplanation(key string, since time.Duration) (string, error) {
+	profile := toleranceProfiles[key]
+	question := fmt.Sprintf(
+		"In a short paragraph, explain how tolerance to %s works and what someone should know about dosing %s after their last use. Our estimate says tolerance is %s. Mention cross-tolerance if relevant.",
+		substances[key].Name, formatDays(since), strings.ToLower(ToleranceStatus(profile, since)),
+	)
+	response, err := Ask(PromptRequest{
+		Question:     question,
+		Temperature:  0.25,
+		Tokens:       400,
+		SystemPrompt: SystemPrompt(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.Text(), nil
+}
+
+func HandleToleranceCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	const usage = "Usage: /tolerance &lt;substance&gt; [days since last use], e.g. /tolerance lsd 5"
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return SendHTML(bot, chatID, usage)
+	}
+
+	days := -1
+	if n, err := strconv.Atoi(fields[len(fields)-1]); err == nil && len(fields) > 1 {
+		if n < 0 {
+			return SendHTML(bot, chatID, usage)
+		}
+		days, fields = n, fields[:len(fields)-1]
+	}
+	key, _, ok := LookupSubstance(strings.Join(fields, " "))
+	if _, known := toleranceProfiles[key]; !ok || !known {
+		return SendHTML(bot, chatID, fmt.Sprintf("I don't have tolerance data for <b>%s</b>.", html.EscapeString(strings.Join(fields, " "))))
+	}
+
+	// Without a day count, fall back to the user's own dose log where the policy allows it
+	var history []DoseEntry
+	if Allowed(update.Message, CapDoseLog) {
+		history = DoseHistory(update.Message.From.ID)
+	}
+	since := time.Duration(days) * oneDay
+	if days < 0 {
+		at, logged := lastUse(history, key)
+		if !logged {
+			return SendHTML(bot, chatID, "I couldn't find that substance in your dose log. "+usage)
+		}
+		since = time.Since(at)
+	}
+
+	text := FormatTolerance(key, since, history)
+	thinking := tgbotapi.NewMessage(chatID, ThinkingMessage)
+	thinking.ReplyToMessageID = update.Message.MessageID
+	sent, err := bot.Send(thinking)
+	if err != nil {
+		return err
+	}
+	defer TrackThinking(chatID, sent.MessageID, update.Message.From.ID)()
+
+	// The curated estimate is shown even if the backend can't explain it
+	if explanation, err := ToleranceExplanation(key, since); err != nil {
+		log.Printf("Error getting tolerance explanation: %v", err)
+	} else {
+		text += "\n\n" + ConvertToTelegramHTML(explanation)
+	}
+	return EditMessageHTML(bot, chatID, sent.MessageID, text, &LinkPreviewOptions{IsDisabled: true}, nil)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// InAllowedTopic reports whether the bot may respond to the message given the chat's topic
+// restriction. Messages outside forum topics belong to the "General" topic.
+func InAllowedTopic(update tgbotapi.Update) bool {
+	topic := GetChatSettings(update.Message.Chat.ID).TopicID
+	if topic == 0 || update.Message.Chat.IsPrivate() {
+		return true
+	}
+	return Extras(update).MessageThreadID == topic
+}
+
+// HasTrigger reports whether text starts with, or contains as a hashtag, the chat's trigger.
+func HasTrigger(chatID int64, text string) bool {
+	trigger := strings.ToLower(GetChatSettings(chatID).Trigger)
+	if trigger == "" {
+		return false
+	}
+	text = strings.ToLower(strings.TrimSpace(text))
+	if strings.HasPrefix(trigger, "#") {
+		for _, word := range strings.Fields(text) {
+			if word == trigger {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.HasPrefix(text, trigger)
+}
+
+// StripTrigger removes the chat's trigger from a question before it is sent to the backend.
+func StripTrigger(chatID int64, text string) string {
+	trigger := GetChatSettings(chatID).Trigger
+	if trigger == "" {
+		return text
+	}
+	if strings.HasPrefix(trigger, "#") {
+		fields := strings.Fields(text)
+		kept := fields[:0]
+		for _, word := range fields {
+			if !strings.EqualFold(word, trigger) {
+				kept = append(kept, word)
+			}
+		}
+		return strings.Join(kept, " ")
+	}
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) >= len(trigger) && strings.EqualFold(trimmed[:len(trigger)], trigger) {
+		return strings.TrimSpace(trimmed[len(trigger):])
+	}
+	return text
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxSpeechChars caps how much of an answer is converted to speech.
+const maxSpeechChars = 3000
+
+var speechMarkupPattern = regexp.MustCompile("[*_~`#|>]+")
+var speechLinkPattern = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+
+// TTSURL is the text-to-speech endpoint, or "" when voice answers are disabled.
+func TTSURL() string {
+	return GetenvVar("TTS_URL", false)
+}
+
+// SpeechText strips Markdown so it isn't read out loud.
+func SpeechText(markdown string) string {
+	text := speechLinkPattern.ReplaceAllString(markdown, "$1")
+	text = speechMarkupPattern.ReplaceAllString(text, "")
+	return Truncate(strings.TrimSpace(text), maxSpeechChars)
+}
+
+// Synthesize converts text to audio through the TTS endpoint, returning the audio and its content type.
+func Synthesize(text string) ([]byte, string, error) {
+	jsonBody, err := json.Marshal(map[string]string{
+		"text":   text,
+		"voice":  GetenvVar("TTS_VOICE", false),
+		"format": "ogg_opus",
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("error marshaling TTS request: %w", err)
+	}
+
+	resp, err := HTTPClient(30*time.Second).Post(TTSURL(), "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, "", fmt.Errorf("error making TTS request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected TTS status: %s", resp.Status)
+	}
+
+	audio, err := io.ReadAll(io.LimitReader(resp.Body, 20<<20))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading TTS audio: %w", err)
+	}
+	return audio, resp.Header.Get("Content-Type"), nil
+}
+
+// SendSpeech synthesizes text and sends it as a voice message replying to replyTo.
+func SendSpeech(bot *tgbotapi.BotAPI, chatID int64, replyTo int, text string) error {
+	bot.Send(tgbotapi.NewChatAction(chatID, tgbotapi.ChatRecordVoice))
+
+	audio, contentType, err := Synthesize(SpeechText(text))
+	if err != nil {
+		return err
+	}
+
+	// Telegram only shows OGG/Opus as a voice note; anything else goes out as an audio file
+	if strings.Contains(contentType, "ogg") || strings.Contains(contentType, "opus") {
+		voice := tgbotapi.NewVoice(chatID, tgbotapi.FileBytes{Name: "answer.ogg", Bytes: audio})
+		voice.ReplyToMessageID = replyTo
+		_, err = bot.Send(voice)
+		return err
+	}
+	file := tgbotapi.NewAudio(chatID, tgbotapi.FileBytes{Name: "answer.mp3", Bytes: audio})
+	file.ReplyToMessageID = replyTo
+	_, err = bot.Send(file)
+	return err
+}
+
+// HandleSpeakCallback reads an answer out loud from its 🔊 button ("tts:<message id>").
+func HandleSpeakCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if TTSURL() == "" || len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	messageID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return AnswerCallback(bot, query, "")
+	}
+	entry, ok := feedback.Get(FeedbackKey(query.Message.Chat.ID, messageID))
+	if !ok {
+		return AnswerCallback(bot, query, "This answer is too old to read out.")
+	}
+
+	if err := AnswerCallback(bot, query, "🔊 Preparing audio..."); err != nil {
+		return err
+	}
+	return SendSpeech(bot, query.Message.Chat.ID, messageID, entry.Answer)
+}
+
+// HandleSpeakCommand reads out the bot answer that /speak replies to.
+func HandleSpeakCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	if TTSURL() == "" {
+		return SendHTML(bot, chatID, "Voice answers are not enabled for this bot.")
+	}
+	if !IsReplyToBot(bot, update.Message) {
+		return SendHTML(bot, chatID, "Reply to one of my answers with /speak to hear it.")
+	}
+
+	reply := update.Message.ReplyToMessage
+	return SendSpeech(bot, chatID, reply.MessageID, RepliedTurn(reply).Answer)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// strongDoses are roughly the strong end of a single dose in mg, by substance key or, for
+// substances without one like fentanyl, by name. Amounts far above them are more likely a unit
+// mistake than a plan, and dangerous either way.
+var strongDoses = map[string]float64{
+	"2c-b":        40,
+	"alprazolam":  4,
+	"amphetamine": 100,
+	"cocaine":     150,
+	"diazepam":    60,
+	"dxm":         1000,
+	"fentanyl":    0.1,
+	"ghb":         4000,
+	"ketamine":    300,
+	"lsd":         0.3,
+	"mdma":        200,
+	"tramadol":    400,
+}
+
+// implausibleFactor is how many strong doses an amount has to be before it is questioned.
+const implausibleFactor = 5
+
+// smallerUnits is the unit one step down, the usual slip when a dose is a thousand times off.
+var smallerUnits = map[string]string{"g": "mg", "gram": "mg", "grams": "mg", "mg": "µg"}
+
+var (
+	// amountFirstPattern finds "2g of lsd" and "500 mg fentanyl"
+	amountFirstPattern = regexp.MustCompile(`(?i)(` + amountNumber + `)\s*(mg|µg|ug|mcg|g|grams?)\s+(?:of\s+)?([\p{L}][\p{L}\d-]*)`)
+	// substanceFirstPattern finds "lsd 2g"
+	substanceFirstPattern = regexp.MustCompile(`(?i)([\p{L}][\p{L}\d-]*)\s+(` + amountNumber + `)\s*(mg|µg|ug|mcg|g|grams?)\b`)
+)
+
+// UnitWarning is a dose that is implausibly high for its substance.
+type UnitWarning struct {
+	Name   string
+	Amount float64
+	Unit   string
+	// Strong is the strong dose in mg the amount was compared to
+	Strong float64
+	// Suggested is the unit the amount is plausible in, if any
+	Suggested string
+}
+
+// CheckDoseUnits reports whether an amount of a substance is implausibly high, suggesting the
+// smaller unit when the amount is an ordinary dose in it.
+func CheckDoseUnits(substance string, amount float64, unit string) (UnitWarning, bool) {
+	name := strings.ToLower(substance)
+	strong, ok := strongDoses[name]
+	if !ok {
+		var key string
+		key, _, _ = LookupSubstance(name)
+		if strong, ok = strongDoses[key]; !ok {
+			return UnitWarning{}, false
+		}
+		name = key
+	}
+	mg, ok := milligrams(amount, unit)
+	if !ok || mg <= strong*implausibleFactor {
+		return UnitWarning{}, false
+	}
+	displayName := substanceName(name)
+	if displayName == name {
+		displayName = strings.ToUpper(name[:1]) + name[1:]
+	}
+	warning := UnitWarning{Name: displayName, Amount: amount, Unit: unit, Strong: strong}
+	if smaller, ok := smallerUnits[strings.ToLower(unit)]; ok {
+		if smallerMG, _ := milligrams(amount, smaller); smallerMG <= strong {
+			warning.Suggested = smaller
+		}
+	}
+	return warning, true
+}
+
+// UnitWarnings finds implausible doses in a question, at most one per substance.
+func UnitWarnings(text string) []UnitWarning {
+	var warnings []UnitWarning
+	seen := map[string]bool{}
+	check := func(amount, unit, substance string) {
+		value, err := parseNumber(amount)
+		if err != nil {
+			return
+		}
+		if warning, ok := CheckDoseUnits(substance, value, unit); ok && !seen[warning.Name] {
+			seen[warning.Name] = true
+			warnings = append(warnings, warning)
+		}
+	}
+	for _, match := range amountFirstPattern.FindAllStringSubmatch(text, -1) {
+		check(match[1], match[2], match[3])
+	}
+	for _, match := range substanceFirstPattern.FindAllStringSubmatch(text, -1) {
+		check(match[2], match[3], match[1])
+	}
+	return warnings
+}
+
+// formatMilligrams renders an amount in mg, in µg below 1mg.
+func formatMilligrams(mg float64) string {
+	if mg < 1 {
+		return strconv.FormatFloat(mg*1000, 'f', -1, 64) + "µg"
+	}
+	return strconv.FormatFloat(mg, 'f', -1, 64) + "mg"
+}
+
+func (w UnitWarning) amount(unit string) string {
+	return strconv.FormatFloat(w.Amount, 'f', -1, 64) + unit
+}
+
+// Text explains the warning: how far off the amount is and the amount it probably was.
+func (w UnitWarning) Text() string {
+	mg, _ := milligrams(w.Amount, w.Unit)
+	text := fmt.Sprintf("⚠️ <b>Check the unit:</b> %s of %s would be about %.0f times a strong dose (%s).",
+		html.EscapeString(w.amount(w.Unit)), html.EscapeString(w.Name), mg/w.Strong, formatMilligrams(w.Strong))
+	if w.Suggested != "" {
+		text += fmt.Sprintf(" Did you mean <b>%s</b>?", html.EscapeString(w.amount(w.Suggested)))
+	}
+	return text + " If that really is the amount taken, treat it as an emergency and call emergency services now."
+}
+
+// SendUnitWarnings replies to a question with its implausible doses, before it is answered.
+func SendUnitWarnings(bot *tgbotapi.BotAPI, message *tgbotapi.Message, question string) error {
+	warnings := UnitWarnings(question)
+	if len(warnings) == 0 {
+		return nil
+	}
+	lines := make([]string, len(warnings))
+	for i, warning := range warnings {
+		lines[i] = warning.Text()
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, strings.Join(lines, "\n\n"))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = message.MessageID
+	_, err := bot.Send(msg)
+	return err
+}
+
+// pendingDose is a dose held back from the log until the user confirms its unit.
+type pendingDose struct {
+	UserID  int64
+	Entry   DoseEntry
+	Warning UnitWarning
+}
+
+// pendingDoses maps the warning message to the dose it holds back.
+var pendingDoses = NewBoundedMap[string, pendingDose]("pending_doses", 10000, 30*time.Minute)
+
+// unitWarningKeyboard offers to log the suggested amount ("us:s"), the amount as entered ("us:a")
+// or nothing ("us:x").
+func unitWarningKeyboard(warning UnitWarning) tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	if warning.Suggested != "" {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Log "+warning.amount(warning.Suggested), "us:s"))
+	}
+	row = append(row,
+		tgbotapi.NewInlineKeyboardButtonData("Log "+warning.amount(warning.Unit), "us:a"),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", "us:x"))
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// HoldImplausibleDose shows the warning for an implausible dose in place of the message, or as a
+// new one when messageID is 0, and holds the dose back until the user picks a unit. It reports
+// whether the dose was held.
+func HoldImplausibleDose(bot *tgbotapi.BotAPI, chatID int64, messageID int, userID int64, entry DoseEntry) (bool, error) {
+	warning, ok := CheckDoseUnits(entry.Substance, entry.Amount, entry.Unit)
+	if !ok {
+		return false, nil
+	}
+	text := warning.Text() + "\n\nNothing is logged until you choose."
+	markup := unitWarningKeyboard(warning)
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.ReplyMarkup = markup
+		sent, err := bot.Send(msg)
+		if err != nil {
+			return true, err
+		}
+		messageID = sent.MessageID
+	} else if err := EditMessageHTML(bot, chatID, messageID, text, nil, &markup); err != nil {
+		return true, err
+	}
+	pendingDoses.Set(FeedbackKey(chatID, messageID), pendingDose{UserID: userID, Entry: entry, Warning: warning})
+	return true, nil
+}
+
+// HandleUnitSanityCallback logs or drops a held back dose ("us:<s|a|x>").
+func HandleUnitSanityCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) error {
+	if len(args) != 1 || query.Message == nil {
+		return AnswerCallback(bot, query, "")
+	}
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	pending, ok := pendingDoses.Get(FeedbackKey(chatID, messageID))
+	if !ok || pending.UserID != query.From.ID {
+		return AnswerCallback(bot, query, "This has expired, log the dose again with /log.")
+	}
+	pendingDoses.LoadAndDelete(FeedbackKey(chatID, messageID))
+
+	entry := pending.Entry
+	switch args[0] {
+	case "x":
+		if err := EditMessageHTML(bot, chatID, messageID, "Cancelled, nothing was logged.", nil, nil); err != nil {
+			return err
+		}
+		return AnswerCallback(bot, query, "")
+	case "s":
+		if pending.Warning.Suggested == "" {
+			return AnswerCallback(bot, query, "")
+		}
+		entry.Unit = pending.Warning.Suggested
+	}
+	reply, err := LogDose(pending.UserID, entry)
+	if err != nil {
+		return err
+	}
+	if err := EditMessageHTML(bot, chatID, messageID, reply, nil, nil); err != nil {
+		return err
+	}
+	return AnswerCallback(bot, query, "Logged")
+}
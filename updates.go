@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UpdateExtras holds update fields the bundled tgbotapi version doesn't decode yet.
+type UpdateExtras struct {
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+}
+
+// extrasRetention is how many updates' extras are kept for asynchronous handlers.
+const extrasRetention = 10000
+
+var updateExtras = NewBoundedMap[int, UpdateExtras]("update_extras", extrasRetention, time.Hour)
+
+// DecodeUpdate decodes a raw update, keeping the fields tgbotapi drops for Extras.
+func DecodeUpdate(raw json.RawMessage) (tgbotapi.Update, error) {
+	var update tgbotapi.Update
+	if err := json.Unmarshal(raw, &update); err != nil {
+		return update, fmt.Errorf("error decoding update: %w", err)
+	}
+
+	var extra struct {
+		Message *UpdateExtras `json:"message"`
+	}
+	if err := json.Unmarshal(raw, &extra); err == nil && extra.Message != nil {
+		updateExtras.Set(update.UpdateID, *extra.Message)
+	}
+	return update, nil
+}
+
+// Extras returns the undecoded fields of an update's message.
+func Extras(update tgbotapi.Update) UpdateExtras {
+	extras, _ := updateExtras.Get(update.UpdateID)
+	return extras
+}
+
+// PollUpdates long-polls getUpdates like tgbotapi's GetUpdatesChan, but decodes through DecodeUpdate.
+func PollUpdates(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) <-chan tgbotapi.Update {
+	ch := make(chan tgbotapi.Update, bot.Buffer)
+
+	go func() {
+		for {
+			params := tgbotapi.Params{}
+			params.AddNonZero("offset", config.Offset)
+			params.AddNonZero("limit", config.Limit)
+			params.AddNonZero("timeout", config.Timeout)
+			params.AddInterface("allowed_updates", config.AllowedUpdates)
+
+			resp, err := bot.MakeRequest("getUpdates", params)
+			var raws []json.RawMessage
+			if err == nil {
+				err = json.Unmarshal(resp.Result, &raws)
+			}
+			if err != nil {
+				log.Println(err)
+				log.Println("Failed to get updates, retrying in 3 seconds...")
+				time.Sleep(time.Second * 3)
+				continue
+			}
+
+			for _, raw := range raws {
+				update, err := DecodeUpdate(raw)
+				if err != nil {
+					// Skip undecodable updates instead of fetching them forever
+					log.Println(err)
+					var id struct {
+						UpdateID int `json:"update_id"`
+					}
+					if json.Unmarshal(raw, &id) == nil && id.UpdateID >= config.Offset {
+						config.Offset = id.UpdateID + 1
+					}
+					continue
+				}
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// webAppInitDataMaxAge is how long after Telegram signed it the web app's initData is accepted.
+const webAppInitDataMaxAge = 24 * time.Hour
+
+// WebAppInfo mirrors Telegram's WebAppInfo object, which the bundled tgbotapi version lacks.
+type WebAppInfo struct {
+	URL string `json:"url"`
+}
+
+// webAppButton is an inline keyboard button that opens a web app.
+type webAppButton struct {
+	Text   string     `json:"text"`
+	WebApp WebAppInfo `json:"web_app"`
+}
+
+type webAppKeyboard struct {
+	InlineKeyboard [][]webAppButton `json:"inline_keyboard"`
+}
+
+// WebAppURL is WEBAPP_URL, the public https URL the dose charts are served at, or "" when the
+// web app is off.
+func WebAppURL() string {
+	raw := GetenvVar("WEBAPP_URL", false)
+	if parsed, err := url.Parse(raw); raw == "" || err != nil || parsed.Scheme != "https" {
+		return ""
+	}
+	if !strings.HasSuffix(raw, "/") {
+		raw += "/"
+	}
+	return raw
+}
+
+// DoseChartsKeyboard opens the dose charts web app. Web app buttons only work in private chats.
+func DoseChartsKeyboard() interface{} {
+	webAppURL := WebAppURL()
+	if webAppURL == "" {
+		return nil
+	}
+	return webAppKeyboard{InlineKeyboard: [][]webAppButton{{{Text: "📊 Charts", WebApp: WebAppInfo{URL: webAppURL}}}}}
+}
+
+// WebAppUser is the user Telegram vouches for in a web app's initData.
+type WebAppUser struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LanguageCode string `json:"language_code"`
+}
+
+// VerifyWebAppInitData checks the signature Telegram puts on a web app's initData with the bot
+// token, as described in https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app,
+// and returns the user it was issued to.
+func VerifyWebAppInitData(initData, botToken string, now time.Time) (WebAppUser, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return WebAppUser{}, fmt.Errorf("malformed init data: %w", err)
+	}
+	given, err := hex.DecodeString(values.Get("hash"))
+	if err != nil || len(given) == 0 {
+		return WebAppUser{}, errors.New("init data is not signed")
+	}
+	values.Del("hash")
+	pairs := make([]string, 0, len(values))
+	for key := range values {
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	if !hmac.Equal(mac.Sum(nil), given) {
+		return WebAppUser{}, errors.New("bad init data signature")
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > webAppInitDataMaxAge {
+		return WebAppUser{}, errors.New("init data expired")
+	}
+	var user WebAppUser
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return WebAppUser{}, errors.New("init data has no user")
+	}
+	return user, nil
+}
+
+// webAppDose is a logged dose as the charts draw it, with the day and time already in the
+// user's time zone.
+type webAppDose struct {
+	Substance string `json:"substance"`
+	Name      string `json:"name"`
+	Label     string `json:"label"`
+	Day       string `json:"day"`
+	Time      string `json:"time"`
+	At        int64  `json:"at"`
+}
+
+// HandleWebAppDoses returns the dose log of the user whose initData is posted as the body.
+func HandleWebAppDoses(bot *tgbotapi.BotAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		initData, err := io.ReadAll(io.LimitReader(r.Body, 8<<10))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+			return
+		}
+		user, err := VerifyWebAppInitData(string(initData), bot.Token, time.Now())
+		if err != nil {
+			log.Printf("Rejected web app request: %v", err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		location := UserLocation(user.ID)
+		locale := UserLocale(user.ID)
+		history := DoseHistory(user.ID)
+		doses := make([]webAppDose, len(history))
+		for i, entry := range history {
+			local := entry.At.In(location)
+			doses[i] = webAppDose{
+				Substance: entry.Substance,
+				Name:      substanceName(entry.Substance),
+				Label:     FormatDose(entry, locale),
+				Day:       local.Format("2006-01-02"),
+				Time:      local.Format("15:04"),
+				At:        entry.At.Unix(),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"time_zone": location.String(),
+			"today":     time.Now().In(location).Format("2006-01-02"),
+			"doses":     doses,
+		})
+	}
+}
+
+// RegisterWebApp serves the dose charts at the path of WEBAPP_URL. It does nothing when the web
+// app is off.
+func RegisterWebApp(mux *http.ServeMux, bot *tgbotapi.BotAPI) {
+	webAppURL := WebAppURL()
+	if webAppURL == "" {
+		return
+	}
+	parsed, _ := url.Parse(webAppURL)
+	mux.HandleFunc(parsed.Path, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != parsed.Path {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline' https://telegram.org; style-src 'unsafe-inline'; connect-src 'self'")
+		io.WriteString(w, doseChartsPage)
+	})
+	mux.HandleFunc(parsed.Path+"api/doses", HandleWebAppDoses(bot))
+}
+
+// StartWebAppServer serves the web app on addr in the background, for polling mode or when it
+// shouldn't share the webhook server.
+func StartWebAppServer(addr string, bot *tgbotapi.BotAPI) {
+	mux := http.NewServeMux()
+	RegisterWebApp(mux, bot)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Web app server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Web app server stopped: %v", err)
+		}
+	}()
+}
+
+// doseChartsPage is the web app: a calendar heatmap of the last 26 weeks and a timeline of each
+// substance, drawn as SVG in Telegram's theme colors. Tapping a day or dose lists what was logged.
+const doseChartsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Dose history</title>
+<script src="https://telegram.org/js/telegram-web-app.js"></script>
+<style>
+body { margin: 0; padding: 12px; font: 14px -apple-system, system-ui, sans-serif;
+  background: var(--tg-theme-bg-color, #fff); color: var(--tg-theme-text-color, #000); }
+h2 { font-size: 15px; margin: 18px 0 8px; }
+.hint { color: var(--tg-theme-hint-color, #888); font-size: 12px; }
+.chips { display: flex; flex-wrap: wrap; gap: 6px; }
+.chip { border: 0; border-radius: 14px; padding: 5px 11px; font-size: 13px;
+  background: var(--tg-theme-secondary-bg-color, #eee); color: var(--tg-theme-text-color, #000); }
+.chip.on { background: var(--tg-theme-button-color, #2481cc); color: var(--tg-theme-button-text-color, #fff); }
+svg { display: block; width: 100%; height: auto; }
+svg rect, svg circle { cursor: pointer; }
+#details { margin-top: 12px; padding: 10px; border-radius: 10px; background: var(--tg-theme-secondary-bg-color, #f4f4f4); }
+#details:empty { display: none; }
+</style>
+</head>
+<body>
+<div id="status" class="hint">Loading…</div>
+<div id="charts" hidden>
+  <div class="chips" id="filters"></div>
+  <h2>Last 26 weeks</h2>
+  <div id="heatmap"></div>
+  <h2>Timeline</h2>
+  <div id="timeline"></div>
+  <div id="details"></div>
+  <p class="hint" id="zone"></p>
+</div>
+<script>
+const app = window.Telegram.WebApp;
+app.ready();
+app.expand();
+const svgNS = "http://www.w3.org/2000/svg";
+const accent = getComputedStyle(document.body).getPropertyValue("--tg-theme-button-color").trim() || "#2481cc";
+let data = null, selected = "";
+
+function el(name, attrs, parent) {
+  const node = document.createElementNS(svgNS, name);
+  for (const key in attrs) node.setAttribute(key, attrs[key]);
+  if (parent) parent.appendChild(node);
+  return node;
+}
+function parseDay(day) { const [y, m, d] = day.split("-").map(Number); return new Date(Date.UTC(y, m - 1, d)); }
+function formatDay(date) { return date.toISOString().slice(0, 10); }
+function doses() { return data.doses.filter(d => !selected || d.substance === selected); }
+
+function showDetails(title, list) {
+  const box = document.getElementById("details");
+  box.textContent = "";
+  const heading = document.createElement("b");
+  heading.textContent = title;
+  box.appendChild(heading);
+  if (!list.length) { box.appendChild(document.createTextNode(" · nothing logged")); return; }
+  for (const d of list) {
+    const line = document.createElement("div");
+    line.textContent = d.day + " " + d.time + " · " + d.label;
+    box.appendChild(line);
+  }
+}
+
+function drawFilters() {
+  const box = document.getElementById("filters");
+  box.textContent = "";
+  const names = {};
+  for (const d of data.doses) names[d.substance] = d.name;
+  for (const key of [""].concat(Object.keys(names).sort())) {
+    const chip = document.createElement("button");
+    chip.className = "chip" + (key === selected ? " on" : "");
+    chip.textContent = key ? names[key] : "All";
+    chip.onclick = () => { selected = key; draw(); };
+    box.appendChild(chip);
+  }
+}
+
+function drawHeatmap() {
+  const box = document.getElementById("heatmap");
+  box.textContent = "";
+  const today = parseDay(data.today);
+  const start = new Date(today);
+  start.setUTCDate(start.getUTCDate() - 7 * 25 - today.getUTCDay());
+  const counts = {};
+  for (const d of doses()) counts[d.day] = (counts[d.day] || 0) + 1;
+  const most = Math.max(1, ...Object.values(counts));
+  const size = 12, gap = 2;
+  const svg = el("svg", {viewBox: "0 0 " + (26 * (size + gap)) + " " + (7 * (size + gap))}, box);
+  for (let day = new Date(start), i = 0; day <= today; day.setUTCDate(day.getUTCDate() + 1), i++) {
+    const key = formatDay(day), count = counts[key] || 0;
+    const cell = el("rect", {x: Math.floor(i / 7) * (size + gap), y: (i % 7) * (size + gap), width: size, height: size, rx: 2,
+      fill: count ? accent : "currentColor", "fill-opacity": count ? 0.25 + 0.75 * count / most : 0.08}, svg);
+    el("title", {}, cell).textContent = key + ": " + count;
+    cell.onclick = () => showDetails(key, doses().filter(d => d.day === key));
+  }
+}
+
+function drawTimeline() {
+  const box = document.getElementById("timeline");
+  box.textContent = "";
+  const list = doses();
+  if (!list.length) return;
+  const keys = [...new Set(list.map(d => d.substance))].sort();
+  const first = Math.min(...list.map(d => d.at)), last = Math.max(Date.now() / 1000, ...list.map(d => d.at));
+  const label = 90, width = 360, row = 26, span = Math.max(last - first, 86400);
+  const svg = el("svg", {viewBox: "0 0 " + (label + width + 10) + " " + (keys.length * row + 20)}, box);
+  keys.forEach((key, i) => {
+    const y = i * row + row / 2;
+    const name = el("text", {x: 0, y: y + 4, "font-size": 12, fill: "currentColor"}, svg);
+    name.textContent = list.find(d => d.substance === key).name;
+    el("line", {x1: label, x2: label + width, y1: y, y2: y, stroke: "currentColor", "stroke-opacity": 0.15}, svg);
+    for (const d of list.filter(d => d.substance === key)) {
+      const dot = el("circle", {cx: label + width * (d.at - first) / span, cy: y, r: 5, fill: accent, "fill-opacity": 0.8}, svg);
+      el("title", {}, dot).textContent = d.day + " " + d.time + " · " + d.label;
+      dot.onclick = () => showDetails(d.name, [d]);
+    }
+  });
+  const axis = keys.length * row + 14;
+  for (const [x, t] of [[label, first], [label + width, last]]) {
+    const tick = el("text", {x: x, y: axis, "font-size": 10, fill: "currentColor", "fill-opacity": 0.6,
+      "text-anchor": x === label ? "start" : "end"}, svg);
+    tick.textContent = new Date(t * 1000).toISOString().slice(0, 10);
+  }
+}
+
+function draw() {
+  drawFilters();
+  drawHeatmap();
+  drawTimeline();
+  document.getElementById("details").textContent = "";
+}
+
+fetch("api/doses", {method: "POST", headers: {"Content-Type": "text/plain"}, body: app.initData})
+  .then(response => { if (!response.ok) throw new Error(response.status); return response.json(); })
+  .then(result => {
+    data = result;
+    if (!data.doses.length) {
+      document.getElementById("status").textContent = "Nothing logged yet. Use /log in the chat to add a dose.";
+      return;
+    }
+    document.getElementById("status").hidden = true;
+    document.getElementById("charts").hidden = false;
+    document.getElementById("zone").textContent = "Times in " + data.time_zone + ". Only you can see this.";
+    draw();
+  })
+  .catch(() => { document.getElementById("status").textContent = "Couldn't load your doses. Open the charts from the chat again."; });
+</script>
+</body>
+</html>
+`
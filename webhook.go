@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// telegramWebhookRanges are the networks Telegram sends webhook requests from, as published in
+// the Bot API documentation.
+var telegramWebhookRanges = []string{"149.154.160.0/20", "91.108.4.0/22"}
+
+// secretTokenPattern is what Telegram accepts as a webhook secret token.
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookSecret returns WEBHOOK_SECRET, or a random token for this run when it isn't set; the
+// webhook is registered again on every start, so Telegram always has the current one.
+func webhookSecret() (string, error) {
+	if secret := GetenvVar("WEBHOOK_SECRET", false); secret != "" {
+		if !secretTokenPattern.MatchString(secret) {
+			return "", fmt.Errorf("WEBHOOK_SECRET must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+		}
+		return secret, nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %w", err)
+	}
+	// It's sent to setWebhook, so it must be redacted like the configured secrets
+	secret := hex.EncodeToString(buf)
+	addRedaction(secret)
+	return secret, nil
+}
+
+// webhookAllowlist parses WEBHOOK_ALLOWED_IPS, a comma-separated list of CIDRs where "telegram"
+// stands for Telegram's published ranges. Empty allows every address, for setups where a
+// reverse proxy already filters them.
+func webhookAllowlist() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(GetenvVar("WEBHOOK_ALLOWED_IPS", false), ",") {
+		entry = strings.TrimSpace(entry)
+		cidrs := []string{entry}
+		switch {
+		case entry == "":
+			continue
+		case strings.EqualFold(entry, "telegram"):
+			cidrs = telegramWebhookRanges
+		case !strings.Contains(entry, "/"):
+			if strings.Contains(entry, ":") {
+				cidrs = []string{entry + "/128"}
+			} else {
+				cidrs = []string{entry + "/32"}
+			}
+		}
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid WEBHOOK_ALLOWED_IPS entry %q: %w", entry, err)
+			}
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// webhookClientIP is the address a webhook request came from. Behind a reverse proxy,
+// WEBHOOK_REAL_IP_HEADER names the header it puts the client address in, e.g. X-Real-IP.
+func webhookClientIP(r *http.Request) net.IP {
+	if header := GetenvVar("WEBHOOK_REAL_IP_HEADER", false); header != "" {
+		if value := r.Header.Get(header); value != "" {
+			// X-Forwarded-For style lists end with the address the proxy saw
+			parts := strings.Split(value, ",")
+			return net.ParseIP(strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func ipAllowed(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookUpdates registers publicURL as the bot's webhook and serves it on addr, delivering
+// updates decoded through DecodeUpdate. Requests without the webhook's secret token, or from
+// outside WEBHOOK_ALLOWED_IPS, are rejected before their body is read. The dose charts web app
+// is served next to the webhook unless WEBAPP_ADDR gives it a server of its own.
+func WebhookUpdates(bot *tgbotapi.BotAPI, publicURL, addr string) (<-chan tgbotapi.Update, error) {
+	parsed, err := url.Parse(publicURL)
+	if err != nil || parsed.Scheme != "https" {
+		return nil, fmt.Errorf("WEBHOOK_URL must be an https URL, got %q", publicURL)
+	}
+	secret, err := webhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := webhookAllowlist()
+	if err != nil {
+		return nil, err
+	}
+	if err := SetWebhook(bot, publicURL, secret); err != nil {
+		return nil, fmt.Errorf("error setting webhook: %w", err)
+	}
+
+	ch := make(chan tgbotapi.Update, bot.Buffer)
+	path := parsed.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	if GetenvVar("WEBAPP_ADDR", false) == "" {
+		RegisterWebApp(mux, bot)
+	}
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ip := webhookClientIP(r); len(allowed) > 0 && !ipAllowed(allowed, ip) {
+			log.Printf("Rejected webhook request from %s: not in WEBHOOK_ALLOWED_IPS", ip)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			log.Printf("Rejected webhook request from %s: bad secret token", webhookClientIP(r))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		raw, err := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+			return
+		}
+		update, err := DecodeUpdate(raw)
+		if err != nil {
+			// Acknowledge anyway so Telegram doesn't redeliver an update we can never decode
+			log.Println(err)
+			return
+		}
+		ch <- update
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Webhook server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Webhook server stopped: %v", err)
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// weeklySummaryWeekday and weeklySummaryHour are when summaries go out, in the user's time zone.
+	weeklySummaryWeekday = time.Monday
+	weeklySummaryHour    = 10
+	// frequentUseDays is how many days out of seven a substance is used before it's pointed out.
+	frequentUseDays = 4
+)
+
+// substanceWeek is one substance's use in the summarized week.
+type substanceWeek struct {
+	Key     string
+	Name    string
+	Doses   int
+	Days    map[string]bool
+	Amounts map[string]float64
+	// MinGap is the shortest time between two doses on different occasions, including the
+	// last dose before the week
+	MinGap time.Duration
+}
+
+// WeeklySummary summarizes the doses logged in the seven days before end, or returns "" when
+// there were none.
+func WeeklySummary(history []DoseEntry, end time.Time, location *time.Location) string {
+	start := end.Add(-7 * oneDay)
+	weeks := map[string]*substanceWeek{}
+	last := map[string]time.Time{}
+	mixes := 0
+	var previous []DoseEntry
+
+	for _, entry := range history {
+		key, substance, ok := LookupSubstance(entry.Substance)
+		name := substance.Name
+		if !ok {
+			name = entry.Substance
+		}
+		if entry.At.After(end) {
+			break
+		}
+		if !entry.At.Before(start) {
+			week := weeks[key]
+			if week == nil {
+				week = &substanceWeek{Key: key, Name: name, Days: map[string]bool{}, Amounts: map[string]float64{}}
+				weeks[key] = week
+			}
+			week.Doses++
+			week.Days[entry.At.In(location).Format("2006-01-02")] = true
+			week.Amounts[entry.Unit] += entry.Amount
+			// Redoses within one session aren't a spacing problem, so they are ignored
+			if at, ok := last[key]; ok && entry.At.Sub(at) >= redoseWindow {
+				if gap := entry.At.Sub(at); week.MinGap == 0 || gap < week.MinGap {
+					week.MinGap = gap
+				}
+			}
+			if len(ActiveInteractions(previous, entry)) > 0 {
+				mixes++
+			}
+		}
+		last[key] = entry.At
+		previous = append(previous, entry)
+	}
+	if len(weeks) == 0 {
+		return ""
+	}
+
+	sorted := make([]*substanceWeek, 0, len(weeks))
+	for _, week := range weeks {
+		sorted = append(sorted, week)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Doses != sorted[j].Doses {
+			return sorted[i].Doses > sorted[j].Doses
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 <b>Your week</b> (%s – %s)\n", start.In(location).Format("Jan 2"), end.In(location).Format("Jan 2"))
+	var observations []string
+	for _, week := range sorted {
+		fmt.Fprintf(&b, "\n• <b>%s</b>: %s on %s, %s in total", html.EscapeString(week.Name),
+			countOf(week.Doses, "dose"), countOf(len(week.Days), "day"), formatAmounts(week.Amounts))
+		observations = append(observations, spacingObservations(week)...)
+	}
+	if mixes > 0 {
+		observations = append(observations, fmt.Sprintf("%s overlapped with a risky combination.", countOf(mixes, "logged dose")))
+	}
+
+	if len(observations) > 0 {
+		b.WriteString("\n\n<b>Observations</b>")
+		for _, observation := range observations {
+			b.WriteString("\n• " + observation)
+		}
+	}
+	b.WriteString("\n\n<i>Based only on what you logged. Turn these off with /weekly off.</i>")
+	return b.String()
+}
+
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func spacingObservations(week *substanceWeek) []string {
+	var observations []string
+	name := html.EscapeString(week.Name)
+	if len(week.Days) >= frequentUseDays {
+		observations = append(observations, fmt.Sprintf("%s on %d of the last 7 days.", name, len(week.Days)))
+	}
+	if week.MinGap == 0 {
+		return observations
+	}
+	if advice, ok := spacingGuidance[week.Key]; ok {
+		if week.MinGap < advice.Gap {
+			observations = append(observations, fmt.Sprintf("Less than %s between %s doses (%s). %s",
+				formatDays(advice.Gap), name, formatDays(week.MinGap), advice.Note))
+		}
+		return observations
+	}
+	if profile, ok := toleranceProfiles[week.Key]; ok && week.MinGap < profile.HalfReset {
+		note := "tolerance was likely still high, so a later dose may have felt weaker."
+		if profile.DropsFaster {
+			note = "tolerance builds quickly, and raising the dose to keep up increases overdose risk."
+		}
+		observations = append(observations, fmt.Sprintf("%s between %s doses: %s", formatDays(week.MinGap), name, note))
+	}
+	return observations
+}
+
+// weeklySummaryDue reports whether it is summary time in the user's time zone and no summary
+// went out in the last six days.
+func weeklySummaryDue(settings UserSettings, now time.Time, location *time.Location) bool {
+	local := now.In(location)
+	return settings.WeeklySummary && local.Weekday() == weeklySummaryWeekday && local.Hour() == weeklySummaryHour &&
+		now.Sub(settings.WeeklySummarySentAt) > 6*oneDay
+}
+
+// SendWeeklySummaries sends the summaries that are due. Users without logged doses that week
+// get nothing.
+func SendWeeklySummaries(bot *tgbotapi.BotAPI, now time.Time) {
+	var due []int64
+	userSettings.Range(func(key string, settings UserSettings) bool {
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err == nil && weeklySummaryDue(settings, now, UserLocation(userID)) && ChatActive(userID) {
+			due = append(due, userID)
+		}
+		return true
+	})
+
+	for _, userID := range due {
+		if err := UpdateUserSettings(userID, func(settings *UserSettings) { settings.WeeklySummarySentAt = now }); err != nil {
+			log.Printf("Error saving weekly summary time for user %d: %v", userID, err)
+			continue
+		}
+		// Users who turned on privacy mode in their DM have no dose log to summarize
+		if PrivacyEnabled(userID) {
+			continue
+		}
+		summary := WeeklySummary(DoseHistory(userID), now, UserLocation(userID))
+		if summary == "" {
+			continue
+		}
+		if _, err := SendTypedHTML(bot, userID, summary, MessageWeeklySummary); err != nil {
+			log.Printf("Error sending weekly summary to user %d: %v", userID, err)
+			NoteSendFailure(userID, err)
+		}
+	}
+}
+
+// ScheduleWeeklySummaries checks every hour for users whose summary is due.
+func ScheduleWeeklySummaries(bot *tgbotapi.BotAPI) {
+	ScheduleJob(Job{
+		Name:     "weekly_summaries",
+		Schedule: Every(time.Hour),
+		Run: func(time.Time) error {
+			SendWeeklySummaries(bot, time.Now())
+			return nil
+		},
+	})
+}
+
+// HandleWeeklyCommand turns the weekly summary on or off, or shows this week's so far.
+func HandleWeeklyCommand(bot *tgbotapi.BotAPI, update tgbotapi.Update, args string) error {
+	chatID := update.Message.Chat.ID
+	userID := update.Message.From.ID
+	const usage = "Usage: /weekly on|off|now"
+
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		if err := UpdateUserSettings(userID, func(settings *UserSettings) { settings.WeeklySummary = true }); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, fmt.Sprintf("I'll send you a summary of your logged doses every %s at %s (%s).",
+			weeklySummaryWeekday, UserLocale(userID).Hour(weeklySummaryHour), html.EscapeString(UserLocation(userID).String())))
+	case "off":
+		if err := UpdateUserSettings(userID, func(settings *UserSettings) { settings.WeeklySummary = false }); err != nil {
+			return err
+		}
+		return SendHTML(bot, chatID, "Weekly summaries are off.")
+	case "now":
+		summary := WeeklySummary(DoseHistory(userID), time.Now(), UserLocation(userID))
+		if summary == "" {
+			summary = "Nothing logged in the last 7 days."
+		}
+		return SendHTML(bot, chatID, summary)
+	case "":
+		status := "off"
+		if GetUserSettings(userID).WeeklySummary {
+			status = "on"
+		}
+		return SendHTML(bot, chatID, fmt.Sprintf("Weekly summaries are <b>%s</b>.\n%s", status, usage))
+	default:
+		return SendHTML(bot, chatID, usage)
+	}
+}